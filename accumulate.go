@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bucketAccumulator holds the observations of a TopKBucket that are not yet
+// recorded in the stream, if BucketFlushInterval is set.
+type bucketAccumulator struct {
+	// the float64 bits of the sum of the observed values
+	sum   atomic.Uint64
+	count atomic.Uint64
+	// set while the bucket is in the pending list of the root
+	pending atomic.Bool
+}
+
+// accumulate adds n observations of v, adding the bucket to the pending list
+// of the root if it is not already there.
+func (b *topkWithLabelValues) accumulate(v float64, n uint64) {
+	for {
+		old := b.acc.sum.Load()
+		if b.acc.sum.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v*float64(n))) {
+			break
+		}
+	}
+	b.acc.count.Add(n)
+	if !b.acc.pending.Load() && b.acc.pending.CompareAndSwap(false, true) {
+		b.root.accMtx.Lock()
+		b.root.accPending = append(b.root.accPending, b)
+		b.root.accMtx.Unlock()
+	}
+}
+
+// flushBuckets records the observations accumulated by the pending buckets.
+// Must be called with streamMtx held.
+func (r *topkRoot) flushBuckets() {
+	r.accMtx.Lock()
+	pending := r.accPending
+	r.accPending = nil
+	r.accMtx.Unlock()
+
+	for _, b := range pending {
+		// clear the flag first, so that a concurrent observation is
+		// either taken here or adds the bucket to the list again
+		b.acc.pending.Store(false)
+		sum := math.Float64frombits(b.acc.sum.Swap(0))
+		n := b.acc.count.Swap(0)
+		if n > 0 || sum > 0 {
+			r.observations.Add(n)
+			if r.decay != nil {
+				sum *= r.decayWeight()
+			}
+			r.stream.Insert(b.compositeLabel, sum)
+			r.touch(b.compositeLabel)
+			if r.audit != nil {
+				r.audit.exact[b.compositeLabel] += sum
+			}
+		}
+	}
+}
+
+// flusher periodically records the observations accumulated by the buckets
+// of a TopK.
+type flusher struct {
+	root *topkRoot
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func startFlusher(root *topkRoot, interval time.Duration) *flusher {
+	f := &flusher{
+		root: root,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go f.run(interval)
+	return f
+}
+
+func (f *flusher) run(interval time.Duration) {
+	defer close(f.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.root.lockStream()
+			f.root.streamMtx.Unlock()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+func (f *flusher) close() {
+	f.closeOnce.Do(func() {
+		close(f.stop)
+		<-f.done
+	})
+}
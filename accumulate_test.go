@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBucketFlushInterval(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, BucketFlushInterval: time.Hour}, []string{"key"})
+	defer k.Close()
+
+	b := k.WithLabelValues("a")
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				b.Inc()
+			}
+			k.WithLabelValues("b").ObserveMany(0.5, 10)
+		}()
+	}
+	wg.Wait()
+
+	// reading the TopK records the accumulated observations
+	if count, _, _ := k.Estimate(prometheus.Labels{"key": "a"}); count != 4000 {
+		t.Errorf("got count %v, expected 4000", count)
+	}
+	if count, _, _ := k.Estimate(prometheus.Labels{"key": "b"}); count != 20 {
+		t.Errorf("got count %v, expected 20", count)
+	}
+	if st := k.Stats(); st.Observations != 4040 {
+		t.Errorf("got %d observations, expected 4040", st.Observations)
+	}
+	b.Add(2)
+	if rank, ok := k.Rank("a"); rank != 1 || !ok {
+		t.Errorf("Rank = %v, %v after another flush", rank, ok)
+	}
+	if count, _, _ := k.Estimate(prometheus.Labels{"key": "a"}); count != 4002 {
+		t.Errorf("got count %v, expected 4002", count)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithBucketFlushInterval(time.Second), WithCountAndSum()); err == nil {
+		t.Error("expected error for per-key counts")
+	}
+}
+
+func TestBucketFlushPeriodic(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, BucketFlushInterval: time.Millisecond}, []string{"key"})
+	defer k.Close()
+	k.WithLabelValues("a").Add(3)
+
+	root := k.(*topkCurry).root
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// look at the stream without flushing
+		root.streamMtx.Lock()
+		count := root.stream.Estimate("a\xff").Count
+		root.streamMtx.Unlock()
+		if count == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the observation was not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// shareAlert calls the OnShareAlert function of a TopK with the keys whose
+// share of the total goes over its ShareAlert.
+type shareAlert struct {
+	fraction float64
+	minTotal float64
+	f        func(labels prometheus.Labels, share float64)
+
+	// the keys over the fraction at their last observation, and the total
+	// of the stream, kept up to date so that it is not computed by every
+	// observation; protected by streamMtx
+	over  map[string]bool
+	total float64
+}
+
+func newShareAlert(opts TopKOpts) *shareAlert {
+	if opts.ShareAlert <= 0 {
+		return nil
+	}
+	return &shareAlert{
+		fraction: opts.ShareAlert,
+		minTotal: opts.ShareAlertMinTotal,
+		f:        opts.OnShareAlert,
+		over:     make(map[string]bool),
+	}
+}
+
+// checkShare calls the OnShareAlert function if the guaranteed count of key
+// went over the fraction of the total since the last observation of the key.
+// Must be called with streamMtx held.
+func (r *topkRoot) checkShare(key string) {
+	a := r.shareAlert
+	var share float64
+	if total := a.total; total > 0 && total >= a.minTotal && r.stream.Monitored(key) {
+		e := r.stream.Estimate(key)
+		share = (e.Count - e.Error) / total
+	}
+	if share <= a.fraction {
+		delete(a.over, key)
+		return
+	}
+	if a.over[key] {
+		return
+	}
+	// forget the keys that were evicted while over the fraction
+	for k := range a.over {
+		if !r.stream.Monitored(k) {
+			delete(a.over, k)
+		}
+	}
+	a.over[key] = true
+	if labels, err := ParseKey(r.variableLabels, key); err == nil {
+		a.f(labels, share)
+	}
+}
+
+// add adds v to the total; a is allowed to be nil.
+// Must be called with streamMtx held.
+func (a *shareAlert) add(v float64) {
+	if a != nil {
+		a.total += v
+	}
+}
+
+// reset sets the total to the one of a new stream, forgetting the keys over
+// the fraction; a is allowed to be nil. Must be called with streamMtx held.
+func (a *shareAlert) reset(s Stream) {
+	if a != nil {
+		a.total = streamTotal(s)
+		a.over = make(map[string]bool)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestShareAlert(t *testing.T) {
+	var alerts []string
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 3,
+		ShareAlert: 0.5, ShareAlertMinTotal: 10,
+		OnShareAlert: func(labels prometheus.Labels, share float64) {
+			alerts = append(alerts, fmt.Sprintf("%s %.2f", labels["key"], share))
+		},
+	}, []string{"key"})
+
+	// under the minimum total
+	k.WithLabelValues("a").Add(5)
+	k.WithLabelValues("b").Add(3)
+	k.WithLabelValues("c").Add(2)
+	if alerts != nil {
+		t.Errorf("got alerts %q under the minimum total", alerts)
+	}
+
+	k.WithLabelValues("a").Add(10)
+	// still over, so no second alert
+	k.WithLabelValues("a").Add(5)
+	want := []string{"a 0.75"}
+	if !reflect.DeepEqual(alerts, want) {
+		t.Errorf("got alerts %q expected %q", alerts, want)
+	}
+
+	// a falls under the fraction, and alerts again once back over it
+	k.WithLabelValues("b").Add(20)
+	k.WithLabelValues("a").Add(0)
+	k.WithLabelValues("a").Add(20)
+	want = append(want, "b 0.51", "a 0.62")
+	if !reflect.DeepEqual(alerts, want) {
+		t.Errorf("got alerts %q expected %q", alerts, want)
+	}
+}
+
+// rangeCounter is a Stream without Total, counting the calls to Range.
+type rangeCounter struct {
+	Stream
+	ranges int
+}
+
+func (s *rangeCounter) Range(f func(tk.Element) bool) {
+	s.ranges++
+	s.Stream.Range(f)
+}
+
+func TestShareAlertCustomStream(t *testing.T) {
+	var s *rangeCounter
+	var alerts int
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 3,
+		NewStream: func(n int) Stream {
+			s = &rangeCounter{Stream: tk.NewStream(n)}
+			return s
+		},
+		ShareAlert:   0.5,
+		OnShareAlert: func(prometheus.Labels, float64) { alerts++ },
+	}, []string{"key"})
+
+	ranges := s.ranges
+	for i := 0; i < 100; i++ {
+		k.WithLabelValues("a").Inc()
+		k.WithLabelValues(fmt.Sprint(i % 3)).Inc()
+	}
+	if s.ranges != ranges {
+		t.Errorf("the observations ranged over the stream %d times", s.ranges-ranges)
+	}
+	if alerts != 1 {
+		t.Errorf("got %d alerts expected 1", alerts)
+	}
+}
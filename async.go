@@ -0,0 +1,102 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// asyncObservation is an observation waiting in the queue of an inserter.
+type asyncObservation struct {
+	key string
+	v   float64
+	n   uint64
+	ex  *prometheus.Exemplar
+}
+
+// inserter records the observations of a TopK in the background, so that
+// Observe never waits for the lock of the stream.
+type inserter struct {
+	root  *topkRoot
+	queue chan asyncObservation
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func startInserter(root *topkRoot, size int) *inserter {
+	in := &inserter{
+		root:  root,
+		queue: make(chan asyncObservation, size),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go in.run()
+	return in
+}
+
+func (in *inserter) run() {
+	defer close(in.done)
+	for {
+		select {
+		case o := <-in.queue:
+			in.root.streamMtx.Lock()
+			in.root.insert(o.key, o.v, o.n, o.ex)
+			in.drain()
+			in.root.streamMtx.Unlock()
+		case <-in.stop:
+			return
+		}
+	}
+}
+
+// enqueue queues n observations of v, or counts them as overflowed if the
+// queue is full.
+func (in *inserter) enqueue(key string, v float64, n uint64, ex *prometheus.Exemplar) {
+	select {
+	case in.queue <- asyncObservation{key: key, v: v, n: n, ex: ex}:
+	default:
+		in.root.overflowed.Add(n)
+	}
+}
+
+// drain records the queued observations, at most one queue length of them so
+// that a busy queue cannot hold the lock forever.
+// Must be called with streamMtx held.
+func (in *inserter) drain() {
+	for i := cap(in.queue); i > 0; i-- {
+		select {
+		case o := <-in.queue:
+			in.root.insert(o.key, o.v, o.n, o.ex)
+		default:
+			return
+		}
+	}
+}
+
+// close stops the background goroutine. The observations queued afterwards
+// are still recorded whenever the stream is read.
+func (in *inserter) close() {
+	in.closeOnce.Do(func() {
+		close(in.stop)
+		<-in.done
+	})
+}
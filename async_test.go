@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestAsyncQueue(t *testing.T) {
+	k, err := NewTopKWithOptions(metricName, WithLabelNames("key"), WithAsyncQueue(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k.WithLabelValues("a").Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	// after Close, the queued observations are recorded by the next read
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	st := k.Stats()
+	if st.Observations+st.Overflowed != 4000 {
+		t.Errorf("got %d observations and %d overflowed, expected 4000 in total", st.Observations, st.Overflowed)
+	}
+	if count, _, _ := k.Estimate(map[string]string{"key": "a"}); count != float64(st.Observations) {
+		t.Errorf("got count %v, expected %d", count, st.Observations)
+	}
+}
+
+func TestAsyncQueueOverflow(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, AsyncQueueSize: 1}, []string{"key"})
+	k.Close()
+	for i := 0; i < 3; i++ {
+		k.WithLabelValues("a").Inc()
+	}
+	if count, _, _ := k.Estimate(map[string]string{"key": "a"}); count != 1 {
+		t.Errorf("got count %v, expected only the queued observation", count)
+	}
+	if st := k.Stats(); st.Observations != 1 || st.Overflowed != 2 {
+		t.Errorf("got %+v, expected 2 overflowed observations", st)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithAsyncQueue(1), WithShards(2)); err == nil {
+		t.Error("expected error for an asynchronous sharded TopK")
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// auditor keeps the exact count of every key of a TopK with Audit, to export
+// how well the stream estimates them.
+type auditor struct {
+	agreementDesc *prometheus.Desc
+	maxErrorDesc  *prometheus.Desc
+	keysDesc      *prometheus.Desc
+
+	// protected by streamMtx
+	exact map[string]float64
+}
+
+func newAuditor(fqName string, constLabels prometheus.Labels) *auditor {
+	return &auditor{
+		agreementDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_audit_top_keys_agreement", fqName),
+			"Fraction of the true top keys of the TopK, by exact count, that are among its tracked keys.",
+			nil, constLabels),
+		maxErrorDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_audit_max_relative_error", fqName),
+			"Largest relative error of the estimated counts of the true top keys of the TopK.",
+			nil, constLabels),
+		keysDesc: prometheus.NewDesc(
+			fmt.Sprintf("%s_audit_exact_keys", fqName),
+			"Number of distinct keys counted exactly by the audit of the TopK.",
+			nil, constLabels),
+		exact: make(map[string]float64),
+	}
+}
+
+// reset replaces the exact counts with the counts of the tracked keys of s.
+// Must be called with streamMtx held.
+func (a *auditor) reset(s Stream) {
+	a.exact = make(map[string]float64)
+	for _, e := range s.Keys() {
+		a.exact[e.Key] = e.Count
+	}
+}
+
+// merge adds the estimates of the tracked keys of a merged stream to the
+// exact counts. Must be called with streamMtx held.
+func (a *auditor) merge(s Stream) {
+	for _, e := range s.Keys() {
+		a.exact[e.Key] += e.Count
+	}
+}
+
+// delete removes the exact count of a key; a is allowed to be nil.
+// Must be called with streamMtx held.
+func (a *auditor) delete(key string) {
+	if a != nil {
+		delete(a.exact, key)
+	}
+}
+
+func (a *auditor) describe(ch chan<- *prometheus.Desc) {
+	ch <- a.agreementDesc
+	ch <- a.maxErrorDesc
+	ch <- a.keysDesc
+}
+
+// auditStats compares the stream to the exact counts.
+type auditStats struct {
+	agreement, maxError float64
+	keys                int
+}
+
+// stats compares the tracked keys of the stream to the true top keys, as many
+// as the stream tracks. Must be called with streamMtx held.
+func (a *auditor) stats(s Stream) auditStats {
+	type exactCount struct {
+		key   string
+		count float64
+	}
+	top := make([]exactCount, 0, len(a.exact))
+	for key, count := range a.exact {
+		top = append(top, exactCount{key, count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].key < top[j].key
+	})
+	st := auditStats{agreement: 1, keys: len(a.exact)}
+	if n := min(len(top), s.Capacity()); n > 0 {
+		top = top[:n]
+		var agree int
+		for _, c := range top {
+			if s.Monitored(c.key) {
+				agree++
+			}
+			if c.count > 0 {
+				st.maxError = math.Max(st.maxError, math.Abs(s.Estimate(c.key).Count-c.count)/c.count)
+			}
+		}
+		st.agreement = float64(agree) / float64(n)
+	}
+	return st
+}
+
+func (a *auditor) collect(ch chan<- prometheus.Metric, st auditStats) {
+	ch <- prometheus.MustNewConstMetric(a.agreementDesc, prometheus.GaugeValue, st.agreement)
+	ch <- prometheus.MustNewConstMetric(a.maxErrorDesc, prometheus.GaugeValue, st.maxError)
+	ch <- prometheus.MustNewConstMetric(a.keysDesc, prometheus.GaugeValue, float64(st.keys))
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAudit(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Audit: true}, []string{"key"})
+	names := []string{
+		"test_metric_audit_top_keys_agreement",
+		"test_metric_audit_max_relative_error",
+		"test_metric_audit_exact_keys",
+	}
+
+	k.WithLabelValues("a").Add(10)
+	k.WithLabelValues("b").Add(5)
+	expected := `
+# HELP test_metric_audit_exact_keys Number of distinct keys counted exactly by the audit of the TopK.
+# TYPE test_metric_audit_exact_keys gauge
+test_metric_audit_exact_keys 2
+# HELP test_metric_audit_max_relative_error Largest relative error of the estimated counts of the true top keys of the TopK.
+# TYPE test_metric_audit_max_relative_error gauge
+test_metric_audit_max_relative_error 0
+# HELP test_metric_audit_top_keys_agreement Fraction of the true top keys of the TopK, by exact count, that are among its tracked keys.
+# TYPE test_metric_audit_top_keys_agreement gauge
+test_metric_audit_top_keys_agreement 1
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	// c evicts b, then d evicts c, which is still the second key by its
+	// exact count since it ranks before d
+	k.WithLabelValues("c").Add(4)
+	k.WithLabelValues("c").Add(2)
+	k.WithLabelValues("d").Add(4)
+	k.WithLabelValues("d").Add(2)
+	expected = `
+# HELP test_metric_audit_exact_keys Number of distinct keys counted exactly by the audit of the TopK.
+# TYPE test_metric_audit_exact_keys gauge
+test_metric_audit_exact_keys 4
+# HELP test_metric_audit_max_relative_error Largest relative error of the estimated counts of the true top keys of the TopK.
+# TYPE test_metric_audit_max_relative_error gauge
+test_metric_audit_max_relative_error 0
+# HELP test_metric_audit_top_keys_agreement Fraction of the true top keys of the TopK, by exact count, that are among its tracked keys.
+# TYPE test_metric_audit_top_keys_agreement gauge
+test_metric_audit_top_keys_agreement 0.5
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+
+	k.Reset()
+	expected = strings.Replace(strings.Replace(expected, "keys 4", "keys 0", 1), "agreement 0.5", "agreement 1", 1)
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuditMerge(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Audit: true}, []string{"key"})
+	other := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"key"})
+	k.WithLabelValues("a").Add(10)
+	other.WithLabelValues("a").Add(1)
+	other.WithLabelValues("b").Add(3)
+	if err := k.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+
+	// the merged keys are counted by their estimates
+	expected := `
+# HELP test_metric_audit_exact_keys Number of distinct keys counted exactly by the audit of the TopK.
+# TYPE test_metric_audit_exact_keys gauge
+test_metric_audit_exact_keys 2
+# HELP test_metric_audit_max_relative_error Largest relative error of the estimated counts of the true top keys of the TopK.
+# TYPE test_metric_audit_max_relative_error gauge
+test_metric_audit_max_relative_error 0
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected),
+		"test_metric_audit_exact_keys", "test_metric_audit_max_relative_error"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "fmt"
+
+// Observation is a single observation for ObserveBatch.
+type Observation struct {
+	// LabelValues are the values of the free labels, in WithLabelValues
+	// order.
+	LabelValues []string
+	Value       float64
+}
+
+// ObserveMap records an observation of every value in values, keyed by the
+// value of the only free label, such as counts pre-aggregated in a local map.
+// It returns an error, recording nothing, if the TopK does not have exactly
+// one free label or a label value is invalid.
+//
+// With a single lock acquisition for all the observations, ObserveMap is
+// much cheaper than calling Observe for every key.
+func (r *topkCurry) ObserveMap(values map[string]float64) error {
+	if n := len(r.root.variableLabels) - len(r.curry); n != 1 {
+		return fmt.Errorf("topk: ObserveMap needs exactly one free label, not %d", n)
+	}
+	keys := make([]string, 0, len(values))
+	vals := make([]float64, 0, len(values))
+	for lv, v := range values {
+		composite, err := r.compositeWithLabelValues(lv)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, composite)
+		vals = append(vals, v)
+	}
+	r.root.insertBatch(keys, vals)
+	return nil
+}
+
+// ObserveBatch is ObserveMap for a TopK with any number of free labels. It
+// returns an error, recording nothing, if the label values of an observation
+// are invalid.
+func (r *topkCurry) ObserveBatch(batch []Observation) error {
+	keys := make([]string, len(batch))
+	vals := make([]float64, len(batch))
+	for i, o := range batch {
+		composite, err := r.compositeWithLabelValues(o.LabelValues...)
+		if err != nil {
+			return err
+		}
+		keys[i] = composite
+		vals[i] = o.Value
+	}
+	r.root.insertBatch(keys, vals)
+	return nil
+}
+
+// insertBatch records an observation of every key, applying the ValuePolicy
+// to the values. The observations bypass the shards and the queue of
+// AsyncQueueSize, since they only take the lock once.
+func (r *topkRoot) insertBatch(keys []string, vals []float64) {
+	r.streamMtx.Lock()
+	defer r.streamMtx.Unlock()
+	for i, key := range keys {
+		v, ok := r.valuePolicy.apply(vals[i])
+		if !ok {
+			r.dropped.Add(1)
+			continue
+		}
+		r.insert(key, v, 1, nil)
+	}
+}
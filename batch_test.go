@@ -0,0 +1,85 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveMany(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, ValuePolicy: ValuePolicyDrop, CountAndSum: true}, []string{"key"})
+	k.WithLabelValues("a").ObserveMany(2, 50)
+	k.WithLabelValues("a").ObserveMany(math.NaN(), 5)
+	k.WithLabelValues("a").ObserveMany(3, 0)
+
+	if count, _, _ := k.Estimate(prometheus.Labels{"key": "a"}); count != 100 {
+		t.Errorf("got count %v, expected 100", count)
+	}
+	if st := k.Stats(); st.Observations != 50 || st.Dropped != 5 {
+		t.Errorf("got %+v, expected 50 observations and 5 dropped", st)
+	}
+	if err := testutil.CollectAndCompare(k, strings.NewReader(`
+# HELP test_metric_count 
+# TYPE test_metric_count counter
+test_metric_count{key="a"} 50
+# HELP test_metric_sum 
+# TYPE test_metric_sum counter
+test_metric_sum{key="a"} 100
+`), metricName+"_count", metricName+"_sum"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestObserveMap(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, ValuePolicy: ValuePolicyDrop}, []string{"a", "b"})
+	if err := k.ObserveMap(map[string]float64{"x": 1}); err == nil {
+		t.Error("expected error for two free labels")
+	}
+
+	c := k.MustCurryWithLabelValues("1")
+	if err := c.ObserveMap(map[string]float64{"x": 3, "y": 2, "z": math.NaN()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.ObserveBatch([]Observation{
+		{LabelValues: []string{"1", "x"}, Value: 1},
+		{LabelValues: []string{"2", "x"}, Value: 5},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rank, ok := k.Rank("1", "x"); rank != 2 || !ok {
+		t.Errorf("Rank = %v, %v, expected 2", rank, ok)
+	}
+	if st := k.Stats(); st.Observations != 4 || st.Dropped != 1 {
+		t.Errorf("got %+v, expected 4 observations and 1 dropped", st)
+	}
+
+	if err := k.ObserveBatch([]Observation{
+		{LabelValues: []string{"3", "x"}, Value: 1},
+		{LabelValues: []string{"3"}, Value: 1},
+	}); err == nil {
+		t.Error("expected error for missing label values")
+	}
+	if _, _, tracked := k.Estimate(prometheus.Labels{"a": "3", "b": "x"}); tracked {
+		t.Error("a failed batch should record nothing")
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// binaryFormatVersion is the first byte of the MarshalBinary encoding.
+const binaryFormatVersion = 1
+
+// errCustomStream is returned when the state of a TopK with a custom Stream
+// would have to be encoded or replaced.
+var errCustomStream = errors.New("topk: the state of a TopK with NewStream cannot be encoded or replaced")
+
+type binarySnapshot struct {
+	LabelNames []string
+	Stream     *tk.Stream
+
+	// set instead of Stream if the TopK is partitioned, by partition key
+	PartitionLabels []string
+	Partitions      map[string]*tk.Stream
+
+	// the id of the hash function, empty for SipHash
+	Hash string
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the tracked keys
+// with their counts and errors, and the error estimates of the keys that are
+// not tracked. Per-key histograms, digests, and exemplars are not included.
+//
+// A curried TopK encodes the state of the whole TopK it was curried from.
+func (r *topkCurry) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(binaryFormatVersion)
+
+	if r.root.customStream != nil {
+		return nil, errCustomStream
+	}
+	snap := binarySnapshot{LabelNames: r.root.variableLabels, Hash: r.root.hash.id()}
+	r.root.rlockStream()
+	defer r.root.streamMtx.RUnlock()
+	switch s := r.root.stream.(type) {
+	case *tk.Stream:
+		snap.Stream = s
+	case *partitionedStream:
+		snap.PartitionLabels = r.root.partitionLabels
+		snap.Partitions = s.parts
+	}
+	if err := gob.NewEncoder(&buf).Encode(snap); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, replacing the state
+// of the whole TopK with the one encoded by MarshalBinary. The encoding must
+// come from a TopK with the same label names, partition labels, number of
+// buckets, and hash function.
+func (r *topkCurry) UnmarshalBinary(data []byte) error {
+	if r.root.customStream != nil {
+		return errCustomStream
+	}
+	if len(data) == 0 || data[0] != binaryFormatVersion {
+		return errors.New("topk: unknown binary encoding version")
+	}
+	var snap binarySnapshot
+	if err := gob.NewDecoder(bytes.NewReader(data[1:])).Decode(&snap); err != nil {
+		return err
+	}
+	if !equalStrings(snap.LabelNames, r.root.variableLabels) {
+		return fmt.Errorf("topk: encoded label names %q do not match %q", snap.LabelNames, r.root.variableLabels)
+	}
+	if !equalStrings(snap.PartitionLabels, r.root.partitionLabels) {
+		return fmt.Errorf("topk: encoded partition labels %q do not match %q", snap.PartitionLabels, r.root.partitionLabels)
+	}
+	if err := r.root.checkHash("binary encoding", snap.Hash); err != nil {
+		return err
+	}
+
+	var (
+		s       Stream
+		streams []*tk.Stream
+	)
+	if len(r.root.partitionLabels) == 0 {
+		if snap.Stream == nil {
+			return errors.New("topk: binary encoding has no stream")
+		}
+		if err := r.root.checkEncodedStream(snap.Stream, nil); err != nil {
+			return err
+		}
+		snap.Stream.SetHash(r.root.hash.hashFunc())
+		s = snap.Stream
+		streams = append(streams, snap.Stream)
+	} else {
+		index, _ := partitionIndex(r.root.variableLabels, r.root.partitionLabels)
+		p := newPartitionedStream(0, index, r.root.hash.hashFunc(), r.root.errorCounters)
+		for pk, ps := range snap.Partitions {
+			if ps == nil {
+				return errors.New("topk: binary encoding has no stream")
+			}
+			if err := r.root.checkEncodedStream(ps, func(key string) bool { return p.partitionKey(key) == pk }); err != nil {
+				return err
+			}
+			p.addPartition(pk, ps)
+			streams = append(streams, ps)
+		}
+		s = p
+	}
+
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+	for _, es := range streams {
+		if err := r.root.checkBuckets("encoded stream", es.Capacity()); err != nil {
+			return err
+		}
+	}
+	if p, ok := s.(*partitionedStream); ok {
+		p.n = r.root.buckets
+	}
+	r.root.setStream(s)
+	return nil
+}
+
+// checkBuckets returns an error if n is not the number of buckets.
+// Must be called with streamMtx held.
+func (r *topkRoot) checkBuckets(what string, n int) error {
+	if n != r.buckets {
+		return fmt.Errorf("topk: %s has %d buckets, expected %d", what, n, r.buckets)
+	}
+	return nil
+}
+
+// checkEncodedStream checks the keys of a decoded stream. If inPartition is
+// not nil, it must return true for every key.
+func (r *topkRoot) checkEncodedStream(s *tk.Stream, inPartition func(key string) bool) error {
+	wantSeps := len(r.variableLabels)
+	var badKey error
+	s.Range(func(e tk.Element) bool {
+		if strings.Count(e.Key, labelParseSplit) != wantSeps || !strings.HasSuffix(e.Key, labelParseSplit) ||
+			(inPartition != nil && !inPartition(e.Key)) {
+			badKey = fmt.Errorf("topk: malformed key %q in binary encoding", e.Key)
+			return false
+		}
+		return true
+	})
+	return badKey
+}
+
+// setStream replaces the stream, discarding all per-key state.
+// Must be called with streamMtx held.
+func (r *topkRoot) setStream(s Stream) {
+	s.OnEvict(func(key string) {
+		r.evictions.Add(1)
+		delete(r.keyState, key)
+		delete(r.lastSeen, key)
+	})
+	r.stream = s
+	if r.decay != nil {
+		r.decay.start = r.now()
+	}
+	if r.keyState != nil {
+		r.keyState = make(map[string]*keyState)
+	}
+	if r.lastSeen != nil {
+		r.lastSeen = make(map[string]time.Time)
+	}
+	if r.audit != nil {
+		r.audit.reset(s)
+	}
+	r.shareAlert.reset(s)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(1)
+
+	data, err := k.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+	restored.WithLabelValues("3", "z").Add(100)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+	// the error estimates of untracked keys are restored too
+	if count, _, _ := restored.Estimate(map[string]string{"a": "2", "b": "x"}); count != 1 {
+		t.Errorf("wrong estimate for untracked key: %v", count)
+	}
+
+	// the restored stream keeps working
+	restored.WithLabelValues("2", "x").Add(10)
+	if rank, ok := restored.Rank("2", "x"); rank != 1 || !ok {
+		t.Errorf("Rank = %v, %v after restore", rank, ok)
+	}
+
+	for _, other := range []TopK{
+		NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a"}),
+		NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"}),
+	} {
+		if err := other.UnmarshalBinary(data); err == nil {
+			t.Error("expected error for mismatched TopK")
+		}
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)/2]); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultBudgetInterval = 10 * time.Second
+
+// BudgetOpts configures a MemoryBudget.
+type BudgetOpts struct {
+	// Bytes is the total memory budget of the TopKs, as measured by
+	// EstimateMemory.
+	Bytes uint64
+	// Interval is the time between rebalancings; the default is 10 seconds.
+	Interval time.Duration
+	// MinBuckets is the number of buckets below which a TopK is never
+	// shrunk; the default is 1.
+	MinBuckets uint64
+}
+
+// A MemoryBudget keeps the total memory used by a set of TopKs under a budget,
+// by periodically changing their number of buckets with SetBuckets.
+//
+// When the TopKs use more than the budget, the ones with the fewest
+// observations since the last rebalancing are shrunk first, down to
+// MinBuckets if needed. When there is memory to spare, the TopKs that were
+// shrunk are grown back towards the number of buckets they had when added,
+// the busiest ones first, by at most doubling them at a time.
+type MemoryBudget struct {
+	opts BudgetOpts
+
+	mtx     sync.Mutex
+	members []*budgetMember
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type budgetMember struct {
+	t TopK
+	// the number of buckets when added
+	maxBuckets uint64
+	// the observation count at the last rebalancing
+	lastObservations uint64
+}
+
+// NewMemoryBudget starts a MemoryBudget. Call Close to stop it.
+func NewMemoryBudget(opts BudgetOpts) (*MemoryBudget, error) {
+	if opts.Bytes == 0 {
+		return nil, errors.New("topk: memory budget has no bytes")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultBudgetInterval
+	}
+	if opts.MinBuckets == 0 {
+		opts.MinBuckets = 1
+	}
+	b := &MemoryBudget{
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *MemoryBudget) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Rebalance()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add puts t under the budget. Its current number of buckets is the most it
+// will be grown to.
+func (b *MemoryBudget) Add(t TopK) {
+	st := t.Stats()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.members = append(b.members, &budgetMember{
+		t:                t,
+		maxBuckets:       uint64(st.Buckets),
+		lastObservations: st.Observations,
+	})
+}
+
+// Remove takes t out of the budget, restoring the number of buckets it had
+// when added. It returns false if t was not added.
+func (b *MemoryBudget) Remove(t TopK) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for i, m := range b.members {
+		if m.t == t {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			m.t.SetBuckets(m.maxBuckets)
+			return true
+		}
+	}
+	return false
+}
+
+// Usage returns the current memory used by the TopKs, in bytes.
+func (b *MemoryBudget) Usage() uint64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	var total uint64
+	for _, m := range b.members {
+		total += m.t.EstimateMemory()
+	}
+	return total
+}
+
+// Rebalance changes the number of buckets of the TopKs to fit the budget. It
+// is called every Interval, but can be called directly.
+func (b *MemoryBudget) Rebalance() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	type usage struct {
+		m        *budgetMember
+		buckets  uint64
+		memory   uint64
+		activity uint64
+	}
+	usages := make([]usage, len(b.members))
+	var total uint64
+	for i, m := range b.members {
+		st := m.t.Stats()
+		usages[i] = usage{
+			m:        m,
+			buckets:  uint64(st.Buckets),
+			memory:   m.t.EstimateMemory(),
+			activity: st.Observations - m.lastObservations,
+		}
+		m.lastObservations = st.Observations
+		total += usages[i].memory
+	}
+	// least active first
+	sort.SliceStable(usages, func(i, j int) bool {
+		return usages[i].activity < usages[j].activity
+	})
+
+	if total > b.opts.Bytes {
+		for _, u := range usages {
+			// measure again after every change, since the memory per
+			// bucket is only an estimate
+			for total > b.opts.Bytes && u.buckets > b.opts.MinBuckets {
+				perBucket := max(u.memory/u.buckets, 1)
+				shrink := (total - b.opts.Bytes + perBucket - 1) / perBucket
+				shrink = max(min(shrink, u.buckets-b.opts.MinBuckets), 1)
+				u.buckets -= shrink
+				u.m.t.SetBuckets(u.buckets)
+				memory := u.m.t.EstimateMemory()
+				total = total - u.memory + memory
+				u.memory = memory
+			}
+		}
+		return
+	}
+
+	for i := len(usages) - 1; i >= 0; i-- {
+		u := usages[i]
+		if u.buckets >= u.m.maxBuckets {
+			continue
+		}
+		perBucket := max(u.memory/u.buckets, 1)
+		grow := min((b.opts.Bytes-total)/perBucket, u.m.maxBuckets-u.buckets, u.buckets)
+		if grow == 0 {
+			continue
+		}
+		u.m.t.SetBuckets(u.buckets + grow)
+		total += grow * perBucket
+	}
+}
+
+// Close stops the rebalancing. The TopKs keep their current number of
+// buckets.
+func (b *MemoryBudget) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return nil
+}
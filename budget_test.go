@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func fillTopK(k TopK, keys, observations int) {
+	for i := 0; i < observations; i++ {
+		k.WithLabelValues(fmt.Sprintf("key-%04d", i%keys)).Inc()
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	busy := NewTopK(TopKOpts{Name: "busy", Buckets: 200}, []string{"key"})
+	quiet := NewTopK(TopKOpts{Name: "quiet", Buckets: 200}, []string{"key"})
+	fillTopK(busy, 300, 3000)
+	fillTopK(quiet, 300, 300)
+
+	full := busy.EstimateMemory() + quiet.EstimateMemory()
+	b, err := NewMemoryBudget(BudgetOpts{Bytes: full * 3 / 4, Interval: time.Hour, MinBuckets: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.Add(busy)
+	b.Add(quiet)
+
+	fillTopK(busy, 300, 3000)
+	fillTopK(quiet, 300, 300)
+	b.Rebalance()
+	if usage := b.Usage(); usage > full*3/4 {
+		t.Errorf("usage %d is over the budget %d", usage, full*3/4)
+	}
+	if q, bz := quiet.Stats().Buckets, busy.Stats().Buckets; q >= 200 || bz != 200 {
+		t.Errorf("got %d buckets for the quiet TopK and %d for the busy one, expected only the quiet one to shrink", q, bz)
+	}
+
+	// grow back once the busy TopK is gone
+	if !b.Remove(busy) || b.Remove(busy) {
+		t.Error("Remove should succeed exactly once")
+	}
+	b.Rebalance()
+	if n := quiet.Stats().Buckets; n <= 100 {
+		t.Errorf("got %d buckets after removing the busy TopK", n)
+	}
+
+	if _, err := NewMemoryBudget(BudgetOpts{}); err == nil {
+		t.Error("expected error for an empty budget")
+	}
+}
+
+func TestSetBuckets(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 10}, []string{"key"})
+	fillTopK(k, 10, 55)
+	if err := k.SetBuckets(5); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(k.Snapshot()); n != 5 {
+		t.Errorf("got %d keys after shrinking, expected 5", n)
+	}
+	if st := k.Stats(); st.Buckets != 5 || st.Evictions != 5 {
+		t.Errorf("got %+v after shrinking", st)
+	}
+	if err := k.SetBuckets(0); err == nil {
+		t.Error("expected error for zero buckets")
+	}
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := restored.Stats().Buckets; n != 5 {
+		t.Errorf("got %d buckets after restoring, expected 5", n)
+	}
+}
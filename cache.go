@@ -0,0 +1,189 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bucketCache holds the TopKBuckets returned by a TopK, so that asking again
+// for the same key returns the same TopKBucket without validating and
+// encoding the label values again, like the Vecs of the Prometheus client.
+//
+// Unlike a Vec, a TopK is meant for keys of unbounded cardinality, so the
+// cache is emptied whenever it grows to twice the number of buckets; the keys
+// that are asked for often are cached again right away.
+type bucketCache struct {
+	mtx sync.RWMutex
+	// by hash of the label values, with the colliding buckets
+	buckets map[uint64][]*topkWithLabelValues
+	n       int
+}
+
+func newBucketCache() *bucketCache {
+	return &bucketCache{buckets: make(map[uint64][]*topkWithLabelValues)}
+}
+
+// getByLabelValues returns the cached bucket of the free label values, or
+// nil.
+func (c *bucketCache) getByLabelValues(h uint64, lvs []string) *topkWithLabelValues {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, b := range c.buckets[h] {
+		if equalStrings(b.labelValues, lvs) {
+			return b
+		}
+	}
+	return nil
+}
+
+// getByLabels returns the cached bucket of the free labels, or nil.
+func (c *bucketCache) getByLabels(h uint64, names []string, labels prometheus.Labels) *topkWithLabelValues {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	for _, b := range c.buckets[h] {
+		if equalLabelValues(b.labelValues, names, labels) {
+			return b
+		}
+	}
+	return nil
+}
+
+// add caches b, or returns the bucket cached for the same label values in
+// the meantime.
+func (c *bucketCache) add(h uint64, b *topkWithLabelValues, limit int) *topkWithLabelValues {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, cached := range c.buckets[h] {
+		if equalStrings(cached.labelValues, b.labelValues) {
+			return cached
+		}
+	}
+	if c.n >= limit {
+		c.buckets = make(map[uint64][]*topkWithLabelValues)
+		c.n = 0
+	}
+	c.buckets[h] = append(c.buckets[h], b)
+	c.n++
+	return b
+}
+
+func equalLabelValues(lvs, names []string, labels prometheus.Labels) bool {
+	for i, name := range names {
+		if v, ok := labels[name]; !ok || v != lvs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// The hash functions are inlined FNV-1a, as in the Prometheus client, to
+// avoid the allocations of hash/fnv.
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+func hashNew() uint64 {
+	return offset64
+}
+
+func hashAdd(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= prime64
+	}
+	return h
+}
+
+func hashAddByte(h uint64, b byte) uint64 {
+	h ^= uint64(b)
+	h *= prime64
+	return h
+}
+
+func hashLabelValues(lvs []string) uint64 {
+	h := hashNew()
+	for _, v := range lvs {
+		h = hashAdd(h, v)
+		h = hashAddByte(h, 0xFF)
+	}
+	return h
+}
+
+func hashLabels(names []string, labels prometheus.Labels) uint64 {
+	h := hashNew()
+	for _, name := range names {
+		h = hashAdd(h, labels[name])
+		h = hashAddByte(h, 0xFF)
+	}
+	return h
+}
+
+// bucketWithLabelValues returns the cached bucket of the free label values,
+// creating it if needed.
+func (r *topkCurry) bucketWithLabelValues(lvs []string) (*topkWithLabelValues, error) {
+	h := hashLabelValues(lvs)
+	if b := r.children.getByLabelValues(h, lvs); b != nil {
+		return b, nil
+	}
+	composite, err := r.compositeWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	b := &topkWithLabelValues{
+		compositeLabel: intern(composite),
+		root:           r.root,
+		labelValues:    internStrings(lvs),
+	}
+	return r.children.add(h, b, r.root.cacheLimit()), nil
+}
+
+// bucketWithLabels is bucketWithLabelValues for a map of the free labels.
+func (r *topkCurry) bucketWithLabels(labels prometheus.Labels) (*topkWithLabelValues, error) {
+	if len(labels) != len(r.freeNames) {
+		// let compositeWithLabels report the error
+		_, err := r.compositeWithLabels(labels)
+		return nil, err
+	}
+	h := hashLabels(r.freeNames, labels)
+	if b := r.children.getByLabels(h, r.freeNames, labels); b != nil {
+		return b, nil
+	}
+	composite, err := r.compositeWithLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+	lvs := make([]string, len(r.freeNames))
+	for i, name := range r.freeNames {
+		lvs[i] = intern(labels[name])
+	}
+	b := &topkWithLabelValues{
+		compositeLabel: intern(composite),
+		root:           r.root,
+		labelValues:    lvs,
+	}
+	return r.children.add(h, b, r.root.cacheLimit()), nil
+}
+
+// cacheLimit returns the number of buckets at which the caches are emptied.
+func (r *topkRoot) cacheLimit() int {
+	return 2 * int(r.cachedBuckets.Load())
+}
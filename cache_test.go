@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestBucketCache(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+
+	b := k.WithLabelValues("1", "x")
+	if k.WithLabelValues("1", "x") != b {
+		t.Error("WithLabelValues returned a different bucket for the same key")
+	}
+	if got := k.With(prometheus.Labels{"a": "1", "b": "x"}); got != b {
+		t.Error("With returned a different bucket than WithLabelValues")
+	}
+	if k.WithLabelValues("1", "y") == b {
+		t.Error("WithLabelValues returned the same bucket for another key")
+	}
+	if _, err := k.GetMetricWith(prometheus.Labels{"a": "1", "c": "x"}); err == nil {
+		t.Error("expected error for an unknown label")
+	}
+	if _, err := k.GetMetricWithLabelValues("1"); err == nil {
+		t.Error("expected error for a missing label value")
+	}
+	if c := k.MustCurryWithLabelValues("1"); c.WithLabelValues("x") != c.WithLabelValues("x") {
+		t.Error("a curried TopK returned different buckets for the same key")
+	}
+
+	// the cache is emptied at twice the number of buckets
+	for i := 0; i < 4; i++ {
+		k.WithLabelValues("2", strconv.Itoa(i))
+	}
+	if k.WithLabelValues("1", "x") == b {
+		t.Error("the cache was not emptied")
+	}
+	if n := k.(*topkCurry).children.n; n > 4 {
+		t.Errorf("the cache has %d buckets", n)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CounterVec is a TopK with the method set of *prometheus.CounterVec, so that
+// it can replace a high-cardinality CounterVec by only changing the
+// constructor call.
+type CounterVec struct {
+	TopK
+}
+
+// NewCounterVec creates a new CounterVec backed by a TopK.
+func NewCounterVec(opts TopKOpts, labelNames []string) *CounterVec {
+	return &CounterVec{NewTopK(opts, labelNames)}
+}
+
+// CurryWith returns a curried CounterVec; see TopK.
+func (v *CounterVec) CurryWith(labels prometheus.Labels) (*CounterVec, error) {
+	t, err := v.TopK.CurryWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterVec{t}, nil
+}
+
+// MustCurryWith works as CurryWith but panics where CurryWith would have
+// returned an error.
+func (v *CounterVec) MustCurryWith(labels prometheus.Labels) *CounterVec {
+	return &CounterVec{v.TopK.MustCurryWith(labels)}
+}
+
+// CurryWithLabelValues returns a curried CounterVec; see TopK.
+func (v *CounterVec) CurryWithLabelValues(lvs ...string) (*CounterVec, error) {
+	t, err := v.TopK.CurryWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterVec{t}, nil
+}
+
+// MustCurryWithLabelValues works as CurryWithLabelValues but panics where
+// CurryWithLabelValues would have returned an error.
+func (v *CounterVec) MustCurryWithLabelValues(lvs ...string) *CounterVec {
+	return &CounterVec{v.TopK.MustCurryWithLabelValues(lvs...)}
+}
+
+// GetMetricWith returns the Counter for the given labels.
+func (v *CounterVec) GetMetricWith(labels prometheus.Labels) (prometheus.Counter, error) {
+	b, err := v.TopK.GetMetricWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// GetMetricWithLabelValues returns the Counter for the given label values.
+func (v *CounterVec) GetMetricWithLabelValues(lvs ...string) (prometheus.Counter, error) {
+	b, err := v.TopK.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// With works as GetMetricWith, but panics where GetMetricWith would have
+// returned an error.
+func (v *CounterVec) With(labels prometheus.Labels) prometheus.Counter {
+	return v.TopK.With(labels)
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error.
+func (v *CounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	return v.TopK.WithLabelValues(lvs...)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// counterVec is the method set of *prometheus.CounterVec used by the tests.
+type counterVec interface {
+	prometheus.Collector
+	With(prometheus.Labels) prometheus.Counter
+	WithLabelValues(...string) prometheus.Counter
+	Delete(prometheus.Labels) bool
+	DeleteLabelValues(...string) bool
+	DeletePartialMatch(prometheus.Labels) int
+	Reset()
+}
+
+var (
+	_ counterVec = &prometheus.CounterVec{}
+	_ counterVec = &CounterVec{}
+)
+
+func TestCounterVec(t *testing.T) {
+	v := NewCounterVec(TopKOpts{Name: metricName, Buckets: 10}, []string{"a", "b"})
+
+	v.WithLabelValues("1", "x").Inc()
+	v.With(prometheus.Labels{"a": "1", "b": "y"}).Add(2)
+	v.MustCurryWith(prometheus.Labels{"a": "2"}).WithLabelValues("x").Inc()
+	if n := testutil.CollectAndCount(v, metricName); n != 3 {
+		t.Fatalf("got %d series, expected 3", n)
+	}
+
+	if !v.DeleteLabelValues("1", "x") || v.Delete(prometheus.Labels{"a": "1", "b": "x"}) {
+		t.Error("Delete should succeed exactly once")
+	}
+	if n := testutil.CollectAndCount(v, metricName); n != 2 {
+		t.Errorf("got %d series after Delete, expected 2", n)
+	}
+
+	v.WithLabelValues("1", "x").Inc()
+	if n := v.MustCurryWith(prometheus.Labels{"b": "x"}).DeletePartialMatch(prometheus.Labels{"a": "1"}); n != 1 {
+		t.Errorf("DeletePartialMatch deleted %d, expected 1", n)
+	}
+	if n := v.DeletePartialMatch(prometheus.Labels{"b": "x"}); n != 1 {
+		t.Errorf("DeletePartialMatch deleted %d, expected 1", n)
+	}
+	if got := testutil.ToFloat64(v.WithLabelValues("1", "y")); got != 2 {
+		t.Errorf("wrong value for remaining key: %v", got)
+	}
+
+	v.Reset()
+	if n := testutil.CollectAndCount(v, metricName); n != 0 {
+		t.Errorf("got %d series after Reset, expected 0", n)
+	}
+}
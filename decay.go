@@ -0,0 +1,81 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"time"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// maxDecayWeight is the weight of an observation above which the observer
+// rescales the stream, so that the weights cannot overflow even if the
+// maintainer has not run for 20 half-lives.
+const maxDecayWeight = 1 << 20
+
+// decay weights the observations of a TopK by their recency: with forward
+// decay, an observation made at t counts 2^((t-start)/halfLife) times as much
+// as one made at start. The stream is rescaled to start = now before it is
+// read, so that its counts are the exponentially decayed sums at that time,
+// and by the maintainer, so that the weights stay small.
+type decay struct {
+	halfLife float64 // seconds
+
+	// protected by streamMtx
+	start time.Time
+}
+
+func newDecay(halfLife time.Duration, start time.Time) *decay {
+	return &decay{halfLife: halfLife.Seconds(), start: start}
+}
+
+// weight returns the weight of an observation made at t.
+func (d *decay) weight(t time.Time) float64 {
+	return math.Exp2(t.Sub(d.start).Seconds() / d.halfLife)
+}
+
+// decayWeight returns the weight of an observation made now, rescaling the
+// stream if it is too large. Must be called with streamMtx held.
+func (r *topkRoot) decayWeight() float64 {
+	now := r.now()
+	w := r.decay.weight(now)
+	if w > maxDecayWeight {
+		r.rescale(now)
+		w = 1
+	}
+	return w
+}
+
+// rescale divides the counts of the stream by the weight of an observation
+// made at now, which becomes the start. Must be called with streamMtx held.
+func (r *topkRoot) rescale(now time.Time) {
+	f := 1 / r.decay.weight(now)
+	switch s := r.stream.(type) {
+	case *tk.Stream:
+		s.Scale(f)
+	case *partitionedStream:
+		for _, ps := range s.parts {
+			ps.Scale(f)
+		}
+	}
+	if r.shareAlert != nil {
+		r.shareAlert.total *= f
+	}
+	r.decay.start = now
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHalfLife(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 2, HalfLife: time.Hour, ReportingThreshold: 0.5,
+		Now: func() time.Time { return now },
+	}, []string{"key"})
+	defer k.Close()
+
+	estimate := func(key string) float64 {
+		count, _, _ := k.Estimate(map[string]string{"key": key})
+		return count
+	}
+	k.WithLabelValues("old").Add(8)
+	now = now.Add(time.Hour)
+	k.WithLabelValues("new").Add(4)
+	if got := estimate("old"); got != 4 {
+		t.Errorf("old key: got %v expected 4 after a half-life", got)
+	}
+	now = now.Add(time.Hour)
+	k.WithLabelValues("new").Add(4)
+	if old, new := estimate("old"), estimate("new"); old != 2 || new != 6 {
+		t.Errorf("got old=%v new=%v expected 2 and 6", old, new)
+	}
+
+	// the weights are rescaled before they overflow
+	now = now.Add(40 * time.Hour)
+	k.WithLabelValues("new").Add(1)
+	if got, want := estimate("new"), 1+6*math.Exp2(-40); math.Abs(got-want) > 1e-9 {
+		t.Errorf("new key: got %v expected %v", got, want)
+	}
+
+	expected := `
+# HELP test_metric 
+# TYPE test_metric gauge
+test_metric{key="new"} 1.000000000005457
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+}
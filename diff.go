@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SnapshotDiff is the change between two snapshots of a TopK, as returned by
+// Diff.
+type SnapshotDiff struct {
+	// Entered are the elements of the new snapshot whose keys are not in
+	// the old one, in the order of the new snapshot.
+	Entered []Element `json:"entered"`
+	// Left are the elements of the old snapshot whose keys are not in the
+	// new one, in the order of the old snapshot.
+	Left []Element `json:"left"`
+	// Changed are the keys of both snapshots, in the order of the new
+	// snapshot, even if their counts did not change.
+	Changed []ElementChange `json:"changed"`
+}
+
+// ElementChange is the change of a key between two snapshots.
+type ElementChange struct {
+	// Labels are the label values of the key.
+	Labels prometheus.Labels `json:"labels"`
+
+	// OldCount and NewCount are the counts of the key in the old and new
+	// snapshots, and Delta is NewCount-OldCount. Since the counts are
+	// estimates, the true change is only known to be within the Error of
+	// the new element.
+	OldCount float64 `json:"old_count"`
+	NewCount float64 `json:"new_count"`
+	Delta    float64 `json:"delta"`
+	// OldRank and NewRank are the 1-based positions of the key in the old
+	// and new snapshots.
+	OldRank int `json:"old_rank"`
+	NewRank int `json:"new_rank"`
+}
+
+// Diff compares two snapshots of a TopK, such as ones taken a few minutes
+// apart, returning the keys that entered and left the tracked keys, and the
+// change of the others. old is the old snapshot and cur the new one; both
+// must be ordered by decreasing count, like the ones of Snapshot and TopN.
+func Diff(old, cur []Element) SnapshotDiff {
+	oldIndex := make(map[string]int, len(old))
+	for i, e := range old {
+		oldIndex[labelsKey(e.Labels)] = i
+	}
+	var d SnapshotDiff
+	for i, e := range cur {
+		key := labelsKey(e.Labels)
+		j, ok := oldIndex[key]
+		if !ok {
+			d.Entered = append(d.Entered, e)
+			continue
+		}
+		delete(oldIndex, key)
+		d.Changed = append(d.Changed, ElementChange{
+			Labels:   e.Labels,
+			OldCount: old[j].Count,
+			NewCount: e.Count,
+			Delta:    e.Count - old[j].Count,
+			OldRank:  j + 1,
+			NewRank:  i + 1,
+		})
+	}
+	left := make([]int, 0, len(oldIndex))
+	for _, j := range oldIndex {
+		left = append(left, j)
+	}
+	sort.Ints(left)
+	for _, j := range left {
+		d.Left = append(d.Left, old[j])
+	}
+	return d
+}
+
+// labelsKey joins the sorted names and values of labels into a key.
+func labelsKey(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, name, labels[name])
+	}
+	return compositeKey(pairs)
+}
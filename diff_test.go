@@ -0,0 +1,56 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDiff(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(1)
+	old := k.Snapshot()
+
+	k.WithLabelValues("1", "y").Add(4)
+	// evicts 2,x
+	k.WithLabelValues("3", "z").Add(2)
+	d := Diff(old, k.Snapshot())
+
+	if len(d.Entered) != 1 || d.Entered[0].Labels["a"] != "3" || d.Entered[0].Count != 2 {
+		t.Errorf("got entered %v", d.Entered)
+	}
+	if len(d.Left) != 1 || d.Left[0].Labels["a"] != "2" {
+		t.Errorf("got left %v", d.Left)
+	}
+	want := []ElementChange{
+		{Labels: prometheus.Labels{"a": "1", "b": "y"}, OldCount: 3, NewCount: 7, Delta: 4, OldRank: 2, NewRank: 1},
+		{Labels: prometheus.Labels{"a": "1", "b": "x"}, OldCount: 5, NewCount: 5, Delta: 0, OldRank: 1, NewRank: 2},
+	}
+	if !reflect.DeepEqual(d.Changed, want) {
+		t.Errorf("got changed %+v expected %+v", d.Changed, want)
+	}
+
+	if d := Diff(old, old); len(d.Entered) != 0 || len(d.Left) != 0 || len(d.Changed) != len(old) {
+		t.Errorf("got %+v comparing a snapshot with itself", d)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Dump writes a table of the tracked keys visible through this TopK, in the
+// order of Snapshot, with their counts and the bounds of their true counts,
+// for logs and debugging. The keys that are certainly among the true top keys
+// are marked with a "*". The format is not stable.
+func (r *topkCurry) Dump(w io.Writer) error {
+	elts := r.Snapshot()
+	r.root.streamMtx.RLock()
+	buckets := r.root.buckets
+	r.root.streamMtx.RUnlock()
+	if _, err := fmt.Fprintf(w, "%s: %d keys, %d buckets\n", r.root.fqName, len(elts), buckets); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tCOUNT\tERROR\tBOUNDS\tLABELS")
+	for i, e := range elts {
+		rank := strconv.Itoa(i + 1)
+		if e.Guaranteed {
+			rank += "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t[%s, %s]\t%s\n", rank,
+			formatFloat(e.Count), formatFloat(e.Error), formatFloat(e.Count-e.Error), formatFloat(e.Count),
+			r.root.formatLabels(e))
+	}
+	return tw.Flush()
+}
+
+// String returns the table written by Dump.
+func (r *topkCurry) String() string {
+	var sb strings.Builder
+	r.Dump(&sb)
+	return sb.String()
+}
+
+// formatLabels formats the labels of e in the order of the label names.
+func (r *topkRoot) formatLabels(e Element) string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range r.variableLabels {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=%q", name, e.Labels[name])
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,38 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "\"z\"").Add(4)
+
+	expected := `test_metric: 2 keys, 2 buckets
+RANK  COUNT  ERROR  BOUNDS  LABELS
+1*    5      0      [5, 5]  {a="1",b="x"}
+2*    4      0      [4, 4]  {a="2",b="\"z\""}
+`
+	if got := k.String(); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sort"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// exactStream is the Stream of a Deterministic TopK: it counts every key
+// exactly, so that its top keys only depend on the observations, and ranks
+// the keys with the same count by key.
+type exactStream struct {
+	n      int
+	counts map[string]float64
+}
+
+var _ Stream = &exactStream{}
+
+func newExactStream(n int) Stream {
+	return &exactStream{n: n, counts: make(map[string]float64)}
+}
+
+func (s *exactStream) Insert(key string, count float64) tk.Element {
+	s.counts[key] += count
+	return tk.Element{Key: key, Count: s.counts[key]}
+}
+
+func (s *exactStream) Set(key string, count float64) tk.Element {
+	s.counts[key] = count
+	return tk.Element{Key: key, Count: count}
+}
+
+func (s *exactStream) Estimate(key string) tk.Element {
+	return tk.Element{Key: key, Count: s.counts[key]}
+}
+
+// Monitored reports whether key has a count, even if it is not among the top
+// keys, so that its per-key state is kept.
+func (s *exactStream) Monitored(key string) bool {
+	_, ok := s.counts[key]
+	return ok
+}
+
+func (s *exactStream) Remove(key string) bool {
+	_, ok := s.counts[key]
+	delete(s.counts, key)
+	return ok
+}
+
+func (s *exactStream) Reset() {
+	s.counts = make(map[string]float64)
+}
+
+// Keys returns the top Capacity keys, ordered by decreasing count, then by
+// key.
+func (s *exactStream) Keys() []tk.Element {
+	elts := make([]tk.Element, 0, len(s.counts))
+	for key, count := range s.counts {
+		elts = append(elts, tk.Element{Key: key, Count: count})
+	}
+	sort.Slice(elts, func(i, j int) bool {
+		if elts[i].Count != elts[j].Count {
+			return elts[i].Count > elts[j].Count
+		}
+		return elts[i].Key < elts[j].Key
+	})
+	if len(elts) > s.n {
+		elts = elts[:s.n]
+	}
+	return elts
+}
+
+// Range visits the top keys in the order of Keys.
+func (s *exactStream) Range(f func(tk.Element) bool) {
+	for _, e := range s.Keys() {
+		if !f(e) {
+			return
+		}
+	}
+}
+
+// Total returns the sum of the counts of all keys.
+func (s *exactStream) Total() float64 {
+	var total float64
+	for _, count := range s.counts {
+		total += count
+	}
+	return total
+}
+
+func (s *exactStream) Capacity() int {
+	return s.n
+}
+
+func (s *exactStream) Resize(n int) {
+	s.n = n
+}
+
+// OnEvict does nothing, since the keys are never evicted.
+func (s *exactStream) OnEvict(func(key string)) {}
@@ -0,0 +1,59 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeterministic(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Deterministic: true}, []string{"key"})
+	for _, key := range []string{"c", "b", "a"} {
+		k.WithLabelValues(key).Inc()
+	}
+	for i := 0; i < 100; i++ {
+		k.WithLabelValues(strconv.Itoa(i)).Add(0.5)
+	}
+	k.WithLabelValues("c").Add(2)
+
+	// the ties are broken by key, and the counts have no error
+	expected := `
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="a"} 1
+test_metric{key="c"} 3
+# HELP test_metric_error Estimation error bound for test_metric.
+# TYPE test_metric_error gauge
+test_metric_error{key="a"} 0
+test_metric_error{key="c"} 0
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName, metricName+"_error"); err != nil {
+		t.Error(err)
+	}
+	if count, err, _ := k.Estimate(map[string]string{"key": "42"}); count != 0.5 || err != 0 {
+		t.Errorf("got %v±%v for an untracked key, expected exactly 0.5", count, err)
+	}
+
+	if err := (&TopKOpts{Name: metricName, Deterministic: true, SamplingFactor: 10}).validate(nil); err == nil {
+		t.Error("expected error for a sampled Deterministic TopK")
+	}
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// newExemplar validates the exemplar labels the same way the Prometheus client
+// does, so that an invalid exemplar is reported when it is observed instead of
+// breaking the collection later.
+func newExemplar(v float64, labels prometheus.Labels, ts time.Time) (*prometheus.Exemplar, error) {
+	var runes int
+	copied := make(prometheus.Labels, len(labels))
+	for name, value := range labels {
+		if !model.UTF8Validation.IsValidLabelName(name) || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("exemplar label name %q is invalid", name)
+		}
+		if !utf8.ValidString(value) {
+			return nil, fmt.Errorf("exemplar label value %q is not valid UTF-8", value)
+		}
+		runes += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		copied[name] = value
+	}
+	if runes > prometheus.ExemplarMaxRunes {
+		return nil, fmt.Errorf("exemplar labels have %d runes, exceeding the limit of %d", runes, prometheus.ExemplarMaxRunes)
+	}
+	return &prometheus.Exemplar{Value: v, Labels: copied, Timestamp: ts}, nil
+}
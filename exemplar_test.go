@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestObserveWithExemplar(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{
+		Name:                        metricName,
+		Buckets:                     3,
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+
+	k.WithLabelValues("a").ObserveWithExemplar(1, prometheus.Labels{"trace_id": "abc"})
+	k.WithLabelValues("a").ObserveWithExemplar(2, prometheus.Labels{"trace_id": "def"})
+	k.WithLabelValues("b").Observe(1)
+
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range mets {
+		for _, m := range v.Metric {
+			key := m.Label[0].GetValue()
+			switch v.GetName() {
+			case metricName:
+				ex := m.GetCounter().GetExemplar()
+				if key == "b" && ex != nil {
+					t.Errorf("unexpected exemplar on b: %v", ex)
+				}
+				if key == "a" && (ex == nil || ex.GetValue() != 2 || ex.Label[0].GetValue() != "def") {
+					t.Errorf("wrong exemplar on a: %v", ex)
+				}
+			case metricName + "_histogram":
+				if key == "a" && len(m.GetHistogram().Exemplars) == 0 {
+					t.Error("histogram is missing exemplars")
+				}
+			}
+		}
+	}
+}
+
+func TestExemplarOnGauge(t *testing.T) {
+	for name, opts := range map[string]TopKOpts{
+		"half life": {HalfLife: time.Hour},
+		"decrement": {DecrementPolicy: DecrementPolicyTracked},
+		"last":      {Mode: ModeLast},
+		"max":       {Mode: ModeMax},
+	} {
+		opts.Name = metricName
+		opts.Buckets = 3
+		k := NewTopK(opts, []string{"key"})
+		k.WithLabelValues("a").ObserveWithExemplar(1, prometheus.Labels{"trace_id": "abc"})
+
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(k)
+		mets, err := reg.Gather()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(mets) == 0 || mets[0].GetName() != metricName || len(mets[0].Metric) != 1 {
+			t.Errorf("%s: count not exported: %v", name, mets)
+		}
+		k.Close()
+	}
+}
+
+func TestInvalidExemplar(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"key"})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for oversized exemplar")
+		}
+	}()
+	k.WithLabelValues("a").ObserveWithExemplar(1, prometheus.Labels{"trace_id": strings.Repeat("x", 200)})
+}
+
+func TestAddWithExemplar(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"key"})
+
+	var adder prometheus.ExemplarAdder = k.WithLabelValues("a")
+	adder.AddWithExemplar(2, prometheus.Labels{"trace_id": "abc"})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for negative value")
+		}
+	}()
+	adder.AddWithExemplar(-1, nil)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"time"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// keyExpiry removes the tracked keys of a TopK that were not observed for its
+// KeyTTL, when maintained.
+type keyExpiry struct {
+	ttl time.Duration
+}
+
+// touch records that key was observed now, if it is tracked. Must be called
+// with streamMtx held.
+func (r *topkRoot) touch(key string) {
+	if r.lastSeen != nil && r.stream.Monitored(key) {
+		r.lastSeen[key] = r.now()
+	}
+}
+
+// expire removes the tracked keys that were not observed for the TTL. The
+// keys tracked without being observed, such as the keys of a restored
+// snapshot, count as observed at their first expire. Must be called with
+// streamMtx held.
+func (r *topkRoot) expire() {
+	now := r.now()
+	var expired []string
+	r.stream.Range(func(e tk.Element) bool {
+		seen, ok := r.lastSeen[e.Key]
+		if !ok {
+			r.lastSeen[e.Key] = now
+		} else if now.Sub(seen) >= r.expiry.ttl {
+			expired = append(expired, e.Key)
+		}
+		return true
+	})
+	for _, key := range expired {
+		r.stream.Remove(key)
+		delete(r.lastSeen, key)
+		delete(r.keyState, key)
+	}
+	r.expired.Add(uint64(len(expired)))
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 3, KeyTTL: time.Hour,
+		Now: func() time.Time { return now },
+	}, []string{"key"})
+	defer k.Close()
+
+	keys := func() []string {
+		var out []string
+		for _, e := range k.Snapshot() {
+			out = append(out, e.Labels["key"])
+		}
+		return out
+	}
+	k.WithLabelValues("dead").Add(10)
+	k.WithLabelValues("live").Add(1)
+	now = now.Add(30 * time.Minute)
+	k.WithLabelValues("live").Inc()
+	now = now.Add(31 * time.Minute)
+	if got := keys(); len(got) != 1 || got[0] != "live" {
+		t.Errorf("got keys %v after expiry, expected [live]", got)
+	}
+	if st := k.Stats(); st.Expired != 1 {
+		t.Errorf("got %d expired keys expected 1", st.Expired)
+	}
+
+	// restored keys were never observed, so they expire a TTL after the
+	// first read
+	snap := k.SnapshotProto()
+	if err := k.RestoreSnapshotProto(snap); err != nil {
+		t.Fatal(err)
+	}
+	if got := keys(); len(got) != 1 {
+		t.Errorf("got keys %v after restore, expected [live]", got)
+	}
+	now = now.Add(time.Hour)
+	if got := keys(); len(got) != 0 {
+		t.Errorf("got keys %v expected none", got)
+	}
+}
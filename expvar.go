@@ -0,0 +1,41 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "expvar"
+
+type expvarVar struct {
+	t TopK
+}
+
+// ExpvarVar returns an expvar.Var holding the current Snapshot of t, encoded
+// as by MarshalJSON. Publish it to serve the top-K under /debug/vars:
+//
+//	expvar.Publish("requests_by_user", topk.ExpvarVar(t))
+func ExpvarVar(t TopK) expvar.Var {
+	return expvarVar{t: t}
+}
+
+// String implements expvar.Var.
+func (v expvarVar) String() string {
+	data, err := v.t.MarshalJSON()
+	if err != nil {
+		return "null"
+	}
+	return string(data)
+}
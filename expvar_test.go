@@ -0,0 +1,48 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"encoding/json"
+	"expvar"
+	"reflect"
+	"testing"
+)
+
+func TestExpvarVar(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a"})
+	k.WithLabelValues("x").Add(5)
+
+	expvar.Publish("topk_test", ExpvarVar(k))
+	var got []Element
+	if err := json.Unmarshal([]byte(expvar.Get("topk_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	// the value is computed on every read
+	k.WithLabelValues("y").Add(1)
+	if err := json.Unmarshal([]byte(expvar.Get("topk_test").String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("stale value: %v", got)
+	}
+}
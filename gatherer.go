@@ -0,0 +1,347 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.yaml.in/yaml/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultOtherValue is the label value used for rolled-up series if a Rule
+// does not set OtherValue.
+const DefaultOtherValue = "other"
+
+// A Rule selects metric families for NewGatherer to reduce.
+//
+// Rules can also be loaded from YAML with ParseRules, using the field names
+// given in the yaml tags.
+type Rule struct {
+	// Name is the name of the metric family to reduce.
+	Name string `yaml:"name,omitempty"`
+
+	// NameRegex selects all metric families whose name matches this
+	// regular expression. Like in Prometheus relabel configs, the regular
+	// expression is anchored at both ends. If both Name and NameRegex are
+	// set, either must match.
+	NameRegex string `yaml:"name_regex,omitempty"`
+
+	// MatchLabels restricts the rule to the series whose label values match
+	// the given regular expressions, anchored at both ends. A missing label
+	// has the empty value. Series that do not match are left alone and do
+	// not count towards K.
+	MatchLabels map[string]string `yaml:"match_labels,omitempty"`
+
+	// K is the number of series to keep, ranked by their value, and must be
+	// positive. For histograms and summaries, the sample count is used.
+	K int `yaml:"keep"`
+
+	// Labels are the high-cardinality label names. The series that are not
+	// kept are summed up into one series for each distinct combination of
+	// the remaining labels, with Labels set to OtherValue. If Labels is
+	// empty, all label values of the rolled-up series are set to OtherValue.
+	Labels []string `yaml:"aggregate_labels,omitempty"`
+
+	// OtherValue is the label value marking rolled-up series. The default
+	// is DefaultOtherValue.
+	OtherValue string `yaml:"other_value,omitempty"`
+}
+
+// ParseRules parses a YAML document with a list of rules under the "rules"
+// key, for example:
+//
+//	rules:
+//	- name_regex: grpc_server_.*
+//	  match_labels:
+//	    grpc_service: my\.pkg\..*
+//	  keep: 20
+//	  aggregate_labels: [grpc_method]
+func ParseRules(data []byte) ([]Rule, error) {
+	var cfg struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Rules {
+		if _, err := cfg.Rules[i].compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return cfg.Rules, nil
+}
+
+type compiledRule struct {
+	*Rule
+	nameRegex   *regexp.Regexp
+	matchLabels map[string]*regexp.Regexp
+}
+
+func anchoredRegexp(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (rule *Rule) compile() (*compiledRule, error) {
+	cr := &compiledRule{Rule: rule}
+	if rule.Name == "" && rule.NameRegex == "" {
+		return nil, fmt.Errorf("topk: rule needs a name or name_regex")
+	}
+	if rule.K <= 0 {
+		return nil, fmt.Errorf("topk: rule keep %d is not positive", rule.K)
+	}
+	if rule.NameRegex != "" {
+		re, err := anchoredRegexp(rule.NameRegex)
+		if err != nil {
+			return nil, err
+		}
+		cr.nameRegex = re
+	}
+	if len(rule.MatchLabels) > 0 {
+		cr.matchLabels = make(map[string]*regexp.Regexp, len(rule.MatchLabels))
+		for name, expr := range rule.MatchLabels {
+			re, err := anchoredRegexp(expr)
+			if err != nil {
+				return nil, fmt.Errorf("label %q: %w", name, err)
+			}
+			cr.matchLabels[name] = re
+		}
+	}
+	return cr, nil
+}
+
+// NewGatherer wraps a Gatherer, reducing the metric families selected by the
+// rules to their top series plus a rollup of the remaining series. This caps
+// the number of series exported by collectors that cannot be changed, such as
+// those of third-party libraries. Only the first rule matching a family is
+// applied.
+//
+// Rolled-up histograms keep only the classic buckets, and rolled-up
+// summaries only keep the sample count and sum. Timestamps and exemplars of
+// rolled-up series are dropped.
+//
+// NewGatherer panics if a rule is invalid; use ParseRules to validate rules
+// from configuration files.
+func NewGatherer(inner prometheus.Gatherer, rules ...Rule) prometheus.Gatherer {
+	g := &gatherer{inner: inner}
+	for i := range rules {
+		cr, err := rules[i].compile()
+		if err != nil {
+			panic(err)
+		}
+		g.rules = append(g.rules, cr)
+	}
+	return g
+}
+
+type gatherer struct {
+	inner prometheus.Gatherer
+	rules []*compiledRule
+}
+
+func (g *gatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.inner.Gather()
+	for _, mf := range mfs {
+		for _, rule := range g.rules {
+			if rule.matchesName(mf.GetName()) {
+				rule.reduce(mf)
+				break
+			}
+		}
+	}
+	return mfs, err
+}
+
+func (rule *compiledRule) matchesName(name string) bool {
+	return name == rule.Name || (rule.nameRegex != nil && rule.nameRegex.MatchString(name))
+}
+
+func (rule *compiledRule) matchesLabels(m *dto.Metric) bool {
+	for name, re := range rule.matchLabels {
+		var val string
+		for _, lp := range m.Label {
+			if lp.GetName() == name {
+				val = lp.GetValue()
+				break
+			}
+		}
+		if !re.MatchString(val) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule *compiledRule) reduce(mf *dto.MetricFamily) {
+	var ms, passed []*dto.Metric
+	for _, m := range mf.Metric {
+		if rule.matchesLabels(m) {
+			ms = append(ms, m)
+		} else {
+			passed = append(passed, m)
+		}
+	}
+	if len(ms) <= rule.K {
+		return
+	}
+	typ := mf.GetType()
+	sort.SliceStable(ms, func(i, j int) bool {
+		return metricValue(typ, ms[i]) > metricValue(typ, ms[j])
+	})
+
+	other := rule.OtherValue
+	if other == "" {
+		other = DefaultOtherValue
+	}
+	rolled := make(map[string]bool, len(rule.Labels))
+	for _, l := range rule.Labels {
+		rolled[l] = true
+	}
+
+	kept := ms[:rule.K]
+	rollups := make(map[string]*dto.Metric)
+	for _, m := range ms[rule.K:] {
+		labels := make([]*dto.LabelPair, len(m.Label))
+		var sig strings.Builder
+		for i, lp := range m.Label {
+			val := lp.GetValue()
+			if len(rolled) == 0 || rolled[lp.GetName()] {
+				val = other
+			}
+			labels[i] = &dto.LabelPair{Name: proto.String(lp.GetName()), Value: proto.String(val)}
+			sig.WriteString(lp.GetName())
+			sig.WriteByte(0xff)
+			sig.WriteString(val)
+			sig.WriteByte(0xff)
+		}
+		dst, ok := rollups[sig.String()]
+		if !ok {
+			dst = &dto.Metric{Label: labels}
+			rollups[sig.String()] = dst
+		}
+		addMetric(typ, dst, m)
+	}
+
+	out := make([]*dto.Metric, 0, len(passed)+len(kept)+len(rollups))
+	for _, m := range append(passed, kept...) {
+		// a kept series might already look like a rollup
+		if dst, ok := rollups[labelSignature(m)]; ok {
+			addMetric(typ, dst, m)
+			continue
+		}
+		out = append(out, m)
+	}
+	for _, m := range rollups {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return labelSignature(out[i]) < labelSignature(out[j])
+	})
+	mf.Metric = out
+}
+
+func labelSignature(m *dto.Metric) string {
+	var sig strings.Builder
+	for _, lp := range m.Label {
+		sig.WriteString(lp.GetName())
+		sig.WriteByte(0xff)
+		sig.WriteString(lp.GetValue())
+		sig.WriteByte(0xff)
+	}
+	return sig.String()
+}
+
+func metricValue(typ dto.MetricType, m *dto.Metric) float64 {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return m.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return m.GetGauge().GetValue()
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		h := m.GetHistogram()
+		if h.SampleCountFloat != nil {
+			return h.GetSampleCountFloat()
+		}
+		return float64(h.GetSampleCount())
+	case dto.MetricType_SUMMARY:
+		return float64(m.GetSummary().GetSampleCount())
+	default:
+		return m.GetUntyped().GetValue()
+	}
+}
+
+// addMetric adds the value of src to the rollup dst.
+func addMetric(typ dto.MetricType, dst, src *dto.Metric) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		if dst.Counter == nil {
+			dst.Counter = &dto.Counter{Value: proto.Float64(0)}
+		}
+		*dst.Counter.Value += src.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		if dst.Gauge == nil {
+			dst.Gauge = &dto.Gauge{Value: proto.Float64(0)}
+		}
+		*dst.Gauge.Value += src.GetGauge().GetValue()
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		addHistogram(dst, src.GetHistogram())
+	case dto.MetricType_SUMMARY:
+		if dst.Summary == nil {
+			dst.Summary = &dto.Summary{SampleCount: proto.Uint64(0), SampleSum: proto.Float64(0)}
+		}
+		*dst.Summary.SampleCount += src.GetSummary().GetSampleCount()
+		*dst.Summary.SampleSum += src.GetSummary().GetSampleSum()
+	default:
+		if dst.Untyped == nil {
+			dst.Untyped = &dto.Untyped{Value: proto.Float64(0)}
+		}
+		*dst.Untyped.Value += src.GetUntyped().GetValue()
+	}
+}
+
+func addHistogram(dst *dto.Metric, src *dto.Histogram) {
+	if dst.Histogram == nil {
+		dst.Histogram = &dto.Histogram{SampleCount: proto.Uint64(0), SampleSum: proto.Float64(0)}
+	}
+	h := dst.Histogram
+	*h.SampleCount += src.GetSampleCount()
+	*h.SampleSum += src.GetSampleSum()
+	if src.SampleCountFloat != nil {
+		h.SampleCountFloat = proto.Float64(h.GetSampleCountFloat() + src.GetSampleCountFloat())
+	}
+
+	for _, b := range src.Bucket {
+		i := sort.Search(len(h.Bucket), func(i int) bool {
+			return h.Bucket[i].GetUpperBound() >= b.GetUpperBound()
+		})
+		if i == len(h.Bucket) || h.Bucket[i].GetUpperBound() != b.GetUpperBound() {
+			h.Bucket = append(h.Bucket, nil)
+			copy(h.Bucket[i+1:], h.Bucket[i:])
+			h.Bucket[i] = &dto.Bucket{UpperBound: proto.Float64(b.GetUpperBound()), CumulativeCount: proto.Uint64(0)}
+		}
+		*h.Bucket[i].CumulativeCount += b.GetCumulativeCount()
+		if b.CumulativeCountFloat != nil {
+			h.Bucket[i].CumulativeCountFloat = proto.Float64(h.Bucket[i].GetCumulativeCountFloat() + b.GetCumulativeCountFloat())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGatherer(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	counters := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "help"}, []string{"path", "code"})
+	hists := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "latency", Help: "help", Buckets: []float64{1, 2}}, []string{"path"})
+	untouched := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "untouched_total", Help: "help"}, []string{"path"})
+	reg.MustRegister(counters, hists, untouched)
+
+	for i := 0; i < 10; i++ {
+		path := fmt.Sprintf("/%d", i)
+		counters.WithLabelValues(path, "200").Add(float64(i))
+		counters.WithLabelValues(path, "500").Add(1)
+		hists.WithLabelValues(path).Observe(float64(i % 3))
+		untouched.WithLabelValues(path).Inc()
+	}
+	hists.WithLabelValues("/9").Observe(0)
+
+	g := NewGatherer(reg,
+		Rule{Name: "requests_total", K: 2, Labels: []string{"path"}},
+		Rule{Name: "latency", K: 1, OtherValue: "rest"},
+	)
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]map[string]float64)
+	for _, mf := range mfs {
+		got[mf.GetName()] = make(map[string]float64)
+		for _, m := range mf.Metric {
+			var key string
+			for _, lp := range m.Label {
+				key += lp.GetName() + "=" + lp.GetValue() + ","
+			}
+			got[mf.GetName()][key] = metricValue(mf.GetType(), m)
+			if h := m.GetHistogram(); h != nil && key == "path=rest," {
+				if len(h.Bucket) != 2 || h.Bucket[0].GetCumulativeCount() != 6 || h.Bucket[1].GetCumulativeCount() != 9 {
+					t.Errorf("wrong rollup buckets: %v", h.Bucket)
+				}
+			}
+		}
+	}
+
+	wantRequests := map[string]float64{
+		"code=200,path=/9,":    9,
+		"code=200,path=/8,":    8,
+		"code=200,path=other,": 0 + 1 + 2 + 3 + 4 + 5 + 6 + 7,
+		"code=500,path=other,": 10,
+	}
+	if fmt.Sprint(got["requests_total"]) != fmt.Sprint(wantRequests) {
+		t.Errorf("wrong requests_total: got %v expected %v", got["requests_total"], wantRequests)
+	}
+	wantLatency := map[string]float64{
+		"path=/9,":   2,
+		"path=rest,": 9,
+	}
+	if fmt.Sprint(got["latency"]) != fmt.Sprint(wantLatency) {
+		t.Errorf("wrong latency: got %v expected %v", got["latency"], wantLatency)
+	}
+	if len(got["untouched_total"]) != 10 {
+		t.Errorf("untouched family was reduced: %v", got["untouched_total"])
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+- name_regex: requests_.*
+  match_labels:
+    code: 2..
+  keep: 1
+  aggregate_labels: [path]
+  other_value: rest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	counters := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests_total", Help: "help"}, []string{"path", "code"})
+	reg.MustRegister(counters)
+	for i := 0; i < 5; i++ {
+		counters.WithLabelValues(fmt.Sprintf("/%d", i), "200").Add(float64(i))
+		counters.WithLabelValues(fmt.Sprintf("/%d", i), "500").Add(1)
+	}
+
+	mfs, err := NewGatherer(reg, rules...).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, m := range mfs[0].Metric {
+		got[m.Label[0].GetValue()+","+m.Label[1].GetValue()] = m.GetCounter().GetValue()
+	}
+	want := map[string]float64{
+		"200,/4":   4,
+		"200,rest": 6,
+		"500,/0":   1,
+		"500,/1":   1,
+		"500,/2":   1,
+		"500,/3":   1,
+		"500,/4":   1,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, doc := range []string{
+		"rules:\n- keep: 1\n",
+		"rules:\n- name_regex: '('\n  keep: 1\n",
+		"rules:\n- name: x\n  match_labels: {a: '['}\n  keep: 1\n",
+		"rules:\n- name: x\n  keep: 0\n",
+		"rules:\n- name: x\n",
+		"rules:\n- name: x\n  kep: 1\n",
+	} {
+		if _, err := ParseRules([]byte(doc)); err == nil {
+			t.Errorf("expected error for %q", doc)
+		}
+	}
+}
+
+func TestNewGathererInvalidRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected NewGatherer to panic for a rule keeping no series")
+		}
+	}()
+	NewGatherer(prometheus.NewRegistry(), Rule{Name: "x"})
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// A Group creates TopKs with shared default options, and operates on all of
+// them at once. A Group is a prometheus.Collector collecting all its TopKs,
+// so registering the Group registers every TopK it creates, including the
+// ones created after registration.
+type Group struct {
+	defaults []Option
+
+	mtx    sync.Mutex
+	byName map[string]TopK
+	order  []string
+}
+
+// NewGroup creates an empty Group. The defaults are applied to every TopK
+// created by the Group, before the options given to New.
+func NewGroup(defaults ...Option) *Group {
+	return &Group{
+		defaults: append([]Option(nil), defaults...),
+		byName:   make(map[string]TopK),
+	}
+}
+
+// New creates a TopK like NewTopKWithOptions, with the defaults of the Group,
+// and adds it to the Group. The fully-qualified names of the TopKs of a Group
+// must be different.
+func (g *Group) New(name string, opts ...Option) (TopK, error) {
+	t, err := NewTopKWithOptions(name, append(append([]Option(nil), g.defaults...), opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	fqName := t.Stats().Name
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if _, dup := g.byName[fqName]; dup {
+		t.Close()
+		return nil, fmt.Errorf("topk: group already has a TopK named %q", fqName)
+	}
+	g.byName[fqName] = t
+	g.order = append(g.order, fqName)
+	return t, nil
+}
+
+// MustNew works as New but panics where New would have returned an error.
+func (g *Group) MustNew(name string, opts ...Option) TopK {
+	t, err := g.New(name, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Get returns the TopK of the Group with the given fully-qualified name.
+func (g *Group) Get(fqName string) (TopK, bool) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	t, ok := g.byName[fqName]
+	return t, ok
+}
+
+// TopKs returns the TopKs of the Group, in the order they were created.
+func (g *Group) TopKs() []TopK {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	ts := make([]TopK, len(g.order))
+	for i, name := range g.order {
+		ts[i] = g.byName[name]
+	}
+	return ts
+}
+
+// Reset resets every TopK of the Group.
+func (g *Group) Reset() {
+	for _, t := range g.TopKs() {
+		t.Reset()
+	}
+}
+
+// Snapshot returns the Snapshot of every TopK of the Group, by
+// fully-qualified name.
+func (g *Group) Snapshot() map[string][]Element {
+	snaps := make(map[string][]Element)
+	for _, t := range g.TopKs() {
+		snaps[t.Stats().Name] = t.Snapshot()
+	}
+	return snaps
+}
+
+// Close closes every TopK of the Group, returning the errors of all of them.
+func (g *Group) Close() error {
+	var errs []error
+	for _, t := range g.TopKs() {
+		if err := t.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Describe implements prometheus.Collector. A Group describes no metrics,
+// making it an unchecked Collector, since TopKs can be added to it after it
+// is registered; use DescribeAll for the Descs of its current TopKs.
+func (g *Group) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (g *Group) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range g.TopKs() {
+		t.Collect(ch)
+	}
+}
+
+// DescribeAll sends the Descs of all the TopKs currently in the Group.
+func (g *Group) DescribeAll(ch chan<- *prometheus.Desc) {
+	for _, t := range g.TopKs() {
+		t.Describe(ch)
+	}
+}
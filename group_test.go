@@ -0,0 +1,77 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGroup(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	g := NewGroup(WithNamespace("svc"), WithBuckets(3))
+	if err := reg.Register(g); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := g.MustNew("paths", WithLabelNames("path"))
+	users := g.MustNew("users", WithLabelNames("user"), WithBuckets(5))
+	if _, err := g.New("paths"); err == nil {
+		t.Error("expected error for a duplicate name")
+	}
+	if st := users.Stats(); st.Name != "svc_users" || st.Buckets != 5 {
+		t.Errorf("got %+v, expected the defaults to be overridden", st)
+	}
+	if got, ok := g.Get("svc_paths"); !ok || got != paths {
+		t.Error("Get did not return the TopK")
+	}
+
+	paths.WithLabelValues("/x").Inc()
+	users.WithLabelValues("alice").Inc()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	if len(names) != 4 {
+		t.Errorf("got metric families %v, expected the counts and errors of both TopKs", names)
+	}
+
+	ch := make(chan *prometheus.Desc, 100)
+	g.DescribeAll(ch)
+	close(ch)
+	if len(ch) < 4 {
+		t.Errorf("DescribeAll sent %d Descs", len(ch))
+	}
+
+	snaps := g.Snapshot()
+	if len(snaps["svc_paths"]) != 1 || len(snaps["svc_users"]) != 1 {
+		t.Errorf("got snapshots %v", snaps)
+	}
+	g.Reset()
+	if n := len(paths.Snapshot()) + len(users.Snapshot()); n != 0 {
+		t.Errorf("got %d keys after Reset", n)
+	}
+	if err := g.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/rand/v2"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// HashFunction is the function hashing the keys of a TopK to the error
+// estimates of the keys it does not track. It is fixed for the lifetime of a
+// TopK: snapshots and encodings can only be restored into, or merged with, a
+// TopK using the same function.
+type HashFunction int
+
+const (
+	// HashSipHash is SipHash-1-3, the default.
+	HashSipHash HashFunction = iota
+	// HashXXHash is xxHash64, faster than SipHash for long keys.
+	HashXXHash
+	// HashMaphash is hash/maphash with a seed chosen at random when the
+	// process starts, the fastest on most platforms. Since the seed is not
+	// saved, the snapshots of a TopK using it can only be restored by the
+	// same process: it cannot be combined with a PersistPath, and its
+	// snapshots cannot be merged by topkmultiproc or topkaggregate.
+	HashMaphash
+)
+
+var (
+	maphashSeed = maphash.MakeSeed()
+	// identifies the seed in snapshots
+	maphashID = fmt.Sprintf("maphash/%016x", rand.Uint64())
+)
+
+func (h HashFunction) String() string {
+	switch h {
+	case HashSipHash:
+		return "siphash"
+	case HashXXHash:
+		return "xxhash"
+	case HashMaphash:
+		return "maphash"
+	}
+	return fmt.Sprintf("HashFunction(%d)", int(h))
+}
+
+// hashFunc returns the function to pass to the streams, nil for the default.
+func (h HashFunction) hashFunc() func(string) uint64 {
+	switch h {
+	case HashXXHash:
+		return xxhash.Sum64String
+	case HashMaphash:
+		return func(key string) uint64 { return maphash.String(maphashSeed, key) }
+	}
+	return nil
+}
+
+// id returns the name of the function recorded in snapshots, empty for the
+// default so that older snapshots keep matching it.
+func (h HashFunction) id() string {
+	switch h {
+	case HashSipHash:
+		return ""
+	case HashMaphash:
+		return maphashID
+	}
+	return h.String()
+}
+
+// hashFromID returns the function with the given snapshot name.
+func hashFromID(id string) (HashFunction, error) {
+	for _, h := range []HashFunction{HashSipHash, HashXXHash, HashMaphash} {
+		if h.id() == id {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("topk: unknown hash function %q, or maphash seeded by another process", id)
+}
+
+// checkHash returns an error if id does not name the hash function of the
+// root.
+func (r *topkRoot) checkHash(what, id string) error {
+	if id != r.hash.id() {
+		return fmt.Errorf("topk: %s hashes keys with %q, expected %q", what, hashName(id), r.hash)
+	}
+	return nil
+}
+
+func hashName(id string) string {
+	if id == "" {
+		return HashSipHash.String()
+	}
+	return id
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	for _, h := range []HashFunction{HashSipHash, HashXXHash, HashMaphash} {
+		k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Hash: h}, []string{"a"})
+		k.WithLabelValues("x").Add(5)
+		k.WithLabelValues("y").Add(3)
+		k.WithLabelValues("z").Add(1)
+		if count, _, _ := k.Estimate(map[string]string{"a": "y"}); count != 3 {
+			t.Errorf("%v: wrong estimate for y: %v", h, count)
+		}
+
+		snap := k.SnapshotProto()
+		restored, err := NewTopKFromSnapshot(snap)
+		if err != nil {
+			t.Fatalf("%v: %v", h, err)
+		}
+		if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %v expected %v", h, got, want)
+		}
+		// the error estimates of untracked keys survive the restore
+		for _, v := range []string{"y", "z", "w"} {
+			want, _, _ := k.Estimate(map[string]string{"a": v})
+			if got, _, _ := restored.Estimate(map[string]string{"a": v}); got != want {
+				t.Errorf("%v: estimate for %s is %v after restore, expected %v", h, v, got, want)
+			}
+		}
+
+		data, err := k.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, other := range []HashFunction{HashSipHash, HashXXHash, HashMaphash} {
+			o := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Hash: other}, []string{"a"})
+			errs := []error{o.RestoreSnapshotProto(snap), o.MergeSnapshotProto(snap), o.UnmarshalBinary(data)}
+			for _, err := range errs {
+				if other == h && err != nil {
+					t.Errorf("%v: %v", h, err)
+				} else if other != h && (err == nil || !strings.Contains(err.Error(), "hash")) {
+					t.Errorf("%v into %v: expected hash mismatch, got %v", h, other, err)
+				}
+			}
+		}
+	}
+}
+
+func TestHashFromID(t *testing.T) {
+	for _, h := range []HashFunction{HashSipHash, HashXXHash, HashMaphash} {
+		if got, err := hashFromID(h.id()); got != h || err != nil {
+			t.Errorf("hashFromID(%q) = %v, %v", h.id(), got, err)
+		}
+	}
+	// a maphash snapshot of another process uses another seed
+	if _, err := hashFromID("maphash/0000000000000000"); err == nil {
+		t.Error("expected error for maphash of another process")
+	}
+}
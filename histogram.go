@@ -0,0 +1,47 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// relabeledMetric exports the value of a standalone metric, such as a per-key
+// histogram, under the Desc and label values of a tracked key.
+type relabeledMetric struct {
+	desc   *prometheus.Desc
+	metric prometheus.Metric
+	lvs    []string
+}
+
+func newRelabeledMetric(desc *prometheus.Desc, m prometheus.Metric, lvs []string) prometheus.Metric {
+	return &relabeledMetric{desc: desc, metric: m, lvs: lvs}
+}
+
+func (m *relabeledMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *relabeledMetric) Write(out *dto.Metric) error {
+	if err := m.metric.Write(out); err != nil {
+		return err
+	}
+	out.Label = prometheus.MakeLabelPairs(m.desc, m.lvs)
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNativeHistogram(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{
+		Name:                        metricName,
+		Buckets:                     2,
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+
+	k.WithLabelValues("a").Observe(0.5)
+	k.WithLabelValues("a").Observe(2)
+	k.WithLabelValues("b").Observe(1)
+	k.WithLabelValues("c").Observe(3)
+
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hists *dto.MetricFamily
+	for _, v := range mets {
+		if v.GetName() == metricName+"_histogram" {
+			hists = v
+		}
+	}
+	if hists == nil {
+		t.Fatal("histogram family not exported")
+	}
+	if hists.GetType() != dto.MetricType_HISTOGRAM {
+		t.Errorf("wrong type: %v", hists.GetType())
+	}
+
+	counts := make(map[string]uint64)
+	for _, m := range hists.Metric {
+		if m.GetHistogram().Schema == nil {
+			t.Error("histogram is not a native histogram")
+		}
+		counts[m.Label[0].GetValue()] = m.GetHistogram().GetSampleCount()
+	}
+	// "b" was replaced by "c", so "c" only has its own observation
+	if len(counts) != 2 || counts["a"] != 2 || counts["c"] != 1 {
+		t.Errorf("wrong sample counts: %v", counts)
+	}
+}
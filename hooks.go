@@ -0,0 +1,88 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sort"
+	"sync"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exportHooks calls the OnEnterTopK and OnExitTopK functions of a TopK with
+// the changes of its exported keys from one Collect to the next.
+type exportHooks struct {
+	onEnter func(labels prometheus.Labels, count float64)
+	onExit  func(labels prometheus.Labels)
+
+	// held while calling the functions, so that they are called in order
+	mtx sync.Mutex
+	// the labels of the keys exported by the last Collect, by canonical key
+	exported map[string]prometheus.Labels
+}
+
+func newExportHooks(opts TopKOpts) *exportHooks {
+	if opts.OnEnterTopK == nil && opts.OnExitTopK == nil {
+		return nil
+	}
+	return &exportHooks{
+		onEnter:  opts.OnEnterTopK,
+		onExit:   opts.OnExitTopK,
+		exported: make(map[string]prometheus.Labels),
+	}
+}
+
+// update calls the functions for the keys that entered and left the exported
+// keys, given the indexes of the exported elements.
+func (h *exportHooks) update(names []string, elts []tk.Element, labels []*keyLabels, exported []int) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	current := make(map[string]prometheus.Labels, len(exported))
+	for _, i := range exported {
+		kl := labels[i]
+		if l, ok := h.exported[kl.canonical]; ok {
+			current[kl.canonical] = l
+			continue
+		}
+		l := make(prometheus.Labels, len(names))
+		for j, name := range names {
+			l[name] = kl.lvs[j]
+		}
+		current[kl.canonical] = l
+		if h.onEnter != nil {
+			h.onEnter(l, elts[i].Count)
+		}
+	}
+
+	if h.onExit != nil {
+		var left []string
+		for key := range h.exported {
+			if _, ok := current[key]; !ok {
+				left = append(left, key)
+			}
+		}
+		sort.Strings(left)
+		for _, key := range left {
+			h.onExit(h.exported[key])
+		}
+	}
+	h.exported = current
+}
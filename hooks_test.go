@@ -0,0 +1,57 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExportHooks(t *testing.T) {
+	var events []string
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 2,
+		OnEnterTopK: func(labels prometheus.Labels, count float64) {
+			events = append(events, fmt.Sprintf("enter %s %v", labels["key"], count))
+		},
+		OnExitTopK: func(labels prometheus.Labels) {
+			events = append(events, "exit "+labels["key"])
+		},
+	}, []string{"key"})
+	collect := func(want ...string) {
+		t.Helper()
+		events = nil
+		testutil.CollectAndCount(k)
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("got %q expected %q", events, want)
+		}
+	}
+
+	k.WithLabelValues("a").Add(5)
+	collect("enter a 5")
+	k.WithLabelValues("b").Add(2)
+	collect("enter b 2")
+	collect()
+	// c evicts b
+	k.WithLabelValues("c").Add(4)
+	collect("enter c 4", "exit b")
+}
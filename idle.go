@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"time"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// idleEviction removes the tracked keys of a TopK whose rate stayed under its
+// IdleRate for its IdleWindow, when maintained.
+type idleEviction struct {
+	rate   float64
+	window time.Duration
+	// the current window of every tracked key, protected by streamMtx
+	windows map[string]idleWindow
+}
+
+// idleWindow is the time and count of a key when its window started.
+type idleWindow struct {
+	start time.Time
+	count float64
+}
+
+// evictIdle removes the tracked keys whose count grew by less than the rate
+// over their last window, and starts a new window for the others. A window
+// starts at the first evictIdle after a key is tracked, or after its count
+// went down, such as when it was removed and tracked again. Must be called
+// with streamMtx held.
+func (r *topkRoot) evictIdle() {
+	now := r.now()
+	windows := make(map[string]idleWindow, len(r.idle.windows))
+	var idle []string
+	r.stream.Range(func(e tk.Element) bool {
+		w, ok := r.idle.windows[e.Key]
+		switch elapsed := now.Sub(w.start); {
+		case !ok || e.Count < w.count:
+			w = idleWindow{start: now, count: e.Count}
+		case elapsed < r.idle.window:
+		case (e.Count-w.count)/elapsed.Seconds() < r.idle.rate:
+			idle = append(idle, e.Key)
+			return true
+		default:
+			w = idleWindow{start: now, count: e.Count}
+		}
+		windows[e.Key] = w
+		return true
+	})
+	for _, key := range idle {
+		r.stream.Remove(key)
+		delete(r.lastSeen, key)
+		delete(r.keyState, key)
+	}
+	r.idle.windows = windows
+	r.idleEvicted.Add(uint64(len(idle)))
+}
@@ -0,0 +1,55 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdleRate(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 3, IdleRate: 1, IdleWindow: time.Minute,
+		Now: func() time.Time { return now },
+	}, []string{"key"})
+	defer k.Close()
+
+	keys := func() []string {
+		var out []string
+		for _, e := range k.Snapshot() {
+			out = append(out, e.Labels["key"])
+		}
+		return out
+	}
+	k.WithLabelValues("slow").Add(1000)
+	k.WithLabelValues("fast").Add(10)
+	keys()
+	for range 2 {
+		now = now.Add(time.Minute)
+		// 30 per minute is under the floor of 60
+		k.WithLabelValues("slow").Add(30)
+		k.WithLabelValues("fast").Add(100)
+	}
+	if got := keys(); len(got) != 1 || got[0] != "fast" {
+		t.Errorf("got keys %v after two windows, expected [fast]", got)
+	}
+	if st := k.Stats(); st.Idle != 1 {
+		t.Errorf("got %d idle keys expected 1", st.Idle)
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tdigest implements the merging variant of Ted Dunning's t-digest, a
+// compact sketch for estimating quantiles of a stream of values.
+//
+// https://github.com/tdunning/t-digest/blob/master/docs/t-digest-paper/histo.pdf
+package tdigest
+
+import (
+	"math"
+	"sort"
+	"unsafe"
+)
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+type centroidsByMean []centroid
+
+func (c centroidsByMean) Len() int           { return len(c) }
+func (c centroidsByMean) Less(i, j int) bool { return c[i].mean < c[j].mean }
+func (c centroidsByMean) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// TDigest estimates quantiles of the values added to it.
+//
+// A TDigest is not safe for concurrent use.
+type TDigest struct {
+	compression float64
+
+	merged   []centroid
+	unmerged []centroid
+
+	// total weight of the merged centroids
+	mergedWeight float64
+	count        float64
+	sum          float64
+	min, max     float64
+}
+
+// New returns an empty TDigest. Higher compression values keep more
+// centroids, trading memory for accuracy; the number of retained centroids is
+// bounded by roughly twice the compression.
+func New(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		unmerged:    make([]centroid, 0, int(compression)*2),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+// MemoryUsage returns an estimate of the number of bytes used by the digest.
+func (t *TDigest) MemoryUsage() uint64 {
+	return uint64(unsafe.Sizeof(*t)) + uint64(cap(t.merged)+cap(t.unmerged))*uint64(unsafe.Sizeof(centroid{}))
+}
+
+// Add records a single occurrence of x.
+func (t *TDigest) Add(x float64) {
+	t.AddWeighted(x, 1)
+}
+
+// AddWeighted records w occurrences of x.
+func (t *TDigest) AddWeighted(x, w float64) {
+	if math.IsNaN(x) || !(w > 0) {
+		return
+	}
+	if len(t.unmerged) == cap(t.unmerged) {
+		t.merge()
+	}
+	t.unmerged = append(t.unmerged, centroid{mean: x, weight: w})
+	t.count += w
+	t.sum += x * w
+	if x < t.min {
+		t.min = x
+	}
+	if x > t.max {
+		t.max = x
+	}
+}
+
+// Clone returns a copy of the digest. Unlike Quantile, it does not modify the
+// digest, so it can be called concurrently with other Clones.
+func (t *TDigest) Clone() *TDigest {
+	c := *t
+	c.merged = append([]centroid(nil), t.merged...)
+	c.unmerged = append(make([]centroid, 0, cap(t.unmerged)), t.unmerged...)
+	return &c
+}
+
+// Count returns the number of values added to the digest.
+func (t *TDigest) Count() float64 {
+	return t.count
+}
+
+// Sum returns the sum of the values added to the digest.
+func (t *TDigest) Sum() float64 {
+	return t.sum
+}
+
+// scale is the k1 scale function from the paper, mapping a quantile to the
+// index space in which each centroid may span at most one unit.
+func (t *TDigest) scale(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *TDigest) scaleInverse(k float64) float64 {
+	if k >= t.compression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/t.compression) + 1) / 2
+}
+
+func (t *TDigest) merge() {
+	if len(t.unmerged) == 0 {
+		return
+	}
+	all := append(t.merged, t.unmerged...)
+	sort.Sort(centroidsByMean(all))
+	total := t.count
+
+	merged := make([]centroid, 0, len(t.merged)+1)
+	cur := all[0]
+	weightSoFar := 0.0
+	qLimit := t.scaleInverse(t.scale(0) + 1)
+	for _, c := range all[1:] {
+		q := (weightSoFar + cur.weight + c.weight) / total
+		if q <= qLimit {
+			cur.weight += c.weight
+			cur.mean += (c.mean - cur.mean) * c.weight / cur.weight
+			continue
+		}
+		weightSoFar += cur.weight
+		merged = append(merged, cur)
+		cur = c
+		qLimit = t.scaleInverse(t.scale(weightSoFar/total) + 1)
+	}
+	merged = append(merged, cur)
+
+	t.merged = merged
+	t.mergedWeight = total
+	t.unmerged = t.unmerged[:0]
+}
+
+// Quantile returns an estimate of the q-th quantile, 0 <= q <= 1, of the
+// values added to the digest. It returns NaN if the digest is empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	t.merge()
+	cs := t.merged
+	if len(cs) == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return t.min
+	}
+	if q >= 1 {
+		return t.max
+	}
+	if len(cs) == 1 {
+		return cs[0].mean
+	}
+
+	index := q * t.mergedWeight
+	// left tail: interpolate between the minimum and the first centroid
+	if index < cs[0].weight/2 {
+		return t.min + index/(cs[0].weight/2)*(cs[0].mean-t.min)
+	}
+	cum := 0.0
+	for i := 0; i < len(cs)-1; i++ {
+		left := cum + cs[i].weight/2
+		right := cum + cs[i].weight + cs[i+1].weight/2
+		if index < right {
+			return cs[i].mean + (index-left)/(right-left)*(cs[i+1].mean-cs[i].mean)
+		}
+		cum += cs[i].weight
+	}
+	// right tail: interpolate between the last centroid and the maximum
+	last := cs[len(cs)-1]
+	left := t.mergedWeight - last.weight/2
+	return last.mean + (index-left)/(last.weight/2)*(t.max-last.mean)
+}
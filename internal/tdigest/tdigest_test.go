@@ -0,0 +1,114 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tdigest
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	td := New(100)
+
+	var exact []float64
+	for i := 0; i < 100000; i++ {
+		v := rng.ExpFloat64()
+		exact = append(exact, v)
+		td.Add(v)
+	}
+	sort.Float64s(exact)
+
+	if td.Count() != float64(len(exact)) {
+		t.Errorf("wrong count: got %v expected %v", td.Count(), len(exact))
+	}
+	for _, q := range []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999} {
+		got := td.Quantile(q)
+		// compare by the rank of the estimate within the exact values
+		rank := float64(sort.SearchFloat64s(exact, got)) / float64(len(exact))
+		if math.Abs(rank-q) > 0.005 {
+			t.Errorf("q=%v: got %v with rank %v", q, got, rank)
+		}
+	}
+	if n := len(td.merged); n > 200 {
+		t.Errorf("digest too large: %d centroids", n)
+	}
+	if td.Quantile(0) != exact[0] || td.Quantile(1) != exact[len(exact)-1] {
+		t.Error("extreme quantiles must be the exact min and max")
+	}
+}
+
+func TestSmall(t *testing.T) {
+	td := New(100)
+	if !math.IsNaN(td.Quantile(0.5)) {
+		t.Error("empty digest must return NaN")
+	}
+	td.Add(3)
+	if td.Quantile(0.5) != 3 {
+		t.Errorf("got %v expected 3", td.Quantile(0.5))
+	}
+	td.Add(1)
+	td.Add(2)
+	if got := td.Quantile(0.5); got != 2 {
+		t.Errorf("got %v expected 2", got)
+	}
+	if td.Sum() != 6 {
+		t.Errorf("wrong sum %v", td.Sum())
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	td := New(50)
+	if n := td.MemoryUsage(); n < 100*16 {
+		t.Errorf("memory usage %d does not cover the centroid buffer", n)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	td := New(50)
+	td.AddWeighted(1, 90)
+	td.AddWeighted(10, 10)
+	td.AddWeighted(5, 0)
+	if td.Count() != 100 || td.Sum() != 190 {
+		t.Errorf("got count %v and sum %v, expected 100 and 190", td.Count(), td.Sum())
+	}
+	if q := td.Quantile(0.5); q < 1 || q > 2 {
+		t.Errorf("median = %v, expected close to 1", q)
+	}
+	if q := td.Quantile(0.99); q != 10 {
+		t.Errorf("99th percentile = %v, expected 10", q)
+	}
+}
+
+func TestClone(t *testing.T) {
+	td := New(50)
+	for i := 0; i < 1000; i++ {
+		td.Add(float64(i))
+	}
+	c := td.Clone()
+	want := td.Quantile(0.5)
+	c.Add(1e6)
+	if got := c.Quantile(0.5); got < want-10 || got > want+10 {
+		t.Errorf("median of the clone = %v, expected about %v", got, want)
+	}
+	if td.Count() != 1000 || td.Quantile(0.5) != want {
+		t.Error("adding to the clone changed the digest")
+	}
+}
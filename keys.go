@@ -0,0 +1,165 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode/utf8"
+	"unique"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// The label values in a composite key are each followed by the separator
+// byte. So that arbitrary byte strings can be used as label values, the
+// separator byte and labelEscapeByte are escaped in the values by prefixing
+// labelEscapeByte and replacing them by escapedSeparator and escapedEscape.
+// Neither byte appears in valid UTF-8, so the escaping is almost always a
+// no-op.
+const (
+	labelEscapeByte  = 0xFE
+	escapedSeparator = 0x01
+	escapedEscape    = 0x02
+)
+
+// needsEscape reports whether v contains a byte that must be escaped.
+func needsEscape(v string) bool {
+	return strings.IndexByte(v, model.SeparatorByte) >= 0 || strings.IndexByte(v, labelEscapeByte) >= 0
+}
+
+// writeLabelValue writes the escaped form of v to buf.
+func writeLabelValue(buf *strings.Builder, v string) {
+	if !needsEscape(v) {
+		buf.WriteString(v)
+		return
+	}
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case model.SeparatorByte:
+			buf.WriteByte(labelEscapeByte)
+			buf.WriteByte(escapedSeparator)
+		case labelEscapeByte:
+			buf.WriteByte(labelEscapeByte)
+			buf.WriteByte(escapedEscape)
+		default:
+			buf.WriteByte(v[i])
+		}
+	}
+}
+
+// escapeLabelValue returns the escaped form of v.
+func escapeLabelValue(v string) string {
+	if !needsEscape(v) {
+		return v
+	}
+	var buf strings.Builder
+	writeLabelValue(&buf, v)
+	return buf.String()
+}
+
+// unescapeLabelValue reverses escapeLabelValue.
+func unescapeLabelValue(v string) string {
+	if strings.IndexByte(v, labelEscapeByte) < 0 {
+		return v
+	}
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] != labelEscapeByte || i+1 == len(v) {
+			sb.WriteByte(v[i])
+			continue
+		}
+		i++
+		switch v[i] {
+		case escapedSeparator:
+			sb.WriteByte(model.SeparatorByte)
+		case escapedEscape:
+			sb.WriteByte(labelEscapeByte)
+		default:
+			sb.WriteByte(labelEscapeByte)
+			sb.WriteByte(v[i])
+		}
+	}
+	return sb.String()
+}
+
+// unescapeLabelValues unescapes the label values split from a composite key
+// in place, returning lvs.
+func unescapeLabelValues(lvs []string) []string {
+	for i, v := range lvs {
+		lvs[i] = unescapeLabelValue(v)
+	}
+	return lvs
+}
+
+// ParseKey splits a composite key, as seen by a custom Stream, into the
+// values of the labels, in the order of labelNames. The keys of a TopK are
+// otherwise always split, as by Snapshot, TopN, and Range, so only a Stream
+// needs to parse them.
+func ParseKey(labelNames []string, key string) (prometheus.Labels, error) {
+	split := strings.Split(key, labelParseSplit)
+	if len(split) != len(labelNames)+1 || split[len(labelNames)] != "" {
+		return nil, fmt.Errorf("topk: %q is not a key of %d label values", key, len(labelNames))
+	}
+	labels := make(prometheus.Labels, len(labelNames))
+	for i, name := range labelNames {
+		labels[name] = unescapeLabelValue(split[i])
+	}
+	return labels, nil
+}
+
+// intern returns the canonical copy of s, so that the keys and label values
+// held by the buckets, the curried TopKs, and the stream share their memory
+// however many times they are built, and do not retain the strings they were
+// sliced from.
+func intern(s string) string {
+	return unique.Make(s).Value()
+}
+
+// internStrings returns a copy of lvs with interned strings.
+func internStrings(lvs []string) []string {
+	out := make([]string, len(lvs))
+	for i, v := range lvs {
+		out[i] = intern(v)
+	}
+	return out
+}
+
+// hashSuffixLen is the length of the suffix added by truncateLabelValue.
+const hashSuffixLen = 9
+
+// truncateLabelValue shortens v to at most max bytes if it is longer, keeping
+// a prefix and replacing the rest with "~" and a hash of v, so that long
+// values with the same prefix remain distinct. The prefix is cut on a rune
+// boundary if v is valid UTF-8.
+func truncateLabelValue(v string, max int) string {
+	if len(v) <= max {
+		return v
+	}
+	h := fnv.New32a()
+	h.Write([]byte(v))
+	end := max - hashSuffixLen
+	if utf8.ValidString(v) {
+		for end > 0 && !utf8.RuneStart(v[end]) {
+			end--
+		}
+	}
+	return fmt.Sprintf("%s~%08x", v[:end], h.Sum32())
+}
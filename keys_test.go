@@ -0,0 +1,155 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+	"unsafe"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEscapeLabelValue(t *testing.T) {
+	for _, v := range []string{"", "plain", "\xff", "a\xffb\xfe", "\xfe\x01", "\xfe"} {
+		esc := escapeLabelValue(v)
+		for i := 0; i < len(esc); i++ {
+			if esc[i] == 0xFF {
+				t.Errorf("escaped form %q of %q contains the separator byte", esc, v)
+			}
+		}
+		if got := unescapeLabelValue(esc); got != v {
+			t.Errorf("unescape(escape(%q)) = %q", v, got)
+		}
+	}
+}
+
+func TestSeparatorInLabelValue(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5}, []string{"a", "b"})
+	k.WithLabelValues("x\xffy", "z").Add(2)
+	k.WithLabelValues("x", "y\xffz").Add(1)
+
+	want := []prometheus.Labels{{"a": "x\xffy", "b": "z"}, {"a": "x", "b": "y\xffz"}}
+	var got []prometheus.Labels
+	for _, e := range k.Snapshot() {
+		got = append(got, e.Labels)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got labels %q, expected %q", got, want)
+	}
+	if rank, ok := k.MustCurryWithLabelValues("x\xffy").Rank("z"); rank != 1 || !ok {
+		t.Errorf("Rank = %v, %v through a curried value with a separator", rank, ok)
+	}
+	if got := k.MustCurryWithLabelValues("x\xffy").CurriedLabels(); got["a"] != "x\xffy" {
+		t.Errorf("got curried value %q", got["a"])
+	}
+
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored.Snapshot(), k.Snapshot()) {
+		t.Errorf("got %v after restore, expected %v", restored.Snapshot(), k.Snapshot())
+	}
+	if n := k.DeletePartialMatch(prometheus.Labels{"b": "y\xffz"}); n != 1 {
+		t.Errorf("DeletePartialMatch deleted %d keys, expected 1", n)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	var keys []string
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5, NewStream: func(buckets int) Stream {
+		return &keyRecorder{Stream: newExactStream(buckets), keys: &keys}
+	}}, []string{"a", "b"})
+	k.WithLabelValues("x\xffy", "z").Inc()
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, expected 1", len(keys))
+	}
+	got, err := ParseKey([]string{"a", "b"}, keys[0])
+	if want := (prometheus.Labels{"a": "x\xffy", "b": "z"}); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, %v expected %q", got, err, want)
+	}
+	if _, err := ParseKey([]string{"a"}, keys[0]); err == nil {
+		t.Error("expected error for the wrong number of labels")
+	}
+}
+
+// keyRecorder is a Stream recording the keys inserted into it.
+type keyRecorder struct {
+	Stream
+	keys *[]string
+}
+
+func (s *keyRecorder) Insert(key string, count float64) tk.Element {
+	*s.keys = append(*s.keys, key)
+	return s.Stream.Insert(key, count)
+}
+
+func TestMaxLabelValueLength(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5, MaxLabelValueLength: 20}, []string{"url"})
+	long1 := "/search?q=" + strings.Repeat("a", 50)
+	long2 := "/search?q=" + strings.Repeat("b", 50)
+	k.WithLabelValues(long1).Add(1)
+	k.WithLabelValues(long1).Add(1)
+	k.WithLabelValues(long2).Add(1)
+	k.WithLabelValues("/short").Add(1)
+
+	counts := make(map[string]float64)
+	for _, e := range k.Snapshot() {
+		v := e.Labels["url"]
+		if len(v) > 20 {
+			t.Errorf("label value %q is longer than 20 bytes", v)
+		}
+		counts[v] = e.Count
+	}
+	if len(counts) != 3 || counts["/short"] != 1 {
+		t.Errorf("got counts %v, expected 3 distinct values", counts)
+	}
+	if count, _, _ := k.Estimate(prometheus.Labels{"url": long1}); count != 2 {
+		t.Errorf("got count %v for the long value, expected 2", count)
+	}
+
+	if got := truncateLabelValue("ééééééééééééé", 16); !utf8.ValidString(got) || len(got) > 16 {
+		t.Errorf("truncated value %q is not valid UTF-8 of at most 16 bytes", got)
+	}
+}
+
+func TestIntern(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 1}, []string{"a", "b"})
+
+	// the curried TopKs have separate caches, so these are built separately
+	b1 := k.MustCurryWithLabelValues("1").WithLabelValues("x").(*topkWithLabelValues)
+	b2 := k.MustCurryWithLabelValues("1").WithLabelValues("x").(*topkWithLabelValues)
+	if b1 == b2 {
+		t.Fatal("expected separate buckets")
+	}
+	if unsafe.StringData(b1.compositeLabel) != unsafe.StringData(b2.compositeLabel) {
+		t.Error("the composite keys were not interned")
+	}
+
+	buf := strings.Repeat("y", 100)
+	v := buf[10:20]
+	b3 := k.WithLabelValues("2", v).(*topkWithLabelValues)
+	if unsafe.StringData(b3.labelValues[1]) == unsafe.StringData(v) {
+		t.Error("the bucket retains the label value it was given")
+	}
+}
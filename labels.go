@@ -0,0 +1,163 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// keyLabels holds the label values of a tracked key, split from the
+// composite key and validated once, and the label pairs built from them, so
+// that Collect does not do it again on every scrape.
+type keyLabels struct {
+	// nil if the key cannot be exported
+	lvs   []string
+	pairs []*dto.LabelPair
+	// the key of lvs escaped the usual way, the same for the distinct keys
+	// that have the same label values
+	canonical string
+}
+
+// labelCache holds the keyLabels of the keys exported by the last Collect.
+type labelCache struct {
+	mtx  sync.Mutex
+	keys map[string]*keyLabels
+}
+
+// lookup returns the keyLabels of the keys, replacing the cache with them so
+// that the keys that are no longer exported are dropped.
+func (c *labelCache) lookup(r *topkRoot, keys []string) []*keyLabels {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	next := make(map[string]*keyLabels, len(keys))
+	out := make([]*keyLabels, len(keys))
+	for i, key := range keys {
+		kl := c.keys[key]
+		if kl == nil {
+			kl = r.newKeyLabels(key)
+		}
+		next[key] = kl
+		out[i] = kl
+	}
+	c.keys = next
+	return out
+}
+
+func (r *topkRoot) newKeyLabels(key string) *keyLabels {
+	split := strings.Split(key, labelParseSplit)
+	if len(split) != len(r.variableLabels)+1 {
+		return &keyLabels{}
+	}
+	lvs := unescapeLabelValues(split[:len(r.variableLabels)])
+	if _, err := prometheus.NewConstMetric(r.countDesc, prometheus.CounterValue, 0, lvs...); err != nil {
+		// such as a label value that is not valid UTF-8
+		return &keyLabels{}
+	}
+	canonical := compositeKey(lvs)
+	if canonical == key {
+		// the usual case, sharing the memory of the key
+		canonical = key
+	}
+	return &keyLabels{lvs: lvs, pairs: prometheus.MakeLabelPairs(r.countDesc, lvs), canonical: canonical}
+}
+
+// dedupe merges the elements with the same label values into the first one,
+// summing their counts and errors, and keeping the per-key values of the
+// first one that has them. The keys that cannot be exported are kept. It
+// returns the remaining elements, labels, and values, reusing the slices.
+func (r *topkRoot) dedupe(elts []tk.Element, labels []*keyLabels, values []*keyValues) ([]tk.Element, []*keyLabels, []*keyValues) {
+	seen := make(map[string]int, len(elts))
+	n := 0
+	for i, kl := range labels {
+		if kl.lvs != nil {
+			if j, ok := seen[kl.canonical]; ok {
+				elts[j].Count += elts[i].Count
+				elts[j].Error += elts[i].Error
+				if values != nil && values[j] == nil {
+					values[j] = values[i]
+				}
+				r.duplicates.Add(1)
+				continue
+			}
+			seen[kl.canonical] = n
+		}
+		elts[n], labels[n] = elts[i], kl
+		if values != nil {
+			values[n] = values[i]
+		}
+		n++
+	}
+	if values != nil {
+		values = values[:n]
+	}
+	return elts[:n], labels[:n], values
+}
+
+// ranks returns the 1-based rank of every exportable element by decreasing
+// count, then by label values, or zero for the keys that cannot be exported.
+func ranks(elts []tk.Element, labels []*keyLabels) []int {
+	order := make([]int, 0, len(elts))
+	for i, kl := range labels {
+		if kl.lvs != nil {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if elts[i].Count != elts[j].Count {
+			return elts[i].Count > elts[j].Count
+		}
+		return labels[i].canonical < labels[j].canonical
+	})
+	rank := make([]int, len(elts))
+	for r, i := range order {
+		rank[i] = r + 1
+	}
+	return rank
+}
+
+// keyMetric is a constant counter or gauge of a tracked key, with the cached
+// label pairs of the key.
+type keyMetric struct {
+	desc      *prometheus.Desc
+	pairs     []*dto.LabelPair
+	valueType prometheus.ValueType
+	value     float64
+}
+
+func (m *keyMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m *keyMetric) Write(out *dto.Metric) error {
+	out.Label = m.pairs
+	if m.valueType == prometheus.GaugeValue {
+		out.Gauge = &dto.Gauge{Value: proto.Float64(m.value)}
+	} else {
+		out.Counter = &dto.Counter{Value: proto.Float64(m.value)}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLabelCache(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, ConstLabels: map[string]string{"z": "const"}}, []string{"b", "a"})
+	k.WithLabelValues("1", "x").Add(3)
+	k.WithLabelValues("2", "y").Add(2)
+
+	want := `
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{a="x",b="1",z="const"} 3
+test_metric{a="y",b="2",z="const"} 2
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(want), metricName); err != nil {
+		t.Fatal(err)
+	}
+	root := k.(*topkCurry).root
+	cached := root.labels.keys["1\xffx\xff"]
+	if cached == nil || len(cached.pairs) != 3 {
+		t.Fatalf("got cached labels %+v", cached)
+	}
+
+	k.WithLabelValues("3", "z").Add(10)
+	if err := testutil.CollectAndCompare(k, strings.NewReader(`
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{a="x",b="1",z="const"} 3
+test_metric{a="z",b="3",z="const"} 10
+`), metricName); err != nil {
+		t.Fatal(err)
+	}
+	if root.labels.keys["1\xffx\xff"] != cached {
+		t.Error("the labels of a key were not reused")
+	}
+	if _, ok := root.labels.keys["2\xffy\xff"]; ok || len(root.labels.keys) != 2 {
+		t.Errorf("the labels of an evicted key were not dropped: %v", root.labels.keys)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sync"
+	"time"
+)
+
+// maintainer periodically does the maintenance of a TopK that does not need
+// to be done while observing: removing the keys older than the KeyTTL or
+// under the IdleRate, and rescaling the counts decayed by the HalfLife. The
+// readers of the TopK do it too, so the maintainer is only needed for a TopK
+// that is not read.
+type maintainer struct {
+	root *topkRoot
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// startMaintainer starts the maintainer of a root if one of its options needs
+// it, returning nil otherwise.
+func startMaintainer(root *topkRoot, opts TopKOpts) *maintainer {
+	var interval time.Duration
+	for _, d := range []time.Duration{opts.KeyTTL, opts.IdleWindow, opts.HalfLife} {
+		if d > 0 {
+			// a tenth of the duration, but at most every second
+			d = min(max(d/10, time.Second), d)
+			if interval == 0 || d < interval {
+				interval = d
+			}
+		}
+	}
+	if interval == 0 {
+		return nil
+	}
+	m := &maintainer{
+		root: root,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go m.run(interval)
+	return m
+}
+
+func (m *maintainer) run(interval time.Duration) {
+	defer close(m.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.root.maintain()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *maintainer) close() {
+	m.closeOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+}
+
+// maintain does the periodic maintenance of the root, which lockStream does
+// as well, so that it is up to date for the readers.
+func (r *topkRoot) maintain() {
+	r.lockStream()
+	r.streamMtx.Unlock()
+}
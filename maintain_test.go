@@ -0,0 +1,48 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintainer(t *testing.T) {
+	if k := NewTopK(TopKOpts{Name: metricName}, nil); k.(*topkCurry).root.maintainer != nil {
+		t.Error("a TopK without KeyTTL or HalfLife should not be maintained")
+	}
+
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, KeyTTL: 10 * time.Millisecond}, []string{"key"})
+	k.WithLabelValues("a").Inc()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(k.Snapshot()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the key was not expired in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-k.(*topkCurry).root.maintainer.done:
+	default:
+		t.Error("Close did not stop the maintainer")
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"unsafe"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// histogramMemory is a rough estimate of the memory used by a per-key native
+// histogram, which depends on the spread of the observed values.
+const histogramMemory = 2048
+
+// EstimateMemory returns an estimate of the number of bytes used by the whole
+// TopK, even if called on a curried TopK: its streams, including the keys of
+// the tracked elements, and the per-key histograms, digests, and counters.
+// The estimate does not include the allocator overhead.
+func (r *topkCurry) EstimateMemory() uint64 {
+	const (
+		mapEntrySize = uint64(unsafe.Sizeof("")+unsafe.Sizeof(&keyState{})) * 3 / 2
+		stateSize    = uint64(unsafe.Sizeof(keyState{}))
+	)
+
+	r.root.rlockStream()
+	defer r.root.streamMtx.RUnlock()
+	n := uint64(unsafe.Sizeof(topkRoot{})) + streamMemory(r.root.stream)
+	for _, s := range r.root.shards {
+		s.mtx.Lock()
+		n += uint64(unsafe.Sizeof(shard{})) + streamMemory(s.stream)
+		s.mtx.Unlock()
+	}
+	for _, st := range r.root.keyState {
+		// the key is shared with the stream
+		n += mapEntrySize + stateSize
+		if st.histogram != nil {
+			n += histogramMemory
+		}
+		if st.digest != nil {
+			n += st.digest.MemoryUsage()
+		}
+		if st.exemplar != nil {
+			for name, value := range st.exemplar.Labels {
+				n += uint64(len(name) + len(value))
+			}
+		}
+	}
+	return n
+}
+
+// streamMemory returns an estimate of the number of bytes used by a stream.
+func streamMemory(s Stream) uint64 {
+	const mapEntrySize = uint64(unsafe.Sizeof("")+unsafe.Sizeof(&tk.Stream{})) * 3 / 2
+
+	switch s := s.(type) {
+	case *tk.Stream:
+		return s.MemoryUsage()
+	case *partitionedStream:
+		var n uint64
+		for pk, ps := range s.parts {
+			n += uint64(len(pk)) + mapEntrySize + ps.MemoryUsage()
+		}
+		return n
+	case interface{ MemoryUsage() uint64 }:
+		return s.MemoryUsage()
+	}
+	return 0
+}
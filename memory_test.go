@@ -0,0 +1,47 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 100}, []string{"key"})
+	empty := k.EstimateMemory()
+	// the error estimates alone use 8 bytes for 6 slots per bucket
+	if empty < 100*6*8 {
+		t.Errorf("empty TopK uses %d bytes, expected at least %d", empty, 100*6*8)
+	}
+	for i := 0; i < 100; i++ {
+		k.WithLabelValues(fmt.Sprintf("key-%03d", i)).Inc()
+	}
+	full := k.EstimateMemory()
+	if full < empty+100*8 {
+		t.Errorf("full TopK uses %d bytes, expected at least %d more than %d", full, 100*8, empty)
+	}
+
+	withDigests := NewTopK(TopKOpts{Name: metricName, Buckets: 100, Quantiles: []float64{0.5}}, []string{"key"})
+	for i := 0; i < 100; i++ {
+		withDigests.WithLabelValues(fmt.Sprintf("key-%03d", i)).Inc()
+	}
+	if n := withDigests.EstimateMemory(); n <= full {
+		t.Errorf("TopK with digests uses %d bytes, expected more than %d", n, full)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/riking/go-prometheus-topk/topkpb"
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+)
+
+// Merge adds the counts of the whole TopK other into the whole TopK, as if
+// all of its observations had been made here. The TopKs must have the same
+// label names, partition labels, Mode, number of buckets, and hash function.
+// The per-key histograms, digests, and exemplars of other are not merged.
+// With a Mode other than ModeSum, the tracked keys of other are recorded by
+// their counts instead: a merge keeps the larger maximum with ModeMax, and
+// the value of other with ModeLast.
+//
+// Merge takes a snapshot of other first, so it never holds both locks and a
+// TopK can be merged into itself.
+func (r *topkCurry) Merge(other TopK) error {
+	return r.MergeSnapshotProto(other.SnapshotProto())
+}
+
+// MergeSnapshotProto is Merge for a snapshot, such as one received from
+// another process.
+func (r *topkCurry) MergeSnapshotProto(snap *topkpb.Snapshot) error {
+	if err := snap.Validate(); err != nil {
+		return err
+	}
+	s, err := r.root.streamFromSnapshot(snap)
+	if err != nil {
+		return err
+	}
+
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+	if err := r.root.checkBuckets("snapshot", s.Capacity()); err != nil {
+		return err
+	}
+	if r.root.mode != ModeSum {
+		r.root.mergeRecorded(s)
+		return nil
+	}
+	total := streamTotal(s)
+	if err := mergeStreams(r.root.stream, s); err != nil {
+		return err
+	}
+	if r.root.audit != nil {
+		r.root.audit.merge(s)
+	}
+	r.root.shareAlert.add(total)
+	return nil
+}
+
+// mergeStreams adds the counts of src into dst, which must be streams of the
+// same kind.
+func mergeStreams(dst, src Stream) error {
+	switch src := src.(type) {
+	case *tk.Stream:
+		return dst.(*tk.Stream).Merge(src)
+	case *partitionedStream:
+		return dst.(*partitionedStream).merge(src)
+	}
+	return nil
+}
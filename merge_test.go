@@ -0,0 +1,96 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMerge(t *testing.T) {
+	a := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"k"})
+	b := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"k"})
+	a.WithLabelValues("x").Add(5)
+	a.WithLabelValues("y").Add(1)
+	b.WithLabelValues("x").Add(2)
+	b.WithLabelValues("z").Add(4)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	snap := a.Snapshot()
+	if len(snap) != 2 {
+		t.Fatalf("wrong number of keys: %v", snap)
+	}
+	if snap[0].Labels["k"] != "x" || snap[0].Count != 7 {
+		t.Errorf("wrong top key: %v", snap[0])
+	}
+	if snap[1].Labels["k"] != "z" || snap[1].Count != 4 {
+		t.Errorf("wrong second key: %v", snap[1])
+	}
+	// y was displaced, so its count is only bounded from above
+	if count, _, tracked := a.Estimate(prometheus.Labels{"k": "y"}); tracked || count < 1 {
+		t.Errorf("wrong estimate for displaced key: %v, %v", count, tracked)
+	}
+
+	if err := a.Merge(a); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := a.Estimate(prometheus.Labels{"k": "x"}); count != 14 {
+		t.Errorf("self-merge did not double the count: %v", count)
+	}
+
+	for _, other := range []TopK{
+		NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a"}),
+		NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"k"}),
+	} {
+		if err := a.Merge(other); err == nil {
+			t.Error("expected error for mismatched TopK")
+		}
+	}
+}
+
+func TestMergeModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode   Mode
+		merged float64
+	}{
+		{ModeMax, 10},
+		{ModeLast, 7},
+	} {
+		a := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Mode: tc.mode}, []string{"k"})
+		b := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Mode: tc.mode}, []string{"k"})
+		a.WithLabelValues("x").Set(10)
+		b.WithLabelValues("x").Set(7)
+
+		if err := a.Merge(b); err != nil {
+			t.Fatal(err)
+		}
+		if count, _, _ := a.Estimate(prometheus.Labels{"k": "x"}); count != tc.merged {
+			t.Errorf("%v: got %v after merge expected %v", tc.mode, count, tc.merged)
+		}
+		if err := a.Merge(a); err != nil {
+			t.Fatal(err)
+		}
+		// a self-merge changes nothing
+		if count, _, _ := a.Estimate(prometheus.Labels{"k": "x"}); count != tc.merged {
+			t.Errorf("%v: got %v after self-merge expected %v", tc.mode, count, tc.merged)
+		}
+	}
+}
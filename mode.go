@@ -0,0 +1,86 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "fmt"
+
+// A Mode decides how the observations of a key are combined into its count.
+type Mode int
+
+const (
+	// ModeSum counts the sum of the observed values, the default.
+	ModeSum Mode = iota
+	// ModeLast keeps the latest observed value of every key, usually
+	// recorded by Set, so that the top keys are the ones with the largest
+	// current values, like the queues with the most items. A key that is not
+	// tracked replaces the tracked key with the smallest value if its value
+	// is larger; otherwise the value is not recorded. The total of Shares is
+	// the sum of the latest values of the tracked keys, and the counts are
+	// exported as gauges.
+	ModeLast
+	// ModeMax keeps the largest observed value of every key, so that the
+	// top keys are the ones with the worst cases, like the endpoints with
+	// the slowest requests. A key that is not tracked replaces the tracked
+	// key with the smallest maximum if its value is larger; since the
+	// maximums only grow, the value of every tracked key is its maximum,
+	// without error. The counts are exported as gauges.
+	ModeMax
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeSum:
+		return "sum"
+	case ModeLast:
+		return "last"
+	case ModeMax:
+		return "max"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// record records v as the value of key with a Mode other than ModeSum.
+// Must be called with streamMtx held.
+func (r *topkRoot) record(key string, v float64) {
+	switch r.mode {
+	case ModeLast:
+		streamSet(r.stream, key, v)
+		if r.audit != nil {
+			r.audit.exact[key] = v
+		}
+	case ModeMax:
+		if !r.stream.Monitored(key) || v > r.stream.Estimate(key).Count {
+			streamSet(r.stream, key, v)
+		}
+		if r.audit != nil {
+			if cur, ok := r.audit.exact[key]; !ok || v > cur {
+				r.audit.exact[key] = v
+			}
+		}
+	}
+}
+
+// mergeRecorded records the counts of the tracked keys of src as values, for
+// a Mode other than ModeSum: with ModeMax, the merged count of a key is the
+// largest of both, and with ModeLast, the one of src. The keys that src does
+// not track are left alone. Must be called with streamMtx held.
+func (r *topkRoot) mergeRecorded(src Stream) {
+	for _, e := range src.Keys() {
+		r.record(e.Key, e.Count)
+	}
+}
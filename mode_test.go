@@ -0,0 +1,75 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestModeLast(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Mode: ModeLast}, []string{"queue"})
+	k.WithLabelValues("a").Set(10)
+	k.WithLabelValues("b").Set(3)
+	k.WithLabelValues("a").Set(2)
+	k.WithLabelValues("c").Set(1)
+	k.WithLabelValues("d").Set(5)
+
+	expected := `
+		# HELP test_metric 
+		# TYPE test_metric gauge
+		test_metric{queue="b"} 3
+		test_metric{queue="d"} 5
+	`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Set to panic with ModeSum")
+			}
+		}()
+		NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"queue"}).WithLabelValues("a").Set(1)
+	}()
+}
+
+func TestModeMax(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Mode: ModeMax}, []string{"endpoint"})
+	for _, o := range []struct {
+		endpoint string
+		v        float64
+	}{
+		{"a", 0.5}, {"b", 2}, {"a", 0.1}, {"c", 0.2}, {"a", 1}, {"b", 0.3},
+	} {
+		k.WithLabelValues(o.endpoint).Observe(o.v)
+	}
+
+	expected := `
+		# HELP test_metric 
+		# TYPE test_metric gauge
+		test_metric{endpoint="a"} 1
+		test_metric{endpoint="b"} 2
+	`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+}
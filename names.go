@@ -0,0 +1,86 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// unitPattern matches the units allowed as a suffix of the metric names.
+var unitPattern = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+// metricNames are the names of the metrics exported for every key.
+type metricNames struct {
+	count, err         string
+	histogram, summary string
+	obsCount, obsSum   string
+	share, overall     string
+	rank, firstSeen    string
+}
+
+// newMetricNames returns the names of the metrics of a TopK, appending the
+// unit, if any, to fqName. If promlint is true, the names follow the rules of
+// promlint: counters end with _total, and no name has the suffix of another
+// type of metric. counter is false if the counts are exported as gauges.
+func newMetricNames(fqName, unit string, promlint, counter bool) metricNames {
+	base := fqName
+	if unit != "" {
+		base = fmt.Sprintf("%s_%s", fqName, unit)
+	}
+	if !promlint {
+		return metricNames{
+			count:     base,
+			err:       base + "_error",
+			histogram: base + "_histogram",
+			summary:   base + "_summary",
+			obsCount:  base + "_count",
+			obsSum:    base + "_sum",
+			share:     fqName + "_share",
+			overall:   base + "_overall",
+			rank:      fqName + "_rank",
+			firstSeen: fqName + "_first_seen_timestamp_seconds",
+		}
+	}
+	names := metricNames{
+		count:     base,
+		err:       base + "_error",
+		histogram: base + "_distribution",
+		summary:   base + "_quantiles",
+		obsCount:  fqName + "_observations_total",
+		obsSum:    base + "_observed_total",
+		share:     fqName + "_share",
+		overall:   base + "_overall",
+		rank:      fqName + "_rank",
+		firstSeen: fqName + "_first_seen_timestamp_seconds",
+	}
+	if counter {
+		names.count += "_total"
+		names.overall += "_total"
+	}
+	return names
+}
+
+// checkUnit returns an error if unit cannot be a suffix of the metric names.
+func checkUnit(unit string) error {
+	if unit != "" && (!unitPattern.MatchString(unit) || unit == "total" || strings.HasSuffix(unit, "_total")) {
+		return fmt.Errorf("topk: %q is not a valid unit", unit)
+	}
+	return nil
+}
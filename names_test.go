@@ -0,0 +1,91 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/client_golang/prometheus/testutil/promlint"
+)
+
+func TestPromlintNames(t *testing.T) {
+	opts := TopKOpts{
+		Name: "response", Help: "Response sizes by user.", Buckets: 2, Unit: "bytes",
+		Quantiles: []float64{0.5}, NativeHistogramBucketFactor: 1.1, CountAndSum: true, Shares: true,
+	}
+	lint := func(k TopK) []promlint.Problem {
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(k)
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := promlint.NewWithMetricFamilies(mfs).Lint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return problems
+	}
+
+	k := NewTopK(opts, []string{"user"})
+	k.WithLabelValues("alice").Observe(100)
+	if problems := lint(k); len(problems) == 0 {
+		t.Error("expected promlint problems without PromlintNames")
+	}
+
+	opts.PromlintNames = true
+	k = NewTopK(opts, []string{"user"})
+	k.WithLabelValues("alice").Observe(100)
+	if problems := lint(k); len(problems) > 0 {
+		t.Errorf("promlint problems: %v", problems)
+	}
+	expected := `
+# HELP response_bytes_total Response sizes by user.
+# TYPE response_bytes_total counter
+response_bytes_total{user="alice"} 100
+# HELP response_bytes_error Estimation error bound for response.
+# TYPE response_bytes_error gauge
+response_bytes_error{user="alice"} -0
+# HELP response_observations_total Response sizes by user.
+# TYPE response_observations_total counter
+response_observations_total{user="alice"} 1
+# HELP response_bytes_observed_total Response sizes by user.
+# TYPE response_bytes_observed_total counter
+response_bytes_observed_total{user="alice"} 100
+# HELP response_share Estimated share of the key in the sum of all counts of response.
+# TYPE response_share gauge
+response_share{user="alice"} 1
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected),
+		"response_bytes_total", "response_bytes_error", "response_observations_total", "response_bytes_observed_total",
+		"response_share"); err != nil {
+		t.Error(err)
+	}
+
+	// the naming is kept by snapshots
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.CollectAndCompare(restored, strings.NewReader(expected), "response_bytes_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ObserverVec returns a view of t that implements prometheus.ObserverVec, so
+// that it can be passed to helpers like promhttp.InstrumentHandlerDuration.
+//
+// The returned view describes and collects only the main family of t, as the
+// promhttp helpers require a single Desc. Register t itself to export all of
+// its metric families.
+func ObserverVec(t TopK) prometheus.ObserverVec {
+	return &observerVec{t: t, desc: firstDesc(t)}
+}
+
+type observerVec struct {
+	t    TopK
+	desc *prometheus.Desc
+}
+
+var _ prometheus.ObserverVec = &observerVec{}
+
+func firstDesc(c prometheus.Collector) *prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	desc := <-ch
+	for range ch {
+	}
+	return desc
+}
+
+func (v *observerVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+func (v *observerVec) Collect(ch chan<- prometheus.Metric) {
+	mch := make(chan prometheus.Metric)
+	go func() {
+		v.t.Collect(mch)
+		close(mch)
+	}()
+	for m := range mch {
+		if m.Desc() == v.desc {
+			ch <- m
+		}
+	}
+}
+
+func (v *observerVec) GetMetricWith(labels prometheus.Labels) (prometheus.Observer, error) {
+	b, err := v.t.GetMetricWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (v *observerVec) GetMetricWithLabelValues(lvs ...string) (prometheus.Observer, error) {
+	b, err := v.t.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (v *observerVec) With(labels prometheus.Labels) prometheus.Observer {
+	return v.t.With(labels)
+}
+
+func (v *observerVec) WithLabelValues(lvs ...string) prometheus.Observer {
+	return v.t.WithLabelValues(lvs...)
+}
+
+func (v *observerVec) CurryWith(labels prometheus.Labels) (prometheus.ObserverVec, error) {
+	t, err := v.t.CurryWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return &observerVec{t: t, desc: v.desc}, nil
+}
+
+func (v *observerVec) MustCurryWith(labels prometheus.Labels) prometheus.ObserverVec {
+	return &observerVec{t: v.t.MustCurryWith(labels), desc: v.desc}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserverVec(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"code", "method"})
+
+	h := promhttp.InstrumentHandlerDuration(ObserverVec(k),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil))
+
+	if got := testutil.ToFloat64(k.WithLabelValues("418", "get")); got <= 0 {
+		t.Errorf("expected a recorded duration, got %v", got)
+	}
+
+	// the view on its own must be a consistent collector
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(ObserverVec(k)); err != nil {
+		t.Fatal(err)
+	}
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mets) != 1 || len(mets[0].Metric) != 2 {
+		t.Errorf("unexpected metrics: %v", mets)
+	}
+}
@@ -0,0 +1,690 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// DefaultBuckets is the number of tracked keys if Buckets is zero.
+	DefaultBuckets = 10
+	// MaxBuckets is the largest accepted number of tracked keys. The memory
+	// used by a TopK grows by about 100 bytes per bucket, not counting the
+	// keys themselves.
+	MaxBuckets = 1 << 20
+	// MinLabelValueLength is the smallest accepted MaxLabelValueLength, which
+	// leaves room for a prefix of 7 bytes before the hash.
+	MinLabelValueLength = 16
+	// MaxErrorCountersPerBucket is the largest accepted
+	// ErrorCountersPerBucket.
+	MaxErrorCountersPerBucket = 64
+)
+
+// Option configures a TopK created by NewTopKWithOptions. Options return an
+// error for invalid arguments.
+type Option func(*options) error
+
+type options struct {
+	TopKOpts
+	labelNames []string
+}
+
+// NewTopKWithOptions creates a TopK with the given name, configured by
+// options. Unlike NewTopK, it validates the configuration, returning an error
+// if it is invalid.
+func NewTopKWithOptions(name string, opts ...Option) (TopK, error) {
+	o := options{TopKOpts: TopKOpts{Name: name, Buckets: DefaultBuckets}}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(o.labelNames); err != nil {
+		return nil, err
+	}
+	return NewTopK(o.TopKOpts, o.labelNames), nil
+}
+
+// validate checks the options and the label names.
+func (opts *TopKOpts) validate(labelNames []string) error {
+	scheme := opts.NameValidationScheme
+	if scheme == model.UnsetValidation {
+		scheme = model.NameValidationScheme
+	} else if scheme == model.UTF8Validation && model.NameValidationScheme != model.UTF8Validation {
+		return errors.New("topk: UTF-8 names require model.NameValidationScheme to be UTF8Validation")
+	}
+	fqName := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	if !scheme.IsValidMetricName(fqName) {
+		return fmt.Errorf("topk: %q is not a valid metric name", fqName)
+	}
+	if err := checkUnit(opts.Unit); err != nil {
+		return err
+	}
+	if opts.Buckets == 0 || opts.Buckets > MaxBuckets {
+		return fmt.Errorf("topk: Buckets %d is not between 1 and %d", opts.Buckets, MaxBuckets)
+	}
+	seen := make(map[string]bool, len(labelNames)+len(opts.ConstLabels)+len(opts.ViewLabels))
+	for name := range opts.ConstLabels {
+		if !scheme.IsValidLabelName(name) {
+			return fmt.Errorf("topk: %q is not a valid label name", name)
+		}
+		seen[name] = true
+	}
+	for name := range opts.ViewLabels {
+		if !scheme.IsValidLabelName(name) {
+			return fmt.Errorf("topk: %q is not a valid label name", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("topk: duplicate label name %q", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range labelNames {
+		if !scheme.IsValidLabelName(name) {
+			return fmt.Errorf("topk: %q is not a valid label name", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("topk: duplicate label name %q", name)
+		}
+		seen[name] = true
+	}
+	partition := make(map[string]bool, len(opts.PartitionLabels))
+	for _, name := range opts.PartitionLabels {
+		if partition[name] {
+			return fmt.Errorf("topk: duplicate partition label %q", name)
+		}
+		if !containsString(labelNames, name) {
+			return fmt.Errorf("topk: partition label %q is not a variable label", name)
+		}
+		partition[name] = true
+	}
+	for name := range opts.LabelConstraints {
+		if !containsString(labelNames, name) {
+			return fmt.Errorf("topk: constrained label %q is not a variable label", name)
+		}
+	}
+	if opts.MaxLabelValueLength != 0 && opts.MaxLabelValueLength < MinLabelValueLength {
+		return fmt.Errorf("topk: MaxLabelValueLength %d is less than %d", opts.MaxLabelValueLength, MinLabelValueLength)
+	}
+	if opts.Shards < 0 {
+		return fmt.Errorf("topk: Shards %d is negative", opts.Shards)
+	}
+	if opts.Shards > 1 && (opts.NativeHistogramBucketFactor > 1 || len(opts.Quantiles) > 0 || opts.CountAndSum || opts.FirstSeen) {
+		return errors.New("topk: a sharded TopK cannot have per-key histograms, summaries, counts and sums, or first-seen times")
+	}
+	if opts.AsyncQueueSize < 0 {
+		return fmt.Errorf("topk: AsyncQueueSize %d is negative", opts.AsyncQueueSize)
+	}
+	if opts.AsyncQueueSize > 0 && opts.Shards > 1 {
+		return errors.New("topk: AsyncQueueSize cannot be combined with Shards")
+	}
+	if opts.BucketFlushInterval < 0 {
+		return fmt.Errorf("topk: BucketFlushInterval %v is negative", opts.BucketFlushInterval)
+	}
+	if opts.BucketFlushInterval > 0 && (opts.NativeHistogramBucketFactor > 1 || len(opts.Quantiles) > 0 || opts.CountAndSum || opts.FirstSeen) {
+		return errors.New("topk: a TopK with BucketFlushInterval cannot have per-key histograms, summaries, counts and sums, or first-seen times")
+	}
+	if opts.TargetObservationRate < 0 || math.IsNaN(opts.TargetObservationRate) {
+		return fmt.Errorf("topk: TargetObservationRate %v is negative", opts.TargetObservationRate)
+	}
+	if (opts.SamplingFactor > 1 || opts.TargetObservationRate > 0) && (opts.NativeHistogramBucketFactor > 1 || len(opts.Quantiles) > 0 || opts.CountAndSum) {
+		return errors.New("topk: a sampled TopK cannot have per-key histograms, summaries, or counts and sums")
+	}
+	if opts.Hash < HashSipHash || opts.Hash > HashMaphash {
+		return fmt.Errorf("topk: unknown %v", opts.Hash)
+	}
+	if opts.Hash == HashMaphash && opts.PersistPath != "" {
+		return errors.New("topk: HashMaphash cannot be combined with a PersistPath")
+	}
+	if opts.NewStream != nil && (len(opts.PartitionLabels) > 0 || opts.Shards > 1 || opts.Hash != HashSipHash || opts.PersistPath != "") {
+		return errors.New("topk: a TopK with NewStream cannot have PartitionLabels, Shards, a Hash, or a PersistPath")
+	}
+	if opts.ErrorCountersPerBucket < 0 || opts.ErrorCountersPerBucket > MaxErrorCountersPerBucket {
+		return fmt.Errorf("topk: ErrorCountersPerBucket %d is not between 0 and %d", opts.ErrorCountersPerBucket, MaxErrorCountersPerBucket)
+	}
+	if opts.ErrorCountersPerBucket > 0 && opts.NewStream != nil {
+		return errors.New("topk: ErrorCountersPerBucket cannot be combined with NewStream")
+	}
+	if opts.HalfLife < 0 {
+		return fmt.Errorf("topk: HalfLife %v is negative", opts.HalfLife)
+	}
+	if opts.HalfLife > 0 && (opts.Shards > 1 || opts.NewStream != nil) {
+		return errors.New("topk: HalfLife cannot be combined with Shards or NewStream")
+	}
+	if opts.KeyTTL < 0 {
+		return fmt.Errorf("topk: KeyTTL %v is negative", opts.KeyTTL)
+	}
+	if opts.KeyTTL > 0 && opts.Shards > 1 {
+		return errors.New("topk: KeyTTL cannot be combined with Shards")
+	}
+	if !(opts.ShareAlert >= 0 && opts.ShareAlert < 1) {
+		return fmt.Errorf("topk: ShareAlert %v is not between 0 and 1", opts.ShareAlert)
+	}
+	if opts.ShareAlert > 0 && opts.OnShareAlert == nil {
+		return errors.New("topk: ShareAlert needs an OnShareAlert function")
+	}
+	if opts.ShareAlert > 0 && (opts.Shards > 1 || opts.BucketFlushInterval > 0 || opts.Mode != ModeSum) {
+		return errors.New("topk: ShareAlert cannot be combined with Shards, BucketFlushInterval, or a Mode other than ModeSum")
+	}
+	if opts.IdleRate < 0 || math.IsNaN(opts.IdleRate) {
+		return fmt.Errorf("topk: IdleRate %v is negative", opts.IdleRate)
+	}
+	if opts.IdleRate > 0 && opts.IdleWindow <= 0 {
+		return fmt.Errorf("topk: IdleWindow %v is not positive", opts.IdleWindow)
+	}
+	if opts.IdleRate > 0 && (opts.HalfLife > 0 || opts.Mode != ModeSum) {
+		return errors.New("topk: IdleRate cannot be combined with HalfLife or a Mode other than ModeSum")
+	}
+	if opts.Audit && (opts.Shards > 1 || opts.HalfLife > 0 || opts.KeyTTL > 0 || opts.IdleRate > 0) {
+		return errors.New("topk: Audit cannot be combined with Shards, HalfLife, KeyTTL, or IdleRate")
+	}
+	if opts.Mode < ModeSum || opts.Mode > ModeMax {
+		return fmt.Errorf("topk: unknown %v", opts.Mode)
+	}
+	if opts.Mode != ModeSum && (opts.Shards > 1 || opts.BucketFlushInterval > 0 || opts.HalfLife > 0 ||
+		opts.DecrementPolicy != DecrementPolicyDisallow) {
+		return fmt.Errorf("topk: Mode %v cannot be combined with Shards, BucketFlushInterval, HalfLife, or a DecrementPolicy", opts.Mode)
+	}
+	if opts.Deterministic && (opts.SamplingFactor > 1 || opts.TargetObservationRate > 0 || opts.Hash == HashMaphash ||
+		opts.Shards > 1 || len(opts.PartitionLabels) > 0 || opts.NewStream != nil || opts.PersistPath != "") {
+		return errors.New("topk: a Deterministic TopK cannot have sampling, HashMaphash, Shards, PartitionLabels, NewStream, or a PersistPath")
+	}
+	for _, q := range opts.Quantiles {
+		if q < 0 || q > 1 || math.IsNaN(q) {
+			return fmt.Errorf("topk: quantile %v is not between 0 and 1", q)
+		}
+	}
+	return nil
+}
+
+// WithNamespace sets the Namespace of the fully-qualified name.
+func WithNamespace(namespace string) Option {
+	return func(o *options) error {
+		o.Namespace = namespace
+		return nil
+	}
+}
+
+// WithSubsystem sets the Subsystem of the fully-qualified name.
+func WithSubsystem(subsystem string) Option {
+	return func(o *options) error {
+		o.Subsystem = subsystem
+		return nil
+	}
+}
+
+// WithHelp sets the Help string.
+func WithHelp(help string) Option {
+	return func(o *options) error {
+		o.Help = help
+		return nil
+	}
+}
+
+// WithUnit appends a unit to the names of the metrics; see TopKOpts.Unit.
+func WithUnit(unit string) Option {
+	return func(o *options) error {
+		o.Unit = unit
+		return nil
+	}
+}
+
+// WithPromlintNames names the metrics so that they pass promlint; see
+// TopKOpts.PromlintNames.
+func WithPromlintNames() Option {
+	return func(o *options) error {
+		o.PromlintNames = true
+		return nil
+	}
+}
+
+// WithErrorHelp sets the Help string of the error metric.
+func WithErrorHelp(help string) Option {
+	return func(o *options) error {
+		o.ErrorHelp = help
+		return nil
+	}
+}
+
+// WithConstLabels adds constant labels.
+func WithConstLabels(labels prometheus.Labels) Option {
+	return func(o *options) error {
+		if o.ConstLabels == nil {
+			o.ConstLabels = make(prometheus.Labels, len(labels))
+		}
+		for name, value := range labels {
+			o.ConstLabels[name] = value
+		}
+		return nil
+	}
+}
+
+// WithViewLabels adds labels to the exported metrics that are not part of the
+// state of the TopK; see TopKOpts.ViewLabels.
+func WithViewLabels(labels prometheus.Labels) Option {
+	return func(o *options) error {
+		if o.ViewLabels == nil {
+			o.ViewLabels = make(prometheus.Labels, len(labels))
+		}
+		for name, value := range labels {
+			o.ViewLabels[name] = value
+		}
+		return nil
+	}
+}
+
+// WithLabelNames sets the names of the variable labels.
+func WithLabelNames(names ...string) Option {
+	return func(o *options) error {
+		o.labelNames = append([]string(nil), names...)
+		return nil
+	}
+}
+
+// WithBuckets sets the number of tracked keys, which must be between 1 and
+// MaxBuckets. The default is DefaultBuckets.
+func WithBuckets(n uint64) Option {
+	return func(o *options) error {
+		if n == 0 || n > MaxBuckets {
+			return fmt.Errorf("topk: Buckets %d is not between 1 and %d", n, MaxBuckets)
+		}
+		o.Buckets = n
+		return nil
+	}
+}
+
+// WithExportedKeys limits the keys exported by Collect; see
+// TopKOpts.ExportedKeys.
+func WithExportedKeys(n uint64) Option {
+	return func(o *options) error {
+		o.ExportedKeys = n
+		return nil
+	}
+}
+
+// WithNameValidationScheme sets the NameValidationScheme.
+func WithNameValidationScheme(scheme model.ValidationScheme) Option {
+	return func(o *options) error {
+		o.NameValidationScheme = scheme
+		return nil
+	}
+}
+
+// WithLabelConstraint normalizes the values of the variable label name with
+// f; see TopKOpts.LabelConstraints.
+func WithLabelConstraint(name string, f func(string) string) Option {
+	return func(o *options) error {
+		if f == nil {
+			return fmt.Errorf("topk: nil constraint for label %q", name)
+		}
+		if o.LabelConstraints == nil {
+			o.LabelConstraints = make(map[string]func(string) string)
+		}
+		o.LabelConstraints[name] = f
+		return nil
+	}
+}
+
+// WithPartitionLabels tracks the top keys of every combination of values of
+// the given labels independently; see TopKOpts.PartitionLabels.
+func WithPartitionLabels(names ...string) Option {
+	return func(o *options) error {
+		o.PartitionLabels = append([]string(nil), names...)
+		return nil
+	}
+}
+
+// WithShards spreads the observations over n shards; see TopKOpts.Shards.
+func WithShards(n int) Option {
+	return func(o *options) error {
+		if n < 1 {
+			return fmt.Errorf("topk: Shards %d is not positive", n)
+		}
+		o.Shards = n
+		return nil
+	}
+}
+
+// WithAsyncQueue makes Observe queue the observations in a queue of the given
+// size for a background goroutine; see TopKOpts.AsyncQueueSize.
+func WithAsyncQueue(size int) Option {
+	return func(o *options) error {
+		if size < 1 {
+			return fmt.Errorf("topk: AsyncQueueSize %d is not positive", size)
+		}
+		o.AsyncQueueSize = size
+		return nil
+	}
+}
+
+// WithBucketFlushInterval makes the TopKBuckets accumulate their observations
+// locally, recording them every interval; see TopKOpts.BucketFlushInterval.
+func WithBucketFlushInterval(interval time.Duration) Option {
+	return func(o *options) error {
+		if interval <= 0 {
+			return fmt.Errorf("topk: BucketFlushInterval %v is not positive", interval)
+		}
+		o.BucketFlushInterval = interval
+		return nil
+	}
+}
+
+// WithSampling records only one in factor observations, scaled by factor;
+// see TopKOpts.SamplingFactor.
+func WithSampling(factor uint64) Option {
+	return func(o *options) error {
+		if factor == 0 {
+			return errors.New("topk: SamplingFactor must be positive")
+		}
+		o.SamplingFactor = factor
+		return nil
+	}
+}
+
+// WithAdaptiveSampling adjusts the sampling factor to record about rate
+// observations per second; see TopKOpts.TargetObservationRate.
+func WithAdaptiveSampling(rate float64) Option {
+	return func(o *options) error {
+		if !(rate > 0) {
+			return fmt.Errorf("topk: TargetObservationRate %v is not positive", rate)
+		}
+		o.TargetObservationRate = rate
+		return nil
+	}
+}
+
+// WithHash sets the function hashing the keys; see TopKOpts.Hash.
+func WithHash(h HashFunction) Option {
+	return func(o *options) error {
+		o.Hash = h
+		return nil
+	}
+}
+
+// WithStream makes the TopK use the Stream returned by newStream; see
+// TopKOpts.NewStream.
+func WithStream(newStream func(buckets int) Stream) Option {
+	return func(o *options) error {
+		if newStream == nil {
+			return errors.New("topk: nil NewStream")
+		}
+		o.NewStream = newStream
+		return nil
+	}
+}
+
+// WithErrorCounters sets the number of error estimates per bucket; see
+// TopKOpts.ErrorCountersPerBucket.
+func WithErrorCounters(perBucket int) Option {
+	return func(o *options) error {
+		if perBucket < 1 {
+			return fmt.Errorf("topk: ErrorCountersPerBucket %d is not positive", perBucket)
+		}
+		o.ErrorCountersPerBucket = perBucket
+		return nil
+	}
+}
+
+// WithHalfLife weights the observations by their recency, halving their
+// weight every halfLife; see TopKOpts.HalfLife.
+func WithHalfLife(halfLife time.Duration) Option {
+	return func(o *options) error {
+		if halfLife <= 0 {
+			return fmt.Errorf("topk: HalfLife %v is not positive", halfLife)
+		}
+		o.HalfLife = halfLife
+		return nil
+	}
+}
+
+// WithIdleRate removes the tracked keys whose count grew by less than rate
+// per second over a window; see TopKOpts.IdleRate.
+func WithIdleRate(rate float64, window time.Duration) Option {
+	return func(o *options) error {
+		if !(rate > 0) || window <= 0 {
+			return fmt.Errorf("topk: IdleRate %v over %v is not positive", rate, window)
+		}
+		o.IdleRate = rate
+		o.IdleWindow = window
+		return nil
+	}
+}
+
+// WithKeyTTL removes the tracked keys that were not observed for ttl; see
+// TopKOpts.KeyTTL.
+func WithKeyTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		if ttl <= 0 {
+			return fmt.Errorf("topk: KeyTTL %v is not positive", ttl)
+		}
+		o.KeyTTL = ttl
+		return nil
+	}
+}
+
+// WithClock replaces time.Now as the clock of the TopK; see TopKOpts.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) error {
+		if now == nil {
+			return errors.New("topk: nil clock")
+		}
+		o.Now = now
+		return nil
+	}
+}
+
+// WithDeterministic makes the output of the TopK only depend on its
+// observations, for tests; see TopKOpts.Deterministic.
+func WithDeterministic() Option {
+	return func(o *options) error {
+		o.Deterministic = true
+		return nil
+	}
+}
+
+// WithAudit also counts every key exactly, to export the accuracy of the
+// TopK; see TopKOpts.Audit.
+func WithAudit() Option {
+	return func(o *options) error {
+		o.Audit = true
+		return nil
+	}
+}
+
+// WithReportingThreshold sets the ReportingThreshold.
+func WithReportingThreshold(threshold float64) Option {
+	return func(o *options) error {
+		o.ReportingThreshold = threshold
+		return nil
+	}
+}
+
+// WithValuePolicy sets the ValuePolicy.
+func WithValuePolicy(p ValuePolicy) Option {
+	return func(o *options) error {
+		o.ValuePolicy = p
+		return nil
+	}
+}
+
+// WithMode sets the Mode combining the observations of a key.
+func WithMode(m Mode) Option {
+	return func(o *options) error {
+		o.Mode = m
+		return nil
+	}
+}
+
+// WithDecrementPolicy sets the DecrementPolicy.
+func WithDecrementPolicy(p DecrementPolicy) Option {
+	return func(o *options) error {
+		o.DecrementPolicy = p
+		return nil
+	}
+}
+
+// WithLabelValuePolicy sets the LabelValuePolicy.
+func WithLabelValuePolicy(p LabelValuePolicy) Option {
+	return func(o *options) error {
+		o.LabelValuePolicy = p
+		return nil
+	}
+}
+
+// WithMaxLabelValueLength sets the MaxLabelValueLength.
+func WithMaxLabelValueLength(n int) Option {
+	return func(o *options) error {
+		if n < MinLabelValueLength {
+			return fmt.Errorf("topk: MaxLabelValueLength %d is less than %d", n, MinLabelValueLength)
+		}
+		o.MaxLabelValueLength = n
+		return nil
+	}
+}
+
+// WithNativeHistogram enables the per-key native histograms with the given
+// bucket factor, which must be greater than one. The other NativeHistogram
+// fields keep their defaults.
+func WithNativeHistogram(bucketFactor float64) Option {
+	return func(o *options) error {
+		if !(bucketFactor > 1) {
+			return fmt.Errorf("topk: native histogram bucket factor %v is not greater than 1", bucketFactor)
+		}
+		o.NativeHistogramBucketFactor = bucketFactor
+		return nil
+	}
+}
+
+// WithQuantiles enables the per-key summaries with the given quantiles.
+func WithQuantiles(quantiles ...float64) Option {
+	return func(o *options) error {
+		o.Quantiles = append([]float64(nil), quantiles...)
+		return nil
+	}
+}
+
+// WithQuantileCompression sets the QuantileCompression of the per-key
+// summaries.
+func WithQuantileCompression(compression float64) Option {
+	return func(o *options) error {
+		if !(compression > 0) {
+			return fmt.Errorf("topk: quantile compression %v is not positive", compression)
+		}
+		o.QuantileCompression = compression
+		return nil
+	}
+}
+
+// WithCountAndSum enables the per-key count and sum of the observations.
+func WithCountAndSum() Option {
+	return func(o *options) error {
+		o.CountAndSum = true
+		return nil
+	}
+}
+
+// WithShares enables the share and overall sum metrics; see TopKOpts.Shares.
+func WithShares() Option {
+	return func(o *options) error {
+		o.Shares = true
+		return nil
+	}
+}
+
+// WithRanks enables the rank metric; see TopKOpts.Ranks.
+func WithRanks() Option {
+	return func(o *options) error {
+		o.Ranks = true
+		return nil
+	}
+}
+
+// WithOnEnterTopK sets the function called with the keys that start being
+// exported; see TopKOpts.OnEnterTopK.
+func WithOnEnterTopK(f func(labels prometheus.Labels, count float64)) Option {
+	return func(o *options) error {
+		o.OnEnterTopK = f
+		return nil
+	}
+}
+
+// WithOnExitTopK sets the function called with the keys that stop being
+// exported; see TopKOpts.OnExitTopK.
+func WithOnExitTopK(f func(labels prometheus.Labels)) Option {
+	return func(o *options) error {
+		o.OnExitTopK = f
+		return nil
+	}
+}
+
+// WithShareAlert calls f with the keys going over fraction of the total once
+// it is at least minTotal; see TopKOpts.ShareAlert.
+func WithShareAlert(fraction, minTotal float64, f func(labels prometheus.Labels, share float64)) Option {
+	return func(o *options) error {
+		o.ShareAlert = fraction
+		o.ShareAlertMinTotal = minTotal
+		o.OnShareAlert = f
+		return nil
+	}
+}
+
+// WithFirstSeen enables the first-seen timestamp metric; see
+// TopKOpts.FirstSeen.
+func WithFirstSeen() Option {
+	return func(o *options) error {
+		o.FirstSeen = true
+		return nil
+	}
+}
+
+// WithPersistence enables checkpointing to path every interval; see
+// TopKOpts.PersistPath.
+func WithPersistence(path string, interval time.Duration) Option {
+	return func(o *options) error {
+		if path == "" {
+			return errors.New("topk: empty persistence path")
+		}
+		o.PersistPath = path
+		o.PersistInterval = interval
+		return nil
+	}
+}
+
+// WithPersistErrorLog sets the PersistErrorLog.
+func WithPersistErrorLog(l Logger) Option {
+	return func(o *options) error {
+		o.PersistErrorLog = l
+		return nil
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+)
+
+func TestNewTopKWithOptions(t *testing.T) {
+	k, err := NewTopKWithOptions("requests",
+		WithNamespace("ns"),
+		WithHelp("Requests by user."),
+		WithErrorHelp("Overcount of the requests by user."),
+		WithConstLabels(prometheus.Labels{"zone": "a"}),
+		WithLabelNames("user"),
+		WithBuckets(2),
+		WithReportingThreshold(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.WithLabelValues("alice").Add(3)
+	k.WithLabelValues("bob").Add(1)
+
+	expected := `
+# HELP ns_requests Requests by user.
+# TYPE ns_requests counter
+ns_requests{user="alice",zone="a"} 3
+# HELP ns_requests_error Overcount of the requests by user.
+# TYPE ns_requests_error gauge
+ns_requests_error{user="alice",zone="a"} -0
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), "ns_requests", "ns_requests_error"); err != nil {
+		t.Error(err)
+	}
+
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if help := restored.SnapshotProto().GetErrorHelp(); help != "Overcount of the requests by user." {
+		t.Errorf("restored error help %q", help)
+	}
+}
+
+func TestDefaultBuckets(t *testing.T) {
+	k, err := NewTopKWithOptions("requests", WithLabelNames("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := k.SnapshotProto().GetBuckets(); n != DefaultBuckets {
+		t.Errorf("got %d buckets expected %d", n, DefaultBuckets)
+	}
+	if n := NewTopK(TopKOpts{Name: metricName}, nil).SnapshotProto().GetBuckets(); n != DefaultBuckets {
+		t.Errorf("NewTopK: got %d buckets expected %d", n, DefaultBuckets)
+	}
+}
+
+func TestNewTopKPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "Buckets") {
+			t.Errorf("expected panic for too many buckets, got %v", r)
+		}
+	}()
+	NewTopK(TopKOpts{Name: metricName, Buckets: MaxBuckets + 1}, nil)
+}
+
+func TestNewTopKWithOptionsErrors(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"zero buckets":       {WithBuckets(0)},
+		"too many buckets":   {WithBuckets(MaxBuckets + 1)},
+		"bad label":          {WithBuckets(1), WithLabelNames("")},
+		"duplicate label":    {WithBuckets(1), WithLabelNames("a", "a")},
+		"const label clash":  {WithBuckets(1), WithLabelNames("a"), WithConstLabels(prometheus.Labels{"a": "x"})},
+		"bad quantile":       {WithBuckets(1), WithQuantiles(1.5)},
+		"bad unit":           {WithUnit("Bytes")},
+		"bad factor":         {WithBuckets(1), WithNativeHistogram(1)},
+		"bad compression":    {WithBuckets(1), WithQuantileCompression(0)},
+		"empty path":         {WithBuckets(1), WithPersistence("", 0)},
+		"unknown partition":  {WithLabelNames("a"), WithPartitionLabels("b")},
+		"dup partition":      {WithLabelNames("a"), WithPartitionLabels("a", "a")},
+		"unknown constraint": {WithLabelNames("a"), WithLabelConstraint("b", strings.ToLower)},
+		"nil constraint":     {WithLabelNames("a"), WithLabelConstraint("a", nil)},
+		"short max length":   {WithMaxLabelValueLength(MinLabelValueLength - 1)},
+		"unknown hash":       {WithHash(HashMaphash + 1)},
+		"persisted maphash":  {WithHash(HashMaphash), WithPersistence("topk.pb", 0)},
+		"no counters":        {WithErrorCounters(0)},
+		"too many counters":  {WithErrorCounters(MaxErrorCountersPerBucket + 1)},
+		"zero half-life":     {WithHalfLife(0)},
+		"sharded half-life":  {WithHalfLife(time.Hour), WithShards(2)},
+		"zero TTL":           {WithKeyTTL(0)},
+		"sharded TTL":        {WithKeyTTL(time.Hour), WithShards(2)},
+		"audit with TTL":     {WithAudit(), WithKeyTTL(time.Hour)},
+		"share alert of 1":   {WithShareAlert(1, 0, func(prometheus.Labels, float64) {})},
+		"nil share alert":    {WithShareAlert(0.5, 0, nil)},
+		"sharded first seen": {WithFirstSeen(), WithShards(2)},
+		"zero idle rate":     {WithIdleRate(0, time.Minute)},
+		"decayed idle rate":  {WithIdleRate(1, time.Minute), WithHalfLife(time.Hour)},
+		"unknown mode":       {WithMode(Mode(5))},
+		"mode with shards":   {WithMode(ModeLast), WithShards(2)},
+	} {
+		if _, err := NewTopKWithOptions("requests", opts...); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := NewTopKWithOptions("", WithBuckets(1)); err == nil {
+		t.Error("expected error for empty name")
+	}
+}
+
+func TestUTF8Names(t *testing.T) {
+	k, err := NewTopKWithOptions("http.server.requests",
+		WithLabelNames("http.route"),
+		WithNameValidationScheme(model.UTF8Validation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.WithLabelValues("/x").Inc()
+
+	want := `
+# HELP "http.server.requests" 
+# TYPE "http.server.requests" counter
+{"http.server.requests","http.route"="/x"} 1
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(want), "http.server.requests"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewTopKWithOptions("http.server.requests", WithNameValidationScheme(model.LegacyValidation)); err == nil {
+		t.Error("expected error for a UTF-8 name with legacy validation")
+	}
+	if _, err := NewTopKWithOptions("requests", WithLabelNames("http.route"), WithNameValidationScheme(model.LegacyValidation)); err == nil {
+		t.Error("expected error for a UTF-8 label name with legacy validation")
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"sort"
+	"strings"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/common/model"
+)
+
+// Stream is the summary structure of a TopK, tracking the counts of the top
+// keys, like the topkstream.Stream it is by default. It can be replaced by
+// setting TopKOpts.NewStream. The TopK serializes all calls to its Stream.
+// The keys are composite keys of all the label values; use ParseKey to split
+// them.
+type Stream interface {
+	// Insert adds count to key, returning its new estimate.
+	Insert(key string, count float64) tk.Element
+	// Estimate returns the estimate of key, whether it is tracked or not.
+	Estimate(key string) tk.Element
+	// Monitored reports whether key is tracked.
+	Monitored(key string) bool
+	// Remove stops tracking key, returning false if it was not tracked.
+	Remove(key string) bool
+	// Reset discards all counts.
+	Reset()
+	// Keys returns the tracked keys, ordered by decreasing count.
+	Keys() []tk.Element
+	// Range calls f for each tracked key, in any order, until f returns
+	// false.
+	Range(f func(tk.Element) bool)
+	// Capacity returns the number of keys that can be tracked.
+	Capacity() int
+	// Resize changes the number of keys that can be tracked, calling the
+	// OnEvict function for the keys that no longer fit.
+	Resize(n int)
+	// OnEvict sets the function to call with every key that stops being
+	// tracked, other than by Remove and Reset.
+	OnEvict(f func(key string))
+}
+
+var (
+	_ Stream = &tk.Stream{}
+	_ Stream = &partitionedStream{}
+)
+
+// partitionedStream tracks the top keys of every partition independently,
+// where the partition of a key is given by the values of the partition labels.
+// A partition is created by the first Insert of one of its keys, and kept
+// until Reset.
+type partitionedStream struct {
+	n int
+	// positions of the partition labels in the composite keys, increasing
+	index []int
+	parts map[string]*tk.Stream
+	// the hash function of the partitions, nil for the default
+	hash func(string) uint64
+	// error counters per bucket of the new partitions
+	counters int
+
+	onEvict func(key string)
+}
+
+func newPartitionedStream(n int, index []int, hash func(string) uint64, counters int) *partitionedStream {
+	return &partitionedStream{
+		n:        n,
+		index:    index,
+		parts:    make(map[string]*tk.Stream),
+		hash:     hash,
+		counters: counters,
+	}
+}
+
+// partitionKey returns the values of the partition labels in a composite key,
+// each followed by the separator byte.
+func (p *partitionedStream) partitionKey(key string) string {
+	var sb strings.Builder
+	field, iIndex := 0, 0
+	for iIndex < len(p.index) {
+		end := strings.IndexByte(key, model.SeparatorByte)
+		if end < 0 {
+			break
+		}
+		if field == p.index[iIndex] {
+			sb.WriteString(key[:end+1])
+			iIndex++
+		}
+		key = key[end+1:]
+		field++
+	}
+	return sb.String()
+}
+
+// addPartition adds the stream of a new partition, setting its hash function.
+func (p *partitionedStream) addPartition(pk string, s *tk.Stream) {
+	s.SetHash(p.hash)
+	s.OnEvict(func(key string) {
+		if p.onEvict != nil {
+			p.onEvict(key)
+		}
+	})
+	p.parts[pk] = s
+}
+
+func (p *partitionedStream) Insert(key string, count float64) tk.Element {
+	pk := p.partitionKey(key)
+	s := p.parts[pk]
+	if s == nil {
+		s = tk.NewStreamWithCounters(p.n, p.n*p.counters)
+		p.addPartition(pk, s)
+	}
+	return s.Insert(key, count)
+}
+
+func (p *partitionedStream) Set(key string, count float64) tk.Element {
+	pk := p.partitionKey(key)
+	s := p.parts[pk]
+	if s == nil {
+		s = tk.NewStreamWithCounters(p.n, p.n*p.counters)
+		p.addPartition(pk, s)
+	}
+	return s.Set(key, count)
+}
+
+func (p *partitionedStream) Estimate(key string) tk.Element {
+	if s := p.parts[p.partitionKey(key)]; s != nil {
+		return s.Estimate(key)
+	}
+	return tk.Element{Key: key}
+}
+
+func (p *partitionedStream) Monitored(key string) bool {
+	s := p.parts[p.partitionKey(key)]
+	return s != nil && s.Monitored(key)
+}
+
+func (p *partitionedStream) Remove(key string) bool {
+	s := p.parts[p.partitionKey(key)]
+	return s != nil && s.Remove(key)
+}
+
+func (p *partitionedStream) Reset() {
+	p.parts = make(map[string]*tk.Stream)
+}
+
+// Keys returns the tracked keys of all partitions, in the order of
+// tk.Stream.Keys.
+func (p *partitionedStream) Keys() []tk.Element {
+	var elts []tk.Element
+	for _, s := range p.parts {
+		s.Range(func(e tk.Element) bool {
+			elts = append(elts, e)
+			return true
+		})
+	}
+	sort.Slice(elts, func(i, j int) bool {
+		return elts[i].Count > elts[j].Count || (elts[i].Count == elts[j].Count && elts[i].Key < elts[j].Key)
+	})
+	return elts
+}
+
+func (p *partitionedStream) Range(f func(tk.Element) bool) {
+	for _, s := range p.parts {
+		cont := true
+		s.Range(func(e tk.Element) bool {
+			cont = f(e)
+			return cont
+		})
+		if !cont {
+			return
+		}
+	}
+}
+
+// Capacity returns the capacity of every partition.
+func (p *partitionedStream) Capacity() int {
+	return p.n
+}
+
+// Total returns the sum of the totals of the partitions.
+func (p *partitionedStream) Total() float64 {
+	var total float64
+	for _, s := range p.parts {
+		total += s.Total()
+	}
+	return total
+}
+
+// Resize changes the capacity of every partition.
+func (p *partitionedStream) Resize(n int) {
+	p.n = n
+	for _, s := range p.parts {
+		s.Resize(n)
+	}
+}
+
+func (p *partitionedStream) OnEvict(f func(key string)) {
+	p.onEvict = f
+}
+
+// merge adds the counts of other, which must have the same partition labels
+// and capacity, partition by partition.
+func (p *partitionedStream) merge(other *partitionedStream) error {
+	for pk, o := range other.parts {
+		if s := p.parts[pk]; s != nil {
+			if err := s.Merge(o); err != nil {
+				return err
+			}
+			continue
+		}
+		elts, alphas, total := o.State()
+		s, err := tk.NewStreamFromState(p.n, elts, alphas, total)
+		if err != nil {
+			return err
+		}
+		p.addPartition(pk, s)
+	}
+	return nil
+}
+
+// newStream returns an empty stream for the root.
+func (r *topkRoot) newStream() Stream {
+	if r.customStream != nil {
+		return r.customStream(r.buckets)
+	}
+	if len(r.partitionLabels) == 0 {
+		s := tk.NewStreamWithCounters(r.buckets, r.buckets*r.errorCounters)
+		s.SetHash(r.hash.hashFunc())
+		return s
+	}
+	index, _ := partitionIndex(r.variableLabels, r.partitionLabels)
+	return newPartitionedStream(r.buckets, index, r.hash.hashFunc(), r.errorCounters)
+}
+
+// streamElements copies the tracked elements of s with a count of at least
+// min, in no particular order, which is cheaper than Keys since they are not
+// sorted.
+func streamElements(s Stream, min float64) []tk.Element {
+	elts := make([]tk.Element, 0, s.Capacity())
+	s.Range(func(e tk.Element) bool {
+		if e.Count >= min {
+			elts = append(elts, e)
+		}
+		return true
+	})
+	return elts
+}
+
+// streamFloor returns the count that a tracked key of s must reach to be
+// certain to be among the true top keys: the minimum tracked count if s is
+// full, otherwise zero.
+func streamFloor(s Stream) float64 {
+	var n int
+	floor := 0.0
+	s.Range(func(e tk.Element) bool {
+		if n == 0 || e.Count < floor {
+			floor = e.Count
+		}
+		n++
+		return true
+	})
+	if n < s.Capacity() {
+		return 0
+	}
+	return floor
+}
+
+// streamTotal returns the sum of all counts inserted into s, or of the counts
+// of its tracked keys if s does not know it.
+func streamTotal(s Stream) float64 {
+	if s, ok := s.(interface{ Total() float64 }); ok {
+		return s.Total()
+	}
+	var total float64
+	s.Range(func(e tk.Element) bool {
+		total += e.Count
+		return true
+	})
+	return total
+}
+
+// streamSet sets the count of key in s, or inserts the difference with its
+// estimate if s cannot set counts.
+func streamSet(s Stream, key string, count float64) {
+	if s, ok := s.(interface {
+		Set(key string, count float64) tk.Element
+	}); ok {
+		s.Set(key, count)
+		return
+	}
+	s.Insert(key, count-s.Estimate(key).Count)
+}
+
+// partitionIndex returns the increasing positions of the partition labels
+// among the label names, and the partition labels in that order.
+func partitionIndex(labelNames, partitionLabels []string) ([]int, []string) {
+	if len(partitionLabels) == 0 {
+		return nil, nil
+	}
+	var (
+		index []int
+		names []string
+	)
+	for i, name := range labelNames {
+		for _, p := range partitionLabels {
+			if p == name {
+				index = append(index, i)
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	return index, names
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newPartitioned() TopK {
+	return NewTopK(TopKOpts{Name: metricName, Buckets: 2, PartitionLabels: []string{"tenant"}}, []string{"path", "tenant"})
+}
+
+func TestPartitionLabels(t *testing.T) {
+	k := newPartitioned()
+	k.WithLabelValues("/a", "small").Add(1)
+	k.WithLabelValues("/b", "small").Add(2)
+	for _, path := range []string{"/x", "/y", "/z"} {
+		k.WithLabelValues(path, "big").Add(100)
+	}
+
+	if n := len(k.Snapshot()); n != 4 {
+		t.Errorf("got %d tracked keys, expected 4", n)
+	}
+	small := k.MustCurryWith(prometheus.Labels{"tenant": "small"})
+	if rank, ok := small.Rank("/a"); rank != 2 || !ok {
+		t.Errorf("Rank = %v, %v, expected the key of the small partition to be kept", rank, ok)
+	}
+	for _, e := range small.Snapshot() {
+		if !e.Guaranteed {
+			t.Errorf("key %v of the small partition is not guaranteed", e.Labels)
+		}
+	}
+	if count, _, tracked := k.Estimate(prometheus.Labels{"path": "/b", "tenant": "other"}); count != 0 || tracked {
+		t.Errorf("Estimate = %v, %v in a missing partition", count, tracked)
+	}
+
+	k.Reset()
+	if n := len(k.Snapshot()); n != 0 {
+		t.Errorf("got %d tracked keys after Reset", n)
+	}
+}
+
+func TestPartitionLabelsSnapshot(t *testing.T) {
+	k := newPartitioned()
+	k.WithLabelValues("/a", "t1").Add(1)
+	k.WithLabelValues("/b", "t1").Add(2)
+	k.WithLabelValues("/c", "t1").Add(3)
+	k.WithLabelValues("/a", "t2").Add(4)
+
+	snap := k.SnapshotProto()
+	if err := snap.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.GetPartitions()) != 2 || snap.GetTotal() != 10 {
+		t.Errorf("got %d partitions with total %v", len(snap.GetPartitions()), snap.GetTotal())
+	}
+	restored, err := NewTopKFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+	// the count of the evicted key is kept in the error estimates
+	if count, _, tracked := restored.Estimate(prometheus.Labels{"path": "/a", "tenant": "t1"}); count != 1 || tracked {
+		t.Errorf("Estimate = %v, %v for an evicted key", count, tracked)
+	}
+	restored.WithLabelValues("/a", "t3").Add(1)
+	if rank, ok := restored.Rank("/a", "t3"); rank != 4 || !ok {
+		t.Errorf("Rank = %v, %v after restore", rank, ok)
+	}
+
+	data, err := k.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := newPartitioned()
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decoded.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	if err := k.Merge(restored); err != nil {
+		t.Fatal(err)
+	}
+	if count, _, _ := k.Estimate(prometheus.Labels{"path": "/a", "tenant": "t2"}); count != 8 {
+		t.Errorf("got count %v after merge, expected 8", count)
+	}
+	if count, _, tracked := k.Estimate(prometheus.Labels{"path": "/a", "tenant": "t3"}); count != 1 || !tracked {
+		t.Errorf("got count %v, %v for a merged partition", count, tracked)
+	}
+
+	unpartitioned := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"path", "tenant"})
+	if err := unpartitioned.RestoreSnapshotProto(snap); err == nil {
+		t.Error("expected error restoring a partitioned snapshot")
+	}
+	if err := k.RestoreSnapshotProto(unpartitioned.SnapshotProto()); err == nil {
+		t.Error("expected error restoring an unpartitioned snapshot")
+	}
+	if err := unpartitioned.UnmarshalBinary(data); err == nil {
+		t.Error("expected error decoding a partitioned encoding")
+	}
+}
+
+func TestStreamElements(t *testing.T) {
+	k := newPartitioned()
+	k.WithLabelValues("/a", "x").Add(1)
+	k.WithLabelValues("/b", "x").Add(3)
+	k.WithLabelValues("/c", "y").Add(2)
+
+	root := k.(*topkCurry).root
+	elts := streamElements(root.stream, 2)
+	sort.Slice(elts, func(i, j int) bool { return elts[i].Count > elts[j].Count })
+	if want := root.stream.Keys()[:2]; !reflect.DeepEqual(elts, want) {
+		t.Errorf("got %v, expected %v", elts, want)
+	}
+}
+
+// countingStream is a custom Stream counting its inserts.
+type countingStream struct {
+	*tk.Stream
+	inserts int
+}
+
+func (s *countingStream) Insert(key string, count float64) tk.Element {
+	s.inserts++
+	return s.Stream.Insert(key, count)
+}
+
+func TestNewStream(t *testing.T) {
+	var cs *countingStream
+	opts := TopKOpts{Name: metricName, Buckets: 2, NewStream: func(buckets int) Stream {
+		cs = &countingStream{Stream: tk.NewStream(buckets)}
+		return cs
+	}}
+	k := NewTopK(opts, []string{"a"})
+	k.WithLabelValues("x").Add(3)
+	k.WithLabelValues("y").Add(1)
+	if cs.inserts != 2 {
+		t.Errorf("got %d inserts expected 2", cs.inserts)
+	}
+	if got, want := k.Snapshot(), []Element{
+		{Labels: prometheus.Labels{"a": "x"}, Count: 3, Guaranteed: true},
+		{Labels: prometheus.Labels{"a": "y"}, Count: 1, Guaranteed: true},
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+	var n int
+	k.Range(func(prometheus.Labels, float64, float64) bool {
+		n++
+		return true
+	})
+	if n != 2 {
+		t.Errorf("Range visited %d keys", n)
+	}
+	if k.EstimateMemory() == 0 {
+		t.Error("the MemoryUsage of the stream is not used")
+	}
+
+	// the tracked keys can be restored into a regular TopK
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+	// but nothing can replace the state of the custom stream
+	if err := k.RestoreSnapshotProto(restored.SnapshotProto()); err == nil {
+		t.Error("expected error restoring a custom stream")
+	}
+	if _, err := k.MarshalBinary(); err == nil {
+		t.Error("expected error encoding a custom stream")
+	}
+
+	opts.PartitionLabels = []string{"a"}
+	if err := opts.validate([]string{"a"}); err == nil {
+		t.Error("expected error for partitioned custom stream")
+	}
+}
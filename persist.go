@@ -0,0 +1,150 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/riking/go-prometheus-topk/topkpb"
+)
+
+// Logger is the interface of the error logs, like the one of promhttp. It is
+// implemented by *log.Logger.
+type Logger interface {
+	Println(v ...interface{})
+}
+
+const defaultPersistInterval = time.Minute
+
+// persister periodically checkpoints the state of a TopK to a file.
+type persister struct {
+	t        *topkCurry
+	path     string
+	errorLog Logger
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// startPersister loads the checkpoint at opts.PersistPath into t, then starts
+// saving checkpoints in the background.
+func startPersister(t *topkCurry, opts TopKOpts) *persister {
+	p := &persister{
+		t:        t,
+		path:     opts.PersistPath,
+		errorLog: opts.PersistErrorLog,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := p.load(); err != nil {
+		p.log(err)
+	}
+
+	interval := opts.PersistInterval
+	if interval <= 0 {
+		interval = defaultPersistInterval
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *persister) run(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.save(); err != nil {
+				p.log(err)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *persister) log(err error) {
+	if p.errorLog != nil {
+		p.errorLog.Println("topk: checkpoint", p.path+":", err)
+	}
+}
+
+func (p *persister) load() error {
+	snap, err := topkpb.ReadFile(p.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return p.t.RestoreSnapshotProto(snap)
+}
+
+func (p *persister) save() error {
+	return topkpb.WriteFile(p.path, p.t.SnapshotProto())
+}
+
+func (p *persister) close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.closeErr = p.save()
+	})
+	return p.closeErr
+}
+
+// ErrNotPersisted is returned by Checkpoint if persistence is not enabled.
+var ErrNotPersisted = errors.New("topk: persistence is not enabled")
+
+// Checkpoint saves a checkpoint immediately, returning ErrNotPersisted if
+// PersistPath is not set.
+func (r *topkCurry) Checkpoint() error {
+	if r.root.persist == nil {
+		return ErrNotPersisted
+	}
+	return r.root.persist.save()
+}
+
+// Close stops the background goroutines of AsyncQueueSize,
+// BucketFlushInterval, TargetObservationRate, KeyTTL, and HalfLife, if
+// enabled, and the checkpointing of the TopK, if enabled, saving a final
+// checkpoint. It is safe to call more than once, and on any TopK curried from
+// the same root.
+func (r *topkCurry) Close() error {
+	if r.root.sampler != nil {
+		r.root.sampler.close()
+	}
+	if r.root.maintainer != nil {
+		r.root.maintainer.close()
+	}
+	if r.root.async != nil {
+		r.root.async.close()
+	}
+	if r.root.flush != nil {
+		r.root.flush.close()
+	}
+	if r.root.persist == nil {
+		return nil
+	}
+	return r.root.persist.close()
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLogger []string
+
+func (l *testLogger) Println(v ...interface{}) {
+	*l = append(*l, fmt.Sprintln(v...))
+}
+
+func TestPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topk.pb")
+	opts := TopKOpts{Name: metricName, Buckets: 2, PersistPath: path}
+
+	k := NewTopK(opts, []string{"a"})
+	k.WithLabelValues("x").Add(5)
+	k.WithLabelValues("y").Add(3)
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	restored := NewTopK(opts, []string{"a"})
+	defer restored.Close()
+	if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	var log testLogger
+	mismatched := NewTopK(TopKOpts{Name: metricName, Buckets: 3, PersistPath: path, PersistErrorLog: &log}, []string{"a"})
+	defer mismatched.Close()
+	if len(mismatched.Snapshot()) != 0 {
+		t.Error("mismatched checkpoint was loaded")
+	}
+	if len(log) != 1 {
+		t.Errorf("expected one logged error, got %q", log)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topk.pb")
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, PersistPath: path, PersistInterval: time.Hour}, []string{"a"})
+	defer k.Close()
+	k.WithLabelValues("x").Add(5)
+
+	if err := k.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("no checkpoint was written: %v", err)
+	}
+
+	if err := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, nil).Checkpoint(); err != ErrNotPersisted {
+		t.Errorf("got %v, expected ErrNotPersisted", err)
+	}
+}
+
+func TestPersistInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "topk.pb")
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, PersistPath: path, PersistInterval: time.Millisecond}, []string{"a"})
+	defer k.Close()
+	k.WithLabelValues("x").Add(5)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no checkpoint was written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
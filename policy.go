@@ -0,0 +1,121 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidValue is returned by TryObserve for NaN, infinite, and negative
+// values.
+var ErrInvalidValue = errors.New("topk: invalid observation value")
+
+// ErrInvalidLabelValue is returned by the methods taking labels for label
+// values that are not valid UTF-8, if the LabelValuePolicy is
+// LabelValuePolicyReject.
+var ErrInvalidLabelValue = errors.New("topk: invalid label value")
+
+// A ValuePolicy decides how Observe handles NaN, infinite, and negative
+// values.
+type ValuePolicy int
+
+const (
+	// ValuePolicyDefault records NaN values as 0, and all other values
+	// unchanged.
+	ValuePolicyDefault ValuePolicy = iota
+	// ValuePolicyClamp records NaN and negative values as 0, and +Inf as the
+	// largest finite float64.
+	ValuePolicyClamp
+	// ValuePolicyDrop ignores NaN, infinite, and negative values.
+	ValuePolicyDrop
+)
+
+func checkValue(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+		return fmt.Errorf("%w: %v", ErrInvalidValue, v)
+	}
+	return nil
+}
+
+// apply returns the value to record for v, or false if v is to be dropped.
+func (p ValuePolicy) apply(v float64) (float64, bool) {
+	if checkValue(v) == nil {
+		return v, true
+	}
+	switch p {
+	case ValuePolicyClamp:
+		if math.IsInf(v, 1) {
+			return math.MaxFloat64, true
+		}
+		return 0, true
+	case ValuePolicyDrop:
+		return 0, false
+	default:
+		if math.IsNaN(v) {
+			return 0, true
+		}
+		return v, true
+	}
+}
+
+// A DecrementPolicy decides how the Dec and Sub methods of the buckets are
+// handled, for TopKs of values that go down, like in-flight requests.
+type DecrementPolicy int
+
+const (
+	// DecrementPolicyDisallow panics in Dec and Sub, like for a counter.
+	DecrementPolicyDisallow DecrementPolicy = iota
+	// DecrementPolicyTracked subtracts from the counts of the tracked
+	// keys, down to zero, and ignores the decrements of the keys that are
+	// not tracked, counting them as Dropped; their counts are unknown, and
+	// new keys enter the top keys with the count of an evicted key anyway.
+	// The counts are exported as gauges.
+	DecrementPolicyTracked
+)
+
+// A LabelValuePolicy decides how label values that are not valid UTF-8 are
+// handled. Such values cannot be exported, so Collect skips their keys and
+// counts them in the "<name>_malformed_keys_total" metric.
+type LabelValuePolicy int
+
+const (
+	// LabelValuePolicyDefault records label values unchanged.
+	LabelValuePolicyDefault LabelValuePolicy = iota
+	// LabelValuePolicyReplace replaces every run of invalid bytes in label
+	// values with the Unicode replacement character.
+	LabelValuePolicyReplace
+	// LabelValuePolicyReject returns an error wrapping
+	// ErrInvalidLabelValue for invalid label values, from the methods that
+	// return errors, and panics in the other ones, like With.
+	LabelValuePolicyReject
+)
+
+// apply returns the label value to record for v.
+func (p LabelValuePolicy) apply(v string) (string, error) {
+	if p == LabelValuePolicyDefault || utf8.ValidString(v) {
+		return v, nil
+	}
+	if p == LabelValuePolicyReplace {
+		return strings.ToValidUTF8(v, string(utf8.RuneError)), nil
+	}
+	return "", fmt.Errorf("%w: %q is not valid UTF-8", ErrInvalidLabelValue, v)
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValuePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy ValuePolicy
+		values []float64
+		want   float64
+	}{
+		{ValuePolicyDefault, []float64{1, math.NaN(), -2}, -1},
+		{ValuePolicyDefault, []float64{1, math.Inf(1)}, math.Inf(1)},
+		{ValuePolicyClamp, []float64{1, math.NaN(), -2, math.Inf(-1)}, 1},
+		{ValuePolicyClamp, []float64{1, math.Inf(1)}, math.MaxFloat64},
+		{ValuePolicyDrop, []float64{1, math.NaN(), math.Inf(1), math.Inf(-1), -2}, 1},
+	} {
+		k := NewTopK(TopKOpts{
+			Name:        metricName,
+			Buckets:     3,
+			ValuePolicy: tc.policy,
+		}, []string{"key"})
+		b := k.WithLabelValues("a")
+		for _, v := range tc.values {
+			b.Observe(v)
+		}
+		if got := testutil.ToFloat64(b); got != tc.want {
+			t.Errorf("policy %v, values %v: got %v expected %v", tc.policy, tc.values, got, tc.want)
+		}
+	}
+}
+
+func TestTryObserve(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"key"})
+	b := k.WithLabelValues("a")
+
+	if err := b.TryObserve(2); err != nil {
+		t.Error(err)
+	}
+	for _, v := range []float64{math.NaN(), math.Inf(1), -1} {
+		if err := b.TryObserve(v); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("TryObserve(%v): got error %v", v, err)
+		}
+	}
+	if got := testutil.ToFloat64(b); got != 2 {
+		t.Errorf("got %v expected 2", got)
+	}
+}
+
+func TestLabelValuePolicy(t *testing.T) {
+	replace := NewTopK(TopKOpts{Name: metricName, Buckets: 3, LabelValuePolicy: LabelValuePolicyReplace}, []string{"key"})
+	replace.WithLabelValues("a\xffb").Inc()
+	replace.MustCurryWithLabelValues("a\xfe\xffb").WithLabelValues().Inc()
+	if err := testutil.CollectAndCompare(replace, strings.NewReader(`
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="a�b"} 2
+`), metricName); err != nil {
+		t.Error(err)
+	}
+
+	reject := NewTopK(TopKOpts{Name: metricName, Buckets: 3, LabelValuePolicy: LabelValuePolicyReject}, []string{"key"})
+	if _, err := reject.GetMetricWithLabelValues("a\xffb"); !errors.Is(err, ErrInvalidLabelValue) {
+		t.Errorf("GetMetricWithLabelValues returned %v, expected ErrInvalidLabelValue", err)
+	}
+	if _, err := reject.GetMetricWith(prometheus.Labels{"key": "a\xffb"}); !errors.Is(err, ErrInvalidLabelValue) {
+		t.Errorf("GetMetricWith returned %v, expected ErrInvalidLabelValue", err)
+	}
+	if _, err := reject.CurryWithLabelValues("a\xffb"); !errors.Is(err, ErrInvalidLabelValue) {
+		t.Errorf("CurryWithLabelValues returned %v, expected ErrInvalidLabelValue", err)
+	}
+	if n := reject.DeletePartialMatch(prometheus.Labels{"key": "a\xffb"}); n != 0 {
+		t.Errorf("DeletePartialMatch deleted %d keys", n)
+	}
+	reject.WithLabelValues("ok").Inc()
+	if n := testutil.CollectAndCount(reject, metricName); n != 1 {
+		t.Errorf("got %d metrics, expected 1", n)
+	}
+}
+
+func TestDecrementPolicy(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"key"})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected Dec to panic without a DecrementPolicy")
+			}
+		}()
+		k.WithLabelValues("a").Dec()
+	}()
+
+	k = NewTopK(TopKOpts{
+		Name:            metricName,
+		Buckets:         2,
+		DecrementPolicy: DecrementPolicyTracked,
+	}, []string{"key"})
+	a := k.WithLabelValues("a")
+	a.Add(5)
+	a.Dec()
+	a.Sub(2)
+	if got := testutil.ToFloat64(a); got != 2 {
+		t.Errorf("got %v expected 2", got)
+	}
+	a.Sub(10)
+	if got := testutil.ToFloat64(a); got != 0 {
+		t.Errorf("got %v after subtracting too much, expected 0", got)
+	}
+	a.Sub(-3)
+	if got := testutil.ToFloat64(a); got != 3 {
+		t.Errorf("got %v after a negative Sub, expected 3", got)
+	}
+
+	// decrements of keys that are not tracked are dropped
+	k.WithLabelValues("b").Add(4)
+	k.WithLabelValues("c").Dec()
+	k.WithLabelValues("a").Sub(math.NaN())
+	if snap := k.Snapshot(); len(snap) != 2 {
+		t.Errorf("got %d keys expected 2", len(snap))
+	}
+	if st := k.Stats(); st.Dropped != 2 {
+		t.Errorf("got %d dropped expected 2", st.Dropped)
+	}
+
+	expected := `
+		# HELP test_metric 
+		# TYPE test_metric gauge
+		test_metric{key="a"} 3
+		test_metric{key="b"} 4
+	`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+}
@@ -18,17 +18,29 @@ limitations under the License.
 // Package topk provides a Metric/Collector implementation of a top-K streaming
 // summary algorithm for use with high cardinality data.
 //
-// The github.com/dgryski/go-topk package is used to implement the calculations.
+// The calculations are implemented by the topkstream package, a fork of
+// github.com/dgryski/go-topk.
 package topk
 
 import (
+	"encoding"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	tk "github.com/riking/go-prometheus-topk/internal/third_party/go-topk"
+	"github.com/riking/go-prometheus-topk/internal/tdigest"
+	"github.com/riking/go-prometheus-topk/topkpb"
+	tk "github.com/riking/go-prometheus-topk/topkstream"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/model"
 )
 
@@ -39,22 +51,113 @@ import (
 // set of Gauges for the error bars.
 //
 // Usage: call one of the With() methods to receive a TopKBucket, and call the
-// Observe method to record an observation. If any NaN values are passed to
-// Observe, they are treated as 0 so as to not pollute the storage.
+// Observe method to record an observation. By default, any NaN values passed
+// to Observe are treated as 0 so as to not pollute the storage; see
+// TopKOpts.ValuePolicy for the alternatives.
+//
+// The most recent exemplar passed to ObserveWithExemplar for a tracked key is
+// attached to the exported counter of that key. Since Prometheus only allows
+// exemplars on counters, it is not attached when the counts are exported as
+// gauges, such as with a HalfLife, but still is to the per-key histograms.
 type TopK interface {
 	prometheus.Collector
+	// MarshalJSON encodes the current Snapshot.
+	json.Marshaler
+	// MarshalBinary and UnmarshalBinary save and restore the full state.
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+	// SnapshotProto and RestoreSnapshotProto save and restore the full state
+	// as a versioned protobuf message.
+	SnapshotProto() *topkpb.Snapshot
+	RestoreSnapshotProto(*topkpb.Snapshot) error
+	// Merge and MergeSnapshotProto add the counts of another TopK.
+	Merge(TopK) error
+	MergeSnapshotProto(*topkpb.Snapshot) error
 
 	CurryWith(prometheus.Labels) (TopK, error)
 	MustCurryWith(prometheus.Labels) TopK
+	CurryWithLabelValues(lvs ...string) (TopK, error)
+	MustCurryWithLabelValues(lvs ...string) TopK
+	// CurriedLabels returns the curried labels and their values, and
+	// FreeLabelNames the remaining labels in WithLabelValues order.
+	CurriedLabels() prometheus.Labels
+	FreeLabelNames() []string
 	GetMetricWith(prometheus.Labels) (TopKBucket, error)
 	GetMetricWithLabelValues(lvs ...string) (TopKBucket, error)
 	With(prometheus.Labels) TopKBucket
 	WithLabelValues(lvs ...string) TopKBucket
+
+	Delete(prometheus.Labels) bool
+	DeleteLabelValues(lvs ...string) bool
+	DeletePartialMatch(prometheus.Labels) int
+	Reset()
+
+	// ObserveMap and ObserveBatch record many observations of different
+	// keys with a single lock acquisition.
+	ObserveMap(values map[string]float64) error
+	ObserveBatch(batch []Observation) error
+
+	// Snapshot returns the current estimates of the tracked keys.
+	Snapshot() []Element
+	// TopN returns the first n elements of Snapshot.
+	TopN(n int) []Element
+	// Estimate returns the current estimate of a single key.
+	Estimate(prometheus.Labels) (count, err float64, tracked bool)
+	// Rank returns the position of a key in the current top-K.
+	Rank(lvs ...string) (rank int, ok bool)
+	// Range iterates over the tracked keys without taking a snapshot.
+	Range(func(labels prometheus.Labels, count, err float64) bool)
+	// Dump writes a table of the tracked keys, and String returns it.
+	Dump(io.Writer) error
+	String() string
+
+	// SetReportingThreshold changes the ReportingThreshold of the whole TopK.
+	SetReportingThreshold(float64)
+	// SetBuckets changes the number of buckets of the whole TopK.
+	SetBuckets(n uint64) error
+	// Stats returns counters describing the internal behavior of the TopK.
+	Stats() Stats
+	// EstimateMemory returns an estimate of the memory used by the TopK, in
+	// bytes.
+	EstimateMemory() uint64
+	// Checkpoint saves a checkpoint immediately, if persistence is enabled.
+	Checkpoint() error
+
+	// Close stops the background work of the TopK, saving a final
+	// checkpoint if persistence is enabled.
+	Close() error
 }
 
+// TopKBucket records observations for a single key of a TopK.
+//
+// TopKBucket is a prometheus.Counter: Add panics on negative values, and
+// collecting a TopKBucket on its own exports the current estimate for its key.
 type TopKBucket interface {
+	prometheus.Counter
+
 	Observe(float64)
-	Inc()
+	// TryObserve records an observation like Observe, but returns an error
+	// wrapping ErrInvalidValue instead if the value is NaN, infinite, or
+	// negative.
+	TryObserve(float64) error
+	// ObserveWithExemplar records an observation like Observe, and
+	// remembers the exemplar if the key is tracked. The exemplar labels are
+	// validated like in the Prometheus client, panicking if they are
+	// invalid.
+	ObserveWithExemplar(v float64, e prometheus.Labels)
+	// AddWithExemplar is ObserveWithExemplar with the semantics of a
+	// counter: it panics if v is negative.
+	AddWithExemplar(v float64, e prometheus.Labels)
+	// ObserveMany records n observations of v at once, such as
+	// pre-aggregated counts.
+	ObserveMany(v float64, n uint64)
+	// Set observes v, such as the latest value of the key with ModeLast,
+	// for a TopK with a Mode other than ModeSum; it panics with ModeSum.
+	Set(float64)
+	// Dec and Sub decrease the count of the key, as decided by the
+	// DecrementPolicy; by default, they panic. A negative Sub adds.
+	Dec()
+	Sub(float64)
 }
 
 type TopKOpts struct {
@@ -73,6 +176,24 @@ type TopKOpts struct {
 	// string.
 	Help string
 
+	// ErrorHelp is the Help string of the <name>_error metric. The default
+	// is DefaultErrorHelp of the fully-qualified name.
+	ErrorHelp string
+
+	// Unit, if not empty, is appended to the names of the metrics of the
+	// keys, like "bytes" in <name>_bytes and <name>_bytes_error. It must be
+	// in snake_case, and should be a base unit.
+	Unit string
+
+	// PromlintNames, if true, names the metrics of the keys so that they
+	// pass promlint: the counts are exported as <name>_<unit>_total, unless
+	// they decay, the histograms as <name>_<unit>_distribution, the
+	// summaries as <name>_<unit>_quantiles, and the counts and sums of
+	// CountAndSum as <name>_observations_total and
+	// <name>_<unit>_observed_total. The error metric stays
+	// <name>_<unit>_error.
+	PromlintNames bool
+
 	// ConstLabels are used to attach fixed labels to this metric. Metrics
 	// with the same fully-qualified name must have the same label names in
 	// their ConstLabels.
@@ -85,24 +206,460 @@ type TopKOpts struct {
 	// https://prometheus.io/docs/instrumenting/writing_exporters/#target-labels,-not-static-scraped-labels
 	ConstLabels prometheus.Labels
 
+	// ViewLabels are added to the exported metrics like ConstLabels, but
+	// are not part of the state of the TopK: snapshots leave them out, so
+	// that variants of the same logical metric, like window="5m" and
+	// window="1h", can be merged and restored into each other while they
+	// coexist in one metric family. See LabeledView to add them to a
+	// derived view instead.
+	ViewLabels prometheus.Labels
+
 	// Buckets provides the number of metric streams that this metric is
-	// expected to keep an accurate count for (the "K" in top-K).
+	// expected to keep an accurate count for (the "K" in top-K). It must not
+	// be more than MaxBuckets; the default is DefaultBuckets.
 	Buckets uint64
 
+	// ExportedKeys, if greater than zero, limits the keys exported by
+	// Collect to this many with the highest counts, so that a TopK can
+	// track more keys than it exports, for the deeper view of Snapshot,
+	// TopN, and Range. With PartitionLabels, the limit applies to all the
+	// partitions together.
+	ExportedKeys uint64
+
+	// OnEnterTopK and OnExitTopK, if not nil, are called by Collect with
+	// the labels of every key that it exports and the last Collect did not,
+	// and of every key that the last Collect exported and it does not, such
+	// as to start tracing the requests of a client that just became heavy.
+	// The first Collect calls OnEnterTopK for every exported key. The calls
+	// are made from the collecting goroutine, one at a time, so they must
+	// be fast and must not collect the TopK; the labels must not be
+	// modified.
+	OnEnterTopK func(labels prometheus.Labels, count float64)
+	OnExitTopK  func(labels prometheus.Labels)
+
+	// ShareAlert, if greater than zero, makes every observation of a key
+	// call OnShareAlert if the guaranteed count of the key went over this
+	// fraction of the total since its last observation, so that the
+	// application can react to a key taking over, such as by rate limiting
+	// it, without waiting for a scrape. The alerts wait until the total is
+	// at least ShareAlertMinTotal, since the first keys have large shares.
+	// OnShareAlert is called while the TopK is locked, so it must be fast
+	// and must not use the TopK. ShareAlert cannot be combined with Shards,
+	// BucketFlushInterval, or a Mode other than ModeSum, whose counts are
+	// not traffic.
+	ShareAlert         float64
+	ShareAlertMinTotal float64
+	OnShareAlert       func(labels prometheus.Labels, share float64)
+
+	// PartitionLabels, if not empty, splits the keys into partitions by the
+	// values of these labels, and tracks the top Buckets keys of every
+	// partition independently, so that the keys of a busy partition never
+	// evict the keys of another. Curry a TopK with the partition labels to
+	// see the top keys of one partition.
+	//
+	// Every partition uses the memory of a whole TopK, and partitions are
+	// only removed by Reset, so the partition labels should have a bounded
+	// number of values.
+	PartitionLabels []string
+
+	// NameValidationScheme decides which metric and label names are valid.
+	// The default is model.NameValidationScheme, which allows any UTF-8 name,
+	// such as the dotted names of OpenTelemetry, unless it was changed; such
+	// names are escaped or quoted when exposed, depending on the format
+	// negotiated with the scraper. Set it to model.LegacyValidation to only
+	// allow the traditional names. UTF8Validation cannot be used when the
+	// global model.NameValidationScheme is LegacyValidation, since the
+	// Prometheus client would then reject the Descs of the TopK.
+	NameValidationScheme model.ValidationScheme
+
+	// LabelConstraints maps variable label names to functions that normalize
+	// their values, such as by lowercasing them or by replacing unexpected
+	// values with a placeholder, before the key is looked up. Like the
+	// constrained labels of the Prometheus client, the constraints also apply
+	// to the values passed to CurryWith, Delete, and the other methods taking
+	// labels.
+	LabelConstraints map[string]func(string) string
+
+	// Shards, if greater than one, spreads the observations over this many
+	// buffer streams with their own locks, so that concurrent observers do
+	// not wait for each other, and merges them into the main stream whenever
+	// it is read, such as by Collect. A good value is runtime.GOMAXPROCS(0)
+	// for a TopK observed from many goroutines at once.
+	//
+	// Every shard uses the memory of a whole TopK, and the estimates are
+	// less accurate, since a key can be sharded out of the top keys.
+	// Observations with exemplars bypass the shards, and a sharded TopK
+	// cannot have per-key histograms, summaries, or counts and sums.
+	Shards int
+
+	// AsyncQueueSize, if greater than zero, makes Observe only queue the
+	// observations, in a queue of this size, for a background goroutine to
+	// record, so that it never waits for the lock of the TopK. The
+	// observations made while the queue is full are dropped, and counted in
+	// the Overflowed field of Stats. Reading the TopK, such as by Collect,
+	// records the queued observations first. It cannot be combined with
+	// Shards; call Close to stop the goroutine.
+	AsyncQueueSize int
+
+	// BucketFlushInterval, if greater than zero, makes every TopKBucket
+	// accumulate its observations locally, with atomic operations instead of
+	// the lock of the TopK, and records them in the TopK every interval and
+	// whenever the TopK is read, such as by Collect. Holding on to a
+	// TopKBucket from WithLabelValues is then much cheaper for a key
+	// observed very often. Observations with exemplars are recorded
+	// immediately, and a TopK with BucketFlushInterval cannot have per-key
+	// histograms, summaries, or counts and sums. Call Close to stop the
+	// periodic flushes.
+	BucketFlushInterval time.Duration
+
+	// SamplingFactor, if greater than one, makes the TopK record only one
+	// in SamplingFactor observations, chosen at random, each counting as
+	// SamplingFactor observations, to take the lock that much less often.
+	// The counts then have an additional error of about the square root of
+	// SamplingFactor times the count, so this is only worth it for keys
+	// observed very often. Observations recorded by ObserveMap and
+	// ObserveBatch are not sampled, and a sampled TopK cannot have per-key
+	// histograms, summaries, or counts and sums.
+	SamplingFactor uint64
+
+	// TargetObservationRate, if greater than zero, enables adaptive
+	// sampling: every second, the sampling factor is adjusted so that the
+	// TopK records about this many observations per second, counting all of
+	// them when traffic is low and bounding the overhead during spikes. The
+	// factor never goes below SamplingFactor, and is exported by
+	// NewStatsCollector. Like SamplingFactor, it cannot be combined with the
+	// per-key histograms, summaries, or counts and sums. Call Close to stop
+	// the adjustments.
+	TargetObservationRate float64
+
+	// Hash is the function hashing the keys that are not tracked to their
+	// error estimates, SipHash by default. A faster function cuts the cost of
+	// every observation of a key that is not tracked. A snapshot or binary
+	// encoding records the function, and can only be restored into, or
+	// merged with, a TopK using the same one. HashMaphash cannot be combined
+	// with a PersistPath.
+	Hash HashFunction
+
+	// NewStream, if not nil, returns the Stream of the TopK, with the given
+	// number of buckets, instead of a topkstream.Stream. A TopK with such a
+	// Stream cannot have PartitionLabels, Shards, a Hash, or a PersistPath,
+	// and cannot restore, merge, or decode the state of another TopK.
+	// SnapshotProto includes its tracked keys, but not the error estimates
+	// of the keys it does not track. If the Stream has a
+	// MemoryUsage() uint64 method, it is used by EstimateMemory.
+	NewStream func(buckets int) Stream
+
+	// ErrorCountersPerBucket is the number of error estimates kept for the
+	// keys that are not tracked, per bucket, or
+	// topkstream.DefaultCountersPerElement if zero. More counters use 8
+	// bytes each, but make a new key less likely to be tracked with the
+	// large error of an evicted key. It cannot be more than
+	// MaxErrorCountersPerBucket, or combined with NewStream. A restored
+	// snapshot keeps the counters it was saved with. It is the only tunable
+	// of topkstream besides the Buckets: the sketch has no epsilon, and the
+	// decay is the HalfLife, computed exactly rather than from a table.
+	ErrorCountersPerBucket int
+
+	// HalfLife, if greater than zero, weights the observations by their
+	// recency, so that the counts are exponentially decayed sums: an
+	// observation made HalfLife ago counts half as much as one made now,
+	// and the keys that were hot in the past are soon outranked by the keys
+	// that are hot now. Since they decrease, the counts are then exported as
+	// gauges. The per-key histograms, summaries, and counts and sums do not
+	// decay. HalfLife cannot be combined with Shards or NewStream, and makes
+	// every reader of the TopK take its write lock to decay the counts. Call
+	// Close to stop the background rescaling of the counts.
+	HalfLife time.Duration
+
+	// KeyTTL, if greater than zero, removes the tracked keys that were not
+	// observed for this long, so that they stop being exported and free
+	// their buckets for the live keys. The keys are checked every tenth of
+	// the TTL, but at most every second, so they can be exported a little
+	// longer. KeyTTL cannot be combined with Shards. Call Close to stop the
+	// checks.
+	KeyTTL time.Duration
+
+	// IdleRate, if greater than zero, removes the tracked keys whose count
+	// grew by less than IdleRate per second over an IdleWindow, so that a
+	// TopK that is never reset stops exporting the keys that were heavy
+	// once, and frees their buckets. Unlike the KeyTTL, the keys that are
+	// still observed, but rarely, are removed too. The rate of every key is
+	// measured over consecutive IdleWindows, so a key is removed one to two
+	// IdleWindows after its rate fell. IdleRate cannot be combined with
+	// HalfLife, already forgetting the old counts, or a Mode other than
+	// ModeSum. Call Close to stop the checks.
+	IdleRate   float64
+	IdleWindow time.Duration
+
+	// Now, if not nil, replaces time.Now as the clock of the KeyTTL, the
+	// IdleWindow, the HalfLife, the timestamps of exemplars and snapshots,
+	// and Timers, so that tests can advance time deterministically. Since
+	// the readers of a TopK remove the expired keys and decay the counts,
+	// this needs no sleeping; the background goroutines still run in real
+	// time.
+	Now func() time.Time
+
+	// Deterministic, if true, makes the output of the TopK only depend on
+	// its observations, for golden tests with testutil.CollectAndCompare: the
+	// keys are counted exactly, without error, and the keys with the same
+	// count are ranked by their label values. Since every key is kept in
+	// memory, it is only meant for tests. It cannot be combined with the
+	// options that add randomness or need a topkstream.Stream: sampling,
+	// HashMaphash, Shards, PartitionLabels, NewStream, and PersistPath.
+	// Set Now as well for stable exemplar timestamps.
+	Deterministic bool
+
+	// Audit, if true, also counts every key exactly, to export how accurate
+	// the TopK is: the fraction of the true top keys that are tracked, as
+	// <name>_audit_top_keys_agreement, the largest relative error of their
+	// estimated counts, as <name>_audit_max_relative_error, and the number
+	// of keys counted, as <name>_audit_exact_keys. The true top keys are as
+	// many as the TopK has buckets. Since every key is kept in memory, it is
+	// only meant to choose the Buckets during development. After a restore
+	// or a merge, the keys are counted from the estimates of the snapshot,
+	// and the keys it did not track are missed, so the audit is only
+	// approximate. Audit cannot be combined with Shards, HalfLife, KeyTTL,
+	// or IdleRate.
+	Audit bool
+
 	// Values under the ReportingThreshold are tracked but not exported.
 	ReportingThreshold float64
+
+	// ValuePolicy decides how NaN, infinite, and negative values passed to
+	// Observe are handled.
+	ValuePolicy ValuePolicy
+
+	// Mode decides how the observations of a key are combined into its
+	// count: by default, they are summed. The modes other than ModeSum
+	// cannot be combined with Shards, BucketFlushInterval, HalfLife, or a
+	// DecrementPolicy.
+	Mode Mode
+
+	// DecrementPolicy decides how the Dec and Sub methods of the buckets
+	// are handled; by default, they panic.
+	DecrementPolicy DecrementPolicy
+
+	// LabelValuePolicy decides how label values that are not valid UTF-8
+	// are handled.
+	LabelValuePolicy LabelValuePolicy
+
+	// MaxLabelValueLength, if not zero, is the maximum length in bytes of
+	// the label values. Longer values are truncated, and end with "~" and a
+	// hash of the full value instead, so that different long values with the
+	// same prefix are still counted separately. It must be at least
+	// MinLabelValueLength.
+	MaxLabelValueLength int
+
+	// NativeHistogramBucketFactor, if greater than one, enables a native
+	// histogram of the observed values for every tracked key, exported as the
+	// "<name>_histogram" metric family. The histogram of a key only covers the
+	// observations made since the key last entered the tracked set.
+	//
+	// See the field of the same name in prometheus.HistogramOpts for the
+	// meaning of this and the following NativeHistogram fields.
+	NativeHistogramBucketFactor     float64
+	NativeHistogramZeroThreshold    float64
+	NativeHistogramMaxBucketNumber  uint32
+	NativeHistogramMinResetDuration time.Duration
+
+	// Quantiles, if not empty, enables a t-digest of the observed values for
+	// every tracked key, from which the listed quantiles are exported as the
+	// "<name>_summary" metric family. Each quantile must be between 0 and 1.
+	// As with the native histograms, the digest of a key only covers the
+	// observations made since the key last entered the tracked set.
+	Quantiles []float64
+
+	// QuantileCompression is the compression parameter of the per-key
+	// t-digests. Higher values are more accurate but use more memory, around
+	// 40 bytes per key for each unit. The default is 50.
+	QuantileCompression float64
+
+	// CountAndSum enables the "<name>_count" and "<name>_sum" metric
+	// families, holding the number and the sum of the observations of every
+	// tracked key. Unlike the main estimate, these are exact, but only cover
+	// the observations made since the key last entered the tracked set, so
+	// their ratio is the average observed value of the key.
+	CountAndSum bool
+
+	// Shares enables the "<name>_share" gauge, the estimated share of every
+	// exported key in the sum of all counts, between 0 and 1, and the
+	// "<name>_overall" metric, that sum, including the counts of the keys
+	// that are not tracked. With PromlintNames, the sum is exported as
+	// "<name>_overall_total" unless it decays.
+	Shares bool
+
+	// Ranks enables the "<name>_rank" gauge, the 1-based position of every
+	// exported key by decreasing count, so that alerts can select the top
+	// keys without sorting in PromQL. The keys with the same count are
+	// ranked by their label values.
+	Ranks bool
+
+	// FirstSeen enables the "<name>_first_seen_timestamp_seconds" gauge,
+	// the time at which every exported key started being tracked, after its
+	// last eviction if any, which tells the keys that just became heavy from
+	// the ones that have been for long. The keys of a restored snapshot or
+	// encoding count as first seen at their next observation. FirstSeen
+	// cannot be combined with Shards or BucketFlushInterval.
+	FirstSeen bool
+
+	// PersistPath, if not empty, enables checkpointing of the stream state
+	// to this file. The state is loaded from the file, if it exists, by
+	// NewTopK, saved every PersistInterval (one minute by default), and saved
+	// a final time by Close. Per-key histograms, digests, and exemplars are not
+	// persisted.
+	//
+	// A checkpoint that cannot be loaded, for example because it has
+	// different label names or number of buckets, is reported to
+	// PersistErrorLog and otherwise ignored.
+	PersistPath     string
+	PersistInterval time.Duration
+
+	// PersistErrorLog, if not nil, receives the errors encountered while
+	// loading and saving checkpoints. Otherwise they are dropped.
+	PersistErrorLog Logger
 }
 
 type topkRoot struct {
-	// unfortunately, all access to the Stream needs to be protected
-	streamMtx sync.Mutex
-	stream    *tk.Stream
+	// unfortunately, all access to the Stream needs to be protected; the
+	// methods that only read it take the read lock, with rlockStream
+	streamMtx sync.RWMutex
+	stream    Stream
+	buckets   int // protected by streamMtx
+	// a copy of buckets that can be read without the lock
+	cachedBuckets atomic.Uint64
+
+	// if not nil, the observations are buffered here; see lockStream
+	shards []*shard
+	async  *inserter
+
+	// buckets with accumulated observations, if BucketFlushInterval is set
+	accMtx     sync.Mutex
+	accPending []*topkWithLabelValues
+	flush      *flusher
+
+	// per-key state for the monitored elements of the stream, populated
+	// only if a per-key export is enabled
+	keyState map[string]*keyState
+
+	fqName      string
+	help        string
+	errorHelp   string
+	unit        string
+	promlint    bool
+	constLabels prometheus.Labels
 
 	countDesc *prometheus.Desc
 	errDesc   *prometheus.Desc
+	histDesc  *prometheus.Desc
+	histOpts  prometheus.HistogramOpts
+	sumDesc   *prometheus.Desc
+
+	obsCountDesc *prometheus.Desc
+	obsSumDesc   *prometheus.Desc
+	// nil without Shares
+	shareDesc   *prometheus.Desc
+	overallDesc *prometheus.Desc
+	// nil without Ranks
+	rankDesc *prometheus.Desc
+	// nil without FirstSeen
+	firstSeenDesc *prometheus.Desc
+	// nil without OnEnterTopK and OnExitTopK
+	hooks *exportHooks
+	// nil without a ShareAlert
+	shareAlert *shareAlert
+	// the most keys exported, or zero for all of them
+	exportedKeys int
+
+	// malformed counts the keys skipped by Collect because they could not
+	// be exported; it is only exported once it is not zero
+	malformedDesc *prometheus.Desc
+	malformed     atomic.Uint64
+	// duplicates counts the keys merged by Collect into another key with
+	// the same label values; it is only exported once it is not zero
+	duplicateDesc *prometheus.Desc
+	duplicates    atomic.Uint64
+
+	// the label values of the exported keys, for Collect
+	labels labelCache
+
+	// counters for Stats
+	observations atomic.Uint64
+	evictions    atomic.Uint64
+	dropped      atomic.Uint64
+	overflowed   atomic.Uint64
+
+	quantiles   []float64
+	compression float64
 
 	variableLabels  []string
+	partitionLabels []string
+
+	valuePolicy      ValuePolicy
+	labelValuePolicy LabelValuePolicy
+	decrementPolicy  DecrementPolicy
+	mode             Mode
+
+	// the current sampling factor, adjusted by the sampler if enabled
+	samplingFactor atomic.Uint64
+	sampler        *sampler
+
+	hash HashFunction
+	// from TopKOpts.NewStream, or nil for a topkstream.Stream
+	customStream func(buckets int) Stream
+	// error counters per bucket of a topkstream.Stream
+	errorCounters int
+	// nil without a HalfLife
+	decay *decay
+	// the type of the exported counts, a gauge if they decay
+	countType prometheus.ValueType
+
+	// nil without a KeyTTL
+	expiry *keyExpiry
+	// nil if no option needs maintenance
+	maintainer *maintainer
+	// the clock, time.Now by default
+	now func() time.Time
+	// the last observation of the tracked keys, nil without a KeyTTL;
+	// protected by streamMtx
+	lastSeen map[string]time.Time
+	expired  atomic.Uint64
+	// nil without an IdleRate
+	idle        *idleEviction
+	idleEvicted atomic.Uint64
+	// nil without Audit
+	audit *auditor
+
+	// label value constraints by label index, or nil if there are none
+	constraints         []func(string) string
+	maxLabelValueLength int
+
+	// protected by streamMtx
 	reportThreshold float64
+
+	persist *persister
+}
+
+// keyState holds the extra data tracked for a single monitored key.
+type keyState struct {
+	histogram prometheus.Histogram
+	digest    *tdigest.TDigest
+
+	obsCount uint64
+	obsSum   float64
+
+	exemplar *prometheus.Exemplar
+
+	firstSeen time.Time
+}
+
+// keyValues is a copy of the per-key values, taken while holding the lock.
+type keyValues struct {
+	histogram prometheus.Histogram
+	digest    *tdigest.TDigest
+	obsCount  uint64
+	obsSum    float64
+	exemplar  *prometheus.Exemplar
+	firstSeen time.Time
 }
 
 type curriedLabelValue struct {
@@ -113,73 +670,632 @@ type curriedLabelValue struct {
 type topkCurry struct {
 	curry []curriedLabelValue
 	root  *topkRoot
+
+	// the composite key of the curried labels that come before all the
+	// free labels, with their number
+	prefix       string
+	prefixLabels int
+
+	// the labels that are not curried, and the buckets returned for them
+	freeNames []string
+	children  *bucketCache
 }
 
 type topkWithLabelValues struct {
 	compositeLabel string
 	root           *topkRoot
+	// the free label values the bucket was created with, for the cache
+	labelValues []string
+
+	acc bucketAccumulator
 }
 
 var (
 	_ TopK                = &topkCurry{}
 	_ TopKBucket          = &topkWithLabelValues{}
 	_ prometheus.Observer = &topkWithLabelValues{}
+
+	_ prometheus.ExemplarObserver = &topkWithLabelValues{}
+	_ prometheus.ExemplarAdder    = &topkWithLabelValues{}
+	_ prometheus.Counter          = &topkWithLabelValues{}
 )
 
-// NewTopK constructs a new TopK metric container.
+// DefaultErrorHelp returns the default Help string of the error metric of the
+// TopK with the fully-qualified name fqName.
+func DefaultErrorHelp(fqName string) string {
+	return fmt.Sprintf("Estimation error bound for %s.", fqName)
+}
+
+// NewTopK constructs a new TopK metric container. It panics if the options or
+// the label names are invalid; use NewTopKWithOptions to get an error
+// instead.
 func NewTopK(opts TopKOpts, labelNames []string) TopK {
+	if opts.Buckets == 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	if err := opts.validate(labelNames); err != nil {
+		panic(err)
+	}
 	fqName := prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)
+	// the sums only grow unless they decay or decrease
+	counter := opts.Mode == ModeSum && opts.HalfLife <= 0 && opts.DecrementPolicy == DecrementPolicyDisallow
+	names := newMetricNames(fqName, opts.Unit, opts.PromlintNames, counter)
+
+	errorHelp := opts.ErrorHelp
+	if errorHelp == "" {
+		errorHelp = DefaultErrorHelp(fqName)
+	}
+	// the labels of the exported metrics, which also have the ViewLabels
+	constLabels := opts.ConstLabels
+	if len(opts.ViewLabels) > 0 {
+		constLabels = copyLabels(opts.ConstLabels)
+		if constLabels == nil {
+			constLabels = make(prometheus.Labels, len(opts.ViewLabels))
+		}
+		for name, value := range opts.ViewLabels {
+			constLabels[name] = value
+		}
+	}
 
 	// Take a copy to avoid mutation
 	varLabels := append([]string(nil), labelNames...)
 
 	root := &topkRoot{
-		stream: tk.NewStream(int(opts.Buckets)),
+		fqName:      fqName,
+		help:        opts.Help,
+		errorHelp:   errorHelp,
+		unit:        opts.Unit,
+		promlint:    opts.PromlintNames,
+		constLabels: copyLabels(opts.ConstLabels),
 
 		countDesc: prometheus.NewDesc(
-			fqName, opts.Help, varLabels, opts.ConstLabels),
+			names.count, opts.Help, varLabels, constLabels),
 		errDesc: prometheus.NewDesc(
-			fmt.Sprintf("%s_error", fqName), opts.Help, varLabels, opts.ConstLabels),
+			names.err, errorHelp, varLabels, constLabels),
 
-		variableLabels:  varLabels,
-		reportThreshold: opts.ReportingThreshold,
+		buckets:          int(opts.Buckets),
+		exportedKeys:     int(opts.ExportedKeys),
+		variableLabels:   varLabels,
+		reportThreshold:  opts.ReportingThreshold,
+		valuePolicy:      opts.ValuePolicy,
+		labelValuePolicy: opts.LabelValuePolicy,
+		decrementPolicy:  opts.DecrementPolicy,
+		mode:             opts.Mode,
+		hash:             opts.Hash,
+		customStream:     opts.NewStream,
+		errorCounters:    opts.ErrorCountersPerBucket,
+		countType:        prometheus.GaugeValue,
+		now:              opts.Now,
+
+		maxLabelValueLength: opts.MaxLabelValueLength,
+	}
+	_, root.partitionLabels = partitionIndex(varLabels, opts.PartitionLabels)
+	if len(opts.LabelConstraints) > 0 {
+		root.constraints = make([]func(string) string, len(varLabels))
+		for i, name := range varLabels {
+			root.constraints[i] = opts.LabelConstraints[name]
+		}
+	}
+	if opts.NativeHistogramBucketFactor > 1 {
+		root.histDesc = prometheus.NewDesc(
+			names.histogram, opts.Help, varLabels, constLabels)
+		root.histOpts = prometheus.HistogramOpts{
+			Name:                            "topk_key_histogram",
+			NativeHistogramBucketFactor:     opts.NativeHistogramBucketFactor,
+			NativeHistogramZeroThreshold:    opts.NativeHistogramZeroThreshold,
+			NativeHistogramMaxBucketNumber:  opts.NativeHistogramMaxBucketNumber,
+			NativeHistogramMinResetDuration: opts.NativeHistogramMinResetDuration,
+		}
+	}
+	if len(opts.Quantiles) > 0 {
+		root.sumDesc = prometheus.NewDesc(
+			names.summary, opts.Help, varLabels, constLabels)
+		root.quantiles = append([]float64(nil), opts.Quantiles...)
+		root.compression = opts.QuantileCompression
+		if root.compression <= 0 {
+			root.compression = 50
+		}
+	}
+	root.malformedDesc = prometheus.NewDesc(
+		fmt.Sprintf("%s_malformed_keys_total", fqName),
+		"Number of times a key of the TopK could not be exported, for example because a label value is not valid UTF-8.",
+		nil, constLabels)
+	root.duplicateDesc = prometheus.NewDesc(
+		fmt.Sprintf("%s_duplicate_keys_total", fqName),
+		"Number of times a key of the TopK was exported merged into another key with the same label values.",
+		nil, constLabels)
+	if opts.CountAndSum {
+		root.obsCountDesc = prometheus.NewDesc(
+			names.obsCount, opts.Help, varLabels, constLabels)
+		root.obsSumDesc = prometheus.NewDesc(
+			names.obsSum, opts.Help, varLabels, constLabels)
+	}
+	if opts.Shares {
+		root.shareDesc = prometheus.NewDesc(
+			names.share, fmt.Sprintf("Estimated share of the key in the sum of all counts of %s.", fqName),
+			varLabels, constLabels)
+		root.overallDesc = prometheus.NewDesc(
+			names.overall, fmt.Sprintf("Sum of all counts of %s, including the keys that are not exported.", fqName),
+			nil, constLabels)
+	}
+	if opts.Ranks {
+		root.rankDesc = prometheus.NewDesc(
+			names.rank, fmt.Sprintf("Rank of the key among the keys of %s by decreasing count, from 1.", fqName),
+			varLabels, constLabels)
+	}
+	root.hooks = newExportHooks(opts)
+	root.shareAlert = newShareAlert(opts)
+	if opts.FirstSeen {
+		root.firstSeenDesc = prometheus.NewDesc(
+			names.firstSeen, fmt.Sprintf("Time at which the key of %s was first tracked, in seconds since the epoch.", fqName),
+			varLabels, constLabels)
+	}
+	if root.histDesc != nil || root.sumDesc != nil || root.obsCountDesc != nil || root.firstSeenDesc != nil {
+		root.keyState = make(map[string]*keyState)
 	}
-	return &topkCurry{root: root, curry: nil}
+	if root.now == nil {
+		root.now = time.Now
+	}
+	if opts.Deterministic {
+		root.customStream = newExactStream
+	}
+	if counter {
+		root.countType = prometheus.CounterValue
+	}
+	if opts.HalfLife > 0 {
+		root.decay = newDecay(opts.HalfLife, root.now())
+	}
+	if opts.KeyTTL > 0 {
+		root.expiry = &keyExpiry{ttl: opts.KeyTTL}
+		root.lastSeen = make(map[string]time.Time)
+	}
+	if opts.IdleRate > 0 {
+		root.idle = &idleEviction{rate: opts.IdleRate, window: opts.IdleWindow}
+	}
+	if opts.Audit {
+		root.audit = newAuditor(fqName, constLabels)
+	}
+	if root.errorCounters == 0 {
+		root.errorCounters = tk.DefaultCountersPerElement
+	}
+	root.setStream(root.newStream())
+	root.shards = root.newShards(opts.Shards)
+	if opts.AsyncQueueSize > 0 {
+		root.async = startInserter(root, opts.AsyncQueueSize)
+	}
+	if opts.BucketFlushInterval > 0 {
+		root.flush = startFlusher(root, opts.BucketFlushInterval)
+	}
+	minFactor := max(opts.SamplingFactor, 1)
+	root.samplingFactor.Store(minFactor)
+	if opts.TargetObservationRate > 0 {
+		root.sampler = startSampler(root, opts.TargetObservationRate, minFactor)
+	}
+	root.maintainer = startMaintainer(root, opts)
+	root.cachedBuckets.Store(opts.Buckets)
+	t := root.newCurry(nil)
+	if opts.PersistPath != "" {
+		root.persist = startPersister(t, opts)
+	}
+	return t
+}
+
+// SetReportingThreshold changes the ReportingThreshold of the whole TopK, even
+// if called on a curried TopK. It takes effect on the next collection.
+func (r *topkCurry) SetReportingThreshold(threshold float64) {
+	r.root.streamMtx.Lock()
+	defer r.root.streamMtx.Unlock()
+	r.root.reportThreshold = threshold
+}
+
+// SetBuckets changes the number of buckets of the whole TopK, even if called
+// on a curried TopK. When shrinking, the keys with the lowest counts stop
+// being tracked. The error estimates of the keys that are not tracked are
+// kept as upper bounds, so the estimates remain valid, but are less accurate
+// than if the TopK had always had n buckets.
+//
+// Checkpoints saved with a different number of buckets cannot be restored.
+func (r *topkCurry) SetBuckets(n uint64) error {
+	if n == 0 || n > MaxBuckets {
+		return fmt.Errorf("topk: Buckets %d is not between 1 and %d", n, MaxBuckets)
+	}
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+	r.root.stream.Resize(int(n))
+	r.root.resizeShards(int(n))
+	r.root.buckets = int(n)
+	r.root.cachedBuckets.Store(n)
+	return nil
+}
+
+// observeKey updates the per-key state of a monitored key with n
+// observations of v. The ex argument may be nil.
+// Must be called with streamMtx held.
+func (r *topkRoot) observeKey(key string, v float64, n uint64, ex *prometheus.Exemplar) {
+	if r.keyState == nil {
+		// first exemplar
+		r.keyState = make(map[string]*keyState)
+	}
+	st, ok := r.keyState[key]
+	if !ok {
+		st = &keyState{firstSeen: r.now()}
+		if r.histDesc != nil {
+			st.histogram = prometheus.NewHistogram(r.histOpts)
+		}
+		if r.sumDesc != nil {
+			st.digest = tdigest.New(r.compression)
+		}
+		r.keyState[key] = st
+	}
+	if st.histogram != nil {
+		for i := uint64(0); i < n; i++ {
+			if ex != nil && i == 0 {
+				st.histogram.(prometheus.ExemplarObserver).ObserveWithExemplar(v, ex.Labels)
+			} else {
+				st.histogram.Observe(v)
+			}
+		}
+	}
+	if st.digest != nil {
+		st.digest.AddWeighted(v, float64(n))
+	}
+	st.obsCount += n
+	st.obsSum += v * float64(n)
+	if ex != nil {
+		st.exemplar = ex
+	}
+}
+
+// values copies out the per-key values of an exported key. The digest is
+// cloned, so that its quantiles can be computed without the lock.
+// Must be called with streamMtx held.
+func (r *topkRoot) values(st *keyState) *keyValues {
+	kv := &keyValues{
+		histogram: st.histogram,
+		obsCount:  st.obsCount,
+		obsSum:    st.obsSum,
+		exemplar:  st.exemplar,
+		firstSeen: st.firstSeen,
+	}
+	if st.digest != nil {
+		kv.digest = st.digest.Clone()
+	}
+	return kv
 }
 
 func (r *topkCurry) Describe(ch chan<- *prometheus.Desc) {
 	ch <- r.root.countDesc
 	ch <- r.root.errDesc
+	if r.root.histDesc != nil {
+		ch <- r.root.histDesc
+	}
+	if r.root.sumDesc != nil {
+		ch <- r.root.sumDesc
+	}
+	if r.root.obsCountDesc != nil {
+		ch <- r.root.obsCountDesc
+		ch <- r.root.obsSumDesc
+	}
+	if r.root.shareDesc != nil {
+		ch <- r.root.shareDesc
+		ch <- r.root.overallDesc
+	}
+	if r.root.rankDesc != nil {
+		ch <- r.root.rankDesc
+	}
+	if r.root.firstSeenDesc != nil {
+		ch <- r.root.firstSeenDesc
+	}
+	ch <- r.root.malformedDesc
+	ch <- r.root.duplicateDesc
+	if r.root.audit != nil {
+		r.root.audit.describe(ch)
+	}
 }
 
 var labelParseSplit = string([]byte{model.SeparatorByte})
 
 func (r *topkCurry) Collect(ch chan<- prometheus.Metric) {
-	r.root.streamMtx.Lock()
-	elts := r.root.stream.Keys()
-	r.root.streamMtx.Unlock()
+	// only copy the elements and per-key values under the read lock,
+	// leaving the sorting to the registry, and the quantiles and metrics to
+	// after it is released
+	r.root.rlockStream()
+	threshold := r.root.reportThreshold
+	elts := streamElements(r.root.stream, threshold)
+	var values []*keyValues
+	if r.root.keyState != nil {
+		values = make([]*keyValues, len(elts))
+		for i, e := range elts {
+			if st := r.root.keyState[e.Key]; st != nil {
+				values[i] = r.root.values(st)
+			}
+		}
+	}
+	var audit auditStats
+	if r.root.audit != nil {
+		audit = r.root.audit.stats(r.root.stream)
+	}
+	var total float64
+	if r.root.shareDesc != nil {
+		total = streamTotal(r.root.stream)
+	}
+	r.root.streamMtx.RUnlock()
 
-	for _, e := range elts {
-		if e.Count < r.root.reportThreshold {
-			// Do not collect if value is too low
+	keys := make([]string, len(elts))
+	for i, e := range elts {
+		keys[i] = e.Key
+	}
+	labels := r.root.labels.lookup(r.root, keys)
+	// distinct keys can still have the same label values, such as keys
+	// escaped differently by a restored encoding, and a pedantic registry
+	// would fail the whole Gather on their metrics
+	elts, labels, values = r.root.dedupe(elts, labels, values)
+	var rank []int
+	limited := r.root.exportedKeys > 0 && len(elts) > r.root.exportedKeys
+	if r.root.rankDesc != nil || limited {
+		rank = ranks(elts, labels)
+	}
+
+	var exported []int
+	for i, e := range elts {
+		kl := labels[i]
+		if kl.lvs == nil {
+			r.root.malformed.Add(1)
+			continue
+		}
+		if limited && rank[i] > r.root.exportedKeys {
+			continue
+		}
+		if r.root.hooks != nil {
+			exported = append(exported, i)
+		}
+		var count prometheus.Metric = &keyMetric{r.root.countDesc, kl.pairs, r.root.countType, e.Count}
+		var kv *keyValues
+		if values != nil {
+			kv = values[i]
+		}
+		if kv != nil && kv.exemplar != nil && r.root.countType == prometheus.CounterValue {
+			count = prometheus.MustNewMetricWithExemplars(count, *kv.exemplar)
+		}
+		ch <- count
+		ch <- &keyMetric{r.root.errDesc, kl.pairs, prometheus.GaugeValue, -e.Error}
+		if r.root.shareDesc != nil && total > 0 {
+			ch <- &keyMetric{r.root.shareDesc, kl.pairs, prometheus.GaugeValue, e.Count / total}
+		}
+		if r.root.rankDesc != nil {
+			ch <- &keyMetric{r.root.rankDesc, kl.pairs, prometheus.GaugeValue, float64(rank[i])}
+		}
+		if kv == nil {
 			continue
 		}
-		split := strings.Split(e.Key, labelParseSplit)
-		if len(split) != len(r.root.variableLabels)+1 {
-			panic("bad label-string value in topk")
+		if r.root.firstSeenDesc != nil {
+			ch <- &keyMetric{r.root.firstSeenDesc, kl.pairs, prometheus.GaugeValue, float64(kv.firstSeen.UnixNano()) / 1e9}
+		}
+		if kv.histogram != nil {
+			ch <- newRelabeledMetric(r.root.histDesc, kv.histogram, kl.lvs)
+		}
+		if d := kv.digest; d != nil {
+			quantiles := make(map[float64]float64, len(r.root.quantiles))
+			for _, q := range r.root.quantiles {
+				quantiles[q] = d.Quantile(q)
+			}
+			ch <- prometheus.MustNewConstSummary(r.root.sumDesc, uint64(d.Count()), d.Sum(), quantiles, kl.lvs...)
+		}
+		if r.root.obsCountDesc != nil {
+			ch <- &keyMetric{r.root.obsCountDesc, kl.pairs, prometheus.CounterValue, float64(kv.obsCount)}
+			ch <- &keyMetric{r.root.obsSumDesc, kl.pairs, prometheus.CounterValue, kv.obsSum}
 		}
-		lvs := split[:len(r.root.variableLabels)]
-		ch <- prometheus.MustNewConstMetric(r.root.countDesc, prometheus.CounterValue, e.Count, lvs...)
-		ch <- prometheus.MustNewConstMetric(r.root.errDesc, prometheus.GaugeValue, -e.Error, lvs...)
+	}
+	if r.root.hooks != nil {
+		r.root.hooks.update(r.root.variableLabels, elts, labels, exported)
+	}
+	if n := r.root.malformed.Load(); n > 0 {
+		ch <- prometheus.MustNewConstMetric(r.root.malformedDesc, prometheus.CounterValue, float64(n))
+	}
+	if n := r.root.duplicates.Load(); n > 0 {
+		ch <- prometheus.MustNewConstMetric(r.root.duplicateDesc, prometheus.CounterValue, float64(n))
+	}
+	if r.root.overallDesc != nil {
+		ch <- prometheus.MustNewConstMetric(r.root.overallDesc, r.root.countType, total)
+	}
+	if r.root.audit != nil {
+		r.root.audit.collect(ch, audit)
 	}
 }
 
 func (b *topkWithLabelValues) Observe(v float64) {
+	v, ok := b.root.valuePolicy.apply(v)
+	if !ok {
+		b.root.dropped.Add(1)
+		return
+	}
+	b.observe(v, nil)
+}
+
+func (b *topkWithLabelValues) TryObserve(v float64) error {
+	if err := checkValue(v); err != nil {
+		b.root.dropped.Add(1)
+		return err
+	}
+	b.observe(v, nil)
+	return nil
+}
+
+func (b *topkWithLabelValues) ObserveWithExemplar(v float64, e prometheus.Labels) {
+	v, ok := b.root.valuePolicy.apply(v)
+	if !ok {
+		b.root.dropped.Add(1)
+		return
+	}
+	ex, err := newExemplar(v, e, b.root.now())
+	if err != nil {
+		panic(err)
+	}
+	b.observe(v, ex)
+}
+
+func (b *topkWithLabelValues) AddWithExemplar(v float64, e prometheus.Labels) {
+	if v < 0 {
+		panic(errors.New("counter cannot decrease in value"))
+	}
+	b.ObserveWithExemplar(v, e)
+}
+
+// ObserveMany records n observations of v at once, needing a single lock
+// acquisition. The exported count of the key grows by n times v. Per-key
+// histograms still cost as much as n calls to Observe.
+func (b *topkWithLabelValues) ObserveMany(v float64, n uint64) {
+	v, ok := b.root.valuePolicy.apply(v)
+	if !ok {
+		b.root.dropped.Add(n)
+		return
+	}
+	if n > 0 {
+		b.observeN(v, n, nil)
+	}
+}
+
+func (b *topkWithLabelValues) observe(v float64, ex *prometheus.Exemplar) {
+	b.observeN(v, 1, ex)
+}
+
+func (b *topkWithLabelValues) observeN(v float64, n uint64, ex *prometheus.Exemplar) {
+	if f := b.root.samplingFactor.Load(); f > 1 {
+		if rand.Uint64N(f) != 0 {
+			return
+		}
+		n *= f
+	}
+	if b.root.flush != nil && ex == nil {
+		b.accumulate(v, n)
+		return
+	}
+	if b.root.async != nil {
+		b.root.async.enqueue(b.compositeLabel, v, n, ex)
+		return
+	}
+	if b.root.shards != nil && ex == nil {
+		s := b.root.lockShard()
+		s.stream.Insert(b.compositeLabel, v*float64(n))
+		s.observations += n
+		s.mtx.Unlock()
+		return
+	}
 	b.root.streamMtx.Lock()
 	defer b.root.streamMtx.Unlock()
-	b.root.stream.Insert(b.compositeLabel, v)
+	b.root.insert(b.compositeLabel, v, n, ex)
+}
+
+// insert records n observations of v for a key. The ex argument may be nil.
+// Must be called with streamMtx held.
+func (r *topkRoot) insert(key string, v float64, n uint64, ex *prometheus.Exemplar) {
+	r.observations.Add(n)
+	count := v * float64(n)
+	if r.decay != nil {
+		count *= r.decayWeight()
+	}
+	if r.mode == ModeSum {
+		r.stream.Insert(key, count)
+		r.shareAlert.add(count)
+		if r.audit != nil {
+			r.audit.exact[key] += count
+		}
+	} else {
+		r.record(key, v)
+	}
+	r.touch(key)
+	if r.shareAlert != nil {
+		r.checkShare(key)
+	}
+	if (r.keyState != nil || ex != nil) && r.stream.Monitored(key) {
+		r.observeKey(key, v, n, ex)
+	}
 }
 
 func (b *topkWithLabelValues) Inc() {
 	b.Observe(1)
 }
+
+func (b *topkWithLabelValues) Add(v float64) {
+	if v < 0 {
+		panic(errors.New("counter cannot decrease in value"))
+	}
+	b.Observe(v)
+}
+
+func (b *topkWithLabelValues) Set(v float64) {
+	if b.root.mode == ModeSum {
+		panic(errors.New("topk: Set needs a Mode other than ModeSum"))
+	}
+	b.Observe(v)
+}
+
+func (b *topkWithLabelValues) Dec() {
+	b.Sub(1)
+}
+
+func (b *topkWithLabelValues) Sub(v float64) {
+	if b.root.decrementPolicy == DecrementPolicyDisallow {
+		panic(errors.New("topk: Sub needs a DecrementPolicy"))
+	}
+	if v < 0 {
+		b.Observe(-v)
+		return
+	}
+	if math.IsNaN(v) {
+		b.root.dropped.Add(1)
+		return
+	}
+	b.root.lockStream()
+	defer b.root.streamMtx.Unlock()
+	b.root.subtract(b.compositeLabel, v)
+}
+
+// subtract decreases the count of a tracked key by v, but not below zero.
+// Must be called with streamMtx held.
+func (r *topkRoot) subtract(key string, v float64) {
+	if !r.stream.Monitored(key) {
+		r.dropped.Add(1)
+		return
+	}
+	if r.decay != nil {
+		v *= r.decayWeight()
+	}
+	v = min(v, r.stream.Estimate(key).Count)
+	r.stream.Insert(key, -v)
+	r.shareAlert.add(-v)
+	r.touch(key)
+	if r.audit != nil {
+		r.audit.exact[key] -= v
+	}
+}
+
+// Desc implements prometheus.Metric.
+func (b *topkWithLabelValues) Desc() *prometheus.Desc {
+	return b.root.countDesc
+}
+
+// Write implements prometheus.Metric by writing the current estimate for the
+// key, whether or not it is tracked.
+func (b *topkWithLabelValues) Write(out *dto.Metric) error {
+	b.root.rlockStream()
+	e := b.root.stream.Estimate(b.compositeLabel)
+	b.root.streamMtx.RUnlock()
+
+	lvs := strings.Split(b.compositeLabel, labelParseSplit)
+	m, err := prometheus.NewConstMetric(b.root.countDesc, b.root.countType, e.Count, unescapeLabelValues(lvs[:len(lvs)-1])...)
+	if err != nil {
+		return err
+	}
+	return m.Write(out)
+}
+
+// Describe implements prometheus.Collector.
+func (b *topkWithLabelValues) Describe(ch chan<- *prometheus.Desc) {
+	ch <- b.root.countDesc
+}
+
+// Collect implements prometheus.Collector.
+func (b *topkWithLabelValues) Collect(ch chan<- prometheus.Metric) {
+	ch <- b
+}
@@ -18,9 +18,15 @@ limitations under the License.
 package topk
 
 import (
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 const metricName = "test_metric"
@@ -66,3 +72,401 @@ func TestRegisterCollect(t *testing.T) {
 		}
 	}
 }
+
+func TestSetReportingThreshold(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3, ReportingThreshold: 2}, []string{"key"})
+	k.WithLabelValues("a").Add(3)
+	k.WithLabelValues("b").Add(1)
+
+	if n := testutil.CollectAndCount(k, metricName); n != 1 {
+		t.Errorf("got %d metrics over the threshold, expected 1", n)
+	}
+	k.MustCurryWith(prometheus.Labels{"key": "a"}).SetReportingThreshold(0)
+	if n := testutil.CollectAndCount(k, metricName); n != 2 {
+		t.Errorf("got %d metrics after lowering the threshold, expected 2", n)
+	}
+}
+
+func TestQuantiles(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{
+		Name:      metricName,
+		Buckets:   3,
+		Quantiles: []float64{0.5, 0.9},
+	}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 100; i++ {
+		k.WithLabelValues("a").Observe(float64(i))
+	}
+	k.WithLabelValues("b").Observe(7)
+
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, v := range mets {
+		if v.GetName() != metricName+"_summary" {
+			continue
+		}
+		found = true
+		for _, m := range v.Metric {
+			s := m.GetSummary()
+			switch m.Label[0].GetValue() {
+			case "a":
+				if s.GetSampleCount() != 100 || s.GetSampleSum() != 5050 {
+					t.Errorf("wrong count/sum for a: %v %v", s.GetSampleCount(), s.GetSampleSum())
+				}
+				for _, q := range s.Quantile {
+					want := q.GetQuantile() * 100
+					if math.Abs(q.GetValue()-want) > 2 {
+						t.Errorf("quantile %v: got %v expected %v", q.GetQuantile(), q.GetValue(), want)
+					}
+				}
+			case "b":
+				if s.Quantile[0].GetValue() != 7 {
+					t.Errorf("wrong median for b: %v", s.Quantile[0].GetValue())
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("summary family not exported")
+	}
+}
+
+func TestCountAndSum(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{
+		Name:        metricName,
+		Buckets:     3,
+		CountAndSum: true,
+	}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+
+	k.WithLabelValues("a").Observe(1)
+	k.WithLabelValues("a").Observe(2)
+	k.WithLabelValues("b").Observe(4)
+
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]float64)
+	for _, v := range mets {
+		for _, m := range v.Metric {
+			got[v.GetName()+"/"+m.Label[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	want := map[string]float64{
+		metricName + "_count/a": 2,
+		metricName + "_sum/a":   3,
+		metricName + "_count/b": 1,
+		metricName + "_sum/b":   4,
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("%s: got %v expected %v", key, got[key], v)
+		}
+	}
+}
+
+func TestBucketCounter(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"key"})
+
+	var c prometheus.Counter = k.WithLabelValues("a")
+	c.Add(2.5)
+	c.Inc()
+	if got := testutil.ToFloat64(c); got != 3.5 {
+		t.Errorf("wrong counter value: got %v expected 3.5", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for negative value")
+		}
+	}()
+	c.Add(-1)
+}
+
+func TestCollectMalformedKeys(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+	k.WithLabelValues("good").Inc()
+	k.WithLabelValues("not \xff UTF-8").Inc()
+	root := k.(*topkCurry).root
+	root.streamMtx.Lock()
+	root.stream.Insert("no separator", 1)
+	root.streamMtx.Unlock()
+
+	want := `
+# HELP test_metric_malformed_keys_total Number of times a key of the TopK could not be exported, for example because a label value is not valid UTF-8.
+# TYPE test_metric_malformed_keys_total counter
+test_metric_malformed_keys_total 2
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="good"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), metricName, metricName+"_malformed_keys_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollectDuplicateKeys(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5}, []string{"key"})
+	if err := reg.Register(k); err != nil {
+		t.Fatal(err)
+	}
+	k.WithLabelValues("a").Add(2)
+	k.WithLabelValues("b").Inc()
+	// make another key split into the same label values
+	root := k.(*topkCurry).root
+	root.streamMtx.Lock()
+	root.stream.Insert("c\xff", 3)
+	root.streamMtx.Unlock()
+	root.labels.keys = map[string]*keyLabels{"c\xff": root.newKeyLabels("a\xff")}
+
+	want := `
+# HELP test_metric_duplicate_keys_total Number of times a key of the TopK was exported merged into another key with the same label values.
+# TYPE test_metric_duplicate_keys_total counter
+test_metric_duplicate_keys_total 1
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="a"} 5
+test_metric{key="b"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want), metricName, metricName+"_duplicate_keys_total"); err != nil {
+		t.Error(err)
+	}
+	if st := k.Stats(); st.Duplicates != 1 {
+		t.Errorf("got %d duplicates expected 1", st.Duplicates)
+	}
+}
+
+func TestErrorCountersPerBucket(t *testing.T) {
+	for _, bc := range []struct {
+		opts TopKOpts
+		want int
+	}{
+		{TopKOpts{}, 6 * 4},
+		{TopKOpts{ErrorCountersPerBucket: 20}, 20 * 4},
+		{TopKOpts{ErrorCountersPerBucket: 20, PartitionLabels: []string{"a"}}, 20 * 4},
+	} {
+		bc.opts.Name = metricName
+		bc.opts.Buckets = 4
+		k := NewTopK(bc.opts, []string{"a"})
+		k.WithLabelValues("x").Inc()
+		snap := k.SnapshotProto()
+		alphas := snap.GetAlphas()
+		if len(snap.GetPartitions()) > 0 {
+			alphas = snap.GetPartitions()[0].GetAlphas()
+		}
+		if len(alphas) != bc.want {
+			t.Errorf("%+v: got %d error counters expected %d", bc.opts, len(alphas), bc.want)
+		}
+	}
+}
+
+// benchmarkGoroutines is the least number of goroutines of the parallel
+// benchmarks.
+const benchmarkGoroutines = 32
+
+// setParallelism makes b.RunParallel use at least benchmarkGoroutines
+// goroutines.
+func setParallelism(b *testing.B) {
+	procs := runtime.GOMAXPROCS(0)
+	b.SetParallelism((benchmarkGoroutines + procs - 1) / procs)
+}
+
+func BenchmarkObserveParallel(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts TopKOpts
+	}{
+		{"Lock", TopKOpts{}},
+		{"Shards", TopKOpts{Shards: runtime.GOMAXPROCS(0)}},
+		{"BucketFlushInterval", TopKOpts{BucketFlushInterval: time.Second}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			bc.opts.Name = metricName
+			bc.opts.Buckets = 100
+			k := NewTopK(bc.opts, []string{"key"})
+			defer k.Close()
+			keys := make([]TopKBucket, 1000)
+			for i := range keys {
+				keys[i] = k.WithLabelValues(strconv.Itoa(i))
+			}
+
+			setParallelism(b)
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					keys[i%len(keys)].Inc()
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkReadParallel measures the readers of a TopK, which only take the
+// read lock, while it is being observed.
+func BenchmarkReadParallel(b *testing.B) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 100}, []string{"key"})
+	for i := 0; i < 1000; i++ {
+		k.WithLabelValues(strconv.Itoa(i)).Add(float64(i))
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		obs := k.WithLabelValues("observed")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				obs.Inc()
+			}
+		}
+	}()
+
+	labels := prometheus.Labels{"key": "500"}
+	setParallelism(b)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			k.Estimate(labels)
+		}
+	})
+}
+
+func TestCollectReadLock(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Quantiles: []float64{0.5}}, []string{"key"})
+	k.WithLabelValues("a").Observe(1)
+
+	// Collect only needs the read lock, even to export the quantiles
+	root := k.(*topkCurry).root
+	root.streamMtx.RLock()
+	defer root.streamMtx.RUnlock()
+	done := make(chan int)
+	go func() {
+		done <- testutil.CollectAndCount(k, metricName+"_summary")
+	}()
+	select {
+	case n := <-done:
+		if n != 1 {
+			t.Errorf("got %d summaries, expected 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Collect waited for the write lock")
+	}
+}
+
+func TestSamplingFactor(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, SamplingFactor: 10}, []string{"key"})
+	b := k.WithLabelValues("a")
+	for i := 0; i < 100000; i++ {
+		b.Inc()
+	}
+	count, _, _ := k.Estimate(prometheus.Labels{"key": "a"})
+	if math.Mod(count, 10) != 0 || math.Abs(count-100000) > 5000 {
+		t.Errorf("got count %v, expected a multiple of 10 close to 100000", count)
+	}
+	if n := k.Stats().Observations; float64(n) != count {
+		t.Errorf("got %d observations, expected %v", n, count)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithSampling(10), WithQuantiles(0.5)); err == nil {
+		t.Error("expected error for a sampled TopK with summaries")
+	}
+}
+
+func TestShares(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Shares: true}, []string{"key"})
+	k.WithLabelValues("a").Add(6)
+	k.WithLabelValues("b").Add(1)
+	k.WithLabelValues("c").Add(3)
+
+	// c replaced b, which still counts in the overall sum
+	expected := `
+# HELP test_metric_overall Sum of all counts of test_metric, including the keys that are not exported.
+# TYPE test_metric_overall counter
+test_metric_overall 10
+# HELP test_metric_share Estimated share of the key in the sum of all counts of test_metric.
+# TYPE test_metric_share gauge
+test_metric_share{key="a"} 0.6
+test_metric_share{key="c"} 0.3
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName+"_overall", metricName+"_share"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRanks(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3, Ranks: true}, []string{"key"})
+	k.WithLabelValues("a").Add(1)
+	k.WithLabelValues("b").Add(5)
+	k.WithLabelValues("c").Add(1)
+
+	expected := `
+# HELP test_metric_rank Rank of the key among the keys of test_metric by decreasing count, from 1.
+# TYPE test_metric_rank gauge
+test_metric_rank{key="a"} 2
+test_metric_rank{key="b"} 1
+test_metric_rank{key="c"} 3
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName+"_rank"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := NewTopK(TopKOpts{
+		Name: metricName, Buckets: 2, FirstSeen: true,
+		Now: func() time.Time { return now },
+	}, []string{"key"})
+	k.WithLabelValues("a").Add(5)
+	k.WithLabelValues("b").Add(1)
+	now = now.Add(time.Minute)
+	k.WithLabelValues("b").Add(1)
+	// c evicts b, and is first seen now
+	k.WithLabelValues("c").Add(3)
+
+	expected := `
+# HELP test_metric_first_seen_timestamp_seconds Time at which the key of test_metric was first tracked, in seconds since the epoch.
+# TYPE test_metric_first_seen_timestamp_seconds gauge
+test_metric_first_seen_timestamp_seconds{key="a"} 1000
+test_metric_first_seen_timestamp_seconds{key="c"} 1060
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName+"_first_seen_timestamp_seconds"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportedKeys(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5, ExportedKeys: 2}, []string{"key"})
+	for i, key := range []string{"a", "b", "c", "d"} {
+		k.WithLabelValues(key).Add(float64(i + 1))
+	}
+
+	expected := `
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="c"} 3
+test_metric{key="d"} 4
+`
+	if err := testutil.CollectAndCompare(k, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+	// all the tracked keys are still queryable
+	if n := len(k.Snapshot()); n != 4 {
+		t.Errorf("got %d keys in the snapshot, expected 4", n)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"encoding/json"
+	"strings"
+
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// Element is the estimate for one tracked key of a TopK.
+type Element struct {
+	// Labels are the label values of the key, including curried labels.
+	Labels prometheus.Labels `json:"labels"`
+
+	// Count is the estimated total of the observations of the key. It is
+	// never lower than the true total.
+	Count float64 `json:"count"`
+	// Error is the maximum overestimation in Count, so the true total is
+	// at least Count-Error.
+	Error float64 `json:"error"`
+	// Guaranteed is true if the key is certain to be among the true top
+	// keys, because its lower bound is at least the count of every key that
+	// is not tracked.
+	Guaranteed bool `json:"guaranteed"`
+}
+
+// Snapshot returns all tracked keys visible through this TopK, ordered by
+// decreasing Count. Unlike Collect, keys under the reporting threshold are
+// included.
+func (r *topkCurry) Snapshot() []Element {
+	return r.topN(-1)
+}
+
+// TopN returns the n tracked keys visible through this TopK with the highest
+// counts, or all of them if there are fewer, like the start of Snapshot. Only
+// the returned keys are split into labels, so it is cheaper than Snapshot for
+// a small n.
+func (r *topkCurry) TopN(n int) []Element {
+	if n <= 0 {
+		return nil
+	}
+	return r.topN(n)
+}
+
+// topN returns the first n elements of Snapshot, or all of them if n is
+// negative.
+func (r *topkCurry) topN(n int) []Element {
+	r.root.rlockStream()
+	elts := r.root.stream.Keys()
+	// every key that is not tracked has a lower count than the minimum of
+	// its stream, if the stream is full
+	floors := make([]float64, len(elts))
+	if p, ok := r.root.stream.(*partitionedStream); ok {
+		byPartition := make(map[*tk.Stream]float64)
+		for i, e := range elts {
+			s := p.parts[p.partitionKey(e.Key)]
+			floor, ok := byPartition[s]
+			if !ok {
+				floor = streamFloor(s)
+				byPartition[s] = floor
+			}
+			floors[i] = floor
+		}
+	} else {
+		floor := streamFloor(r.root.stream)
+		for i := range floors {
+			floors[i] = floor
+		}
+	}
+	r.root.streamMtx.RUnlock()
+
+	size := len(elts)
+	if n >= 0 {
+		size = min(size, n)
+	}
+	out := make([]Element, 0, size)
+	for i, e := range elts {
+		if len(out) == size {
+			break
+		}
+		lvs, ok := r.splitKey(e.Key)
+		if !ok {
+			continue
+		}
+		out = append(out, r.root.element(e, lvs, e.Count-e.Error >= floors[i]))
+	}
+	return out
+}
+
+// splitKey splits a composite key into label values, returning false if the
+// key does not match the curried labels.
+func (r *topkCurry) splitKey(key string) ([]string, bool) {
+	split := strings.Split(key, labelParseSplit)
+	if len(split) != len(r.root.variableLabels)+1 {
+		return nil, false
+	}
+	for _, cv := range r.curry {
+		if split[cv.index] != cv.value {
+			return nil, false
+		}
+	}
+	return unescapeLabelValues(split[:len(r.root.variableLabels)]), true
+}
+
+func (r *topkRoot) element(e tk.Element, lvs []string, guaranteed bool) Element {
+	labels := make(prometheus.Labels, len(lvs))
+	for i, name := range r.variableLabels {
+		labels[name] = lvs[i]
+	}
+	return Element{
+		Labels:     labels,
+		Count:      e.Count,
+		Error:      e.Error,
+		Guaranteed: guaranteed,
+	}
+}
+
+// Estimate returns the estimate for the key with the given labels, whether or
+// not it is tracked. The true total of the key is between count-err and
+// count. Like With, Estimate panics if the labels are invalid.
+func (r *topkCurry) Estimate(labels prometheus.Labels) (count, err float64, tracked bool) {
+	composite, cerr := r.compositeWithLabels(labels)
+	if cerr != nil {
+		panic(cerr)
+	}
+
+	r.root.rlockStream()
+	defer r.root.streamMtx.RUnlock()
+	e := r.root.stream.Estimate(composite)
+	return e.Count, e.Error, r.root.stream.Monitored(composite)
+}
+
+// Rank returns the 1-based position of the key with the given label values
+// among the tracked keys visible through this TopK, ordered by decreasing
+// count. It returns false if the key is not tracked. Like WithLabelValues,
+// Rank panics if the label values are invalid.
+func (r *topkCurry) Rank(lvs ...string) (rank int, ok bool) {
+	composite, err := r.compositeWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+
+	r.root.rlockStream()
+	if !r.root.stream.Monitored(composite) {
+		r.root.streamMtx.RUnlock()
+		return 0, false
+	}
+	elts := r.root.stream.Keys()
+	r.root.streamMtx.RUnlock()
+
+	for _, e := range elts {
+		if _, visible := r.splitKey(e.Key); !visible {
+			continue
+		}
+		rank++
+		if e.Key == composite {
+			return rank, true
+		}
+	}
+	return 0, false
+}
+
+// Range calls f for each tracked key visible through this TopK, in no
+// particular order, until f returns false. The lock of the TopK is held
+// during the iteration, so f must not use the TopK.
+//
+// To avoid allocations, the labels map is reused between calls to f; copy it
+// to retain it.
+func (r *topkCurry) Range(f func(labels prometheus.Labels, count, err float64) bool) {
+	labels := make(prometheus.Labels, len(r.root.variableLabels))
+
+	r.root.rlockStream()
+	defer r.root.streamMtx.RUnlock()
+	visit := func(e tk.Element) bool {
+		if !r.fillLabels(labels, e.Key) {
+			return true
+		}
+		return f(labels, e.Count, e.Error)
+	}
+	// call the concrete type so that visit does not escape
+	switch s := r.root.stream.(type) {
+	case *tk.Stream:
+		s.Range(visit)
+	case *partitionedStream:
+		s.Range(visit)
+	default:
+		// a custom stream would make visit escape
+		for _, e := range s.Keys() {
+			if !visit(e) {
+				break
+			}
+		}
+	}
+}
+
+// fillLabels parses a composite key into labels without allocating, returning
+// false if the key is malformed or does not match the curried labels.
+func (r *topkCurry) fillLabels(labels prometheus.Labels, key string) bool {
+	iCurry := 0
+	for i, name := range r.root.variableLabels {
+		end := strings.IndexByte(key, model.SeparatorByte)
+		if end < 0 {
+			return false
+		}
+		val := key[:end]
+		key = key[end+1:]
+		if iCurry < len(r.curry) && r.curry[iCurry].index == i {
+			if r.curry[iCurry].value != val {
+				return false
+			}
+			iCurry++
+		}
+		labels[name] = unescapeLabelValue(val)
+	}
+	return key == ""
+}
+
+// MarshalJSON implements json.Marshaler, encoding the Snapshot as an array of
+// elements.
+func (r *topkCurry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Snapshot())
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSnapshot(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3, ReportingThreshold: 100}, []string{"a", "b"})
+
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(4)
+
+	want := []Element{
+		{Labels: prometheus.Labels{"a": "1", "b": "x"}, Count: 5, Guaranteed: true},
+		{Labels: prometheus.Labels{"a": "2", "b": "x"}, Count: 4, Guaranteed: true},
+		{Labels: prometheus.Labels{"a": "1", "b": "y"}, Count: 3, Guaranteed: true},
+	}
+	if got := k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	// the first two are not tracked, the last one replaces 1/y
+	k.WithLabelValues("2", "y").Add(1)
+	k.WithLabelValues("2", "y").Add(1)
+	k.WithLabelValues("2", "y").Add(2)
+	got := k.Snapshot()
+	if len(got) != 3 {
+		t.Fatalf("got %d elements, expected 3", len(got))
+	}
+	var replaced Element
+	for _, e := range got {
+		if e.Labels["a"] == "2" && e.Labels["b"] == "y" {
+			replaced = e
+		} else if e.Error != 0 || !e.Guaranteed {
+			t.Errorf("expected exact guaranteed element: %v", e)
+		}
+	}
+	// 2/y inherits an error from the untracked keys
+	if replaced.Error == 0 || replaced.Guaranteed || replaced.Count-replaced.Error > 4 || replaced.Count < 4 {
+		t.Errorf("wrong bounds for replaced element: %v", replaced)
+	}
+
+	curried := k.MustCurryWith(prometheus.Labels{"b": "y"})
+	if cs := curried.Snapshot(); !reflect.DeepEqual(cs, []Element{replaced}) {
+		t.Errorf("curried: got %v expected %v", cs, replaced)
+	}
+}
+
+func TestTopN(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(4)
+
+	snap := k.Snapshot()
+	for n, want := range map[int][]Element{0: nil, 1: snap[:1], 2: snap[:2], 5: snap} {
+		if got := k.TopN(n); !reflect.DeepEqual(got, want) {
+			t.Errorf("TopN(%d): got %v expected %v", n, got, want)
+		}
+	}
+
+	// the keys that are not visible are skipped
+	curried := k.MustCurryWith(prometheus.Labels{"a": "1"})
+	if got, want := curried.TopN(1), curried.Snapshot()[:1]; !reflect.DeepEqual(got, want) || got[0].Count != 5 {
+		t.Errorf("curried: got %v expected %v", got, want)
+	}
+	if got := k.MustCurryWith(prometheus.Labels{"b": "y"}).TopN(1); len(got) != 1 || got[0].Count != 3 {
+		t.Errorf("curried: got %v expected the count of 1/y", got)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(1)
+
+	if count, err, tracked := k.Estimate(prometheus.Labels{"a": "1", "b": "x"}); count != 5 || err != 0 || !tracked {
+		t.Errorf("got %v %v %v", count, err, tracked)
+	}
+	curried := k.MustCurryWith(prometheus.Labels{"a": "2"})
+	if count, err, tracked := curried.Estimate(prometheus.Labels{"b": "x"}); count != 1 || err != 1 || tracked {
+		t.Errorf("got %v %v %v for untracked key", count, err, tracked)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for invalid labels")
+		}
+	}()
+	k.Estimate(prometheus.Labels{"a": "1"})
+}
+
+func TestRank(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"})
+
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(4)
+
+	for _, tc := range []struct {
+		lvs  []string
+		rank int
+	}{
+		{[]string{"1", "x"}, 1},
+		{[]string{"2", "x"}, 2},
+		{[]string{"1", "y"}, 3},
+	} {
+		if rank, ok := k.Rank(tc.lvs...); rank != tc.rank || !ok {
+			t.Errorf("Rank(%v) = %v, %v; expected %v", tc.lvs, rank, ok, tc.rank)
+		}
+	}
+	if _, ok := k.Rank("3", "x"); ok {
+		t.Error("untracked key has a rank")
+	}
+	if rank, ok := k.MustCurryWith(prometheus.Labels{"a": "1"}).Rank("y"); rank != 2 || !ok {
+		t.Errorf("curried Rank = %v, %v; expected 2", rank, ok)
+	}
+}
+
+func TestRange(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"})
+
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(4)
+
+	got := make(map[string]float64)
+	k.MustCurryWith(prometheus.Labels{"b": "x"}).Range(func(labels prometheus.Labels, count, err float64) bool {
+		got[labels["a"]+labels["b"]] = count
+		return true
+	})
+	if want := map[string]float64{"1x": 5, "2x": 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	var calls int
+	k.Range(func(prometheus.Labels, float64, float64) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("Range did not stop: %d calls", calls)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		k.Range(func(prometheus.Labels, float64, float64) bool { return true })
+	})
+	if allocs > 2 {
+		t.Errorf("Range allocates %v times", allocs)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a"})
+	k.WithLabelValues("x").Add(2)
+	k.WithLabelValues("y").Add(1.5)
+
+	got, err := json.Marshal(map[string]interface{}{"top": k})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"top":[{"labels":{"a":"x"},"count":2,"error":0,"guaranteed":true},{"labels":{"a":"y"},"count":1.5,"error":0,"guaranteed":true}]}`
+	if string(got) != want {
+		t.Errorf("got %s expected %s", got, want)
+	}
+}
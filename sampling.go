@@ -0,0 +1,95 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// samplingInterval is how often the adaptive sampling factor is
+	// adjusted.
+	samplingInterval = time.Second
+	// maxSamplingFactor bounds the adaptive sampling factor.
+	maxSamplingFactor = 1 << 20
+)
+
+// sampler adjusts the sampling factor of a TopK so that it records about
+// TargetObservationRate observations per second.
+type sampler struct {
+	root   *topkRoot
+	target float64
+	min    uint64
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func startSampler(root *topkRoot, target float64, min uint64) *sampler {
+	s := &sampler{
+		root:   root,
+		target: target,
+		min:    min,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *sampler) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(samplingInterval)
+	defer ticker.Stop()
+	last, lastTime := s.root.observations.Load(), time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			// the recorded observations are scaled by the sampling
+			// factor, so this estimates all the observations made
+			obs := s.root.observations.Load()
+			s.root.samplingFactor.Store(s.factor(float64(obs-last) / now.Sub(lastTime).Seconds()))
+			last, lastTime = obs, now
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// factor returns the sampling factor that brings rate observations per
+// second down to the target.
+func (s *sampler) factor(rate float64) uint64 {
+	f := math.Ceil(rate / s.target)
+	switch {
+	case !(f > float64(s.min)):
+		return s.min
+	case f > maxSamplingFactor:
+		return maxSamplingFactor
+	}
+	return uint64(f)
+}
+
+func (s *sampler) close() {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
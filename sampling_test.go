@@ -0,0 +1,56 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "testing"
+
+func TestSamplerFactor(t *testing.T) {
+	s := &sampler{target: 1000, min: 2}
+	for _, tc := range []struct {
+		rate float64
+		want uint64
+	}{
+		{0, 2},
+		{1500, 2},
+		{2500, 3},
+		{1e6, 1000},
+		{1e12, maxSamplingFactor},
+	} {
+		if got := s.factor(tc.rate); got != tc.want {
+			t.Errorf("factor(%v) = %d, expected %d", tc.rate, got, tc.want)
+		}
+	}
+}
+
+func TestAdaptiveSampling(t *testing.T) {
+	k, err := NewTopKWithOptions(metricName, WithLabelNames("key"), WithAdaptiveSampling(100), WithSampling(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if f := k.Stats().SamplingFactor; f != 4 {
+		t.Errorf("got sampling factor %d, expected to start at SamplingFactor", f)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithAdaptiveSampling(100), WithCountAndSum()); err == nil {
+		t.Error("expected error for an adaptively sampled TopK with per-key counts")
+	}
+	if _, err := NewTopKWithOptions(metricName, WithAdaptiveSampling(0)); err == nil {
+		t.Error("expected error for a zero rate")
+	}
+}
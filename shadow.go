@@ -0,0 +1,148 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ShadowCounterVec records every increment into both an exact
+// prometheus.CounterVec and a TopK with the same label names, but only exports
+// the TopK. This keeps the exact counts available to the program, for example
+// through testutil.ToFloat64, while capping the number of scraped series.
+//
+// Do not register the exact CounterVec; register the ShadowCounterVec (or
+// the TopK) instead.
+type ShadowCounterVec struct {
+	exact *prometheus.CounterVec
+	topk  TopK
+}
+
+// NewShadowCounterVec wraps an existing CounterVec and a TopK with the same
+// label names.
+func NewShadowCounterVec(exact *prometheus.CounterVec, t TopK) *ShadowCounterVec {
+	return &ShadowCounterVec{exact: exact, topk: t}
+}
+
+// Exact returns the wrapped CounterVec.
+func (v *ShadowCounterVec) Exact() *prometheus.CounterVec {
+	return v.exact
+}
+
+// TopK returns the wrapped TopK.
+func (v *ShadowCounterVec) TopK() TopK {
+	return v.topk
+}
+
+// Describe implements prometheus.Collector by describing only the TopK.
+func (v *ShadowCounterVec) Describe(ch chan<- *prometheus.Desc) {
+	v.topk.Describe(ch)
+}
+
+// Collect implements prometheus.Collector by collecting only the TopK.
+func (v *ShadowCounterVec) Collect(ch chan<- prometheus.Metric) {
+	v.topk.Collect(ch)
+}
+
+// CurryWith curries both the CounterVec and the TopK.
+func (v *ShadowCounterVec) CurryWith(labels prometheus.Labels) (*ShadowCounterVec, error) {
+	exact, err := v.exact.CurryWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	t, err := v.topk.CurryWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return &ShadowCounterVec{exact: exact, topk: t}, nil
+}
+
+// MustCurryWith works as CurryWith but panics where CurryWith would have
+// returned an error.
+func (v *ShadowCounterVec) MustCurryWith(labels prometheus.Labels) *ShadowCounterVec {
+	n, err := v.CurryWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// GetMetricWith returns a Counter incrementing both the CounterVec and the
+// TopK for the given labels.
+func (v *ShadowCounterVec) GetMetricWith(labels prometheus.Labels) (prometheus.Counter, error) {
+	exact, err := v.exact.GetMetricWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	b, err := v.topk.GetMetricWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return &shadowCounter{TopKBucket: b, exact: exact}, nil
+}
+
+// GetMetricWithLabelValues returns a Counter incrementing both the CounterVec
+// and the TopK for the given label values.
+func (v *ShadowCounterVec) GetMetricWithLabelValues(lvs ...string) (prometheus.Counter, error) {
+	exact, err := v.exact.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	b, err := v.topk.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	return &shadowCounter{TopKBucket: b, exact: exact}, nil
+}
+
+// With works as GetMetricWith, but panics where GetMetricWith would have
+// returned an error.
+func (v *ShadowCounterVec) With(labels prometheus.Labels) prometheus.Counter {
+	c, err := v.GetMetricWith(labels)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// WithLabelValues works as GetMetricWithLabelValues, but panics where
+// GetMetricWithLabelValues would have returned an error.
+func (v *ShadowCounterVec) WithLabelValues(lvs ...string) prometheus.Counter {
+	c, err := v.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// shadowCounter is collected as the TopKBucket, but also increments the exact
+// Counter.
+type shadowCounter struct {
+	TopKBucket
+	exact prometheus.Counter
+}
+
+func (c *shadowCounter) Inc() {
+	c.exact.Inc()
+	c.TopKBucket.Inc()
+}
+
+func (c *shadowCounter) Add(v float64) {
+	c.exact.Add(v)
+	c.TopKBucket.Add(v)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShadowCounterVec(t *testing.T) {
+	exact := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "exact"}, []string{"a", "b"})
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+	v := NewShadowCounterVec(exact, k)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(v); err != nil {
+		t.Fatal(err)
+	}
+
+	v.WithLabelValues("1", "x").Add(3)
+	v.MustCurryWith(prometheus.Labels{"a": "2"}).WithLabelValues("x").Inc()
+	v.With(prometheus.Labels{"a": "3", "b": "x"}).Inc()
+
+	if n := testutil.CollectAndCount(exact); n != 3 {
+		t.Errorf("exact vec has %d series, expected 3", n)
+	}
+	if got := testutil.ToFloat64(exact.WithLabelValues("2", "x")); got != 1 {
+		t.Errorf("wrong exact count: %v", got)
+	}
+
+	mets, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mets {
+		if mf.GetName() == "exact" {
+			t.Error("exact vec must not be exported")
+		}
+		if mf.GetName() == metricName && len(mf.Metric) != 2 {
+			t.Errorf("TopK exported %d series, expected 2", len(mf.Metric))
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// shard buffers the observations of a sharded TopK, so that concurrent
+// observers rarely wait for each other. Its stream has the capacity of the
+// main stream, and is merged into it and emptied whenever the main stream is
+// read.
+type shard struct {
+	mtx    sync.Mutex
+	stream Stream
+	// observations recorded since the last flush
+	observations uint64
+
+	// keep the locks of different shards on different cache lines
+	_ [64]byte
+}
+
+// newShards returns the shards of the root, or nil if it is not sharded.
+func (r *topkRoot) newShards(n int) []*shard {
+	if n <= 1 {
+		return nil
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{stream: r.newStream()}
+	}
+	return shards
+}
+
+// lockShard returns a locked shard, preferring one that is not in use: it
+// starts at a random shard, so that the observers spread over all of them,
+// and only waits if all of them are locked.
+func (r *topkRoot) lockShard() *shard {
+	start := rand.IntN(len(r.shards))
+	for i := range r.shards {
+		if s := r.shards[(start+i)%len(r.shards)]; s.mtx.TryLock() {
+			return s
+		}
+	}
+	s := r.shards[start]
+	s.mtx.Lock()
+	return s
+}
+
+// lockStream locks streamMtx and records the observations buffered by the
+// shards, queued for the inserter, or accumulated by the buckets, then decays
+// the counts to now if the TopK has a HalfLife, and removes the expired keys
+// if it has a KeyTTL and the idle keys if it has an IdleRate. Every user of
+// the stream other than the observers must use it or rlockStream instead of
+// locking streamMtx directly.
+func (r *topkRoot) lockStream() {
+	r.streamMtx.Lock()
+	if r.flush != nil {
+		r.flushBuckets()
+	}
+	if r.async != nil {
+		r.async.drain()
+	}
+	for _, s := range r.shards {
+		s.mtx.Lock()
+		if s.observations > 0 {
+			// the shards always have the capacity and partitions of
+			// the stream, so the merge cannot fail
+			_ = mergeStreams(r.stream, s.stream)
+			s.stream.Reset()
+			r.observations.Add(s.observations)
+			s.observations = 0
+		}
+		s.mtx.Unlock()
+	}
+	if r.decay != nil {
+		r.rescale(r.now())
+	}
+	if r.expiry != nil {
+		r.expire()
+	}
+	if r.idle != nil {
+		r.evictIdle()
+	}
+}
+
+// rlockStream is lockStream for the readers that do not modify the stream:
+// it records the buffered observations, if any, then read-locks streamMtx.
+// Observations made in between are only recorded by the next reader.
+func (r *topkRoot) rlockStream() {
+	if r.shards != nil || r.async != nil || r.flush != nil || r.decay != nil || r.expiry != nil || r.idle != nil {
+		r.lockStream()
+		r.streamMtx.Unlock()
+	}
+	r.streamMtx.RLock()
+}
+
+// resizeShards changes the capacity of the shards to match the stream.
+// Must be called with streamMtx held.
+func (r *topkRoot) resizeShards(n int) {
+	for _, s := range r.shards {
+		s.mtx.Lock()
+		s.stream.Resize(n)
+		s.mtx.Unlock()
+	}
+}
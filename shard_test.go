@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestShards(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 10, Shards: 4}, []string{"key"})
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				k.WithLabelValues(fmt.Sprint(i % 5)).Inc()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// with fewer keys than buckets, the merged counts are exact
+	elts := k.Snapshot()
+	if len(elts) != 5 {
+		t.Fatalf("got %d keys, expected 5", len(elts))
+	}
+	for _, e := range elts {
+		if e.Count != 1600 || e.Error != 0 {
+			t.Errorf("got %v±%v for %v, expected 1600", e.Count, e.Error, e.Labels)
+		}
+	}
+	if n := k.Stats().Observations; n != 8000 {
+		t.Errorf("got %d observations, expected 8000", n)
+	}
+
+	k.WithLabelValues("new").Add(10000)
+	if rank, ok := k.Rank("new"); rank != 1 || !ok {
+		t.Errorf("Rank = %v, %v, expected a buffered observation to be merged", rank, ok)
+	}
+	if err := k.SetBuckets(2); err != nil {
+		t.Fatal(err)
+	}
+	k.WithLabelValues("0").Inc()
+	if n := len(k.Snapshot()); n != 2 {
+		t.Errorf("got %d keys after SetBuckets, expected 2", n)
+	}
+	k.Reset()
+	if n := len(k.Snapshot()); n != 0 {
+		t.Errorf("got %d keys after Reset", n)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithShards(4), WithCountAndSum()); err == nil {
+		t.Error("expected error for a sharded TopK with per-key counts")
+	}
+	if _, err := NewTopKWithOptions(metricName, WithShards(0)); err == nil {
+		t.Error("expected error for zero shards")
+	}
+}
+
+func TestShardsPartitioned(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 1, Shards: 2, PartitionLabels: []string{"tenant"}}, []string{"path", "tenant"})
+	for i := 0; i < 10; i++ {
+		k.WithLabelValues("/a", "x").Inc()
+		k.WithLabelValues("/b", "y").Inc()
+	}
+	if count, _, tracked := k.Estimate(prometheus.Labels{"path": "/b", "tenant": "y"}); count != 10 || !tracked {
+		t.Errorf("Estimate = %v, %v, expected 10", count, tracked)
+	}
+	if n := len(k.Snapshot()); n != 2 {
+		t.Errorf("got %d keys, expected one per partition", n)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/riking/go-prometheus-topk/topkpb"
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// SnapshotProto returns the descriptor and the full stream state of the TopK.
+// Like MarshalBinary, per-key histograms, digests, and exemplars are not
+// included, and a curried TopK returns the state of the whole TopK.
+func (r *topkCurry) SnapshotProto() *topkpb.Snapshot {
+	root := r.root
+	snap := &topkpb.Snapshot{
+		Name:            root.fqName,
+		Help:            root.help,
+		ErrorHelp:       root.errorHelp,
+		Unit:            root.unit,
+		PromlintNames:   root.promlint,
+		Mode:            int32(root.mode),
+		DecrementPolicy: int32(root.decrementPolicy),
+		LabelNames:      append([]string(nil), root.variableLabels...),
+		ConstLabels:     copyLabels(root.constLabels),
+		PartitionLabels: append([]string(nil), root.partitionLabels...),
+		TimestampMs:     root.now().UnixMilli(),
+		Hash:            root.hash.id(),
+	}
+	if root.decay != nil {
+		snap.HalfLifeNs = int64(math.Round(root.decay.halfLife * float64(time.Second)))
+	}
+
+	var elts []tk.Element
+	root.rlockStream()
+	snap.Buckets = uint64(root.buckets)
+	switch s := root.stream.(type) {
+	case *tk.Stream:
+		elts, snap.Alphas, snap.Total = s.State()
+	case *partitionedStream:
+		for pk, ps := range s.parts {
+			pelts, alphas, total := ps.State()
+			elts = append(elts, pelts...)
+			snap.Partitions = append(snap.Partitions, &topkpb.Partition{
+				LabelValues: splitPartitionKey(pk),
+				Alphas:      alphas,
+				Total:       total,
+			})
+			snap.Total += total
+		}
+	default:
+		// a custom stream has no error estimates to save
+		elts = streamElements(s, 0)
+		snap.Alphas = []float64{0}
+		snap.Total = streamTotal(s)
+	}
+	root.streamMtx.RUnlock()
+	sort.Slice(snap.Partitions, func(i, j int) bool {
+		return lessStrings(snap.Partitions[i].LabelValues, snap.Partitions[j].LabelValues)
+	})
+
+	snap.Elements = make([]*topkpb.Element, 0, len(elts))
+	for _, e := range elts {
+		lvs := strings.Split(e.Key, labelParseSplit)
+		if len(lvs) != len(root.variableLabels)+1 {
+			root.malformed.Add(1)
+			continue
+		}
+		lvs = unescapeLabelValues(lvs[:len(root.variableLabels)])
+		snap.Elements = append(snap.Elements, &topkpb.Element{
+			LabelValues: lvs,
+			Count:       e.Count,
+			Error:       e.Error,
+		})
+	}
+	return snap
+}
+
+// RestoreSnapshotProto replaces the state of the whole TopK with the stream
+// state in snap, discarding all per-key state. The snapshot must have the
+// same label names, partition labels, Mode, and number of buckets; its name,
+// help, and constant labels are not checked.
+func (r *topkCurry) RestoreSnapshotProto(snap *topkpb.Snapshot) error {
+	if err := snap.Validate(); err != nil {
+		return err
+	}
+	s, err := r.root.streamFromSnapshot(snap)
+	if err != nil {
+		return err
+	}
+
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+	if err := r.root.checkBuckets("snapshot", s.Capacity()); err != nil {
+		return err
+	}
+	r.root.setStream(s)
+	return nil
+}
+
+// NewTopKFromSnapshot creates a TopK with the name, help strings, unit,
+// naming, constant labels, label names, partition labels, number of buckets,
+// hash function, Mode, HalfLife, and DecrementPolicy of snap, restoring its
+// stream state. Options that are not part of the snapshot, like
+// ReportingThreshold or Quantiles, take their default values. Call Close on
+// the TopK of a snapshot with a HalfLife to stop its maintenance.
+func NewTopKFromSnapshot(snap *topkpb.Snapshot) (TopK, error) {
+	if err := snap.Validate(); err != nil {
+		return nil, err
+	}
+	opts := TopKOpts{
+		Name:        snap.GetName(),
+		Help:        snap.GetHelp(),
+		ErrorHelp:   snap.GetErrorHelp(),
+		Unit:        snap.GetUnit(),
+		ConstLabels: copyLabels(snap.GetConstLabels()),
+		Buckets:     snap.GetBuckets(),
+
+		PartitionLabels: snap.GetPartitionLabels(),
+		PromlintNames:   snap.GetPromlintNames(),
+		Mode:            Mode(snap.GetMode()),
+		HalfLife:        time.Duration(snap.GetHalfLifeNs()),
+		DecrementPolicy: DecrementPolicy(snap.GetDecrementPolicy()),
+	}
+	hash, err := hashFromID(snap.GetHash())
+	if err != nil {
+		return nil, err
+	}
+	opts.Hash = hash
+	if err := opts.validate(snap.GetLabelNames()); err != nil {
+		return nil, err
+	}
+	t := NewTopK(opts, snap.GetLabelNames())
+	if err := t.RestoreSnapshotProto(snap); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// streamFromSnapshot checks that a validated snapshot has the label names and
+// partition labels of the TopK, and rebuilds its stream. The caller must
+// check the number of buckets while holding streamMtx.
+func (r *topkRoot) streamFromSnapshot(snap *topkpb.Snapshot) (Stream, error) {
+	if r.customStream != nil {
+		return nil, errCustomStream
+	}
+	if !equalStrings(snap.GetLabelNames(), r.variableLabels) {
+		return nil, fmt.Errorf("topk: snapshot label names %q do not match %q", snap.GetLabelNames(), r.variableLabels)
+	}
+	index, partitionLabels := partitionIndex(r.variableLabels, snap.GetPartitionLabels())
+	if len(partitionLabels) != len(snap.GetPartitionLabels()) || !equalStrings(partitionLabels, r.partitionLabels) {
+		return nil, fmt.Errorf("topk: snapshot partition labels %q do not match %q", snap.GetPartitionLabels(), r.partitionLabels)
+	}
+	if err := r.checkHash("snapshot", snap.GetHash()); err != nil {
+		return nil, err
+	}
+	if mode := Mode(snap.GetMode()); mode != r.mode {
+		return nil, fmt.Errorf("topk: snapshot mode %v does not match %v", mode, r.mode)
+	}
+	if snap.GetBuckets() > MaxBuckets {
+		return nil, fmt.Errorf("topk: snapshot has %d buckets, more than %d", snap.GetBuckets(), MaxBuckets)
+	}
+	buckets := int(snap.GetBuckets())
+
+	elts := make([]tk.Element, 0, len(snap.GetElements()))
+	for _, e := range snap.GetElements() {
+		elts = append(elts, tk.Element{Key: compositeKey(e.GetLabelValues()), Count: e.GetCount(), Error: e.GetError()})
+	}
+	if index == nil {
+		s, err := tk.NewStreamFromState(buckets, elts, snap.GetAlphas(), snap.GetTotal())
+		if err != nil {
+			return nil, err
+		}
+		s.SetHash(r.hash.hashFunc())
+		return s, nil
+	}
+
+	// the partition label values are in the order of the snapshot, which
+	// may differ from the order of the label names
+	order := make([]int, len(index))
+	for i, name := range partitionLabels {
+		for j, sn := range snap.GetPartitionLabels() {
+			if name == sn {
+				order[i] = j
+			}
+		}
+	}
+	p := newPartitionedStream(buckets, index, r.hash.hashFunc(), r.errorCounters)
+	partElts := make(map[string][]tk.Element, len(snap.GetPartitions()))
+	for _, e := range elts {
+		pk := p.partitionKey(e.Key)
+		partElts[pk] = append(partElts[pk], e)
+	}
+	lvs := make([]string, len(order))
+	for _, part := range snap.GetPartitions() {
+		for i, j := range order {
+			lvs[i] = part.GetLabelValues()[j]
+		}
+		pk := compositeKey(lvs)
+		s, err := tk.NewStreamFromState(buckets, partElts[pk], part.GetAlphas(), part.GetTotal())
+		if err != nil {
+			return nil, err
+		}
+		p.addPartition(pk, s)
+	}
+	return p, nil
+}
+
+// compositeKey joins label values into a key.
+func compositeKey(lvs []string) string {
+	var buf strings.Builder
+	for _, v := range lvs {
+		writeLabelValue(&buf, v)
+		buf.WriteByte(model.SeparatorByte)
+	}
+	return buf.String()
+}
+
+// splitPartitionKey splits a partition key into label values.
+func splitPartitionKey(pk string) []string {
+	lvs := strings.Split(pk, labelParseSplit)
+	return unescapeLabelValues(lvs[:len(lvs)-1])
+}
+
+func lessStrings(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+func copyLabels(labels prometheus.Labels) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestSnapshotProto(t *testing.T) {
+	opts := TopKOpts{
+		Name:        metricName,
+		Help:        "help",
+		ConstLabels: prometheus.Labels{"c": "v"},
+		Buckets:     2,
+	}
+	k := NewTopK(opts, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(5)
+	k.WithLabelValues("1", "y").Add(3)
+	k.WithLabelValues("2", "x").Add(1)
+
+	snap := k.MustCurryWith(prometheus.Labels{"a": "2"}).SnapshotProto()
+	if snap.GetName() != metricName || snap.GetHelp() != "help" || snap.GetBuckets() != 2 {
+		t.Errorf("wrong descriptor: %v", snap)
+	}
+	if !reflect.DeepEqual(snap.GetConstLabels(), map[string]string{"c": "v"}) {
+		t.Errorf("wrong const labels: %v", snap.GetConstLabels())
+	}
+	if len(snap.GetElements()) != 2 || snap.GetTotal() != 9 {
+		t.Errorf("curried snapshot should contain the whole state: %v", snap)
+	}
+
+	data, err := topkpb.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := topkpb.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewTopK(opts, []string{"a", "b"})
+	restored.WithLabelValues("3", "z").Add(100)
+	if err := restored.RestoreSnapshotProto(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.Snapshot(), k.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+	if count, _, _ := restored.Estimate(prometheus.Labels{"a": "2", "b": "x"}); count != 1 {
+		t.Errorf("wrong estimate for untracked key: %v", count)
+	}
+
+	for _, other := range []TopK{
+		NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a"}),
+		NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a", "b"}),
+	} {
+		if err := other.RestoreSnapshotProto(decoded); err == nil {
+			t.Error("expected error for mismatched TopK")
+		}
+	}
+
+	decoded.Elements[0].LabelValues = []string{"1"}
+	if err := restored.RestoreSnapshotProto(decoded); err == nil {
+		t.Error("expected error for wrong number of label values")
+	}
+	if _, err := topkpb.Unmarshal(data[:len(data)/2]); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestNewTopKFromSnapshot(t *testing.T) {
+	k := NewTopK(TopKOpts{
+		Namespace:   "ns",
+		Name:        metricName,
+		Help:        "help",
+		ConstLabels: prometheus.Labels{"c": "v"},
+		Buckets:     3,
+	}, []string{"a"})
+	k.WithLabelValues("x").Add(5)
+	k.WithLabelValues("y").Add(3)
+
+	restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gather := func(c prometheus.Collector) interface{} {
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(c)
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mfs
+	}
+	if got, want := gather(restored), gather(k); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	if _, err := NewTopKFromSnapshot(&topkpb.Snapshot{Buckets: 1, Alphas: []float64{0}}); err == nil {
+		t.Error("expected error for snapshot without a name")
+	}
+	if _, err := NewTopKFromSnapshot(&topkpb.Snapshot{Name: metricName}); err == nil {
+		t.Error("expected error for snapshot without buckets")
+	}
+}
+
+func TestSnapshotMode(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 3, Mode: ModeMax}, []string{"a"})
+	k.WithLabelValues("x").Set(5)
+	snap := k.SnapshotProto()
+
+	restored, err := NewTopKFromSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.WithLabelValues("x").Set(2)
+	if count, _, _ := restored.Estimate(prometheus.Labels{"a": "x"}); count != 5 {
+		t.Errorf("got %v expected the maximum of 5", count)
+	}
+
+	sum := NewTopK(TopKOpts{Name: metricName, Buckets: 3}, []string{"a"})
+	if err := sum.RestoreSnapshotProto(snap); err == nil {
+		t.Error("expected error restoring a ModeMax snapshot into a ModeSum TopK")
+	}
+	if err := sum.MergeSnapshotProto(snap); err == nil {
+		t.Error("expected error merging a ModeMax snapshot into a ModeSum TopK")
+	}
+}
+
+func TestSnapshotGaugeOptions(t *testing.T) {
+	for name, opts := range map[string]TopKOpts{
+		"half life": {HalfLife: time.Hour},
+		"decrement": {DecrementPolicy: DecrementPolicyTracked},
+	} {
+		opts.Name = metricName
+		opts.Buckets = 3
+		k := NewTopK(opts, []string{"a"})
+		k.WithLabelValues("x").Add(5)
+
+		restored, err := NewTopKFromSnapshot(k.SnapshotProto())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the counts can go down, so they are still exported as gauges
+		mfs, err := testutil.CollectAndFormat(restored, expfmt.TypeTextPlain, metricName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(mfs), "# TYPE test_metric gauge") {
+			t.Errorf("%s: got %s expected a gauge", name, mfs)
+		}
+		k.Close()
+		restored.Close()
+	}
+}
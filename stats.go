@@ -0,0 +1,154 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	tk "github.com/riking/go-prometheus-topk/topkstream"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stats describes the internal behavior of a whole TopK, to help choose the
+// number of buckets. A TopK whose Evictions grow about as fast as its
+// Observations has too few buckets for its keys to stay tracked.
+type Stats struct {
+	// Name is the fully-qualified metric name of the TopK.
+	Name string
+
+	// Observations is the number of recorded observations.
+	Observations uint64
+	// Evictions is the number of times a tracked key was replaced by
+	// another one.
+	Evictions uint64
+	// Dropped is the number of observations that were not recorded
+	// because of the ValuePolicy, or rejected by TryObserve.
+	Dropped uint64
+	// Overflowed is the number of observations that were not recorded
+	// because the queue of AsyncQueueSize was full.
+	Overflowed uint64
+	// Malformed is the number of times a key could not be exported.
+	Malformed uint64
+	// Duplicates is the number of times a key was exported merged into
+	// another key with the same label values.
+	Duplicates uint64
+	// Expired is the number of tracked keys removed because they were not
+	// observed for the KeyTTL.
+	Expired uint64
+	// Idle is the number of tracked keys removed because their rate was
+	// under the IdleRate.
+	Idle uint64
+
+	// TrackedKeys is the current number of tracked keys, of all
+	// partitions if the TopK is partitioned.
+	TrackedKeys int
+	// Buckets is the number of buckets of the TopK, or of every partition.
+	Buckets int
+	// SamplingFactor is the current sampling factor: one in SamplingFactor
+	// observations is recorded.
+	SamplingFactor uint64
+}
+
+// Stats returns the Stats of the whole TopK, even if called on a curried
+// TopK.
+func (r *topkCurry) Stats() Stats {
+	r.root.rlockStream()
+	var tracked int
+	r.root.stream.Range(func(e tk.Element) bool {
+		tracked++
+		return true
+	})
+	buckets := r.root.buckets
+	r.root.streamMtx.RUnlock()
+
+	return Stats{
+		Name:         r.root.fqName,
+		Observations: r.root.observations.Load(),
+		Evictions:    r.root.evictions.Load(),
+		Dropped:      r.root.dropped.Load(),
+		Overflowed:   r.root.overflowed.Load(),
+		Malformed:    r.root.malformed.Load(),
+		Duplicates:   r.root.duplicates.Load(),
+		Expired:      r.root.expired.Load(),
+		Idle:         r.root.idleEvicted.Load(),
+		TrackedKeys:  tracked,
+		Buckets:      buckets,
+
+		SamplingFactor: r.root.samplingFactor.Load(),
+	}
+}
+
+var (
+	statsObservationsDesc = prometheus.NewDesc("topk_observations_total",
+		"Number of observations recorded by the TopK.", []string{"metric"}, nil)
+	statsEvictionsDesc = prometheus.NewDesc("topk_evictions_total",
+		"Number of times a tracked key of the TopK was replaced by another one.", []string{"metric"}, nil)
+	statsDroppedDesc = prometheus.NewDesc("topk_dropped_observations_total",
+		"Number of invalid observations that the TopK did not record.", []string{"metric"}, nil)
+	statsOverflowedDesc = prometheus.NewDesc("topk_overflowed_observations_total",
+		"Number of observations that the TopK did not record because its queue was full.", []string{"metric"}, nil)
+	statsExpiredDesc = prometheus.NewDesc("topk_expired_keys_total",
+		"Number of tracked keys the TopK removed because they were not observed for its TTL.", []string{"metric"}, nil)
+	statsIdleDesc = prometheus.NewDesc("topk_idle_keys_total",
+		"Number of tracked keys the TopK removed because their rate was under its IdleRate.", []string{"metric"}, nil)
+	statsTrackedDesc = prometheus.NewDesc("topk_tracked_keys",
+		"Number of keys currently tracked by the TopK.", []string{"metric"}, nil)
+	statsBucketsDesc = prometheus.NewDesc("topk_buckets",
+		"Number of keys the TopK can track.", []string{"metric"}, nil)
+	statsSamplingDesc = prometheus.NewDesc("topk_sampling_factor",
+		"Current sampling factor of the TopK: one in this many observations is recorded.", []string{"metric"}, nil)
+)
+
+type statsCollector struct {
+	ts []TopK
+}
+
+// NewStatsCollector returns a collector exporting the Stats of the TopKs,
+// labeled by the fully-qualified name of each TopK. The TopKs must have
+// different names. The Malformed and Duplicates counts are left out, since
+// every TopK already exports them.
+func NewStatsCollector(ts ...TopK) prometheus.Collector {
+	return &statsCollector{ts: append([]TopK(nil), ts...)}
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- statsObservationsDesc
+	ch <- statsEvictionsDesc
+	ch <- statsDroppedDesc
+	ch <- statsOverflowedDesc
+	ch <- statsExpiredDesc
+	ch <- statsIdleDesc
+	ch <- statsTrackedDesc
+	ch <- statsBucketsDesc
+	ch <- statsSamplingDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.ts {
+		st := t.Stats()
+		name := st.Name
+		ch <- prometheus.MustNewConstMetric(statsObservationsDesc, prometheus.CounterValue, float64(st.Observations), name)
+		ch <- prometheus.MustNewConstMetric(statsEvictionsDesc, prometheus.CounterValue, float64(st.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(statsDroppedDesc, prometheus.CounterValue, float64(st.Dropped), name)
+		ch <- prometheus.MustNewConstMetric(statsOverflowedDesc, prometheus.CounterValue, float64(st.Overflowed), name)
+		ch <- prometheus.MustNewConstMetric(statsExpiredDesc, prometheus.CounterValue, float64(st.Expired), name)
+		ch <- prometheus.MustNewConstMetric(statsIdleDesc, prometheus.CounterValue, float64(st.Idle), name)
+		ch <- prometheus.MustNewConstMetric(statsTrackedDesc, prometheus.GaugeValue, float64(st.TrackedKeys), name)
+		ch <- prometheus.MustNewConstMetric(statsBucketsDesc, prometheus.GaugeValue, float64(st.Buckets), name)
+		ch <- prometheus.MustNewConstMetric(statsSamplingDesc, prometheus.GaugeValue, float64(st.SamplingFactor), name)
+	}
+}
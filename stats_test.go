@@ -0,0 +1,65 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStats(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, ValuePolicy: ValuePolicyDrop}, []string{"key"})
+	k.WithLabelValues("a").Add(3)
+	k.WithLabelValues("b").Add(1)
+	k.WithLabelValues("c").Add(2)
+	k.WithLabelValues("c").Observe(math.NaN())
+	if err := k.WithLabelValues("c").TryObserve(-1); err == nil {
+		t.Error("expected error for negative value")
+	}
+
+	want := Stats{
+		Name:         metricName,
+		Observations: 3,
+		Evictions:    1,
+		Dropped:      2,
+		TrackedKeys:  2,
+		Buckets:      2,
+
+		SamplingFactor: 1,
+	}
+	if got := k.MustCurryWithLabelValues("a").Stats(); got != want {
+		t.Errorf("got %+v, expected %+v", got, want)
+	}
+
+	if err := testutil.CollectAndCompare(NewStatsCollector(k), strings.NewReader(`
+# HELP topk_evictions_total Number of times a tracked key of the TopK was replaced by another one.
+# TYPE topk_evictions_total counter
+topk_evictions_total{metric="test_metric"} 1
+# HELP topk_tracked_keys Number of keys currently tracked by the TopK.
+# TYPE topk_tracked_keys gauge
+topk_tracked_keys{metric="test_metric"} 2
+`), "topk_evictions_total", "topk_tracked_keys"); err != nil {
+		t.Error(err)
+	}
+	if problems, err := testutil.CollectAndLint(NewStatsCollector(k)); err != nil || len(problems) > 0 {
+		t.Errorf("lint problems %v, error %v", problems, err)
+	}
+}
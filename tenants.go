@@ -0,0 +1,306 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrTenantLimit is returned by Tenants.Get for a new tenant when there are
+// already MaxTenants.
+var ErrTenantLimit = errors.New("topk: too many tenants")
+
+// TenantOpts configures Tenants.
+type TenantOpts struct {
+	// Label is the name of the constant label holding the tenant of every
+	// TopK. It is mandatory.
+	Label string
+	// IdleTimeout, if greater than zero, removes the tenants that were not
+	// returned by Get, and whose TopK got no observations, for this long.
+	// The tenants are checked every tenth of the timeout, but at most every
+	// second, and the observations are noticed by the checks.
+	IdleTimeout time.Duration
+	// MaxTenants, if greater than zero, is the most tenants at once.
+	MaxTenants int
+	// Budget, if not nil, keeps the memory of all the TopKs of the tenants
+	// under a MemoryBudget. The TopKs are removed from it with their
+	// tenant.
+	Budget *MemoryBudget
+}
+
+// Tenants creates a TopK per tenant on first use, with the same options and a
+// constant label holding the tenant, and removes the tenants that are idle.
+// Tenants is a prometheus.Collector collecting the TopKs of all current
+// tenants, so that a removed tenant stops being exported without
+// unregistering anything.
+//
+// The TopK of a removed tenant is closed, and no longer exported; call Get for
+// every observation, or at least regularly, rather than keeping the TopK.
+type Tenants struct {
+	name    string
+	opts    TenantOpts
+	options []Option
+	now     func() time.Time
+	// the PersistPath of the options, suffixed for every tenant
+	persistPath     string
+	persistInterval time.Duration
+	errorLog        Logger
+
+	mtx     sync.RWMutex
+	tenants map[string]*tenant
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type tenant struct {
+	t TopK
+	// the last time the tenant was used, in Unix nanoseconds
+	lastActive atomic.Int64
+	// the observation count at the last check for idle tenants
+	lastObservations uint64
+}
+
+// NewTenants creates the Tenants of TopKs created like NewTopKWithOptions with
+// name and options. The options are checked once, with a constant label for
+// the tenant. With WithPersistence, every tenant checkpoints to its own
+// file, the path followed by a dot and the escaped name of the tenant, and
+// the errors of the final checkpoints of the idle tenants go to the
+// PersistErrorLog. Call Close to stop checking for idle tenants and close
+// every TopK.
+func NewTenants(name string, tenantOpts TenantOpts, opts ...Option) (*Tenants, error) {
+	if tenantOpts.Label == "" {
+		return nil, errors.New("topk: tenants have no label")
+	}
+	if tenantOpts.IdleTimeout < 0 {
+		return nil, fmt.Errorf("topk: IdleTimeout %v is negative", tenantOpts.IdleTimeout)
+	}
+	o := options{TopKOpts: TopKOpts{Name: name, Buckets: DefaultBuckets}}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+	if _, dup := o.ConstLabels[tenantOpts.Label]; dup {
+		return nil, fmt.Errorf("topk: duplicate label name %q", tenantOpts.Label)
+	}
+	if err := WithConstLabels(prometheus.Labels{tenantOpts.Label: ""})(&o); err != nil {
+		return nil, err
+	}
+	if err := o.validate(o.labelNames); err != nil {
+		return nil, err
+	}
+	ts := &Tenants{
+		name:    name,
+		opts:    tenantOpts,
+		options: append([]Option(nil), opts...),
+		now:     o.Now,
+		tenants: make(map[string]*tenant),
+
+		persistPath:     o.PersistPath,
+		persistInterval: o.PersistInterval,
+		errorLog:        o.PersistErrorLog,
+	}
+	if ts.now == nil {
+		ts.now = time.Now
+	}
+	if tenantOpts.IdleTimeout > 0 {
+		ts.stop = make(chan struct{})
+		ts.done = make(chan struct{})
+		go ts.run(min(max(tenantOpts.IdleTimeout/10, time.Second), tenantOpts.IdleTimeout))
+	}
+	return ts, nil
+}
+
+func (ts *Tenants) run(interval time.Duration) {
+	defer close(ts.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := ts.RemoveIdle(); err != nil && ts.errorLog != nil {
+				ts.errorLog.Println("topk: removing idle tenants:", err)
+			}
+		case <-ts.stop:
+			return
+		}
+	}
+}
+
+// Get returns the TopK of a tenant, creating it if it does not exist yet.
+func (ts *Tenants) Get(name string) (TopK, error) {
+	now := ts.now().UnixNano()
+	ts.mtx.RLock()
+	tn := ts.tenants[name]
+	ts.mtx.RUnlock()
+	if tn != nil {
+		tn.lastActive.Store(now)
+		return tn.t, nil
+	}
+
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	if tn := ts.tenants[name]; tn != nil {
+		tn.lastActive.Store(now)
+		return tn.t, nil
+	}
+	if ts.opts.MaxTenants > 0 && len(ts.tenants) >= ts.opts.MaxTenants {
+		return nil, ErrTenantLimit
+	}
+	opts := append(append([]Option(nil), ts.options...), WithConstLabels(prometheus.Labels{ts.opts.Label: name}))
+	if ts.persistPath != "" {
+		opts = append(opts, WithPersistence(ts.persistPath+"."+url.PathEscape(name), ts.persistInterval))
+	}
+	t, err := NewTopKWithOptions(ts.name, opts...)
+	if err != nil {
+		return nil, err
+	}
+	tn = &tenant{t: t}
+	tn.lastActive.Store(now)
+	ts.tenants[name] = tn
+	if ts.opts.Budget != nil {
+		ts.opts.Budget.Add(t)
+	}
+	return t, nil
+}
+
+// MustGet works as Get but panics where Get would have returned an error.
+func (ts *Tenants) MustGet(name string) TopK {
+	t, err := ts.Get(name)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Names returns the current tenants, sorted.
+func (ts *Tenants) Names() []string {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+	names := make([]string, 0, len(ts.tenants))
+	for name := range ts.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remove removes a tenant, closing its TopK. It returns false if there is no
+// such tenant, and the error of closing the TopK.
+func (ts *Tenants) Remove(name string) (bool, error) {
+	ts.mtx.Lock()
+	tn := ts.tenants[name]
+	delete(ts.tenants, name)
+	ts.mtx.Unlock()
+	if tn == nil {
+		return false, nil
+	}
+	return true, ts.closeTenant(tn)
+}
+
+// RemoveIdle removes the tenants that were not used and got no observations
+// for IdleTimeout, returning their number and the errors of closing their
+// TopKs. It is called regularly, but can be called directly.
+func (ts *Tenants) RemoveIdle() (int, error) {
+	if ts.opts.IdleTimeout <= 0 {
+		return 0, nil
+	}
+	now := ts.now()
+	var idle []*tenant
+	ts.mtx.Lock()
+	for name, tn := range ts.tenants {
+		if obs := tn.t.Stats().Observations; obs != tn.lastObservations {
+			tn.lastObservations = obs
+			tn.lastActive.Store(now.UnixNano())
+			continue
+		}
+		if now.Sub(time.Unix(0, tn.lastActive.Load())) >= ts.opts.IdleTimeout {
+			delete(ts.tenants, name)
+			idle = append(idle, tn)
+		}
+	}
+	ts.mtx.Unlock()
+	var errs []error
+	for _, tn := range idle {
+		if err := ts.closeTenant(tn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(idle), errors.Join(errs...)
+}
+
+func (ts *Tenants) closeTenant(tn *tenant) error {
+	if ts.opts.Budget != nil {
+		ts.opts.Budget.Remove(tn.t)
+	}
+	return tn.t.Close()
+}
+
+func (ts *Tenants) topKs() []TopK {
+	ts.mtx.RLock()
+	defer ts.mtx.RUnlock()
+	t := make([]TopK, 0, len(ts.tenants))
+	for _, tn := range ts.tenants {
+		t = append(t, tn.t)
+	}
+	return t
+}
+
+// Describe implements prometheus.Collector. Like a Group, Tenants describe no
+// metrics, making them an unchecked Collector, since tenants come and go
+// after registration.
+func (ts *Tenants) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (ts *Tenants) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range ts.topKs() {
+		t.Collect(ch)
+	}
+}
+
+// Close stops checking for idle tenants and removes every tenant, returning
+// the errors of closing their TopKs.
+func (ts *Tenants) Close() error {
+	ts.closeOnce.Do(func() {
+		if ts.stop != nil {
+			close(ts.stop)
+			<-ts.done
+		}
+	})
+	ts.mtx.Lock()
+	tenants := ts.tenants
+	ts.tenants = make(map[string]*tenant)
+	ts.mtx.Unlock()
+	var errs []error
+	for _, tn := range tenants {
+		if err := ts.closeTenant(tn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTenants(t *testing.T) {
+	now := time.Unix(1000, 0)
+	ts, err := NewTenants("requests", TenantOpts{Label: "tenant", IdleTimeout: time.Hour, MaxTenants: 2},
+		WithLabelNames("path"), WithBuckets(2), WithClock(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(ts)
+
+	ts.MustGet("a").WithLabelValues("/x").Add(2)
+	ts.MustGet("b").WithLabelValues("/y").Inc()
+	if got, err := ts.Get("a"); err != nil || got != ts.MustGet("a") {
+		t.Errorf("Get returned a different TopK: %v", err)
+	}
+	if _, err := ts.Get("c"); !errors.Is(err, ErrTenantLimit) {
+		t.Errorf("got %v, expected ErrTenantLimit", err)
+	}
+
+	expected := `
+# HELP requests 
+# TYPE requests counter
+requests{path="/x",tenant="a"} 2
+requests{path="/y",tenant="b"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+
+	// b is idle for an hour while a keeps getting observations, noticed
+	// by the checks
+	if n, _ := ts.RemoveIdle(); n != 0 {
+		t.Errorf("removed %d active tenants", n)
+	}
+	now = now.Add(time.Hour / 2)
+	ts.MustGet("a").WithLabelValues("/x").Inc()
+	if n, _ := ts.RemoveIdle(); n != 0 {
+		t.Errorf("removed %d tenants before the timeout", n)
+	}
+	now = now.Add(time.Hour / 2)
+	if n, _ := ts.RemoveIdle(); n != 1 {
+		t.Errorf("removed %d tenants, expected 1", n)
+	}
+	if names := ts.Names(); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("got tenants %v, expected [a]", names)
+	}
+	// there is room for a new tenant again
+	ts.MustGet("c").WithLabelValues("/z").Inc()
+
+	expected = `
+# HELP requests 
+# TYPE requests counter
+requests{path="/x",tenant="a"} 3
+requests{path="/z",tenant="c"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+
+	if ok, err := ts.Remove("c"); !ok || err != nil {
+		t.Errorf("Remove did not remove the tenant: %v", err)
+	}
+	if ok, _ := ts.Remove("c"); ok {
+		t.Error("Remove removed the tenant twice")
+	}
+
+	for name, opts := range map[string]TenantOpts{
+		"no label":         {},
+		"negative timeout": {Label: "tenant", IdleTimeout: -1},
+		"label clash":      {Label: "path"},
+	} {
+		if _, err := NewTenants("requests", opts, WithLabelNames("path")); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if _, err := NewTenants("requests", TenantOpts{Label: "tenant"}, WithConstLabels(prometheus.Labels{"tenant": "x"})); err == nil {
+		t.Error("expected error for a const label clash")
+	}
+}
+
+func TestTenantsBudget(t *testing.T) {
+	b, err := NewMemoryBudget(BudgetOpts{Bytes: 1 << 30, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ts, err := NewTenants("requests", TenantOpts{Label: "tenant", Budget: b}, WithLabelNames("path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.MustGet("a")
+	if b.Usage() == 0 {
+		t.Error("the TopK of the tenant is not under the budget")
+	}
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if usage := b.Usage(); usage != 0 {
+		t.Errorf("got usage %d after Close, expected 0", usage)
+	}
+}
+
+func TestTenantsPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests")
+	newTenants := func() *Tenants {
+		ts, err := NewTenants("requests", TenantOpts{Label: "tenant"},
+			WithLabelNames("path"), WithBuckets(2), WithPersistence(path, time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	ts := newTenants()
+	ts.MustGet("a").WithLabelValues("/x").Add(3)
+	ts.MustGet("b/c").WithLabelValues("/y").Add(5)
+	ts.Close()
+
+	// every tenant restores its own checkpoint
+	ts = newTenants()
+	defer ts.Close()
+	for tenant, want := range map[string]string{"a": "/x", "b/c": "/y"} {
+		if got := topKeyPaths(ts.MustGet(tenant)); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("tenant %s: got keys %v expected [%s]", tenant, got, want)
+		}
+	}
+}
+
+func TestTenantsRemoveError(t *testing.T) {
+	// the final checkpoint cannot be saved in a missing directory
+	path := filepath.Join(t.TempDir(), "missing", "requests")
+	ts, err := NewTenants("requests", TenantOpts{Label: "tenant"},
+		WithLabelNames("path"), WithBuckets(2), WithPersistence(path, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.MustGet("a").WithLabelValues("/x").Inc()
+	if ok, err := ts.Remove("a"); !ok || err == nil {
+		t.Errorf("got %v, %v expected the error of the checkpoint", ok, err)
+	}
+}
+
+func topKeyPaths(k TopK) []string {
+	var paths []string
+	for _, e := range k.Snapshot() {
+		paths = append(paths, e.Labels["path"])
+	}
+	return paths
+}
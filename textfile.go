@@ -0,0 +1,34 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// WriteToTextfile writes the metrics of ts in the text exposition format to
+// filename, for the textfile collector of the node exporter. Like
+// prometheus.WriteToTextfile, it writes to a temporary file first and renames
+// it, so the collector never reads a partial file.
+func WriteToTextfile(filename string, ts ...TopK) error {
+	reg := prometheus.NewRegistry()
+	for _, t := range ts {
+		if err := reg.Register(t); err != nil {
+			return err
+		}
+	}
+	return prometheus.WriteToTextfile(filename, reg)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteToTextfile(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Help: "help", Buckets: 2}, []string{"a"})
+	k.WithLabelValues("x").Add(5)
+
+	path := filepath.Join(t.TempDir(), "topk.prom")
+	if err := WriteToTextfile(path, k); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `test_metric{a="x"} 5`) {
+		t.Errorf("missing sample in:\n%s", data)
+	}
+
+	if err := WriteToTextfile(path, k, k); err == nil {
+		t.Error("expected error for duplicate TopKs")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import "time"
+
+// Timer is a helper type to time functions, like prometheus.Timer. Use
+// NewTimer to create new instances.
+type Timer struct {
+	begin  time.Time
+	bucket TopKBucket
+	now    func() time.Time
+}
+
+// NewTimer creates a new Timer. The provided TopKBucket is used to observe
+// the duration in seconds. Timer is usually used to time a function call in
+// the following way:
+//
+//	func TimeMe(user string) {
+//		timer := topk.NewTimer(requestSeconds.WithLabelValues(user))
+//		defer timer.ObserveDuration()
+//		// Do actual work.
+//	}
+//
+// If the TopKBucket belongs to a TopK with a Now clock, the Timer uses it.
+func NewTimer(b TopKBucket) *Timer {
+	now := time.Now
+	if tb, ok := b.(*topkWithLabelValues); ok {
+		now = tb.root.now
+	}
+	return &Timer{
+		begin:  now(),
+		bucket: b,
+		now:    now,
+	}
+}
+
+// ObserveDuration records the duration passed since the Timer was created
+// with NewTimer, in seconds, and returns it.
+func (t *Timer) ObserveDuration() time.Duration {
+	d := t.now().Sub(t.begin)
+	if t.bucket != nil {
+		t.bucket.Observe(d.Seconds())
+	}
+	return d
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestTimer(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a"})
+
+	timer := NewTimer(k.WithLabelValues("x"))
+	time.Sleep(time.Millisecond)
+	d := timer.ObserveDuration()
+
+	count, _, _ := k.Estimate(prometheus.Labels{"a": "x"})
+	if count != d.Seconds() || count < 0.001 {
+		t.Errorf("observed %v, timer returned %v", count, d)
+	}
+
+	// a nil bucket only measures
+	if NewTimer(nil).ObserveDuration() < 0 {
+		t.Error("negative duration")
+	}
+}
+
+func TestTimerClock(t *testing.T) {
+	now := time.Unix(1000, 0)
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2, Now: func() time.Time { return now }}, []string{"a"})
+
+	timer := NewTimer(k.WithLabelValues("x"))
+	now = now.Add(3 * time.Second)
+	if d := timer.ObserveDuration(); d != 3*time.Second {
+		t.Errorf("timer returned %v expected 3s", d)
+	}
+	if ts := k.SnapshotProto().GetTimestampMs(); ts != now.UnixMilli() {
+		t.Errorf("snapshot timestamp %d expected %d", ts, now.UnixMilli())
+	}
+}
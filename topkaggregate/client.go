@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkaggregate
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	defaultInterval = 15 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// PusherOpts configures a Pusher.
+type PusherOpts struct {
+	// Replica identifies this process to the aggregator; the default is the
+	// host name. A push replaces the earlier pushes with the same replica.
+	Replica string
+
+	// Interval is the time between two pushes; the default is 15 seconds.
+	Interval time.Duration
+
+	// Timeout bounds every push; the default is 10 seconds.
+	Timeout time.Duration
+
+	// ErrorLog, if not nil, receives the errors of the background pushes.
+	// Otherwise they are dropped.
+	ErrorLog topk.Logger
+}
+
+// Pusher periodically sends the snapshots of some TopKs to an aggregator.
+type Pusher struct {
+	client   topkpb.AggregatorClient
+	ts       []topk.TopK
+	replica  string
+	timeout  time.Duration
+	errorLog topk.Logger
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewPusher starts pushing the snapshots of ts over conn in the background.
+// The TopKs must have distinct names.
+func NewPusher(conn grpc.ClientConnInterface, opts PusherOpts, ts ...topk.TopK) *Pusher {
+	p := &Pusher{
+		client:   topkpb.NewAggregatorClient(conn),
+		ts:       append([]topk.TopK(nil), ts...),
+		replica:  opts.Replica,
+		timeout:  opts.Timeout,
+		errorLog: opts.ErrorLog,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if p.replica == "" {
+		p.replica, _ = os.Hostname()
+	}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *Pusher) run(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Push(context.Background()); err != nil && p.errorLog != nil {
+				p.errorLog.Println("topkaggregate: push:", err)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Push sends the current snapshots immediately.
+func (p *Pusher) Push(ctx context.Context) error {
+	req := &topkpb.PushRequest{
+		Replica:   p.replica,
+		Snapshots: make([]*topkpb.Snapshot, 0, len(p.ts)),
+	}
+	for _, t := range p.ts {
+		req.Snapshots = append(req.Snapshots, t.SnapshotProto())
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	_, err := p.client.Push(ctx, req)
+	return err
+}
+
+// Close stops the background pushes and sends the final snapshots. It does
+// not close the connection.
+func (p *Pusher) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.closeErr = p.Push(context.Background())
+	})
+	return p.closeErr
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkaggregate
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestPusher(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	agg := NewServer(ServerOpts{})
+	topkpb.RegisterAggregatorServer(srv, agg)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, replica := range []string{"a", "b"} {
+		k := topk.NewTopK(topk.TopKOpts{Name: "requests", Help: "Requests by user.", Buckets: 5}, []string{"user"})
+		k.WithLabelValues("alice").Add(2)
+		p := NewPusher(conn, PusherOpts{Replica: replica, Interval: time.Hour}, k)
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(agg)
+	expected := `
+# HELP requests Requests by user.
+# TYPE requests counter
+requests{user="alice"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+}
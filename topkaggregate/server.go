@@ -0,0 +1,176 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkaggregate provides a gRPC service that merges the TopKs of many
+// replicas into a fleet-wide top-K.
+//
+// Each replica runs a Pusher, which periodically sends the snapshots of its
+// TopKs to the aggregator. The aggregator runs a Server, which is both the
+// gRPC service and a Collector exporting the merged TopKs. Unlike the PromQL
+// topk function applied to per-replica top-K metrics, the merge accounts for
+// the keys that each replica is no longer tracking.
+//
+// The snapshots of a TopK using HashMaphash cannot be merged across
+// replicas, since its seed differs in every process.
+package topkaggregate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServerOpts configures a Server.
+type ServerOpts struct {
+	// ReplicaTTL, if positive, is the time after which the snapshots of a
+	// replica that stopped pushing are dropped. By default they are kept
+	// forever, so that the counts of replicas that exited are not lost.
+	ReplicaTTL time.Duration
+}
+
+// Server implements the Aggregator service, and collects the merge of the
+// snapshots pushed by all replicas.
+//
+// The metric names are only known once the snapshots are pushed, so Server
+// is an unchecked collector: it describes no metrics. Options of the replica
+// TopKs that are not part of the snapshots, like ReportingThreshold, are not
+// applied.
+type Server struct {
+	topkpb.UnimplementedAggregatorServer
+
+	ttl time.Duration
+	now func() time.Time
+
+	mtx      sync.Mutex
+	replicas map[string]replica
+}
+
+type replica struct {
+	snapshots []*topkpb.Snapshot
+	pushed    time.Time
+}
+
+// NewServer returns a Server with no replicas. Register it with
+// topkpb.RegisterAggregatorServer and with a Prometheus registry.
+func NewServer(opts ServerOpts) *Server {
+	return &Server{
+		ttl:      opts.ReplicaTTL,
+		now:      time.Now,
+		replicas: make(map[string]replica),
+	}
+}
+
+// Push implements topkpb.AggregatorServer.
+func (s *Server) Push(ctx context.Context, req *topkpb.PushRequest) (*topkpb.PushResponse, error) {
+	if req.GetReplica() == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing replica")
+	}
+	names := make(map[string]bool, len(req.GetSnapshots()))
+	for _, snap := range req.GetSnapshots() {
+		if err := snap.Validate(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if names[snap.GetName()] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate snapshot name %q", snap.GetName())
+		}
+		names[snap.GetName()] = true
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.replicas[req.GetReplica()] = replica{snapshots: req.GetSnapshots(), pushed: s.now()}
+	return &topkpb.PushResponse{}, nil
+}
+
+// Describe implements prometheus.Collector.
+func (s *Server) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector. Snapshots that cannot be merged,
+// for example because replicas disagree on the label names, are reported as
+// invalid metrics.
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	merged, errs := s.merge()
+	// the merged TopKs are only built for this collection, and may have
+	// started maintenance, such as for a HalfLife
+	defer func() {
+		for _, t := range merged {
+			t.Close()
+		}
+	}()
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		merged[name].Collect(ch)
+	}
+	for _, err := range errs {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("topkaggregate_error", "Error merging a TopK snapshot.", nil, nil), err)
+	}
+}
+
+// merge drops the expired replicas, and merges the snapshots with the same
+// metric name. Replicas are merged in order of name, so that the result does
+// not depend on the order of the pushes.
+func (s *Server) merge() (map[string]topk.TopK, []error) {
+	s.mtx.Lock()
+	ids := make([]string, 0, len(s.replicas))
+	for id, r := range s.replicas {
+		if s.ttl > 0 && s.now().Sub(r.pushed) > s.ttl {
+			delete(s.replicas, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	snapshots := make([][]*topkpb.Snapshot, len(ids))
+	for i, id := range ids {
+		snapshots[i] = s.replicas[id].snapshots
+	}
+	s.mtx.Unlock()
+
+	merged := make(map[string]topk.TopK)
+	var errs []error
+	for i, snaps := range snapshots {
+		for _, snap := range snaps {
+			t, ok := merged[snap.GetName()]
+			var err error
+			if !ok {
+				t, err = topk.NewTopKFromSnapshot(snap)
+				if err == nil {
+					merged[snap.GetName()] = t
+				}
+			} else {
+				err = t.MergeSnapshotProto(snap)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("topkaggregate: replica %q: %w", ids[i], err))
+			}
+		}
+	}
+	return merged, errs
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkaggregate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func snapshot(name string, counts map[string]float64) *topkpb.Snapshot {
+	k := topk.NewTopK(topk.TopKOpts{Name: name, Help: "Requests by user.", Buckets: 5}, []string{"user"})
+	for user, c := range counts {
+		k.WithLabelValues(user).Add(c)
+	}
+	return k.SnapshotProto()
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer(ServerOpts{ReplicaTTL: time.Minute})
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	push := func(replica string, snaps ...*topkpb.Snapshot) error {
+		_, err := s.Push(context.Background(), &topkpb.PushRequest{Replica: replica, Snapshots: snaps})
+		return err
+	}
+	if err := push("a", snapshot("requests", map[string]float64{"alice": 1, "bob": 1})); err != nil {
+		t.Fatal(err)
+	}
+	// a later push from the same replica replaces the earlier one
+	if err := push("a", snapshot("requests", map[string]float64{"alice": 2, "bob": 1})); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Minute / 2)
+	if err := push("b", snapshot("requests", map[string]float64{"alice": 1, "carol": 4})); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(s)
+	expected := `
+# HELP requests Requests by user.
+# TYPE requests counter
+requests{user="alice"} 3
+requests{user="bob"} 1
+requests{user="carol"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+
+	// replica a expires
+	now = now.Add(time.Minute)
+	expected = `
+# HELP requests Requests by user.
+# TYPE requests counter
+requests{user="alice"} 1
+requests{user="carol"} 4
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+
+	for _, err := range []error{
+		push(""),
+		push("c", &topkpb.Snapshot{Name: "requests"}),
+		push("c", snapshot("requests", nil), snapshot("requests", nil)),
+	} {
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkauto provides constructors that automatically register the
+// TopKs with a prometheus.Registerer, like the promauto package of the
+// Prometheus client.
+//
+// The constructors at the top level register with prometheus.DefaultRegisterer;
+// use With to register with another one. Like in promauto, registration
+// errors cause a panic.
+package topkauto
+
+import (
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewTopK works like the function of the same name in the topk package, but
+// it automatically registers the TopK with prometheus.DefaultRegisterer.
+func NewTopK(opts topk.TopKOpts, labelNames []string) topk.TopK {
+	return With(prometheus.DefaultRegisterer).NewTopK(opts, labelNames)
+}
+
+// NewCounterVec works like the function of the same name in the topk
+// package, but it automatically registers the CounterVec with
+// prometheus.DefaultRegisterer.
+func NewCounterVec(opts topk.TopKOpts, labelNames []string) *topk.CounterVec {
+	return With(prometheus.DefaultRegisterer).NewCounterVec(opts, labelNames)
+}
+
+// Factory provides factory methods to create TopKs that are automatically
+// registered with a Registerer. Create a Factory with the With function.
+type Factory struct {
+	r prometheus.Registerer
+}
+
+// With creates a Factory using the provided Registerer for registration of
+// the created TopKs. If the provided Registerer is nil, the returned Factory
+// creates TopKs that are not registered with any Registerer.
+func With(r prometheus.Registerer) Factory {
+	return Factory{r: r}
+}
+
+// NewTopK works like the function of the same name in the topk package but
+// it automatically registers the TopK with the Factory's Registerer.
+func (f Factory) NewTopK(opts topk.TopKOpts, labelNames []string) topk.TopK {
+	t := topk.NewTopK(opts, labelNames)
+	if f.r != nil {
+		f.r.MustRegister(t)
+	}
+	return t
+}
+
+// NewCounterVec works like the function of the same name in the topk package
+// but it automatically registers the CounterVec with the Factory's
+// Registerer.
+func (f Factory) NewCounterVec(opts topk.TopKOpts, labelNames []string) *topk.CounterVec {
+	v := topk.NewCounterVec(opts, labelNames)
+	if f.r != nil {
+		f.r.MustRegister(v)
+	}
+	return v
+}
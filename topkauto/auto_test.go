@@ -0,0 +1,55 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkauto
+
+import (
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFactory(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	f := With(reg)
+
+	k := f.NewTopK(topk.TopKOpts{Name: "requests", Help: "help", Buckets: 5}, []string{"user"})
+	k.WithLabelValues("alice").Inc()
+	v := f.NewCounterVec(topk.TopKOpts{Name: "bytes", Help: "help", Buckets: 5}, []string{"user"})
+	v.WithLabelValues("alice").Add(10)
+
+	if n, err := testutil.GatherAndCount(reg, "requests", "bytes"); err != nil || n != 2 {
+		t.Errorf("got %d metrics, %v", n, err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for duplicate registration")
+		}
+	}()
+	f.NewTopK(topk.TopKOpts{Name: "requests", Help: "help", Buckets: 5}, []string{"user"})
+}
+
+func TestNilRegisterer(t *testing.T) {
+	k := With(nil).NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5}, []string{"user"})
+	if err := prometheus.NewRegistry().Register(k); err != nil {
+		t.Errorf("TopK was registered: %v", err)
+	}
+}
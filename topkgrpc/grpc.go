@@ -0,0 +1,217 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkgrpc provides gRPC interceptors and a stats.Handler that count
+// RPCs into TopKs, for services whose callers are too many to label with a
+// regular CounterVec.
+//
+// The labels of the TopK are filled from the RPC, as listed in the Label
+// constants and the options. Each RPC is counted once it completes, except
+// for client streams, which are counted when the stream is created.
+package topkgrpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// The label names that are filled in without an Option.
+const (
+	// LabelService and LabelMethod are the two components of the full
+	// method name.
+	LabelService = "grpc_service"
+	LabelMethod  = "grpc_method"
+	// LabelCode is the name of the status code, like "NotFound".
+	LabelCode = "grpc_code"
+	// LabelPeer is the address of the peer, without the port.
+	LabelPeer = "peer"
+)
+
+// labelFunc computes a label value from an RPC.
+type labelFunc func(ctx context.Context, fullMethod string, err error) string
+
+var defaultLabels = map[string]labelFunc{
+	LabelService: func(_ context.Context, fullMethod string, _ error) string {
+		service, _ := splitMethod(fullMethod)
+		return service
+	},
+	LabelMethod: func(_ context.Context, fullMethod string, _ error) string {
+		_, method := splitMethod(fullMethod)
+		return method
+	},
+	LabelCode: func(_ context.Context, _ string, err error) string {
+		return status.Code(err).String()
+	},
+	LabelPeer: func(ctx context.Context, _ string, _ error) string {
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return ""
+		}
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	},
+}
+
+func splitMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// Option configures the interceptors and the stats.Handler.
+type Option func(*options)
+
+type options struct {
+	labels map[string]labelFunc
+}
+
+// WithLabelFromContext fills the label with the given name with the result of
+// f, for example the identity of the caller taken from its credentials or
+// metadata. It can replace a default label.
+func WithLabelFromContext(name string, f func(context.Context) string) Option {
+	return func(o *options) {
+		o.labels[name] = func(ctx context.Context, _ string, _ error) string { return f(ctx) }
+	}
+}
+
+// recorder counts RPCs into a TopK.
+type recorder struct {
+	t  topk.TopK
+	fs []labelFunc
+}
+
+// newRecorder panics if a label of t is not known.
+func newRecorder(t topk.TopK, opts []Option) *recorder {
+	o := options{labels: make(map[string]labelFunc, len(defaultLabels))}
+	for name, f := range defaultLabels {
+		o.labels[name] = f
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	names := t.FreeLabelNames()
+	r := &recorder{t: t, fs: make([]labelFunc, len(names))}
+	for i, name := range names {
+		f, ok := o.labels[name]
+		if !ok {
+			panic(fmt.Errorf("topkgrpc: label %q is not supported", name))
+		}
+		r.fs[i] = f
+	}
+	return r
+}
+
+func (r *recorder) record(ctx context.Context, fullMethod string, err error) {
+	lvs := make([]string, len(r.fs))
+	for i, f := range r.fs {
+		lvs[i] = f(ctx, fullMethod, err)
+	}
+	r.t.WithLabelValues(lvs...).Inc()
+}
+
+// UnaryServerInterceptor counts the unary RPCs handled by a server.
+func UnaryServerInterceptor(t topk.TopK, opts ...Option) grpc.UnaryServerInterceptor {
+	r := newRecorder(t, opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		r.record(ctx, info.FullMethod, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor counts the streaming RPCs handled by a server.
+func StreamServerInterceptor(t topk.TopK, opts ...Option) grpc.StreamServerInterceptor {
+	r := newRecorder(t, opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		r.record(ss.Context(), info.FullMethod, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor counts the unary RPCs made by a client. The peer is
+// not known to client interceptors, so the peer label is empty.
+func UnaryClientInterceptor(t topk.TopK, opts ...Option) grpc.UnaryClientInterceptor {
+	r := newRecorder(t, opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, callOpts...)
+		r.record(ctx, method, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor counts the streams created by a client, with the
+// code of the creation.
+func StreamClientInterceptor(t topk.TopK, opts ...Option) grpc.StreamClientInterceptor {
+	r := newRecorder(t, opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, callOpts...)
+		r.record(ctx, method, err)
+		return cs, err
+	}
+}
+
+type methodKey struct{}
+
+type statsHandler struct {
+	r *recorder
+}
+
+// NewStatsHandler returns a stats.Handler counting all the RPCs of a server
+// or a client once they end, including client streams. Install it with
+// grpc.StatsHandler or grpc.WithStatsHandler.
+func NewStatsHandler(t topk.TopK, opts ...Option) stats.Handler {
+	return &statsHandler{r: newRecorder(t, opts)}
+}
+
+// TagRPC implements stats.Handler.
+func (h *statsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, methodKey{}, info.FullMethodName)
+}
+
+// HandleRPC implements stats.Handler.
+func (h *statsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	end, ok := s.(*stats.End)
+	if !ok {
+		return
+	}
+	method, _ := ctx.Value(methodKey{}).(string)
+	h.r.record(ctx, method, end.Error)
+}
+
+// TagConn implements stats.Handler.
+func (h *statsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn implements stats.Handler.
+func (h *statsHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,114 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkgrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func caller(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("caller"); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func TestInterceptors(t *testing.T) {
+	labels := []string{LabelService, LabelMethod, LabelCode, "caller"}
+	opts := func() topk.TopKOpts { return topk.TopKOpts{Name: "rpcs", Buckets: 10} }
+	serverRPCs := topk.NewTopK(opts(), labels)
+	statsRPCs := topk.NewTopK(opts(), []string{LabelMethod, LabelCode, LabelPeer})
+	clientRPCs := topk.NewTopK(opts(), []string{LabelMethod, LabelCode})
+
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(serverRPCs, WithLabelFromContext("caller", caller))),
+		grpc.StatsHandler(NewStatsHandler(statsRPCs)))
+	hs := health.NewServer()
+	hs.SetServingStatus("up", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(clientRPCs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "caller", "alice")
+	for _, service := range []string{"up", "up", "missing"} {
+		client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	}
+
+	check := func(name string, k topk.TopK, labels prometheus.Labels, want float64) {
+		t.Helper()
+		if count, _, _ := k.Estimate(labels); count != want {
+			t.Errorf("%s %v: got %v expected %v", name, labels, count, want)
+		}
+	}
+	const service = "grpc.health.v1.Health"
+	check("server", serverRPCs, prometheus.Labels{LabelService: service, LabelMethod: "Check", LabelCode: "OK", "caller": "alice"}, 2)
+	check("server", serverRPCs, prometheus.Labels{LabelService: service, LabelMethod: "Check", LabelCode: "NotFound", "caller": "alice"}, 1)
+	check("client", clientRPCs, prometheus.Labels{LabelMethod: "Check", LabelCode: "OK"}, 2)
+
+	// the stats handler may see the end of the RPC after the client
+	deadline := time.Now().Add(5 * time.Second)
+	for len(statsRPCs.Snapshot()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	check("stats", statsRPCs, prometheus.Labels{LabelMethod: "Check", LabelCode: "NotFound", LabelPeer: "bufconn"}, 1)
+}
+
+func TestUnsupportedLabel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unsupported label")
+		}
+	}()
+	UnaryServerInterceptor(topk.NewTopK(topk.TopKOpts{Name: "rpcs", Buckets: 10}, []string{"caller"}))
+}
+
+func TestCurriedTopK(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "rpcs", Buckets: 10}, []string{"server", LabelMethod})
+	r := newRecorder(k.MustCurryWith(prometheus.Labels{"server": "a"}), nil)
+	r.record(context.Background(), "/grpc.health.v1.Health/Check", nil)
+	if count, _, _ := k.Estimate(prometheus.Labels{"server": "a", LabelMethod: "Check"}); count != 1 {
+		t.Errorf("got %v expected 1", count)
+	}
+}
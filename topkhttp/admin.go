@@ -0,0 +1,82 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AdminHandler returns a handler for operating on t without a restart. It
+// serves the following POST endpoints, relative to the root of the handler
+// (use http.StripPrefix to mount it under a prefix):
+//
+//	/reset                 discard all counts
+//	/delete?<label>=<v>... stop tracking the keys matching all the labels
+//	/threshold?value=<v>   change the reporting threshold
+//	/checkpoint            save a checkpoint, if persistence is enabled
+//
+// The handler performs no authentication: only expose it on an internal
+// listener, or wrap it in an authenticating handler.
+func AdminHandler(t topk.TopK) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /reset", func(w http.ResponseWriter, r *http.Request) {
+		t.Reset()
+		fmt.Fprintln(w, "reset")
+	})
+	mux.HandleFunc("POST /delete", func(w http.ResponseWriter, r *http.Request) {
+		labels := prometheus.Labels{}
+		for name, values := range r.URL.Query() {
+			if len(values) != 1 {
+				http.Error(w, fmt.Sprintf("expected one value for label %q", name), http.StatusBadRequest)
+				return
+			}
+			labels[name] = values[0]
+		}
+		if len(labels) == 0 {
+			http.Error(w, "no labels to match", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "deleted %d keys\n", t.DeletePartialMatch(labels))
+	})
+	mux.HandleFunc("POST /threshold", func(w http.ResponseWriter, r *http.Request) {
+		v, err := strconv.ParseFloat(r.URL.Query().Get("value"), 64)
+		if err != nil {
+			http.Error(w, "invalid threshold: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		t.SetReportingThreshold(v)
+		fmt.Fprintf(w, "threshold set to %v\n", v)
+	})
+	mux.HandleFunc("POST /checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		if err := t.Checkpoint(); err == topk.ErrNotPersisted {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintln(w, "checkpoint saved")
+	})
+	return mux
+}
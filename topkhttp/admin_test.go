@@ -0,0 +1,76 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAdminHandler(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5}, []string{"user", "path"})
+	k.WithLabelValues("scanner", "/a").Add(100)
+	k.WithLabelValues("scanner", "/b").Add(100)
+	k.WithLabelValues("alice", "/a").Add(3)
+	h := AdminHandler(k)
+
+	do := func(method, target string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec.Code
+	}
+
+	if code := do("GET", "/reset"); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /reset: got %d", code)
+	}
+	if code := do("POST", "/delete?user=scanner"); code != http.StatusOK {
+		t.Errorf("POST /delete: got %d", code)
+	}
+	if snap := k.Snapshot(); len(snap) != 1 || snap[0].Labels["user"] != "alice" {
+		t.Errorf("wrong keys after delete: %v", snap)
+	}
+	if code := do("POST", "/delete"); code != http.StatusBadRequest {
+		t.Errorf("POST /delete without labels: got %d", code)
+	}
+
+	if code := do("POST", "/threshold?value=5"); code != http.StatusOK {
+		t.Errorf("POST /threshold: got %d", code)
+	}
+	if n := testutil.CollectAndCount(k, "requests"); n != 0 {
+		t.Errorf("threshold was not applied: %d metrics", n)
+	}
+	if code := do("POST", "/threshold?value=x"); code != http.StatusBadRequest {
+		t.Errorf("POST /threshold with bad value: got %d", code)
+	}
+
+	if code := do("POST", "/checkpoint"); code != http.StatusConflict {
+		t.Errorf("POST /checkpoint without persistence: got %d", code)
+	}
+
+	if code := do("POST", "/reset"); code != http.StatusOK {
+		t.Errorf("POST /reset: got %d", code)
+	}
+	if snap := k.Snapshot(); len(snap) != 0 {
+		t.Errorf("keys left after reset: %v", snap)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkhttp provides HTTP handlers for inspecting TopKs, without
+// waiting for a scrape.
+package topkhttp
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+var tableTemplate = template.Must(template.New("table").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>top-K</title>
+<style>
+table { border-collapse: collapse; font-family: monospace; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<p>{{len .Elements}} tracked keys. The true count of a key is between the
+lower bound and the count. <a href="?format=json">JSON</a></p>
+<table>
+<tr>
+<th><a href="?sort=count">Rank</a></th>
+{{- range .LabelNames}}
+<th><a href="?sort=label:{{.}}">{{.}}</a></th>
+{{- end}}
+<th><a href="?sort=count">Count</a></th>
+<th><a href="?sort=error">Error</a></th>
+<th><a href="?sort=lower">Lower bound</a></th>
+<th>Guaranteed</th>
+</tr>
+{{- range .Elements}}
+<tr>
+<td class="num">{{.Rank}}</td>
+{{- range .LabelValues}}
+<td>{{.}}</td>
+{{- end}}
+<td class="num">{{.Count}}</td>
+<td class="num">{{.Error}}</td>
+<td class="num">{{.Lower}}</td>
+<td>{{.Guaranteed}}</td>
+</tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type tableData struct {
+	LabelNames []string
+	Elements   []tableElement
+}
+
+type tableElement struct {
+	Rank         int
+	LabelValues  []string
+	Count, Error float64
+	Lower        float64
+	Guaranteed   bool
+}
+
+// Handler returns a handler serving the current Snapshot of t, as an HTML
+// table by default, or as JSON if the "format" query parameter is "json" or
+// the request accepts only application/json.
+//
+// The table is sorted by decreasing count, or by the "sort" query parameter:
+// "error" and "lower" sort by decreasing error or lower bound, and
+// "label:<name>" sorts by increasing value of a label. The rank column is
+// always the rank by count.
+func Handler(t topk.TopK) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "json" || r.Header.Get("Accept") == "application/json" {
+			data, err := t.MarshalJSON()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+			return
+		}
+
+		data := table(t.Snapshot())
+		sortTable(data, r.URL.Query().Get("sort"))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tableTemplate.Execute(w, data); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+func table(snap []topk.Element) tableData {
+	var data tableData
+	if len(snap) > 0 {
+		for name := range snap[0].Labels {
+			data.LabelNames = append(data.LabelNames, name)
+		}
+		sort.Strings(data.LabelNames)
+	}
+	data.Elements = make([]tableElement, len(snap))
+	for i, e := range snap {
+		lvs := make([]string, len(data.LabelNames))
+		for j, name := range data.LabelNames {
+			lvs[j] = e.Labels[name]
+		}
+		data.Elements[i] = tableElement{
+			Rank:        i + 1,
+			LabelValues: lvs,
+			Count:       e.Count,
+			Error:       e.Error,
+			Lower:       e.Count - e.Error,
+			Guaranteed:  e.Guaranteed,
+		}
+	}
+	return data
+}
+
+// sortTable sorts the elements, which are already sorted by count.
+func sortTable(data tableData, key string) {
+	var less func(a, b *tableElement) bool
+	switch {
+	case key == "error":
+		less = func(a, b *tableElement) bool { return a.Error > b.Error }
+	case key == "lower":
+		less = func(a, b *tableElement) bool { return a.Lower > b.Lower }
+	case strings.HasPrefix(key, "label:"):
+		idx := -1
+		for i, name := range data.LabelNames {
+			if name == strings.TrimPrefix(key, "label:") {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return
+		}
+		less = func(a, b *tableElement) bool { return a.LabelValues[idx] < b.LabelValues[idx] }
+	default:
+		return
+	}
+	sort.SliceStable(data.Elements, func(i, j int) bool {
+		return less(&data.Elements[i], &data.Elements[j])
+	})
+}
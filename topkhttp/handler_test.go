@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+func TestHandler(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5}, []string{"user", "path"})
+	k.WithLabelValues("bob", "/b").Add(5)
+	k.WithLabelValues("alice", "/<script>").Add(3)
+	h := Handler(k)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?format=json", nil))
+	var elts []topk.Element
+	if err := json.Unmarshal(rec.Body.Bytes(), &elts); err != nil {
+		t.Fatal(err)
+	}
+	if len(elts) != 2 || rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("wrong JSON response: %s", rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	body := rec.Body.String()
+	if strings.Contains(body, "<script>") || !strings.Contains(body, "&lt;script&gt;") {
+		t.Error("label values are not escaped")
+	}
+	if strings.Index(body, "bob") > strings.Index(body, "alice") {
+		t.Error("table is not sorted by count")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/?sort=label:user", nil))
+	body = rec.Body.String()
+	if strings.Index(body, "bob") < strings.Index(body, "alice") {
+		t.Error("table is not sorted by label")
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+// The label names that are filled in without an Option. A TopK passed to an
+// Instrument function must only have these labels, or labels added with
+// WithLabelFromRequest.
+const (
+	LabelCode      = "code"
+	LabelMethod    = "method"
+	LabelHost      = "host"
+	LabelPath      = "path"
+	LabelUserAgent = "user_agent"
+)
+
+// labelFunc computes a label value from a request and its response code.
+type labelFunc func(r *http.Request, code int) string
+
+var defaultLabels = map[string]labelFunc{
+	LabelCode:   func(_ *http.Request, code int) string { return strconv.Itoa(code) },
+	LabelMethod: func(r *http.Request, _ int) string { return r.Method },
+	LabelHost: func(r *http.Request, _ int) string {
+		if r.Host != "" {
+			return r.Host
+		}
+		return r.URL.Host
+	},
+	LabelPath:      func(r *http.Request, _ int) string { return r.URL.Path },
+	LabelUserAgent: func(r *http.Request, _ int) string { return r.UserAgent() },
+}
+
+// Option configures the Instrument functions.
+type Option func(*options)
+
+type options struct {
+	labels map[string]labelFunc
+}
+
+// WithLabelFromRequest fills the label with the given name with the result of
+// f, for example a path with the IDs removed. It can replace a default label.
+func WithLabelFromRequest(name string, f func(*http.Request) string) Option {
+	return func(o *options) {
+		o.labels[name] = func(r *http.Request, _ int) string { return f(r) }
+	}
+}
+
+// labelValues returns the function computing the label values of t, panicking
+// if a label of t is not known.
+func labelValues(t topk.TopK, opts []Option) func(r *http.Request, code int) []string {
+	o := options{labels: make(map[string]labelFunc, len(defaultLabels))}
+	for name, f := range defaultLabels {
+		o.labels[name] = f
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	names := t.FreeLabelNames()
+	fs := make([]labelFunc, len(names))
+	for i, name := range names {
+		f, ok := o.labels[name]
+		if !ok {
+			panic(fmt.Errorf("topkhttp: label %q is not supported", name))
+		}
+		fs[i] = f
+	}
+	return func(r *http.Request, code int) []string {
+		lvs := make([]string, len(fs))
+		for i, f := range fs {
+			lvs[i] = f(r, code)
+		}
+		return lvs
+	}
+}
+
+// InstrumentHandlerCounter is a middleware wrapping next, counting the
+// requests into t, like promhttp.InstrumentHandlerCounter. The labels of t
+// are filled from the request and the response code, as listed in the Label
+// constants and the options.
+func InstrumentHandlerCounter(t topk.TopK, next http.Handler, opts ...Option) http.HandlerFunc {
+	lvs := labelValues(t, opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		d := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(d, r)
+		t.WithLabelValues(lvs(r, d.Code())...).Inc()
+	}
+}
+
+// InstrumentHandlerDuration is like InstrumentHandlerCounter, but adds the
+// duration of the requests in seconds, so that the top keys are the ones
+// with the largest total latency.
+func InstrumentHandlerDuration(t topk.TopK, next http.Handler, opts ...Option) http.HandlerFunc {
+	lvs := labelValues(t, opts)
+	return func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		d := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(d, r)
+		t.WithLabelValues(lvs(r, d.Code())...).Observe(time.Since(now).Seconds())
+	}
+}
+
+// Middleware returns InstrumentHandlerCounter as a function wrapping
+// handlers, for routers that take middlewares in this form.
+func Middleware(t topk.TopK, opts ...Option) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return InstrumentHandlerCounter(t, next, opts...)
+	}
+}
+
+// responseRecorder records the status code of a response. The optional
+// interfaces of the wrapped ResponseWriter, other than http.Flusher, are
+// reached through Unwrap by http.ResponseController.
+type responseRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (d *responseRecorder) WriteHeader(code int) {
+	// informational responses are followed by the final one
+	if d.code == 0 && code >= 200 {
+		d.code = code
+	}
+	d.ResponseWriter.WriteHeader(code)
+}
+
+func (d *responseRecorder) Write(b []byte) (int, error) {
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	return d.ResponseWriter.Write(b)
+}
+
+func (d *responseRecorder) Flush() {
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	if f, ok := d.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (d *responseRecorder) Unwrap() http.ResponseWriter {
+	return d.ResponseWriter
+}
+
+// Code returns the status code of the response, which is 200 if the handler
+// did not write anything.
+func (d *responseRecorder) Code() int {
+	if d.code == 0 {
+		return http.StatusOK
+	}
+	return d.code
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestInstrumentHandlerCounter(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"method", "path", "code", "user_agent", "tenant"})
+	h := InstrumentHandlerCounter(k, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("ok"))
+	}), WithLabelFromRequest("tenant", func(r *http.Request) string {
+		return r.Header.Get("X-Tenant")
+	}))
+
+	for _, path := range []string{"/a", "/a", "/missing"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", "test")
+		req.Header.Set("X-Tenant", "t1")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for _, c := range []struct {
+		path, code string
+		want       float64
+	}{{"/a", "200", 2}, {"/missing", "404", 1}} {
+		count, _, _ := k.Estimate(prometheus.Labels{"method": "GET", "path": c.path, "code": c.code, "user_agent": "test", "tenant": "t1"})
+		if count != c.want {
+			t.Errorf("%s: got %v expected %v", c.path, count, c.want)
+		}
+	}
+}
+
+func TestInstrumentHandlerDuration(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "latency", Buckets: 10}, []string{"host"})
+	h := Middleware(k)(http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+	if count, _, _ := k.Estimate(prometheus.Labels{"host": "example.com"}); count != 1 {
+		t.Errorf("got %v expected 1", count)
+	}
+
+	d := InstrumentHandlerDuration(k, http.NotFoundHandler())
+	d.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://example.com/", nil))
+	if count, _, _ := k.Estimate(prometheus.Labels{"host": "example.com"}); count <= 1 {
+		t.Errorf("duration was not added: %v", count)
+	}
+}
+
+func TestUnsupportedLabel(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(error).Error(), "tenant") {
+			t.Errorf("expected panic for unsupported label, got %v", r)
+		}
+	}()
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"tenant"})
+	InstrumentHandlerCounter(k, http.NotFoundHandler())
+}
+
+func TestCurriedTopK(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"service", "path"})
+	h := InstrumentHandlerCounter(k.MustCurryWith(prometheus.Labels{"service": "api"}), http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+	if count, _, _ := k.Estimate(prometheus.Labels{"service": "api", "path": "/a"}); count != 1 {
+		t.Errorf("got %v expected 1", count)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"net/http"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// InstrumentRoundTripperCounter wraps next, counting the outgoing requests
+// into t, like promhttp.InstrumentRoundTripperCounter. The labels are filled
+// like for InstrumentHandlerCounter; the host is the one of the request URL
+// unless Request.Host is set. Requests that fail without a response are not
+// counted.
+func InstrumentRoundTripperCounter(t topk.TopK, next http.RoundTripper, opts ...Option) promhttp.RoundTripperFunc {
+	lvs := labelValues(t, opts)
+	return func(r *http.Request) (*http.Response, error) {
+		resp, err := next.RoundTrip(r)
+		if err == nil {
+			t.WithLabelValues(lvs(r, resp.StatusCode)...).Inc()
+		}
+		return resp, err
+	}
+}
+
+// InstrumentRoundTripperDuration is like InstrumentRoundTripperCounter, but
+// adds the time until the response headers are received, in seconds.
+func InstrumentRoundTripperDuration(t topk.TopK, next http.RoundTripper, opts ...Option) promhttp.RoundTripperFunc {
+	lvs := labelValues(t, opts)
+	return func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next.RoundTrip(r)
+		if err == nil {
+			t.WithLabelValues(lvs(r, resp.StatusCode)...).Observe(time.Since(start).Seconds())
+		}
+		return resp, err
+	}
+}
+
+// InstrumentRoundTripper tracks the top outgoing requests both by count, in
+// requests, and by total latency, in latency. Either TopK may be nil.
+func InstrumentRoundTripper(requests, latency topk.TopK, next http.RoundTripper, opts ...Option) http.RoundTripper {
+	if latency != nil {
+		next = InstrumentRoundTripperDuration(latency, next, opts...)
+	}
+	if requests != nil {
+		next = InstrumentRoundTripperCounter(requests, next, opts...)
+	}
+	return next
+}
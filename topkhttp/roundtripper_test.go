@@ -0,0 +1,64 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func TestInstrumentRoundTripper(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	requests := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"host", "code"})
+	latency := topk.NewTopK(topk.TopKOpts{Name: "latency", Buckets: 10}, []string{"host"})
+	client := &http.Client{Transport: InstrumentRoundTripper(requests, latency, http.DefaultTransport)}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL + "/x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	host := srv.Listener.Addr().String()
+	if count, _, _ := requests.Estimate(prometheus.Labels{"host": host, "code": "404"}); count != 2 {
+		t.Errorf("got %v requests expected 2", count)
+	}
+	if count, _, _ := latency.Estimate(prometheus.Labels{"host": host}); count <= 0 {
+		t.Errorf("no latency recorded: %v", count)
+	}
+
+	failing := InstrumentRoundTripperCounter(requests, promhttp.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("refused")
+	}))
+	if _, err := failing.RoundTrip(httptest.NewRequest("GET", "http://down.example/", nil)); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(requests.Snapshot()) != 1 {
+		t.Errorf("failed request was counted: %v", requests.Snapshot())
+	}
+}
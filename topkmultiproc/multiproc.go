@@ -0,0 +1,225 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkmultiproc supports applications made of several processes, like
+// pre-forked workers, that each observe into their own TopK but are scraped
+// through a single exporter.
+//
+// Every worker runs a Writer, which periodically saves the snapshot of its
+// TopK to a directory shared by all processes. The exporter registers a
+// Collector for the same directory, which merges the snapshots of all the
+// workers at scrape time.
+//
+// The directory should be emptied before the workers are started for the
+// first time. The files of workers that exited are kept, so that their counts
+// are not lost.
+//
+// The snapshots of a TopK using HashMaphash cannot be merged across
+// processes, since its seed differs in every process.
+package topkmultiproc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fileSuffix is the extension of the snapshot files.
+const fileSuffix = ".pb"
+
+const defaultInterval = 10 * time.Second
+
+// WriterOpts configures a Writer.
+type WriterOpts struct {
+	// Dir is the directory shared with the exporter.
+	Dir string
+
+	// ID distinguishes the files of this process from those of the other
+	// workers. The default is the process ID.
+	ID string
+
+	// Interval is the time between two snapshots; the default is 10
+	// seconds. Observations are only visible to the exporter after the
+	// next snapshot.
+	Interval time.Duration
+
+	// ErrorLog, if not nil, receives the errors encountered while writing
+	// snapshots. Otherwise they are dropped.
+	ErrorLog topk.Logger
+}
+
+// Writer periodically saves the snapshot of a TopK for a Collector.
+type Writer struct {
+	t        topk.TopK
+	path     string
+	errorLog topk.Logger
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewWriter writes a first snapshot of t in opts.Dir and starts writing them
+// in the background. The file is named after the metric name of t, which
+// must therefore be unique among the TopKs of the process.
+func NewWriter(t topk.TopK, opts WriterOpts) (*Writer, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("topkmultiproc: no directory")
+	}
+	id := opts.ID
+	if id == "" {
+		id = strconv.Itoa(os.Getpid())
+	}
+	snap := t.SnapshotProto()
+	w := &Writer{
+		t:        t,
+		path:     filepath.Join(opts.Dir, snap.GetName()+"_"+id+fileSuffix),
+		errorLog: opts.ErrorLog,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := topkpb.WriteFile(w.path, snap); err != nil {
+		return nil, err
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	go w.run(interval)
+	return w, nil
+}
+
+func (w *Writer) run(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Flush(); err != nil && w.errorLog != nil {
+				w.errorLog.Println("topkmultiproc: writing", w.path+":", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Flush writes a snapshot immediately.
+func (w *Writer) Flush() error {
+	return topkpb.WriteFile(w.path, w.t.SnapshotProto())
+}
+
+// Close stops the background writes and writes a final snapshot. The file is
+// left in place, so the counts of the process stay visible to the exporter.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		w.closeErr = w.Flush()
+	})
+	return w.closeErr
+}
+
+// Collector merges the snapshots in a directory at collection time.
+//
+// The metric names are only known once the files are read, so Collector is
+// an unchecked collector: it describes no metrics. Options of the worker
+// TopKs that are not part of the snapshots, like ReportingThreshold, are not
+// applied.
+type Collector struct {
+	dir string
+}
+
+// NewCollector returns a Collector for the snapshots written in dir.
+func NewCollector(dir string) *Collector {
+	return &Collector{dir: dir}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector. Snapshots that cannot be read or
+// merged are reported as invalid metrics.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	merged, errs := c.merge()
+	// the merged TopKs are only built for this collection, and may have
+	// started maintenance, such as for a HalfLife
+	defer func() {
+		for _, t := range merged {
+			t.Close()
+		}
+	}()
+	names := make([]string, 0, len(merged))
+	for name := range merged {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		merged[name].Collect(ch)
+	}
+	for _, err := range errs {
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("topkmultiproc_error", "Error reading a TopK snapshot.", nil, nil), err)
+	}
+}
+
+// merge reads all snapshots and merges those with the same metric name.
+func (c *Collector) merge() (map[string]topk.TopK, []error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+	merged := make(map[string]topk.TopK)
+	var errs []error
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		snap, err := topkpb.ReadFile(filepath.Join(c.dir, name))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("topkmultiproc: %s: %w", name, err))
+			continue
+		}
+		t, ok := merged[snap.GetName()]
+		if !ok {
+			t, err = topk.NewTopKFromSnapshot(snap)
+			if err == nil {
+				merged[snap.GetName()] = t
+			}
+		} else {
+			err = t.MergeSnapshotProto(snap)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("topkmultiproc: %s: %w", name, err))
+		}
+	}
+	return merged, errs
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkmultiproc
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMultiprocess(t *testing.T) {
+	dir := t.TempDir()
+	opts := topk.TopKOpts{Name: "requests", Help: "Requests by user.", Buckets: 5}
+
+	var writers []*Writer
+	for i, id := range []string{"1", "2"} {
+		k := topk.NewTopK(opts, []string{"user"})
+		k.WithLabelValues("alice").Add(float64(i + 1))
+		k.WithLabelValues("bob").Add(1)
+		w, err := NewWriter(k, WriterOpts{Dir: dir, ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writers = append(writers, w)
+		k.WithLabelValues("carol").Add(1)
+	}
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewCollector(dir))
+	expected := `
+# HELP requests Requests by user.
+# TYPE requests counter
+requests{user="alice"} 3
+requests{user="bob"} 2
+requests{user="carol"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "requests"); err != nil {
+		t.Error(err)
+	}
+
+	// a snapshot that cannot be merged fails the collection
+	other := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5}, []string{"path"})
+	w, err := NewWriter(other, WriterOpts{Dir: dir, ID: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := reg.Gather(); err == nil {
+		t.Error("expected error for mismatched snapshot")
+	}
+	os.Remove(filepath.Join(dir, "requests_3.pb"))
+
+	if err := os.WriteFile(filepath.Join(dir, "garbage.pb"), []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Gather(); err == nil {
+		t.Error("expected error for corrupt snapshot")
+	}
+}
+
+func TestCollectorCloses(t *testing.T) {
+	dir := t.TempDir()
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5, HalfLife: time.Hour}, []string{"user"})
+	defer k.Close()
+	k.WithLabelValues("alice").Inc()
+	w, err := NewWriter(k, WriterOpts{Dir: dir, ID: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewCollector(dir)
+	before := runtime.NumGoroutine()
+	for range 50 {
+		testutil.CollectAndCount(c)
+	}
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("got %d goroutines after 50 collections, %d before", after, before)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topknet wraps network listeners to track the top remote addresses,
+// by number of connections and by traffic, without a series per address.
+package topknet
+
+import (
+	"net"
+	"net/netip"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+// Opts configures the wrapper. The TopKs must have a single label, which
+// receives the remote address, or its prefix.
+type Opts struct {
+	// Connections, if not nil, counts the accepted connections.
+	Connections topk.TopK
+
+	// Bytes, if not nil, adds the bytes read from and written to the
+	// connections, as they are transferred.
+	Bytes topk.TopK
+
+	// IPv4PrefixLen and IPv6PrefixLen, if not zero, aggregate the
+	// addresses by prefix, like "192.0.2.0/24". By default the full
+	// addresses are used, without the port.
+	IPv4PrefixLen int
+	IPv6PrefixLen int
+}
+
+// key returns the label value for a remote address.
+func (o *Opts) key(addr net.Addr) string {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		// not an IP address, like a Unix socket
+		return addr.String()
+	}
+	ip := ap.Addr().Unmap()
+	bits := o.IPv6PrefixLen
+	if ip.Is4() {
+		bits = o.IPv4PrefixLen
+	}
+	if bits <= 0 || bits >= ip.BitLen() {
+		return ip.String()
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return ip.String()
+	}
+	return p.String()
+}
+
+type listener struct {
+	net.Listener
+	opts Opts
+}
+
+// WrapListener returns a listener recording the connections accepted by l.
+func WrapListener(l net.Listener, opts Opts) net.Listener {
+	return &listener{Listener: l, opts: opts}
+}
+
+// Accept implements net.Listener.
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	key := l.opts.key(c.RemoteAddr())
+	if l.opts.Connections != nil {
+		l.opts.Connections.WithLabelValues(key).Inc()
+	}
+	if l.opts.Bytes == nil {
+		return c, nil
+	}
+	return &conn{Conn: c, bytes: l.opts.Bytes.WithLabelValues(key)}, nil
+}
+
+type conn struct {
+	net.Conn
+	bytes topk.TopKBucket
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.bytes.Add(float64(n))
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.bytes.Add(float64(n))
+	}
+	return n, err
+}
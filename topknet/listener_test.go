@@ -0,0 +1,89 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topknet
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWrapListener(t *testing.T) {
+	conns := topk.NewTopK(topk.TopKOpts{Name: "connections", Buckets: 10}, []string{"addr"})
+	bytes := topk.NewTopK(topk.TopKOpts{Name: "bytes", Buckets: 10}, []string{"addr"})
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := WrapListener(inner, Opts{Connections: conns, Bytes: bytes, IPv4PrefixLen: 24})
+	defer l.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2; i++ {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			io.Copy(c, c)
+			c.Close()
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("hello"))
+		c.(*net.TCPConn).CloseWrite()
+		io.ReadAll(c)
+		c.Close()
+	}
+	<-done
+
+	labels := prometheus.Labels{"addr": "127.0.0.0/24"}
+	if count, _, _ := conns.Estimate(labels); count != 2 {
+		t.Errorf("got %v connections expected 2", count)
+	}
+	if count, _, _ := bytes.Estimate(labels); count != 20 {
+		t.Errorf("got %v bytes expected 20", count)
+	}
+}
+
+func TestKey(t *testing.T) {
+	for _, c := range []struct {
+		opts Opts
+		addr net.Addr
+		want string
+	}{
+		{Opts{}, &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 80}, "192.0.2.7"},
+		{Opts{IPv4PrefixLen: 24}, &net.TCPAddr{IP: net.ParseIP("192.0.2.7"), Port: 80}, "192.0.2.0/24"},
+		{Opts{IPv6PrefixLen: 48}, &net.TCPAddr{IP: net.ParseIP("2001:db8:1:2::1"), Port: 80}, "2001:db8:1::/48"},
+		{Opts{}, &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}, "/tmp/sock"},
+	} {
+		if got := c.opts.key(c.addr); got != c.want {
+			t.Errorf("key(%v) = %q, expected %q", c.addr, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkotel bridges TopKs into an OpenTelemetry MeterProvider, so they
+// are exported by its readers alongside the native instruments.
+//
+// Register the Producer with a reader:
+//
+//	reader := metric.NewPeriodicReader(exporter, metric.WithProducer(topkotel.NewProducer(t)))
+//
+// The data points are the same as the ones of the topkotlp package: a
+// monotonic cumulative Sum of the counts, or a Gauge if they can decrease,
+// and a Gauge of the negated error bounds named like in the Prometheus export.
+package topkotel
+
+import (
+	"context"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const scopeName = "github.com/riking/go-prometheus-topk/topkotel"
+
+type producer struct {
+	ts    []topk.TopK
+	start time.Time
+}
+
+// NewProducer returns a metric.Producer for the snapshots of ts. The start
+// time of the cumulative sums is the time of the call.
+func NewProducer(ts ...topk.TopK) metric.Producer {
+	return &producer{
+		ts:    append([]topk.TopK(nil), ts...),
+		start: time.Now(),
+	}
+}
+
+// Produce implements metric.Producer.
+func (p *producer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	metrics := make([]metricdata.Metrics, 0, 2*len(p.ts))
+	for _, t := range p.ts {
+		snap := t.SnapshotProto()
+		now := time.UnixMilli(snap.GetTimestampMs())
+		counts := make([]metricdata.DataPoint[float64], 0, len(snap.GetElements()))
+		errs := make([]metricdata.DataPoint[float64], 0, len(snap.GetElements()))
+		for _, el := range snap.GetElements() {
+			kvs := make([]attribute.KeyValue, 0, len(snap.GetConstLabels())+len(snap.GetLabelNames()))
+			for name, value := range snap.GetConstLabels() {
+				kvs = append(kvs, attribute.String(name, value))
+			}
+			for i, name := range snap.GetLabelNames() {
+				kvs = append(kvs, attribute.String(name, el.GetLabelValues()[i]))
+			}
+			attrs := attribute.NewSet(kvs...)
+			counts = append(counts, metricdata.DataPoint[float64]{
+				Attributes: attrs,
+				StartTime:  p.start,
+				Time:       now,
+				Value:      el.GetCount(),
+			})
+			errs = append(errs, metricdata.DataPoint[float64]{
+				Attributes: attrs,
+				Time:       now,
+				Value:      -el.GetError(),
+			})
+		}
+		count := metricdata.Metrics{
+			Name:        snap.GetName(),
+			Description: snap.GetHelp(),
+			Data: metricdata.Sum[float64]{
+				DataPoints:  counts,
+				Temporality: metricdata.CumulativeTemporality,
+				IsMonotonic: true,
+			},
+		}
+		if !monotonic(snap) {
+			for i := range counts {
+				counts[i].StartTime = time.Time{}
+			}
+			count.Data = metricdata.Gauge[float64]{DataPoints: counts}
+		}
+		metrics = append(metrics, count, metricdata.Metrics{
+			Name:        snap.GetName() + "_error",
+			Description: snap.GetErrorHelp(),
+			Data:        metricdata.Gauge[float64]{DataPoints: errs},
+		})
+	}
+	return []metricdata.ScopeMetrics{{
+		Scope:   instrumentation.Scope{Name: scopeName},
+		Metrics: metrics,
+	}}, nil
+}
+
+// monotonic reports whether the counts of snap only grow, and are exported
+// as counters by the TopK.
+func monotonic(snap *topkpb.Snapshot) bool {
+	return topk.Mode(snap.GetMode()) == topk.ModeSum && snap.GetHalfLifeNs() <= 0 &&
+		topk.DecrementPolicy(snap.GetDecrementPolicy()) == topk.DecrementPolicyDisallow
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkotel
+
+import (
+	"context"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestProducer(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{
+		Name:        "requests",
+		Help:        "Requests by user.",
+		ConstLabels: prometheus.Labels{"zone": "a"},
+		Buckets:     5,
+	}, []string{"user"})
+	k.WithLabelValues("alice").Add(3)
+
+	reader := metric.NewManualReader(metric.WithProducer(NewProducer(k)))
+	provider := metric.NewMeterProvider(metric.WithReader(reader))
+	defer provider.Shutdown(context.Background())
+	counter, err := provider.Meter("native").Int64Counter("native_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counter.Add(context.Background(), 1)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 2 {
+		t.Fatalf("expected native and bridged scopes, got %v", rm.ScopeMetrics)
+	}
+	var bridged *metricdata.ScopeMetrics
+	for i := range rm.ScopeMetrics {
+		if rm.ScopeMetrics[i].Scope.Name == scopeName {
+			bridged = &rm.ScopeMetrics[i]
+		}
+	}
+	if bridged == nil || len(bridged.Metrics) != 2 {
+		t.Fatalf("wrong bridged metrics: %v", bridged)
+	}
+	sum, ok := bridged.Metrics[0].Data.(metricdata.Sum[float64])
+	if !ok || !sum.IsMonotonic || len(sum.DataPoints) != 1 {
+		t.Fatalf("wrong sum: %v", bridged.Metrics[0])
+	}
+	dp := sum.DataPoints[0]
+	if dp.Value != 3 {
+		t.Errorf("wrong value: %v", dp.Value)
+	}
+	if v, ok := dp.Attributes.Value(attribute.Key("user")); !ok || v.AsString() != "alice" {
+		t.Errorf("wrong attributes: %v", dp.Attributes)
+	}
+	if v, ok := dp.Attributes.Value(attribute.Key("zone")); !ok || v.AsString() != "a" {
+		t.Errorf("wrong attributes: %v", dp.Attributes)
+	}
+	if _, ok := bridged.Metrics[1].Data.(metricdata.Gauge[float64]); !ok || bridged.Metrics[1].Name != "requests_error" {
+		t.Errorf("wrong error gauge: %v", bridged.Metrics[1])
+	}
+}
+
+func TestProducerGauge(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5, Mode: topk.ModeLast}, []string{"user"})
+	k.WithLabelValues("alice").Add(3)
+	sms, err := NewProducer(k).Produce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gauge, ok := sms[0].Metrics[0].Data.(metricdata.Gauge[float64])
+	if !ok || len(gauge.DataPoints) != 1 || gauge.DataPoints[0].Value != 3 {
+		t.Errorf("wrong gauge: %v", sms[0].Metrics[0])
+	}
+}
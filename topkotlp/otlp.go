@@ -0,0 +1,273 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkotlp periodically exports the snapshots of TopKs to an
+// OpenTelemetry collector, using OTLP over HTTP with protobuf payloads.
+//
+// The count of every tracked key is a monotonic cumulative Sum data point,
+// with the label values as attributes, or a Gauge data point if the counts
+// can decrease. The error bound is a Gauge data point named like in the
+// Prometheus export, with the same negative value.
+package topkotlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultInterval = 60 * time.Second
+	defaultTimeout  = 10 * time.Second
+
+	scopeName = "github.com/riking/go-prometheus-topk/topkotlp"
+)
+
+// Opts configures an Exporter.
+type Opts struct {
+	// URL is the OTLP/HTTP metrics endpoint, usually ending in
+	// "/v1/metrics". It is mandatory.
+	URL string
+
+	// Resource holds the resource attributes, like "service.name".
+	Resource map[string]string
+
+	// Interval is the time between two exports; the default is 60 seconds.
+	Interval time.Duration
+
+	// Timeout bounds every export; the default is 10 seconds.
+	Timeout time.Duration
+
+	// Client, if not nil, is used instead of http.DefaultClient.
+	Client *http.Client
+
+	// Header is added to every request, for example for authentication.
+	Header http.Header
+
+	// ErrorLog, if not nil, receives the errors of the background exports.
+	// Otherwise they are dropped.
+	ErrorLog topk.Logger
+}
+
+// Exporter periodically sends the snapshots of some TopKs.
+type Exporter struct {
+	opts   Opts
+	client *http.Client
+	ts     []topk.TopK
+	start  time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewExporter starts exporting the snapshots of ts in the background. The
+// start time of the cumulative sums is the time of the call.
+func NewExporter(opts Opts, ts ...topk.TopK) (*Exporter, error) {
+	if opts.URL == "" {
+		return nil, errors.New("topkotlp: no URL")
+	}
+	e := &Exporter{
+		opts:   opts,
+		client: opts.Client,
+		ts:     append([]topk.TopK(nil), ts...),
+		start:  time.Now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if e.client == nil {
+		e.client = http.DefaultClient
+	}
+	if e.opts.Timeout <= 0 {
+		e.opts.Timeout = defaultTimeout
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	go e.run(interval)
+	return e, nil
+}
+
+func (e *Exporter) run(interval time.Duration) {
+	defer close(e.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.Export(context.Background()); err != nil && e.opts.ErrorLog != nil {
+				e.opts.ErrorLog.Println("topkotlp: export:", err)
+			}
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// Export sends the current snapshots immediately.
+func (e *Exporter) Export(ctx context.Context) error {
+	snaps := make([]*topkpb.Snapshot, 0, len(e.ts))
+	for _, t := range e.ts {
+		snaps = append(snaps, t.SnapshotProto())
+	}
+	data, err := proto.Marshal(Request(snaps, e.opts.Resource, e.start))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for name, values := range e.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("topkotlp: server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close stops the background exports and sends the final snapshots.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.stop)
+		<-e.done
+		e.closeErr = e.Export(context.Background())
+	})
+	return e.closeErr
+}
+
+// Request converts snapshots to an OTLP export request. The constant labels
+// of a snapshot become attributes of all its data points, and start is the
+// start time of the cumulative sums. Like in Prometheus, the counts that can
+// decrease, with a Mode, a HalfLife, or a DecrementPolicy, are gauges.
+func Request(snaps []*topkpb.Snapshot, resource map[string]string, start time.Time) *collectorpb.ExportMetricsServiceRequest {
+	// the snapshot timestamps have a millisecond resolution
+	startNano := uint64(start.UnixMilli()) * uint64(time.Millisecond)
+	metrics := make([]*metricspb.Metric, 0, 2*len(snaps))
+	for _, snap := range snaps {
+		nowNano := uint64(snap.GetTimestampMs()) * uint64(time.Millisecond)
+		counts := make([]*metricspb.NumberDataPoint, 0, len(snap.GetElements()))
+		errs := make([]*metricspb.NumberDataPoint, 0, len(snap.GetElements()))
+		for _, el := range snap.GetElements() {
+			attrs := attributes(snap.GetConstLabels())
+			for i, name := range snap.GetLabelNames() {
+				attrs = append(attrs, attribute(name, el.GetLabelValues()[i]))
+			}
+			counts = append(counts, &metricspb.NumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: startNano,
+				TimeUnixNano:      nowNano,
+				Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: el.GetCount()},
+			})
+			errs = append(errs, &metricspb.NumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: nowNano,
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: -el.GetError()},
+			})
+		}
+		count := &metricspb.Metric{
+			Name:        snap.GetName(),
+			Description: snap.GetHelp(),
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				DataPoints:             counts,
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}},
+		}
+		if !monotonic(snap) {
+			for _, dp := range counts {
+				dp.StartTimeUnixNano = 0
+			}
+			count.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: counts}}
+		}
+		metrics = append(metrics, count, &metricspb.Metric{
+			Name:        snap.GetName() + "_error",
+			Description: errorHelp(snap),
+			Data:        &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: errs}},
+		})
+	}
+	return &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: attributes(resource)},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// monotonic reports whether the counts of snap only grow, and are exported
+// as counters by the TopK.
+func monotonic(snap *topkpb.Snapshot) bool {
+	return topk.Mode(snap.GetMode()) == topk.ModeSum && snap.GetHalfLifeNs() <= 0 &&
+		topk.DecrementPolicy(snap.GetDecrementPolicy()) == topk.DecrementPolicyDisallow
+}
+
+// attributes converts labels to attributes, sorted by name.
+func attributes(labels map[string]string) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(labels))
+	for name, value := range labels {
+		attrs = append(attrs, attribute(name, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+func attribute(name, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   name,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// errorHelp returns the help of the error metric of snap, which older
+// snapshots do not have.
+func errorHelp(snap *topkpb.Snapshot) string {
+	if help := snap.GetErrorHelp(); help != "" {
+		return help
+	}
+	return topk.DefaultErrorHelp(snap.GetName())
+}
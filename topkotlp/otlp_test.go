@@ -0,0 +1,95 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkotlp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExporter(t *testing.T) {
+	var got collectorpb.ExportMetricsServiceRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := proto.Unmarshal(body, &got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	k := topk.NewTopK(topk.TopKOpts{
+		Name:        "requests",
+		Help:        "Requests by user.",
+		ConstLabels: prometheus.Labels{"zone": "a"},
+		Buckets:     5,
+	}, []string{"user"})
+	k.WithLabelValues("alice").Add(3)
+
+	e, err := NewExporter(Opts{URL: srv.URL, Resource: map[string]string{"service.name": "api"}}, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rm := got.GetResourceMetrics()
+	if len(rm) != 1 || rm[0].GetResource().GetAttributes()[0].GetKey() != "service.name" {
+		t.Fatalf("wrong resource: %v", &got)
+	}
+	metrics := rm[0].GetScopeMetrics()[0].GetMetrics()
+	if len(metrics) != 2 || metrics[0].GetName() != "requests" || metrics[1].GetName() != "requests_error" {
+		t.Fatalf("wrong metrics: %v", metrics)
+	}
+	sum := metrics[0].GetSum()
+	if !sum.GetIsMonotonic() || len(sum.GetDataPoints()) != 1 {
+		t.Fatalf("wrong sum: %v", sum)
+	}
+	dp := sum.GetDataPoints()[0]
+	if dp.GetAsDouble() != 3 || dp.GetStartTimeUnixNano() == 0 || dp.GetStartTimeUnixNano() > dp.GetTimeUnixNano() {
+		t.Errorf("wrong data point: %v", dp)
+	}
+	attrs := dp.GetAttributes()
+	if len(attrs) != 2 || attrs[0].GetKey() != "zone" || attrs[1].GetKey() != "user" || attrs[1].GetValue().GetStringValue() != "alice" {
+		t.Errorf("wrong attributes: %v", attrs)
+	}
+
+	if _, err := NewExporter(Opts{}); err == nil {
+		t.Error("expected error for missing URL")
+	}
+}
+
+func TestRequestGauge(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5, Mode: topk.ModeLast}, []string{"user"})
+	k.WithLabelValues("alice").Add(3)
+	req := Request([]*topkpb.Snapshot{k.SnapshotProto()}, nil, time.Now())
+	count := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0]
+	if count.GetSum() != nil || len(count.GetGauge().GetDataPoints()) != 1 || count.GetGauge().GetDataPoints()[0].GetAsDouble() != 3 {
+		t.Errorf("wrong gauge: %v", count)
+	}
+}
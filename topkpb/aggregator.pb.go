@@ -0,0 +1,194 @@
+// Copyright 2019 Google LLC
+// Copyright 2019 Kane York
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: aggregator.proto
+
+package topkpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type PushRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Identifies the replica, for example by its host name.
+	Replica string `protobuf:"bytes,1,opt,name=replica,proto3" json:"replica,omitempty"`
+	// The current snapshots of the TopKs of the replica, with distinct names.
+	Snapshots     []*Snapshot `protobuf:"bytes,2,rep,name=snapshots,proto3" json:"snapshots,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushRequest) Reset() {
+	*x = PushRequest{}
+	mi := &file_aggregator_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushRequest) ProtoMessage() {}
+
+func (x *PushRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregator_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushRequest.ProtoReflect.Descriptor instead.
+func (*PushRequest) Descriptor() ([]byte, []int) {
+	return file_aggregator_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *PushRequest) GetReplica() string {
+	if x != nil {
+		return x.Replica
+	}
+	return ""
+}
+
+func (x *PushRequest) GetSnapshots() []*Snapshot {
+	if x != nil {
+		return x.Snapshots
+	}
+	return nil
+}
+
+type PushResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PushResponse) Reset() {
+	*x = PushResponse{}
+	mi := &file_aggregator_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PushResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushResponse) ProtoMessage() {}
+
+func (x *PushResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_aggregator_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushResponse.ProtoReflect.Descriptor instead.
+func (*PushResponse) Descriptor() ([]byte, []int) {
+	return file_aggregator_proto_rawDescGZIP(), []int{1}
+}
+
+var File_aggregator_proto protoreflect.FileDescriptor
+
+const file_aggregator_proto_rawDesc = "" +
+	"\n" +
+	"\x10aggregator.proto\x12\x10topk.snapshot.v1\x1a\x0esnapshot.proto\"a\n" +
+	"\vPushRequest\x12\x18\n" +
+	"\areplica\x18\x01 \x01(\tR\areplica\x128\n" +
+	"\tsnapshots\x18\x02 \x03(\v2\x1a.topk.snapshot.v1.SnapshotR\tsnapshots\"\x0e\n" +
+	"\fPushResponse2S\n" +
+	"\n" +
+	"Aggregator\x12E\n" +
+	"\x04Push\x12\x1d.topk.snapshot.v1.PushRequest\x1a\x1e.topk.snapshot.v1.PushResponseB-Z+github.com/riking/go-prometheus-topk/topkpbb\x06proto3"
+
+var (
+	file_aggregator_proto_rawDescOnce sync.Once
+	file_aggregator_proto_rawDescData []byte
+)
+
+func file_aggregator_proto_rawDescGZIP() []byte {
+	file_aggregator_proto_rawDescOnce.Do(func() {
+		file_aggregator_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_aggregator_proto_rawDesc), len(file_aggregator_proto_rawDesc)))
+	})
+	return file_aggregator_proto_rawDescData
+}
+
+var file_aggregator_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_aggregator_proto_goTypes = []any{
+	(*PushRequest)(nil),  // 0: topk.snapshot.v1.PushRequest
+	(*PushResponse)(nil), // 1: topk.snapshot.v1.PushResponse
+	(*Snapshot)(nil),     // 2: topk.snapshot.v1.Snapshot
+}
+var file_aggregator_proto_depIdxs = []int32{
+	2, // 0: topk.snapshot.v1.PushRequest.snapshots:type_name -> topk.snapshot.v1.Snapshot
+	0, // 1: topk.snapshot.v1.Aggregator.Push:input_type -> topk.snapshot.v1.PushRequest
+	1, // 2: topk.snapshot.v1.Aggregator.Push:output_type -> topk.snapshot.v1.PushResponse
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_aggregator_proto_init() }
+func file_aggregator_proto_init() {
+	if File_aggregator_proto != nil {
+		return
+	}
+	file_snapshot_proto_init()
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_aggregator_proto_rawDesc), len(file_aggregator_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_aggregator_proto_goTypes,
+		DependencyIndexes: file_aggregator_proto_depIdxs,
+		MessageInfos:      file_aggregator_proto_msgTypes,
+	}.Build()
+	File_aggregator_proto = out.File
+	file_aggregator_proto_goTypes = nil
+	file_aggregator_proto_depIdxs = nil
+}
@@ -0,0 +1,466 @@
+// Copyright 2019 Google LLC
+// Copyright 2019 Kane York
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: snapshot.proto
+
+package topkpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Snapshot is the full state of a TopK: its descriptor and the contents of
+// its stream.
+type Snapshot struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Fully-qualified metric name.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Help string `protobuf:"bytes,2,opt,name=help,proto3" json:"help,omitempty"`
+	// Names of the variable labels, in order.
+	LabelNames  []string          `protobuf:"bytes,3,rep,name=label_names,json=labelNames,proto3" json:"label_names,omitempty"`
+	ConstLabels map[string]string `protobuf:"bytes,4,rep,name=const_labels,json=constLabels,proto3" json:"const_labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Number of tracked keys (the "K" in top-K).
+	Buckets uint64 `protobuf:"varint,5,opt,name=buckets,proto3" json:"buckets,omitempty"`
+	// The tracked keys, in no particular order.
+	Elements []*Element `protobuf:"bytes,6,rep,name=elements,proto3" json:"elements,omitempty"`
+	// Error estimates for the keys that are not tracked, indexed by key hash.
+	// Empty if the snapshot is partitioned.
+	Alphas []float64 `protobuf:"fixed64,7,rep,packed,name=alphas,proto3" json:"alphas,omitempty"`
+	// Sum of all observations.
+	Total float64 `protobuf:"fixed64,8,opt,name=total,proto3" json:"total,omitempty"`
+	// Time the snapshot was taken, in milliseconds since the Unix epoch.
+	TimestampMs int64 `protobuf:"varint,9,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	// Names of the labels whose values select an independent stream of
+	// buckets each, in order. Empty if the snapshot is not partitioned.
+	PartitionLabels []string `protobuf:"bytes,10,rep,name=partition_labels,json=partitionLabels,proto3" json:"partition_labels,omitempty"`
+	// The streams of a partitioned snapshot. The elements of all partitions
+	// are in Snapshot.elements.
+	Partitions []*Partition `protobuf:"bytes,11,rep,name=partitions,proto3" json:"partitions,omitempty"`
+	// The hash function of the keys that are not tracked, which must match
+	// the one of the TopK restoring the snapshot. Empty for SipHash-1-3.
+	Hash string `protobuf:"bytes,12,opt,name=hash,proto3" json:"hash,omitempty"`
+	// Help of the error metric. Empty in older snapshots, for the default of
+	// topk.DefaultErrorHelp.
+	ErrorHelp string `protobuf:"bytes,13,opt,name=error_help,json=errorHelp,proto3" json:"error_help,omitempty"`
+	// Unit suffix of the metric names, and whether they follow promlint, as in
+	// topk.TopKOpts.
+	Unit          string `protobuf:"bytes,14,opt,name=unit,proto3" json:"unit,omitempty"`
+	PromlintNames bool   `protobuf:"varint,15,opt,name=promlint_names,json=promlintNames,proto3" json:"promlint_names,omitempty"`
+	// How the observations of a key are combined into its count, the value of
+	// topk.Mode: 0 for topk.ModeSum, the default of older snapshots.
+	Mode int32 `protobuf:"varint,16,opt,name=mode,proto3" json:"mode,omitempty"`
+	// Half-life of the decay of the counts in nanoseconds, 0 if they do not
+	// decay, and the value of topk.DecrementPolicy, as in topk.TopKOpts.
+	HalfLifeNs      int64 `protobuf:"varint,17,opt,name=half_life_ns,json=halfLifeNs,proto3" json:"half_life_ns,omitempty"`
+	DecrementPolicy int32 `protobuf:"varint,18,opt,name=decrement_policy,json=decrementPolicy,proto3" json:"decrement_policy,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_snapshot_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Snapshot) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Snapshot) GetHelp() string {
+	if x != nil {
+		return x.Help
+	}
+	return ""
+}
+
+func (x *Snapshot) GetLabelNames() []string {
+	if x != nil {
+		return x.LabelNames
+	}
+	return nil
+}
+
+func (x *Snapshot) GetConstLabels() map[string]string {
+	if x != nil {
+		return x.ConstLabels
+	}
+	return nil
+}
+
+func (x *Snapshot) GetBuckets() uint64 {
+	if x != nil {
+		return x.Buckets
+	}
+	return 0
+}
+
+func (x *Snapshot) GetElements() []*Element {
+	if x != nil {
+		return x.Elements
+	}
+	return nil
+}
+
+func (x *Snapshot) GetAlphas() []float64 {
+	if x != nil {
+		return x.Alphas
+	}
+	return nil
+}
+
+func (x *Snapshot) GetTotal() float64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Snapshot) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *Snapshot) GetPartitionLabels() []string {
+	if x != nil {
+		return x.PartitionLabels
+	}
+	return nil
+}
+
+func (x *Snapshot) GetPartitions() []*Partition {
+	if x != nil {
+		return x.Partitions
+	}
+	return nil
+}
+
+func (x *Snapshot) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Snapshot) GetErrorHelp() string {
+	if x != nil {
+		return x.ErrorHelp
+	}
+	return ""
+}
+
+func (x *Snapshot) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *Snapshot) GetPromlintNames() bool {
+	if x != nil {
+		return x.PromlintNames
+	}
+	return false
+}
+
+func (x *Snapshot) GetMode() int32 {
+	if x != nil {
+		return x.Mode
+	}
+	return 0
+}
+
+func (x *Snapshot) GetHalfLifeNs() int64 {
+	if x != nil {
+		return x.HalfLifeNs
+	}
+	return 0
+}
+
+func (x *Snapshot) GetDecrementPolicy() int32 {
+	if x != nil {
+		return x.DecrementPolicy
+	}
+	return 0
+}
+
+// Partition is the state of the stream of one partition.
+type Partition struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Values of the partition labels, in the order of
+	// Snapshot.partition_labels.
+	LabelValues []string `protobuf:"bytes,1,rep,name=label_values,json=labelValues,proto3" json:"label_values,omitempty"`
+	// Error estimates for the keys of the partition that are not tracked.
+	Alphas []float64 `protobuf:"fixed64,2,rep,packed,name=alphas,proto3" json:"alphas,omitempty"`
+	// Sum of the observations of the partition.
+	Total         float64 `protobuf:"fixed64,3,opt,name=total,proto3" json:"total,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Partition) Reset() {
+	*x = Partition{}
+	mi := &file_snapshot_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Partition) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Partition) ProtoMessage() {}
+
+func (x *Partition) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Partition.ProtoReflect.Descriptor instead.
+func (*Partition) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Partition) GetLabelValues() []string {
+	if x != nil {
+		return x.LabelValues
+	}
+	return nil
+}
+
+func (x *Partition) GetAlphas() []float64 {
+	if x != nil {
+		return x.Alphas
+	}
+	return nil
+}
+
+func (x *Partition) GetTotal() float64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+// Element is a tracked key.
+type Element struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Values of the variable labels, in the order of Snapshot.label_names.
+	LabelValues []string `protobuf:"bytes,1,rep,name=label_values,json=labelValues,proto3" json:"label_values,omitempty"`
+	// Estimated total of the observations of the key.
+	Count float64 `protobuf:"fixed64,2,opt,name=count,proto3" json:"count,omitempty"`
+	// Maximum overestimation in count.
+	Error         float64 `protobuf:"fixed64,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Element) Reset() {
+	*x = Element{}
+	mi := &file_snapshot_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Element) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Element) ProtoMessage() {}
+
+func (x *Element) ProtoReflect() protoreflect.Message {
+	mi := &file_snapshot_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Element.ProtoReflect.Descriptor instead.
+func (*Element) Descriptor() ([]byte, []int) {
+	return file_snapshot_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Element) GetLabelValues() []string {
+	if x != nil {
+		return x.LabelValues
+	}
+	return nil
+}
+
+func (x *Element) GetCount() float64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Element) GetError() float64 {
+	if x != nil {
+		return x.Error
+	}
+	return 0
+}
+
+var File_snapshot_proto protoreflect.FileDescriptor
+
+const file_snapshot_proto_rawDesc = "" +
+	"\n" +
+	"\x0esnapshot.proto\x12\x10topk.snapshot.v1\"\xbc\x05\n" +
+	"\bSnapshot\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04help\x18\x02 \x01(\tR\x04help\x12\x1f\n" +
+	"\vlabel_names\x18\x03 \x03(\tR\n" +
+	"labelNames\x12N\n" +
+	"\fconst_labels\x18\x04 \x03(\v2+.topk.snapshot.v1.Snapshot.ConstLabelsEntryR\vconstLabels\x12\x18\n" +
+	"\abuckets\x18\x05 \x01(\x04R\abuckets\x125\n" +
+	"\belements\x18\x06 \x03(\v2\x19.topk.snapshot.v1.ElementR\belements\x12\x16\n" +
+	"\x06alphas\x18\a \x03(\x01R\x06alphas\x12\x14\n" +
+	"\x05total\x18\b \x01(\x01R\x05total\x12!\n" +
+	"\ftimestamp_ms\x18\t \x01(\x03R\vtimestampMs\x12)\n" +
+	"\x10partition_labels\x18\n" +
+	" \x03(\tR\x0fpartitionLabels\x12;\n" +
+	"\n" +
+	"partitions\x18\v \x03(\v2\x1b.topk.snapshot.v1.PartitionR\n" +
+	"partitions\x12\x12\n" +
+	"\x04hash\x18\f \x01(\tR\x04hash\x12\x1d\n" +
+	"\n" +
+	"error_help\x18\r \x01(\tR\terrorHelp\x12\x12\n" +
+	"\x04unit\x18\x0e \x01(\tR\x04unit\x12%\n" +
+	"\x0epromlint_names\x18\x0f \x01(\bR\rpromlintNames\x12\x12\n" +
+	"\x04mode\x18\x10 \x01(\x05R\x04mode\x12 \n" +
+	"\fhalf_life_ns\x18\x11 \x01(\x03R\n" +
+	"halfLifeNs\x12)\n" +
+	"\x10decrement_policy\x18\x12 \x01(\x05R\x0fdecrementPolicy\x1a>\n" +
+	"\x10ConstLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\\\n" +
+	"\tPartition\x12!\n" +
+	"\flabel_values\x18\x01 \x03(\tR\vlabelValues\x12\x16\n" +
+	"\x06alphas\x18\x02 \x03(\x01R\x06alphas\x12\x14\n" +
+	"\x05total\x18\x03 \x01(\x01R\x05total\"X\n" +
+	"\aElement\x12!\n" +
+	"\flabel_values\x18\x01 \x03(\tR\vlabelValues\x12\x14\n" +
+	"\x05count\x18\x02 \x01(\x01R\x05count\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\x01R\x05errorB-Z+github.com/riking/go-prometheus-topk/topkpbb\x06proto3"
+
+var (
+	file_snapshot_proto_rawDescOnce sync.Once
+	file_snapshot_proto_rawDescData []byte
+)
+
+func file_snapshot_proto_rawDescGZIP() []byte {
+	file_snapshot_proto_rawDescOnce.Do(func() {
+		file_snapshot_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_snapshot_proto_rawDesc), len(file_snapshot_proto_rawDesc)))
+	})
+	return file_snapshot_proto_rawDescData
+}
+
+var file_snapshot_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_snapshot_proto_goTypes = []any{
+	(*Snapshot)(nil),  // 0: topk.snapshot.v1.Snapshot
+	(*Partition)(nil), // 1: topk.snapshot.v1.Partition
+	(*Element)(nil),   // 2: topk.snapshot.v1.Element
+	nil,               // 3: topk.snapshot.v1.Snapshot.ConstLabelsEntry
+}
+var file_snapshot_proto_depIdxs = []int32{
+	3, // 0: topk.snapshot.v1.Snapshot.const_labels:type_name -> topk.snapshot.v1.Snapshot.ConstLabelsEntry
+	2, // 1: topk.snapshot.v1.Snapshot.elements:type_name -> topk.snapshot.v1.Element
+	1, // 2: topk.snapshot.v1.Snapshot.partitions:type_name -> topk.snapshot.v1.Partition
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_snapshot_proto_init() }
+func file_snapshot_proto_init() {
+	if File_snapshot_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_snapshot_proto_rawDesc), len(file_snapshot_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_snapshot_proto_goTypes,
+		DependencyIndexes: file_snapshot_proto_depIdxs,
+		MessageInfos:      file_snapshot_proto_msgTypes,
+	}.Build()
+	File_snapshot_proto = out.File
+	file_snapshot_proto_goTypes = nil
+	file_snapshot_proto_depIdxs = nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkpb defines a versioned protobuf encoding of the state of a
+// TopK, for transferring and persisting snapshots.
+//
+// The schema is in snapshot.proto; run go generate after changing it.
+package topkpb
+
+//go:generate buf generate
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Marshal encodes s in the protobuf wire format.
+func Marshal(s *Snapshot) ([]byte, error) {
+	return proto.Marshal(s)
+}
+
+// Unmarshal decodes a Snapshot from the protobuf wire format, and checks it
+// with Validate.
+func Unmarshal(data []byte) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := proto.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate checks that s is internally consistent: it has a positive number
+// of buckets and error estimates, no more elements than buckets, and one label
+// value per label name in every element. In a partitioned snapshot, the
+// limits apply to every partition, and every element must belong to one.
+func (s *Snapshot) Validate() error {
+	if s.GetBuckets() == 0 {
+		return errors.New("topkpb: snapshot has no buckets")
+	}
+	for i, e := range s.GetElements() {
+		if len(e.GetLabelValues()) != len(s.GetLabelNames()) {
+			return fmt.Errorf("topkpb: element %d has %d label values, expected %d", i, len(e.GetLabelValues()), len(s.GetLabelNames()))
+		}
+	}
+	if len(s.GetPartitionLabels()) > 0 {
+		return s.validatePartitions()
+	}
+	if len(s.GetPartitions()) > 0 {
+		return errors.New("topkpb: snapshot has partitions but no partition labels")
+	}
+	if uint64(len(s.GetElements())) > s.GetBuckets() {
+		return fmt.Errorf("topkpb: snapshot has %d elements but only %d buckets", len(s.GetElements()), s.GetBuckets())
+	}
+	if len(s.GetAlphas()) == 0 {
+		return errors.New("topkpb: snapshot has no error estimates")
+	}
+	return nil
+}
+
+func (s *Snapshot) validatePartitions() error {
+	if len(s.GetAlphas()) > 0 {
+		return errors.New("topkpb: partitioned snapshot has top-level error estimates")
+	}
+	index := make([]int, len(s.GetPartitionLabels()))
+	for i, name := range s.GetPartitionLabels() {
+		index[i] = -1
+		for j, n := range s.GetLabelNames() {
+			if n == name {
+				index[i] = j
+			}
+		}
+		if index[i] < 0 {
+			return fmt.Errorf("topkpb: partition label %q is not a label name", name)
+		}
+	}
+
+	elements := make(map[string]uint64, len(s.GetPartitions()))
+	for i, p := range s.GetPartitions() {
+		if len(p.GetLabelValues()) != len(index) {
+			return fmt.Errorf("topkpb: partition %d has %d label values, expected %d", i, len(p.GetLabelValues()), len(index))
+		}
+		if len(p.GetAlphas()) == 0 {
+			return fmt.Errorf("topkpb: partition %d has no error estimates", i)
+		}
+		key := fmt.Sprintf("%q", p.GetLabelValues())
+		if _, dup := elements[key]; dup {
+			return fmt.Errorf("topkpb: duplicate partition %q", p.GetLabelValues())
+		}
+		elements[key] = 0
+	}
+	lvs := make([]string, len(index))
+	for i, e := range s.GetElements() {
+		for j, k := range index {
+			lvs[j] = e.GetLabelValues()[k]
+		}
+		key := fmt.Sprintf("%q", lvs)
+		n, ok := elements[key]
+		if !ok {
+			return fmt.Errorf("topkpb: element %d is not in any partition", i)
+		}
+		if n == s.GetBuckets() {
+			return fmt.Errorf("topkpb: partition %q has more than %d elements", lvs, s.GetBuckets())
+		}
+		elements[key] = n + 1
+	}
+	return nil
+}
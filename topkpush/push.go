@@ -0,0 +1,94 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkpush pushes TopKs to a Prometheus Pushgateway, for batch jobs
+// that exit before they can be scraped.
+package topkpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/prometheus/common/model"
+)
+
+// Opts configures the push.
+type Opts struct {
+	// Job is the value of the "job" grouping label. It is mandatory.
+	Job string
+
+	// Grouping holds the other grouping labels, like the "instance".
+	Grouping map[string]string
+
+	// Client, if not nil, is used instead of http.DefaultClient.
+	Client push.HTTPDoer
+}
+
+// New returns a push.Pusher for the metrics of ts, to the Pushgateway at url.
+// It returns an error if a grouping label is invalid, or is also a variable or
+// constant label of one of the TopKs: the Pushgateway would reject those
+// metrics.
+func New(url string, opts Opts, ts ...topk.TopK) (*push.Pusher, error) {
+	if opts.Job == "" {
+		return nil, errors.New("topkpush: no job")
+	}
+	grouping := map[string]string{"job": opts.Job}
+	for name, value := range opts.Grouping {
+		if !model.LabelName(name).IsValid() || name == "job" {
+			return nil, fmt.Errorf("topkpush: invalid grouping label %q", name)
+		}
+		grouping[name] = value
+	}
+	for _, t := range ts {
+		snap := t.SnapshotProto()
+		for _, name := range snap.GetLabelNames() {
+			if _, ok := grouping[name]; ok {
+				return nil, fmt.Errorf("topkpush: label %q of %s is also a grouping label", name, snap.GetName())
+			}
+		}
+		for name := range snap.GetConstLabels() {
+			if _, ok := grouping[name]; ok {
+				return nil, fmt.Errorf("topkpush: constant label %q of %s is also a grouping label", name, snap.GetName())
+			}
+		}
+	}
+
+	p := push.New(url, opts.Job)
+	for name, value := range opts.Grouping {
+		p = p.Grouping(name, value)
+	}
+	if opts.Client != nil {
+		p = p.Client(opts.Client)
+	}
+	for _, t := range ts {
+		p = p.Collector(t)
+	}
+	return p, nil
+}
+
+// Push replaces all the metrics of the group with the current metrics of ts.
+func Push(ctx context.Context, url string, opts Opts, ts ...topk.TopK) error {
+	p, err := New(url, opts, ts...)
+	if err != nil {
+		return err
+	}
+	return p.PushContext(ctx)
+}
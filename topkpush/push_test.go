@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkpush
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestPush(t *testing.T) {
+	var method, path string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Help: "Requests by user.", Buckets: 5}, []string{"user"})
+	k.WithLabelValues("alice").Add(3)
+
+	opts := Opts{Job: "batch", Grouping: map[string]string{"instance": "host1"}}
+	if err := Push(context.Background(), srv.URL, opts, k); err != nil {
+		t.Fatal(err)
+	}
+	if method != http.MethodPut || path != "/metrics/job/batch/instance/host1" {
+		t.Errorf("wrong request: %s %s", method, path)
+	}
+	dec := expfmt.NewDecoder(bytes.NewReader(body), expfmt.NewFormat(expfmt.TypeProtoDelim))
+	var names []string
+	for {
+		var mf dto.MetricFamily
+		if err := dec.Decode(&mf); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, mf.GetName())
+	}
+	if !reflect.DeepEqual(names, []string{"requests", "requests_error"}) {
+		t.Errorf("wrong pushed families: %v", names)
+	}
+
+	for _, bad := range []Opts{
+		{},
+		{Job: "batch", Grouping: map[string]string{"user": "alice"}},
+		{Job: "batch", Grouping: map[string]string{"": "x"}},
+	} {
+		if _, err := New(srv.URL, bad, k); err == nil {
+			t.Errorf("expected error for %v", bad)
+		}
+	}
+	withConst := topk.NewTopK(topk.TopKOpts{Name: "x", Buckets: 1, ConstLabels: prometheus.Labels{"instance": "a"}}, nil)
+	if _, err := New(srv.URL, opts, withConst); err == nil {
+		t.Error("expected error for constant label clashing with grouping")
+	}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkredis keeps the state of a top-K in Redis, so that many
+// short-lived processes, like CLI jobs or serverless functions, can
+// contribute to a single top-K scraped from one exporter.
+//
+// The counts are kept in a sorted set, and updated by a Lua script that
+// implements the Space-Saving algorithm: once the set holds Buckets members,
+// a new key replaces the member with the lowest count, inheriting that count
+// as its error. The errors are kept in a hash next to the sorted set.
+package topkredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/redis/go-redis/v9"
+)
+
+var labelSeparator = string([]byte{model.SeparatorByte})
+
+const defaultTimeout = 10 * time.Second
+
+// KEYS[1] is the sorted set of counts, KEYS[2] the hash of errors.
+// ARGV[1] is the member, ARGV[2] the count, ARGV[3] the capacity.
+var insertScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if score or redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('ZINCRBY', KEYS[1], ARGV[2], ARGV[1])
+	return 0
+end
+local min = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+redis.call('ZREM', KEYS[1], min[1])
+redis.call('HDEL', KEYS[2], min[1])
+redis.call('ZADD', KEYS[1], tonumber(min[2]) + tonumber(ARGV[2]), ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], min[2])
+return 1
+`)
+
+// Opts configures a TopK.
+type Opts struct {
+	// Key is the Redis key of the sorted set of counts. The errors are kept
+	// in the hash at Key + ":error".
+	Key string
+
+	// Buckets is the number of keys tracked (the "K" in top-K).
+	Buckets uint64
+
+	// Namespace, Subsystem, Name, Help, ErrorHelp, and ConstLabels describe
+	// the exported metrics, like in topk.TopKOpts.
+	Namespace   string
+	Subsystem   string
+	Name        string
+	Help        string
+	ErrorHelp   string
+	ConstLabels prometheus.Labels
+
+	// Timeout bounds the Redis calls of Collect; the default is 10 seconds.
+	Timeout time.Duration
+}
+
+// TopK is a top-K stored in Redis. It is a prometheus.Collector exporting
+// the same metrics as a topk.TopK with the same options.
+type TopK struct {
+	client     redis.Cmdable
+	opts       Opts
+	labelNames []string
+	desc       *prometheus.Desc
+}
+
+// New returns a TopK stored in Redis through client.
+func New(client redis.Cmdable, opts Opts, labelNames []string) *TopK {
+	return &TopK{
+		client:     client,
+		opts:       opts,
+		labelNames: append([]string(nil), labelNames...),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help, labelNames, opts.ConstLabels),
+	}
+}
+
+func (t *TopK) errorKey() string {
+	return t.opts.Key + ":error"
+}
+
+// Observe adds v to the count of the key with the given label values.
+func (t *TopK) Observe(ctx context.Context, v float64, lvs ...string) error {
+	if len(lvs) != len(t.labelNames) {
+		return fmt.Errorf("topkredis: expected %d label values but got %d", len(t.labelNames), len(lvs))
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) || v < 0 {
+		return fmt.Errorf("topkredis: invalid value %v", v)
+	}
+	var sb strings.Builder
+	for _, lv := range lvs {
+		if strings.Contains(lv, labelSeparator) {
+			return fmt.Errorf("topkredis: label value %q contains the separator byte", lv)
+		}
+		sb.WriteString(lv)
+		sb.WriteString(labelSeparator)
+	}
+	return insertScript.Run(ctx, t.client,
+		[]string{t.opts.Key, t.errorKey()},
+		sb.String(), v, t.opts.Buckets).Err()
+}
+
+// Snapshot reads the state of the TopK from Redis. The untracked keys are
+// estimated at the lowest count, as in the Space-Saving algorithm.
+func (t *TopK) Snapshot(ctx context.Context) (*topkpb.Snapshot, error) {
+	if t.opts.Buckets == 0 {
+		return nil, errors.New("topkredis: no buckets")
+	}
+	var counts *redis.ZSliceCmd
+	var errs *redis.MapStringStringCmd
+	_, err := t.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		counts = p.ZRangeWithScores(ctx, t.opts.Key, 0, -1)
+		errs = p.HGetAll(ctx, t.errorKey())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &topkpb.Snapshot{
+		Name:        prometheus.BuildFQName(t.opts.Namespace, t.opts.Subsystem, t.opts.Name),
+		Help:        t.opts.Help,
+		ErrorHelp:   t.opts.ErrorHelp,
+		LabelNames:  t.labelNames,
+		ConstLabels: t.opts.ConstLabels,
+		Buckets:     t.opts.Buckets,
+		Alphas:      []float64{0},
+		TimestampMs: time.Now().UnixMilli(),
+	}
+	for _, z := range counts.Val() {
+		member, _ := z.Member.(string)
+		lvs := strings.Split(member, labelSeparator)
+		if len(lvs) != len(t.labelNames)+1 {
+			return nil, fmt.Errorf("topkredis: malformed member %q", member)
+		}
+		e := &topkpb.Element{LabelValues: lvs[:len(t.labelNames)], Count: z.Score}
+		if s, ok := errs.Val()[member]; ok {
+			if e.Error, err = strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("topkredis: malformed error of %q: %w", member, err)
+			}
+		}
+		snap.Elements = append(snap.Elements, e)
+		// every observation is added to exactly one count
+		snap.Total += z.Score
+	}
+	if uint64(len(snap.Elements)) >= t.opts.Buckets && len(snap.Elements) > 0 {
+		// the members are sorted by increasing count
+		snap.Alphas[0] = snap.Elements[0].Count
+	}
+	return snap, nil
+}
+
+// Reset deletes the state of the TopK from Redis.
+func (t *TopK) Reset(ctx context.Context) error {
+	return t.client.Del(ctx, t.opts.Key, t.errorKey()).Err()
+}
+
+// Describe implements prometheus.Collector.
+func (t *TopK) Describe(ch chan<- *prometheus.Desc) {
+	topk.NewTopK(topk.TopKOpts{
+		Namespace:   t.opts.Namespace,
+		Subsystem:   t.opts.Subsystem,
+		Name:        t.opts.Name,
+		Help:        t.opts.Help,
+		ErrorHelp:   t.opts.ErrorHelp,
+		ConstLabels: t.opts.ConstLabels,
+		Buckets:     1,
+	}, t.labelNames).Describe(ch)
+}
+
+// Collect implements prometheus.Collector, reading the state from Redis. A
+// Redis error is reported as an invalid metric.
+func (t *TopK) Collect(ch chan<- prometheus.Metric) {
+	timeout := t.opts.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	snap, err := t.Snapshot(ctx)
+	var k topk.TopK
+	if err == nil {
+		k, err = topk.NewTopKFromSnapshot(snap)
+	}
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(t.desc, err)
+		return
+	}
+	k.Collect(ch)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkredis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	ctx := context.Background()
+
+	opts := Opts{Key: "topk:requests", Buckets: 2, Name: "requests", Help: "Requests by user."}
+	// separate processes share the state through Redis
+	for _, obs := range []struct {
+		user string
+		v    float64
+	}{{"alice", 3}, {"bob", 1}, {"alice", 2}, {"carol", 2}} {
+		if err := New(client, opts, []string{"user"}).Observe(ctx, obs.v, obs.user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k := New(client, opts, []string{"user"})
+	snap, err := k.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.GetTotal() != 8 || snap.GetAlphas()[0] != 3 {
+		t.Errorf("wrong total or floor: %v", snap)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(k)
+	// carol replaced bob, inheriting its count as error
+	expected := `
+# HELP requests Requests by user.
+# TYPE requests counter
+requests{user="alice"} 5
+requests{user="carol"} 3
+# HELP requests_error Estimation error bound for requests.
+# TYPE requests_error gauge
+requests_error{user="alice"} -0
+requests_error{user="carol"} -1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	if err := k.Observe(ctx, 1); err == nil {
+		t.Error("expected error for missing label values")
+	}
+	if err := k.Observe(ctx, -1, "alice"); err == nil {
+		t.Error("expected error for negative value")
+	}
+
+	if err := k.Reset(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if snap, err := k.Snapshot(ctx); err != nil || len(snap.GetElements()) != 0 {
+		t.Errorf("Reset did not clear the state: %v, %v", snap, err)
+	}
+
+	mr.Close()
+	if _, err := reg.Gather(); err == nil {
+		t.Error("expected error when Redis is down")
+	}
+}
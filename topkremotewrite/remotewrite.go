@@ -0,0 +1,308 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkremotewrite periodically sends the metrics of TopKs to a
+// Prometheus remote-write endpoint, for environments that cannot be scraped.
+//
+// The requests follow version 1.0 of the remote-write protocol: snappy
+// compressed protobuf WriteRequests of float samples. Native histograms are
+// not sent.
+package topkremotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	defaultInterval = 15 * time.Second
+	defaultTimeout  = 10 * time.Second
+)
+
+// Opts configures a Writer.
+type Opts struct {
+	// URL is the remote-write endpoint. It is mandatory.
+	URL string
+
+	// ExternalLabels are added to every series, for example to identify
+	// the job and instance, since there is no scrape to add them.
+	ExternalLabels map[string]string
+
+	// Interval is the time between two writes; the default is 15 seconds.
+	Interval time.Duration
+
+	// Timeout bounds every write; the default is 10 seconds.
+	Timeout time.Duration
+
+	// Client, if not nil, is used instead of http.DefaultClient.
+	Client *http.Client
+
+	// Header is added to every request, for example for authentication.
+	Header http.Header
+
+	// ErrorLog, if not nil, receives the errors of the background writes.
+	// Otherwise they are dropped.
+	ErrorLog topk.Logger
+}
+
+// Writer periodically sends the metrics of some collectors, usually TopKs.
+type Writer struct {
+	opts     Opts
+	client   *http.Client
+	gatherer prometheus.Gatherer
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewWriter starts sending the metrics of cs in the background.
+func NewWriter(opts Opts, cs ...prometheus.Collector) (*Writer, error) {
+	if opts.URL == "" {
+		return nil, errors.New("topkremotewrite: no URL")
+	}
+	for name := range opts.ExternalLabels {
+		if !model.LabelName(name).IsValid() || name == model.MetricNameLabel {
+			return nil, fmt.Errorf("topkremotewrite: invalid external label %q", name)
+		}
+	}
+	reg := prometheus.NewRegistry()
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	w := &Writer{
+		opts:     opts,
+		client:   opts.Client,
+		gatherer: reg,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if w.client == nil {
+		w.client = http.DefaultClient
+	}
+	if w.opts.Timeout <= 0 {
+		w.opts.Timeout = defaultTimeout
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	go w.run(interval)
+	return w, nil
+}
+
+func (w *Writer) run(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Write(context.Background()); err != nil && w.opts.ErrorLog != nil {
+				w.opts.ErrorLog.Println("topkremotewrite: write:", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Write sends the current metrics immediately.
+func (w *Writer) Write(ctx context.Context) error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	data := encodeWriteRequest(timeSeries(mfs, w.opts.ExternalLabels, time.Now()))
+
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.opts.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("topkremotewrite: server returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close stops the background writes and sends the final metrics.
+func (w *Writer) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		w.closeErr = w.Write(context.Background())
+	})
+	return w.closeErr
+}
+
+type label struct {
+	name, value string
+}
+
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+// timeSeries converts the gathered metrics to samples at now. Summaries are
+// split into their quantile, _sum, and _count series, and histograms into
+// their classic _bucket, _sum, and _count series. The native buckets are not
+// exported, as they need the remote-write histogram encoding: a native
+// histogram only has its le="+Inf" bucket.
+func timeSeries(mfs []*dto.MetricFamily, external map[string]string, now time.Time) []series {
+	var out []series
+	add := func(name string, m *dto.Metric, v float64, extra ...label) {
+		ts := now.UnixMilli()
+		if m.TimestampMs != nil {
+			ts = m.GetTimestampMs()
+		}
+		labels := make([]label, 0, len(m.GetLabel())+len(external)+len(extra)+1)
+		labels = append(labels, label{model.MetricNameLabel, name})
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, label{lp.GetName(), lp.GetValue()})
+		}
+		labels = append(labels, extra...)
+		// like in Prometheus, the labels of the series win over the external
+		// labels
+	nextExternal:
+		for name, value := range external {
+			for _, l := range labels {
+				if l.name == name {
+					continue nextExternal
+				}
+			}
+			labels = append(labels, label{name, value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+		out = append(out, series{labels: labels, value: v, timestamp: ts})
+	}
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, m, q.GetValue(), label{model.QuantileLabel, formatFloat(q.GetQuantile())})
+				}
+				add(name+"_sum", m, s.GetSampleSum())
+				add(name+"_count", m, float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				count := float64(h.GetSampleCount())
+				if h.SampleCountFloat != nil {
+					count = h.GetSampleCountFloat()
+				}
+				inf := false
+				for _, b := range h.GetBucket() {
+					v := float64(b.GetCumulativeCount())
+					if b.CumulativeCountFloat != nil {
+						v = b.GetCumulativeCountFloat()
+					}
+					inf = inf || math.IsInf(b.GetUpperBound(), +1)
+					add(name+"_bucket", m, v, label{model.BucketLabel, formatFloat(b.GetUpperBound())})
+				}
+				if !inf {
+					add(name+"_bucket", m, count, label{model.BucketLabel, "+Inf"})
+				}
+				add(name+"_sum", m, h.GetSampleSum())
+				add(name+"_count", m, count)
+			}
+		}
+	}
+	return out
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(f)
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(ss []series) []byte {
+	var out, ts, msg []byte
+	for _, s := range ss {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+	return out
+}
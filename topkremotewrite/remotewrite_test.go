@@ -0,0 +1,182 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkremotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeWriteRequest decodes the series of a WriteRequest to strings like
+// `name{label="value"} 1`, ignoring the timestamps.
+func decodeWriteRequest(t *testing.T, data []byte) []string {
+	fields := func(b []byte, f func(num protowire.Number, typ protowire.Type, v []byte)) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			m := protowire.ConsumeFieldValue(num, typ, b)
+			if m < 0 {
+				t.Fatal(protowire.ParseError(m))
+			}
+			f(num, typ, b[:m])
+			b = b[m:]
+		}
+	}
+	var out []string
+	fields(data, func(_ protowire.Number, _ protowire.Type, v []byte) {
+		ts, _ := protowire.ConsumeBytes(v)
+		var name string
+		var labels []string
+		var value float64
+		fields(ts, func(num protowire.Number, _ protowire.Type, v []byte) {
+			msg, _ := protowire.ConsumeBytes(v)
+			switch num {
+			case 1:
+				var l [2]string
+				fields(msg, func(num protowire.Number, _ protowire.Type, v []byte) {
+					s, _ := protowire.ConsumeString(v)
+					l[num-1] = s
+				})
+				if l[0] == "__name__" {
+					name = l[1]
+				} else {
+					labels = append(labels, l[0]+`="`+l[1]+`"`)
+				}
+			case 2:
+				fields(msg, func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == 1 {
+						bits, _ := protowire.ConsumeFixed64(v)
+						value = math.Float64frombits(bits)
+					}
+				})
+			}
+		})
+		out = append(out, name+"{"+strings.Join(labels, ",")+"} "+formatFloat(value))
+	})
+	sort.Strings(out)
+	return out
+}
+
+func TestWriter(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer x" {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		data, err := snappy.Decode(nil, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		got = decodeWriteRequest(t, data)
+	}))
+	defer srv.Close()
+
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 5, Quantiles: []float64{0.5}}, []string{"user"})
+	k.WithLabelValues("alice").Observe(3)
+	w, err := NewWriter(Opts{
+		URL:            srv.URL,
+		ExternalLabels: map[string]string{"job": "lambda", "user": "ignored"},
+		Header:         http.Header{"Authorization": {"Bearer x"}},
+	}, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`requests_error{job="lambda",user="alice"} -0`,
+		`requests_summary_count{job="lambda",user="alice"} 1`,
+		`requests_summary_sum{job="lambda",user="alice"} 3`,
+		`requests_summary{job="lambda",quantile="0.5",user="alice"} 3`,
+		`requests{job="lambda",user="alice"} 3`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q expected %q", got, expected)
+	}
+
+	w, err = NewWriter(Opts{URL: srv.URL}, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(context.Background()); err == nil || !strings.Contains(err.Error(), "bad headers") {
+		t.Errorf("expected error from server, got %v", err)
+	}
+
+	if _, err := NewWriter(Opts{}); err == nil {
+		t.Error("expected error for missing URL")
+	}
+}
+
+func TestHistogramSeries(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Buckets: []float64{1, 2}})
+	reg.MustRegister(h)
+	h.Observe(1.5)
+	h.Observe(3)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, s := range timeSeries(mfs, nil, time.Now()) {
+		var name string
+		var labels []string
+		for _, l := range s.labels {
+			if l.name == "__name__" {
+				name = l.value
+			} else {
+				labels = append(labels, l.name+`="`+l.value+`"`)
+			}
+		}
+		got = append(got, name+"{"+strings.Join(labels, ",")+"} "+formatFloat(s.value))
+	}
+	expected := []string{
+		`latency_bucket{le="1"} 0`,
+		`latency_bucket{le="2"} 1`,
+		`latency_bucket{le="+Inf"} 2`,
+		`latency_sum{} 4.5`,
+		`latency_count{} 2`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q expected %q", got, expected)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkslog counts log records into a TopK, exporting the noisiest
+// log lines as metrics.
+package topkslog
+
+import (
+	"context"
+	"log/slog"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+// The label names with a special meaning. The other labels of the TopK are
+// filled with the values of the attributes with the same key, or the empty
+// string. The keys of the attributes in groups are prefixed by the group
+// names, joined with "_".
+const (
+	LabelLevel   = "level"
+	LabelMessage = "message"
+)
+
+type handler struct {
+	next  slog.Handler
+	t     topk.TopK
+	names []string
+
+	// prefix of the keys of the next attributes, from WithGroup
+	prefix string
+	// values of the labels set by WithAttrs, or nil
+	bound []string
+}
+
+// NewHandler returns a handler counting the records into t, then passing them
+// to next. If next is nil, the records are only counted. Only the records
+// enabled by next are counted.
+func NewHandler(next slog.Handler, t topk.TopK) slog.Handler {
+	return &handler{
+		next:  next,
+		t:     t,
+		names: t.FreeLabelNames(),
+	}
+}
+
+// Enabled implements slog.Handler.
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next == nil || h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler.
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	lvs := make([]string, len(h.names))
+	copy(lvs, h.bound)
+	for i, name := range h.names {
+		switch name {
+		case LabelLevel:
+			lvs[i] = r.Level.String()
+		case LabelMessage:
+			lvs[i] = r.Message
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		h.fill(lvs, h.prefix, a)
+		return true
+	})
+	h.t.WithLabelValues(lvs...).Inc()
+
+	if h.next == nil {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// fill sets the label values of lvs matching a, which is named prefix+a.Key.
+func (h *handler) fill(lvs []string, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range v.Group() {
+			h.fill(lvs, prefix, ga)
+		}
+		return
+	}
+	key := prefix + a.Key
+	for i, name := range h.names {
+		if name == key && name != LabelLevel && name != LabelMessage {
+			lvs[i] = v.String()
+		}
+	}
+}
+
+// WithAttrs implements slog.Handler.
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.bound = make([]string, len(h.names))
+	copy(h2.bound, h.bound)
+	for _, a := range attrs {
+		h.fill(h2.bound, h.prefix, a)
+	}
+	if h.next != nil {
+		h2.next = h.next.WithAttrs(attrs)
+	}
+	return &h2
+}
+
+// WithGroup implements slog.Handler.
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	if h.next != nil {
+		h2.next = h.next.WithGroup(name)
+	}
+	return &h2
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkslog
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandler(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "log_records", Buckets: 10}, []string{"level", "message", "component", "req_path"})
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), k))
+
+	db := logger.With("component", "db")
+	db.Info("slow query")
+	db.Info("slow query")
+	db.WithGroup("req").Warn("timeout", "path", "/a")
+	logger.Debug("not enabled")
+
+	for _, c := range []struct {
+		labels prometheus.Labels
+		want   float64
+	}{
+		{prometheus.Labels{"level": "INFO", "message": "slow query", "component": "db", "req_path": ""}, 2},
+		{prometheus.Labels{"level": "WARN", "message": "timeout", "component": "db", "req_path": "/a"}, 1},
+	} {
+		if count, _, _ := k.Estimate(c.labels); count != c.want {
+			t.Errorf("%v: got %v expected %v", c.labels, count, c.want)
+		}
+	}
+	if n := len(k.Snapshot()); n != 2 {
+		t.Errorf("disabled record was counted: %v", k.Snapshot())
+	}
+	if strings.Count(buf.String(), "\n") != 3 {
+		t.Errorf("records were not passed on:\n%s", &buf)
+	}
+
+	// without a next handler, the records are only counted
+	only := slog.New(NewHandler(nil, k))
+	only.Debug("debug")
+	if count, _, _ := k.Estimate(prometheus.Labels{"level": "DEBUG", "message": "debug", "component": "", "req_path": ""}); count != 1 {
+		t.Errorf("record was not counted without next handler: %v", count)
+	}
+}
+
+func TestCurriedTopK(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "log_records", Buckets: 10}, []string{"service", "level"})
+	logger := slog.New(NewHandler(nil, k.MustCurryWith(prometheus.Labels{"service": "api"})))
+	logger.Info("started")
+	if count, _, _ := k.Estimate(prometheus.Labels{"service": "api", "level": "INFO"}); count != 1 {
+		t.Errorf("got %v expected 1", count)
+	}
+}
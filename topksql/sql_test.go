@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topksql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fakeConn supports ExecerContext, but only the prepared path for queries.
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return fakeTx{}, nil }
+func (fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string              { return []string{"x"} }
+func (fakeRows) Close() error                   { return nil }
+func (fakeRows) Next(dest []driver.Value) error { return io.EOF }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+func TestWrapConnector(t *testing.T) {
+	count := topk.NewTopK(topk.TopKOpts{Name: "statements", Buckets: 10}, []string{"statement"})
+	duration := topk.NewTopK(topk.TopKOpts{Name: "statement_seconds", Buckets: 10}, []string{"statement"})
+	db := sql.OpenDB(WrapConnector(fakeConnector{}, Opts{Count: count, Duration: duration}))
+	defer db.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec("UPDATE t\n  SET x = ?", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rows, err := db.Query("SELECT x FROM t WHERE y = ?", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	st, err := db.Prepare("DELETE FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Exec(); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	for query, want := range map[string]float64{
+		"UPDATE t SET x = ?":          2,
+		"SELECT x FROM t WHERE y = ?": 1,
+		"DELETE FROM t":               1,
+	} {
+		if got, _, _ := count.Estimate(prometheus.Labels{"statement": query}); got != want {
+			t.Errorf("%q: got %v expected %v", query, got, want)
+		}
+		if got, _, tracked := duration.Estimate(prometheus.Labels{"statement": query}); !tracked || got < 0 {
+			t.Errorf("%q: no duration recorded", query)
+		}
+	}
+	if n := len(count.Snapshot()); n != 3 {
+		t.Errorf("statements recorded more than once: %v", count.Snapshot())
+	}
+}
+
+func TestBeginTxOptions(t *testing.T) {
+	db := sql.OpenDB(WrapConnector(fakeConnector{}, Opts{}))
+	defer db.Close()
+
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	for _, opts := range []*sql.TxOptions{{Isolation: sql.LevelSerializable}, {ReadOnly: true}} {
+		if _, err := db.BeginTx(context.Background(), opts); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
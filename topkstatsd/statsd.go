@@ -0,0 +1,205 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topkstatsd ingests the statsd protocol into TopKs, to bound the
+// cardinality of the metrics of applications that only emit statsd.
+//
+// Every line is parsed as "<name>:<value>|<type>[|@<rate>][|#<tags>]", with
+// DogStatsD-style "key:value" tags. Counters add their value, scaled up by the
+// sample rate, to their key. Timers, histograms, and distributions add their
+// value, so that the top keys are the ones with the largest total. Gauges,
+// sets, and negative values are ignored.
+package topkstatsd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	topk "github.com/riking/go-prometheus-topk"
+)
+
+// NameLabel, in the Labels of a Mapping, stands for the statsd metric name.
+const NameLabel = "__name__"
+
+// Mapping sends the statsd metrics with a given name to a TopK.
+type Mapping struct {
+	// Name is the statsd metric name. A trailing "*" matches any suffix.
+	Name string
+
+	// TopK receives the values of the matching metrics.
+	TopK topk.TopK
+
+	// Labels lists, for every label of TopK in order, the name of the
+	// statsd tag holding its value, or NameLabel for the metric name. A
+	// missing tag is an empty label value.
+	Labels []string
+}
+
+func (m *Mapping) matches(name string) bool {
+	if prefix, ok := strings.CutSuffix(m.Name, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return name == m.Name
+}
+
+// Opts configures a Server.
+type Opts struct {
+	// Mappings are tried in order; the first one matching a metric name
+	// wins. Metrics matching no mapping are dropped.
+	Mappings []Mapping
+
+	// ErrorLog, if not nil, receives the lines that cannot be parsed.
+	// Otherwise they are dropped.
+	ErrorLog topk.Logger
+}
+
+// Server receives statsd metrics.
+type Server struct {
+	opts Opts
+}
+
+// NewServer returns a Server applying opts.
+func NewServer(opts Opts) *Server {
+	return &Server{opts: opts}
+}
+
+// HandleLine records a single statsd line.
+func (s *Server) HandleLine(line string) error {
+	name, value, tags, err := parseLine(line)
+	if err != nil || value < 0 {
+		return err
+	}
+	for i := range s.opts.Mappings {
+		m := &s.opts.Mappings[i]
+		if !m.matches(name) {
+			continue
+		}
+		lvs := make([]string, len(m.Labels))
+		for j, tag := range m.Labels {
+			if tag == NameLabel {
+				lvs[j] = name
+			} else {
+				lvs[j] = tags[tag]
+			}
+		}
+		b, err := m.TopK.GetMetricWithLabelValues(lvs...)
+		if err != nil {
+			return err
+		}
+		b.Observe(value)
+		return nil
+	}
+	return nil
+}
+
+// HandleMessage records all the newline-separated lines of a message, like
+// a UDP packet.
+func (s *Server) HandleMessage(msg string) {
+	for _, line := range strings.Split(msg, "\n") {
+		if line == "" {
+			continue
+		}
+		if err := s.HandleLine(line); err != nil && s.opts.ErrorLog != nil {
+			s.opts.ErrorLog.Println("topkstatsd:", err)
+		}
+	}
+}
+
+// ServePacket reads UDP messages from conn until it is closed.
+func (s *Server) ServePacket(conn net.PacketConn) error {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if n > 0 {
+			s.HandleMessage(string(buf[:n]))
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve accepts TCP connections from l until it is closed, reading
+// newline-separated lines from each connection.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		s.HandleMessage(scanner.Text())
+	}
+}
+
+// parseLine parses a statsd line, returning a negative value for the types
+// that are ignored.
+func parseLine(line string) (name string, value float64, tags map[string]string, err error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return "", 0, nil, fmt.Errorf("malformed line %q", line)
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return "", 0, nil, fmt.Errorf("malformed line %q", line)
+	}
+	switch fields[1] {
+	case "c", "ms", "h", "d":
+	case "g", "s":
+		return name, -1, nil, nil
+	default:
+		return "", 0, nil, fmt.Errorf("unknown metric type %q in line %q", fields[1], line)
+	}
+	value, err = strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", 0, nil, fmt.Errorf("malformed value in line %q: %w", line, err)
+	}
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return "", 0, nil, fmt.Errorf("malformed sample rate in line %q", line)
+			}
+			if fields[1] == "c" {
+				value /= rate
+			}
+		case strings.HasPrefix(f, "#"):
+			tags = make(map[string]string)
+			for _, tag := range strings.Split(f[1:], ",") {
+				k, v, _ := strings.Cut(tag, ":")
+				tags[k] = v
+			}
+		}
+	}
+	return name, value, tags, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topkstatsd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandleLine(t *testing.T) {
+	requests := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"metric", "user"})
+	latency := topk.NewTopK(topk.TopKOpts{Name: "latency", Buckets: 10}, []string{"endpoint"})
+	s := NewServer(Opts{Mappings: []Mapping{
+		{Name: "api.latency", TopK: latency, Labels: []string{"endpoint"}},
+		{Name: "api.*", TopK: requests, Labels: []string{NameLabel, "user"}},
+	}})
+
+	for _, line := range []string{
+		"api.requests:1|c|#user:alice",
+		"api.requests:1|c|@0.5|#user:alice,region:eu",
+		"api.errors:2|c|#user:bob",
+		"api.latency:120|ms|#endpoint:/a",
+		"api.latency:80|ms|@0.1|#endpoint:/a",
+		"api.connections:5|g",
+		"other.requests:1|c",
+	} {
+		if err := s.HandleLine(line); err != nil {
+			t.Errorf("%q: %v", line, err)
+		}
+	}
+
+	for _, c := range []struct {
+		t      topk.TopK
+		labels prometheus.Labels
+		want   float64
+	}{
+		{requests, prometheus.Labels{"metric": "api.requests", "user": "alice"}, 3},
+		{requests, prometheus.Labels{"metric": "api.errors", "user": "bob"}, 2},
+		{latency, prometheus.Labels{"endpoint": "/a"}, 200},
+	} {
+		if count, _, _ := c.t.Estimate(c.labels); count != c.want {
+			t.Errorf("%v: got %v expected %v", c.labels, count, c.want)
+		}
+	}
+	if n := len(requests.Snapshot()); n != 2 {
+		t.Errorf("gauge or unmapped metric was recorded: %v", requests.Snapshot())
+	}
+
+	for _, line := range []string{"nocolon", "a:1", "a:x|c", "a:1|q", "a:1|c|@2"} {
+		if err := s.HandleLine(line); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestServePacket(t *testing.T) {
+	requests := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 10}, []string{"user"})
+	s := NewServer(Opts{Mappings: []Mapping{{Name: "requests", TopK: requests, Labels: []string{"user"}}}})
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.ServePacket(conn) }()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("requests:1|c|#user:alice\nrequests:2|c|#user:alice\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if count, _, _ := requests.Estimate(prometheus.Labels{"user": "alice"}); count == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("packet was not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	conn.Close()
+	if err := <-done; err != nil {
+		t.Errorf("ServePacket: %v", err)
+	}
+}
@@ -0,0 +1,579 @@
+// Package topkstream implements the Filtered Space-Saving TopK streaming
+// algorithm, estimating the most frequent keys of a stream and their counts
+// in a fixed amount of memory. It is the summary structure of the Prometheus
+// TopK, and can be used on its own.
+//
+// A Stream is not safe for concurrent use. Its exported API is stable: the
+// encoding of MarshalBinary can be decoded by later versions.
+/*
+
+The original Space-Saving algorithm:
+https://icmi.cs.ucsb.edu/research/tech_reports/reports/2005-23.pdf
+
+The Filtered Space-Saving enhancement:
+http://www.l2f.inesc-id.pt/~fmmb/wiki/uploads/Work/misnis.ref0a.pdf
+
+This implementation follows the algorithm of the FSS paper, but not the
+suggested implementation.  Specifically, we use a heap instead of a sorted list
+of monitored items, and since we are also using a map to provide O(1) access on
+update also don't need the c_i counters in the hash table.
+
+Licensed under the MIT license.
+
+*/
+package topkstream
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"math"
+	"sort"
+	"unsafe"
+
+	"github.com/dgryski/go-sip13"
+)
+
+// Element is a TopK item
+type Element struct {
+	Key string
+	// Count is an upper bound of the total count of the key.
+	Count float64
+	// Error is the most Count may overestimate the total count of the key
+	// by, so that Count-Error is a lower bound.
+	Error float64
+}
+
+type elementsByCountDescending []Element
+
+func (elts elementsByCountDescending) Len() int { return len(elts) }
+func (elts elementsByCountDescending) Less(i, j int) bool {
+	return (elts[i].Count > elts[j].Count) || (elts[i].Count == elts[j].Count && elts[i].Key < elts[j].Key)
+}
+func (elts elementsByCountDescending) Swap(i, j int) { elts[i], elts[j] = elts[j], elts[i] }
+
+type keys struct {
+	m    map[string]int
+	elts []Element
+}
+
+// Implement the container/heap interface
+
+func (tk *keys) Len() int { return len(tk.elts) }
+func (tk *keys) Less(i, j int) bool {
+	return (tk.elts[i].Count < tk.elts[j].Count) || (tk.elts[i].Count == tk.elts[j].Count && tk.elts[i].Error > tk.elts[j].Error)
+}
+func (tk *keys) Swap(i, j int) {
+
+	tk.elts[i], tk.elts[j] = tk.elts[j], tk.elts[i]
+
+	tk.m[tk.elts[i].Key] = i
+	tk.m[tk.elts[j].Key] = j
+}
+
+func (tk *keys) Push(x interface{}) {
+	e := x.(Element)
+	tk.m[e.Key] = len(tk.elts)
+	tk.elts = append(tk.elts, e)
+}
+
+func (tk *keys) Pop() interface{} {
+	var e Element
+	e, tk.elts = tk.elts[len(tk.elts)-1], tk.elts[:len(tk.elts)-1]
+
+	delete(tk.m, e.Key)
+
+	return e
+}
+
+// Stream calculates the TopK elements for a stream.
+//
+// This type has been modified from the original; it has been changed to use floating-point counters.
+type Stream struct {
+	n      int
+	k      keys
+	alphas []float64
+	cum    float64
+
+	onEvict func(key string)
+	// nil for the default of SipHash-1-3
+	hash func(key string) uint64
+}
+
+// DefaultCountersPerElement is the number of error estimates of a Stream
+// for each element it monitors, the multiplicative constant from the paper.
+const DefaultCountersPerElement = 6
+
+// NewStream returns a Stream estimating the top n most frequent elements
+func NewStream(n int) *Stream {
+	return NewStreamWithCounters(n, n*DefaultCountersPerElement)
+}
+
+// NewStreamWithCounters returns a Stream estimating the top n most frequent
+// elements, with the given number of error estimates for the elements it does
+// not monitor. More counters use more memory, but make new elements less
+// likely to share the error of an old one, so that fewer elements are
+// monitored with a large error. Resize keeps the number of counters
+// proportional to n.
+func NewStreamWithCounters(n, counters int) *Stream {
+	return &Stream{
+		n:      n,
+		k:      keys{m: make(map[string]int), elts: make([]Element, 0, n)},
+		alphas: make([]float64, max(counters, 1)),
+	}
+}
+
+// SetHash changes the function hashing the keys to their error estimates,
+// which is SipHash-1-3 by default. It must be called before the first Insert,
+// or right after restoring a state built with the same function, since the
+// error estimates of a stream are only meaningful for the hash they were
+// built with. The Merge of two streams also requires the same function.
+func (s *Stream) SetHash(hash func(key string) uint64) {
+	s.hash = hash
+}
+
+func (s *Stream) hashOf(x string) uint64 {
+	if s.hash != nil {
+		return s.hash(x)
+	}
+	return sip13.Sum64Str(0, 0, x)
+}
+
+func reduce(x uint64, n int) uint32 {
+	return uint32(uint64(uint32(x)) * uint64(n) >> 32)
+}
+
+// Insert adds an element to the stream to be tracked
+// It returns an estimation for the just inserted element
+func (s *Stream) Insert(x string, count float64) Element {
+	// Protect against poison floats by setting them to 0.
+	if math.IsNaN(count) {
+		count = 0
+	}
+
+	xhash := reduce(s.hashOf(x), len(s.alphas))
+
+	// track cumulative sum
+	s.cum += count
+
+	// are we tracking this element?
+	if idx, ok := s.k.m[x]; ok {
+		s.k.elts[idx].Count += count
+		e := s.k.elts[idx]
+		heap.Fix(&s.k, idx)
+		return e
+	}
+
+	// can we track more elements?
+	if len(s.k.elts) < s.n {
+		// there is free space
+		e := Element{Key: x, Count: count}
+		heap.Push(&s.k, e)
+		return e
+	}
+
+	if s.alphas[xhash]+count < s.k.elts[0].Count {
+		e := Element{
+			Key:   x,
+			Error: s.alphas[xhash],
+			Count: s.alphas[xhash] + count,
+		}
+		s.alphas[xhash] += count
+		return e
+	}
+
+	// replace the current minimum element
+	minKey := s.k.elts[0].Key
+
+	mkhash := reduce(s.hashOf(minKey), len(s.alphas))
+	s.alphas[mkhash] = s.k.elts[0].Count
+
+	e := Element{
+		Key:   x,
+		Error: s.alphas[xhash],
+		Count: s.alphas[xhash] + count,
+	}
+	s.k.elts[0] = e
+
+	// we're not longer monitoring minKey
+	delete(s.k.m, minKey)
+	// but 'x' is as array position 0
+	s.k.m[x] = 0
+
+	heap.Fix(&s.k, 0)
+	if s.onEvict != nil {
+		s.onEvict(minKey)
+	}
+	return e
+}
+
+// Set sets the count of x, such as the current value of a gauge, instead of
+// adding to it. If x is not monitored, it replaces the minimum element if
+// count is larger, and is not recorded otherwise. The count of an element
+// set while it is monitored has no error, and Total changes by the difference
+// between the counts of the monitored elements, so that it is their sum if
+// only Set is used.
+func (s *Stream) Set(x string, count float64) Element {
+	if math.IsNaN(count) {
+		count = 0
+	}
+
+	if idx, ok := s.k.m[x]; ok {
+		s.cum += count - s.k.elts[idx].Count
+		s.k.elts[idx] = Element{Key: x, Count: count}
+		heap.Fix(&s.k, idx)
+		return Element{Key: x, Count: count}
+	}
+
+	e := Element{Key: x, Count: count}
+	if len(s.k.elts) < s.n {
+		s.cum += count
+		heap.Push(&s.k, e)
+		return e
+	}
+	if count <= s.k.elts[0].Count {
+		return e
+	}
+
+	minKey := s.k.elts[0].Key
+	s.cum += count - s.k.elts[0].Count
+	s.k.elts[0] = e
+	delete(s.k.m, minKey)
+	s.k.m[x] = 0
+	heap.Fix(&s.k, 0)
+	if s.onEvict != nil {
+		s.onEvict(minKey)
+	}
+	return e
+}
+
+// OnEvict registers a function to be called with the key of every element
+// that is displaced from the set of monitored elements by Insert.
+func (s *Stream) OnEvict(f func(key string)) {
+	s.onEvict = f
+}
+
+// Remove stops monitoring x, returning false if x was not monitored. The
+// count of x is discarded; it does not contribute to the error of later
+// elements.
+func (s *Stream) Remove(x string) bool {
+	idx, ok := s.k.m[x]
+	if !ok {
+		return false
+	}
+	heap.Remove(&s.k, idx)
+	return true
+}
+
+// Reset discards all counts, returning the stream to its initial state.
+func (s *Stream) Reset() {
+	s.k.m = make(map[string]int)
+	s.k.elts = s.k.elts[:0]
+	for i := range s.alphas {
+		s.alphas[i] = 0
+	}
+	s.cum = 0
+}
+
+// Monitored reports whether x is currently in the set of monitored elements.
+func (s *Stream) Monitored(x string) bool {
+	_, ok := s.k.m[x]
+	return ok
+}
+
+// Capacity returns the number of elements the stream monitors once it is full.
+func (s *Stream) Capacity() int {
+	return s.n
+}
+
+// Total returns the sum of all counts inserted into the stream, including the
+// counts of the elements that are not monitored.
+func (s *Stream) Total() float64 {
+	return s.cum
+}
+
+// MemoryUsage returns an estimate of the number of bytes used by the stream,
+// including the keys of the monitored elements.
+func (s *Stream) MemoryUsage() uint64 {
+	const (
+		streamSize  = uint64(unsafe.Sizeof(Stream{}))
+		elementSize = uint64(unsafe.Sizeof(Element{}))
+		// a string key and an int value, with the amortized overhead of
+		// the map buckets
+		mapEntrySize = uint64(unsafe.Sizeof("")+unsafe.Sizeof(0)) * 3 / 2
+	)
+	n := streamSize + uint64(cap(s.k.elts))*elementSize + uint64(len(s.alphas))*8
+	for _, e := range s.k.elts {
+		// the key is shared by the element and the map
+		n += uint64(len(e.Key)) + mapEntrySize
+	}
+	return n
+}
+
+// Keys returns the current estimates for the most frequent elements
+func (s *Stream) Keys() []Element {
+	elts := append([]Element(nil), s.k.elts...)
+	sort.Sort(elementsByCountDescending(elts))
+	return elts
+}
+
+// Range calls f for each of the monitored elements, in no particular order,
+// until f returns false.
+func (s *Stream) Range(f func(Element) bool) {
+	for _, e := range s.k.elts {
+		if !f(e) {
+			return
+		}
+	}
+}
+
+// Estimate returns an estimate for the item x
+func (s *Stream) Estimate(x string) Element {
+	xhash := reduce(s.hashOf(x), len(s.alphas))
+
+	// are we tracking this element?
+	if idx, ok := s.k.m[x]; ok {
+		e := s.k.elts[idx]
+		return e
+	}
+	count := s.alphas[xhash]
+	e := Element{
+		Key:   x,
+		Error: count,
+		Count: count,
+	}
+	return e
+}
+
+// Merge adds the counts of other into s, as if all the elements inserted into
+// other had been inserted into s. Both streams must have the same capacity.
+//
+// The count of every monitored element of either stream is combined with the
+// estimate of the other stream, and the n largest of these become the
+// monitored elements. The error estimates of unmonitored elements are added,
+// and raised to the count of any element that is no longer monitored, so
+// that they remain upper bounds.
+func (s *Stream) Merge(other *Stream) error {
+	if other.n != s.n || len(other.alphas) != len(s.alphas) {
+		return errors.New("topk: cannot merge streams of different capacities")
+	}
+	otherElts, otherAlphas, otherCum := other.State()
+
+	cand := make([]Element, 0, len(s.k.elts)+len(otherElts))
+	for _, e := range s.k.elts {
+		o := other.Estimate(e.Key)
+		cand = append(cand, Element{Key: e.Key, Count: e.Count + o.Count, Error: e.Error + o.Error})
+	}
+	for _, o := range otherElts {
+		if _, ok := s.k.m[o.Key]; ok {
+			continue
+		}
+		e := s.Estimate(o.Key)
+		cand = append(cand, Element{Key: o.Key, Count: e.Count + o.Count, Error: e.Error + o.Error})
+	}
+	for i := range s.alphas {
+		s.alphas[i] += otherAlphas[i]
+	}
+	s.cum += otherCum
+
+	sort.Sort(elementsByCountDescending(cand))
+	kept := cand
+	if len(kept) > s.n {
+		kept = cand[:s.n]
+	}
+	var evicted []string
+	for _, e := range cand[len(kept):] {
+		xhash := reduce(s.hashOf(e.Key), len(s.alphas))
+		if e.Count > s.alphas[xhash] {
+			s.alphas[xhash] = e.Count
+		}
+		if _, ok := s.k.m[e.Key]; ok {
+			evicted = append(evicted, e.Key)
+		}
+	}
+
+	s.k.m = make(map[string]int, len(kept))
+	s.k.elts = s.k.elts[:0]
+	for _, e := range kept {
+		s.k.m[e.Key] = len(s.k.elts)
+		s.k.elts = append(s.k.elts, e)
+	}
+	heap.Init(&s.k)
+
+	if s.onEvict != nil {
+		for _, key := range evicted {
+			s.onEvict(key)
+		}
+	}
+	return nil
+}
+
+// Resize changes the capacity of the stream to n. If the stream has more than
+// n monitored elements, only the n with the highest counts stay monitored,
+// and the others are reported to the OnEvict function.
+//
+// The error estimates of unmonitored elements are rebuilt for the new
+// capacity, each from the maximum of the old estimates it covers, so that they
+// remain upper bounds.
+func (s *Stream) Resize(n int) {
+	if n <= 0 || n == s.n {
+		return
+	}
+	elts := append([]Element(nil), s.k.elts...)
+	sort.Sort(elementsByCountDescending(elts))
+	var evicted []string
+	if len(elts) > n {
+		for _, e := range elts[n:] {
+			xhash := reduce(s.hashOf(e.Key), len(s.alphas))
+			if e.Count > s.alphas[xhash] {
+				s.alphas[xhash] = e.Count
+			}
+			evicted = append(evicted, e.Key)
+		}
+		elts = elts[:n]
+	}
+
+	// reduce maps the hash range [i<<32/len, (i+1)<<32/len) to index i, so
+	// every new index covers a contiguous range of old indexes
+	old := s.alphas
+	s.alphas = make([]float64, max((len(old)*n+s.n/2)/s.n, 1))
+	for i, a := range old {
+		lo := uint64(i) * uint64(len(s.alphas)) / uint64(len(old))
+		hi := (uint64(i+1)*uint64(len(s.alphas)) - 1) / uint64(len(old))
+		for j := lo; j <= hi; j++ {
+			if a > s.alphas[j] {
+				s.alphas[j] = a
+			}
+		}
+	}
+
+	s.n = n
+	s.k.m = make(map[string]int, len(elts))
+	s.k.elts = make([]Element, 0, n)
+	for _, e := range elts {
+		s.k.m[e.Key] = len(s.k.elts)
+		s.k.elts = append(s.k.elts, e)
+	}
+	heap.Init(&s.k)
+
+	if s.onEvict != nil {
+		for _, key := range evicted {
+			s.onEvict(key)
+		}
+	}
+}
+
+// Scale multiplies all counts and error estimates by f, which must be
+// positive, such as to decay the counts of past elements.
+func (s *Stream) Scale(f float64) {
+	for i := range s.k.elts {
+		s.k.elts[i].Count *= f
+		s.k.elts[i].Error *= f
+	}
+	for i := range s.alphas {
+		s.alphas[i] *= f
+	}
+	s.cum *= f
+}
+
+// State returns copies of the monitored elements, in no particular order, and
+// of the error estimates of unmonitored elements, along with the sum of all
+// counts inserted into the stream.
+func (s *Stream) State() (elts []Element, alphas []float64, total float64) {
+	elts = append([]Element(nil), s.k.elts...)
+	alphas = append([]float64(nil), s.alphas...)
+	return elts, alphas, s.cum
+}
+
+// NewStreamFromState returns a Stream of capacity n with the given state, as
+// returned by State.
+func NewStreamFromState(n int, elts []Element, alphas []float64, total float64) (*Stream, error) {
+	if n <= 0 || len(elts) > n || len(alphas) == 0 {
+		return nil, errors.New("topk: invalid stream state")
+	}
+	s := &Stream{
+		n:      n,
+		k:      keys{m: make(map[string]int, len(elts)), elts: make([]Element, 0, n)},
+		alphas: append([]float64(nil), alphas...),
+		cum:    total,
+	}
+	for _, e := range elts {
+		if _, dup := s.k.m[e.Key]; dup {
+			return nil, errors.New("topk: duplicate key in stream state")
+		}
+		s.k.m[e.Key] = len(s.k.elts)
+		s.k.elts = append(s.k.elts, e)
+	}
+	heap.Init(&s.k)
+	return s, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the monitored
+// elements and the error estimates. The hash function and the OnEvict
+// function are not included.
+func (s *Stream) MarshalBinary() ([]byte, error) {
+	return s.GobEncode()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, replacing the state
+// of the stream with the one encoded by MarshalBinary, including its capacity.
+func (s *Stream) UnmarshalBinary(data []byte) error {
+	return s.GobDecode(data)
+}
+
+// GobEncode implements gob.GobEncoder, like MarshalBinary.
+func (s *Stream) GobEncode() ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(s.n); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(s.k.m); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(s.k.elts); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(s.alphas); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(s.cum); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, like UnmarshalBinary.
+func (s *Stream) GobDecode(b []byte) error {
+	dec := gob.NewDecoder(bytes.NewBuffer(b))
+	if err := dec.Decode(&s.n); err != nil {
+		return err
+	}
+	if err := dec.Decode(&s.k.m); err != nil {
+		return err
+	}
+	if err := dec.Decode(&s.k.elts); err != nil {
+		return err
+	}
+	if err := dec.Decode(&s.alphas); err != nil {
+		return err
+	}
+	if err := dec.Decode(&s.cum); err != nil {
+		return err
+	}
+
+	if s.n <= 0 || len(s.k.elts) > s.n || len(s.alphas) == 0 {
+		return errors.New("topk: invalid stream encoding")
+	}
+	// rebuild the index and the heap, in case the encoding is inconsistent
+	s.k.m = make(map[string]int, len(s.k.elts))
+	for i, e := range s.k.elts {
+		if _, dup := s.k.m[e.Key]; dup {
+			return errors.New("topk: duplicate key in stream encoding")
+		}
+		s.k.m[e.Key] = i
+	}
+	heap.Init(&s.k)
+	return nil
+}
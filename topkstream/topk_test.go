@@ -0,0 +1,390 @@
+package topkstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type freqs struct {
+	keys   []string
+	counts map[string]float64
+}
+
+func (f freqs) Len() int { return len(f.keys) }
+
+// Actually 'Greater', since we want decreasing
+func (f *freqs) Less(i, j int) bool {
+	return f.counts[f.keys[i]] > f.counts[f.keys[j]] || f.counts[f.keys[i]] == f.counts[f.keys[j]] && f.keys[i] < f.keys[j]
+}
+
+func (f *freqs) Swap(i, j int) { f.keys[i], f.keys[j] = f.keys[j], f.keys[i] }
+
+func TestTopK(t *testing.T) {
+
+	f, err := os.Open("testdata/domains.txt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(f)
+
+	tk := NewStream(100)
+	exact := make(map[string]float64)
+
+	for scanner.Scan() {
+
+		item := scanner.Text()
+
+		exact[item]++
+		e := tk.Insert(item, 1)
+		if e.Count < exact[item] {
+			t.Errorf("estimate lower than exact: key=%v, exact=%v, estimate=%v", e.Key, exact[item], e.Count)
+		}
+		if e.Count-e.Error > exact[item] {
+			t.Errorf("error bounds too large: key=%v, count=%v, error=%v, exact=%v", e.Key, e.Count, e.Error, exact[item])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Println("error during scan: ", err)
+	}
+
+	var keys []string
+
+	for k, _ := range exact {
+		keys = append(keys, k)
+	}
+
+	freq := &freqs{keys: keys, counts: exact}
+
+	sort.Sort(freq)
+
+	top := tk.Keys()
+
+	// at least the top 25 must be in order
+	for i := 0; i < 25; i++ {
+		if top[i].Key != freq.keys[i] {
+			t.Errorf("key mismatch: idx=%d top=%s (%v) exact=%s (%v)", i, top[i].Key, top[i].Count, freq.keys[i], freq.counts[freq.keys[i]])
+		}
+	}
+	for k, v := range exact {
+		e := tk.Estimate(k)
+		if e.Count < v {
+			t.Errorf("estimate lower than exact: key=%v, exact=%v, estimate=%v", e.Key, v, e.Count)
+		}
+		if e.Count-e.Error > v {
+			t.Errorf("error bounds too large: key=%v, count=%v, error=%v, exact=%v", e.Key, e.Count, e.Error, v)
+		}
+	}
+	for _, k := range top {
+		e := tk.Estimate(k.Key)
+		if e != k {
+			t.Errorf("estimate differs from top keys: key=%v, estimate=%v(-%v) top=%v(-%v)", e.Key, e.Count, e.Error, k.Count, k.Error)
+		}
+	}
+
+	// gob
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(tk); err != nil {
+		t.Error(err)
+	}
+
+	decoded := NewStream(100)
+	dec := gob.NewDecoder(&buf)
+	if err := dec.Decode(decoded); err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(tk, decoded) {
+		t.Error("they are not equal.")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	tk := NewStream(2)
+
+	var evicted []string
+	tk.OnEvict(func(key string) {
+		evicted = append(evicted, key)
+	})
+
+	tk.Insert("a", 3)
+	tk.Insert("b", 1)
+	tk.Insert("c", 2)
+
+	if !reflect.DeepEqual(evicted, []string{"b"}) {
+		t.Errorf("wrong evictions: got %v", evicted)
+	}
+	if tk.Monitored("b") {
+		t.Error("evicted key is still monitored")
+	}
+	if !tk.Monitored("a") || !tk.Monitored("c") {
+		t.Error("expected a and c to be monitored")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	f, err := os.Open("testdata/domains.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	streams := []*Stream{NewStream(100), NewStream(100)}
+	exact := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for i := 0; scanner.Scan(); i++ {
+		item := scanner.Text()
+		exact[item]++
+		streams[i%2].Insert(item, 1)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	merged, other := streams[0], streams[1]
+	var evicted int
+	merged.OnEvict(func(string) { evicted++ })
+	if err := merged.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Keys()) != 100 {
+		t.Errorf("wrong number of keys after merge: %d", len(merged.Keys()))
+	}
+	if evicted == 0 {
+		t.Error("expected evictions")
+	}
+	for k, v := range exact {
+		e := merged.Estimate(k)
+		if e.Count < v {
+			t.Errorf("estimate lower than exact: key=%v, exact=%v, estimate=%v", e.Key, v, e.Count)
+		}
+		if e.Count-e.Error > v {
+			t.Errorf("error bounds too large: key=%v, count=%v, error=%v, exact=%v", e.Key, e.Count, e.Error, v)
+		}
+	}
+
+	if err := merged.Merge(NewStream(10)); err == nil {
+		t.Error("expected error for different capacities")
+	}
+}
+
+func TestState(t *testing.T) {
+	tk := NewStream(2)
+	tk.Insert("a", 3)
+	tk.Insert("b", 1)
+	tk.Insert("c", 2)
+
+	elts, alphas, total := tk.State()
+	restored, err := NewStreamFromState(2, elts, alphas, total)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tk.Keys(), restored.Keys()) {
+		t.Errorf("keys differ: %v != %v", tk.Keys(), restored.Keys())
+	}
+	if e, r := tk.Estimate("b"), restored.Estimate("b"); e != r {
+		t.Errorf("estimates differ: %v != %v", e, r)
+	}
+
+	if _, err := NewStreamFromState(1, elts, alphas, total); err == nil {
+		t.Error("expected error for too many elements")
+	}
+	if _, err := NewStreamFromState(2, []Element{{Key: "a"}, {Key: "a"}}, alphas, total); err == nil {
+		t.Error("expected error for duplicate keys")
+	}
+}
+
+func TestRemoveReset(t *testing.T) {
+	tk := NewStream(3)
+	tk.Insert("a", 3)
+	tk.Insert("b", 1)
+	tk.Insert("c", 2)
+
+	if !tk.Remove("b") || tk.Remove("b") {
+		t.Error("Remove should succeed exactly once")
+	}
+	keys := tk.Keys()
+	if len(keys) != 2 || keys[0].Key != "a" || keys[1].Key != "c" {
+		t.Errorf("wrong keys after Remove: %v", keys)
+	}
+	for _, e := range keys {
+		if tk.Estimate(e.Key) != e {
+			t.Errorf("index broken after Remove: %v", e)
+		}
+	}
+
+	tk.Reset()
+	if len(tk.Keys()) != 0 || tk.Monitored("a") {
+		t.Error("Reset did not clear the stream")
+	}
+	if !reflect.DeepEqual(tk, NewStream(3)) {
+		t.Error("Reset stream differs from a new stream")
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	tk := NewStream(10)
+	empty := tk.MemoryUsage()
+	if empty < 10*6*8 {
+		t.Errorf("memory usage %d does not cover the error estimates", empty)
+	}
+	tk.Insert("0123456789", 1)
+	if grown := tk.MemoryUsage(); grown < empty+10 {
+		t.Errorf("memory usage %d did not grow by the key size from %d", grown, empty)
+	}
+}
+
+func TestResize(t *testing.T) {
+	f, err := os.Open("testdata/domains.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tk := NewStream(100)
+	exact := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		exact[scanner.Text()]++
+		tk.Insert(scanner.Text(), 1)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	top := tk.Keys()
+	var evicted int
+	tk.OnEvict(func(string) { evicted++ })
+	tk.Resize(20)
+	if evicted != 80 || tk.Capacity() != 20 || len(tk.Keys()) != 20 {
+		t.Errorf("got %d evictions and %d keys after shrinking", evicted, len(tk.Keys()))
+	}
+	if !reflect.DeepEqual(tk.Keys(), top[:20]) {
+		t.Error("the top keys changed after shrinking")
+	}
+	for _, size := range []int{20, 150} {
+		tk.Resize(size)
+		for k, v := range exact {
+			e := tk.Estimate(k)
+			if e.Count < v {
+				t.Errorf("estimate lower than exact after resizing to %d: key=%v, exact=%v, estimate=%v", size, e.Key, v, e.Count)
+			}
+		}
+	}
+	tk.Insert("new", 1)
+	if !tk.Monitored("new") {
+		t.Error("a grown stream should monitor new keys")
+	}
+}
+
+func TestSetHash(t *testing.T) {
+	tk := NewStream(1)
+	tk.SetHash(func(string) uint64 { return 0 })
+	tk.Insert("a", 3)
+	tk.Insert("b", 1)
+
+	// with a constant hash, every untracked key shares one error estimate
+	if e := tk.Estimate("c"); e.Count != 1 {
+		t.Errorf("wrong estimate for untracked key: %v", e)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	tk := NewStream(2)
+	tk.Insert("a", 3)
+	tk.Insert("b", 1)
+	tk.Insert("c", 2)
+
+	data, err := tk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := NewStream(10)
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tk, decoded) {
+		t.Error("they are not equal.")
+	}
+	if err := decoded.UnmarshalBinary(data[:len(data)/2]); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func TestNewStreamWithCounters(t *testing.T) {
+	tk := NewStreamWithCounters(2, 1)
+	tk.Insert("a", 3)
+	tk.Insert("b", 2)
+	// with a single counter, every unmonitored element shares its error
+	tk.Insert("c", 1)
+	if e := tk.Estimate("d"); e.Count != 1 {
+		t.Errorf("wrong estimate for unmonitored element: %v", e)
+	}
+
+	tk = NewStreamWithCounters(10, 100)
+	tk.Resize(5)
+	if _, alphas, _ := tk.State(); len(alphas) != 50 {
+		t.Errorf("got %d counters after resizing, expected 50", len(alphas))
+	}
+}
+
+func TestScale(t *testing.T) {
+	tk := NewStream(1)
+	tk.Insert("a", 4)
+	tk.Insert("b", 2)
+	tk.Scale(0.5)
+	if e := tk.Estimate("a"); e.Count != 2 || e.Error != 0 {
+		t.Errorf("wrong estimate for a after scaling: %v", e)
+	}
+	if e := tk.Estimate("b"); e.Count != 1 {
+		t.Errorf("wrong estimate for b after scaling: %v", e)
+	}
+	if _, _, total := tk.State(); total != 3 {
+		t.Errorf("wrong total after scaling: %v", total)
+	}
+}
+
+func TestTotal(t *testing.T) {
+	s := NewStream(1)
+	s.Insert("a", 2)
+	s.Insert("b", 3)
+	if got := s.Total(); got != 5 {
+		t.Errorf("got total %v expected 5", got)
+	}
+	s.Scale(0.5)
+	if got := s.Total(); got != 2.5 {
+		t.Errorf("got total %v expected 2.5 after scaling", got)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewStream(2)
+	s.Set("a", 5)
+	s.Set("b", 3)
+	s.Set("a", 1)
+	if e := s.Estimate("a"); e.Count != 1 || e.Error != 0 {
+		t.Errorf("got %+v after setting a tracked key", e)
+	}
+
+	// a smaller value than the minimum is not recorded
+	s.Set("c", 1)
+	if s.Monitored("c") {
+		t.Error("c replaced a key with a larger value")
+	}
+	s.Set("c", 4)
+	if !s.Monitored("c") || s.Monitored("a") {
+		t.Errorf("c did not replace a: %v", s.Keys())
+	}
+	if got := s.Total(); got != 7 {
+		t.Errorf("got total %v expected 7", got)
+	}
+}
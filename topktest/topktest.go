@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package topktest provides helpers for testing code that records into a
+// TopK. Since the counts of a TopK are estimates, the comparisons accept any
+// count within the error bounds; for exact output, create the TopK with
+// TopKOpts.Deterministic.
+package topktest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	topk "github.com/riking/go-prometheus-topk"
+	"github.com/riking/go-prometheus-topk/topkpb"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectToMap gathers the exported counts of k into a map keyed by the label
+// set of each key, formatted like `a="x",b="y"` with the label names sorted,
+// without the constant labels. Like a scrape, it leaves out the keys under the
+// reporting threshold.
+func CollectToMap(k topk.TopK) (map[string]float64, error) {
+	snap := k.SnapshotProto()
+	variable := make(map[string]bool, len(snap.GetLabelNames()))
+	for _, name := range snap.GetLabelNames() {
+		variable[name] = true
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(k); err != nil {
+		return nil, err
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]float64)
+	for _, mf := range mfs {
+		if mf.GetName() != countName(snap) {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(prometheus.Labels, len(variable))
+			for _, lp := range m.GetLabel() {
+				if variable[lp.GetName()] {
+					labels[lp.GetName()] = lp.GetValue()
+				}
+			}
+			value := m.GetCounter().GetValue()
+			if m.GetGauge() != nil {
+				value = m.GetGauge().GetValue()
+			}
+			out[FormatLabels(labels)] = value
+		}
+	}
+	return out, nil
+}
+
+// countName returns the name of the count metric of the TopK saved in snap,
+// which has the Unit as a suffix, and with PromlintNames ends with _total
+// if the counts are exported as counters.
+func countName(snap *topkpb.Snapshot) string {
+	name := snap.GetName()
+	if snap.GetUnit() != "" {
+		name += "_" + snap.GetUnit()
+	}
+	counter := topk.Mode(snap.GetMode()) == topk.ModeSum && snap.GetHalfLifeNs() <= 0 &&
+		topk.DecrementPolicy(snap.GetDecrementPolicy()) == topk.DecrementPolicyDisallow
+	if snap.GetPromlintNames() && counter {
+		name += "_total"
+	}
+	return name
+}
+
+// FormatLabels formats labels like the keys of CollectToMap.
+func FormatLabels(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[name]))
+	}
+	return sb.String()
+}
+
+// TopKeys returns the labels of the n tracked keys of k with the highest
+// counts, or of all of them if it tracks fewer, in decreasing order.
+func TopKeys(k topk.TopK, n int) []prometheus.Labels {
+	elts := k.TopN(n)
+	out := make([]prometheus.Labels, len(elts))
+	for i, e := range elts {
+		out[i] = e.Labels
+	}
+	return out
+}
+
+// AssertTopKeys fails the test unless the len(expected) tracked keys of k
+// with the highest counts have the expected labels, in order.
+func AssertTopKeys(t testing.TB, k topk.TopK, expected ...prometheus.Labels) {
+	t.Helper()
+	if got := TopKeys(k, len(expected)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got top keys %s, expected %s", formatList(got), formatList(expected))
+	}
+}
+
+// WithinBounds reports whether want is a possible true count of the key of e,
+// between e.Count-e.Error and e.Count.
+func WithinBounds(e topk.Element, want float64) bool {
+	return e.Count-e.Error <= want && want <= e.Count
+}
+
+// AssertCount fails the test unless want is a possible true count of the key
+// with the given labels, within the error bounds of its estimate.
+func AssertCount(t testing.TB, k topk.TopK, labels prometheus.Labels, want float64) {
+	t.Helper()
+	count, err, _ := k.Estimate(labels)
+	if !WithinBounds(topk.Element{Labels: labels, Count: count, Error: err}, want) {
+		t.Errorf("count of {%s} is %v, error %v: %v is not between %v and %v",
+			FormatLabels(labels), count, err, want, count-err, count)
+	}
+}
+
+func formatList(ls []prometheus.Labels) string {
+	parts := make([]string, len(ls))
+	for i, l := range ls {
+		parts[i] = "{" + FormatLabels(l) + "}"
+	}
+	return fmt.Sprintf("[%s]", strings.Join(parts, " "))
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topktest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	topk "github.com/riking/go-prometheus-topk"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recorder is a testing.TB recording the failures instead of failing.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func newTopK() topk.TopK {
+	k := topk.NewTopK(topk.TopKOpts{
+		Name:               "requests",
+		Buckets:            3,
+		ConstLabels:        prometheus.Labels{"zone": "a"},
+		ReportingThreshold: 2,
+		Deterministic:      true,
+	}, []string{"path", "code"})
+	k.WithLabelValues("/a", "200").Add(5)
+	k.WithLabelValues("/b", "500").Add(3)
+	k.WithLabelValues("/c", "200").Add(1)
+	return k
+}
+
+func TestCollectToMap(t *testing.T) {
+	got, err := CollectToMap(newTopK())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{
+		`code="200",path="/a"`: 5,
+		`code="500",path="/b"`: 3,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+}
+
+func TestCollectToMapNames(t *testing.T) {
+	for name, opts := range map[string]topk.TopKOpts{
+		"unit":     {Name: "request", Unit: "bytes", Buckets: 3},
+		"promlint": {Name: "request", Unit: "bytes", PromlintNames: true, Buckets: 3},
+		"gauge":    {Name: "request", PromlintNames: true, HalfLife: time.Hour, Buckets: 3},
+	} {
+		k := topk.NewTopK(opts, []string{"path"})
+		k.WithLabelValues("/a").Add(5)
+		got, err := CollectToMap(k)
+		k.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[`path="/a"`] <= 0 {
+			t.Errorf("%s: got %v expected the count of /a", name, got)
+		}
+	}
+}
+
+func TestAssertTopKeys(t *testing.T) {
+	k := newTopK()
+	AssertTopKeys(t, k,
+		prometheus.Labels{"path": "/a", "code": "200"},
+		prometheus.Labels{"path": "/b", "code": "500"},
+	)
+
+	r := &recorder{}
+	AssertTopKeys(r, k, prometheus.Labels{"path": "/b", "code": "500"})
+	if len(r.errors) != 1 {
+		t.Errorf("expected a failure for the wrong top key, got %q", r.errors)
+	}
+}
+
+func TestAssertCount(t *testing.T) {
+	k := topk.NewTopK(topk.TopKOpts{Name: "requests", Buckets: 1}, []string{"path"})
+	k.WithLabelValues("/a").Add(3)
+	k.WithLabelValues("/b").Add(2)
+	// /b is not tracked, so its estimate is an upper bound
+	AssertCount(t, k, prometheus.Labels{"path": "/b"}, 2)
+	AssertCount(t, k, prometheus.Labels{"path": "/a"}, 3)
+
+	r := &recorder{}
+	AssertCount(r, k, prometheus.Labels{"path": "/b"}, 6)
+	if len(r.errors) != 1 {
+		t.Errorf("expected a failure for a count out of bounds, got %q", r.errors)
+	}
+}
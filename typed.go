@@ -0,0 +1,127 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Typed is a TopK whose label values are the fields of a struct type L, so
+// that the labels of every observation are checked at compile time:
+//
+//	type requestLabels struct {
+//		Path   string
+//		Method string
+//		Code   string `topk:"status_code"`
+//	}
+//	requests := topk.NewTyped[requestLabels](topk.TopKOpts{Name: "requests", Buckets: 20})
+//	requests.With(requestLabels{Path: "/x", Method: "GET", Code: "200"}).Inc()
+//
+// Every exported field of L must have a string kind. The label name of a field
+// is given by its "topk" struct tag, or else is the field name in snake case;
+// fields tagged "-" are skipped.
+//
+// The methods of the embedded TopK remain available, except that With and
+// Delete take an L.
+type Typed[L any] struct {
+	TopK
+	fields []int
+}
+
+// NewTyped creates a Typed TopK with the labels of L. It panics if L is not a
+// struct type with valid labels, or if the options are invalid.
+func NewTyped[L any](opts TopKOpts) *Typed[L] {
+	names, fields, err := structLabels(reflect.TypeOf((*L)(nil)).Elem())
+	if err != nil {
+		panic(err)
+	}
+	return &Typed[L]{TopK: NewTopK(opts, names), fields: fields}
+}
+
+// LabelValues returns the label values of l, in the order of the label names.
+func (t *Typed[L]) LabelValues(l L) []string {
+	v := reflect.ValueOf(l)
+	lvs := make([]string, len(t.fields))
+	for i, f := range t.fields {
+		lvs[i] = v.Field(f).String()
+	}
+	return lvs
+}
+
+// With returns the TopKBucket for the labels l. Like WithLabelValues, it
+// panics if the curried labels of the TopK make l invalid.
+func (t *Typed[L]) With(l L) TopKBucket {
+	return t.TopK.WithLabelValues(t.LabelValues(l)...)
+}
+
+// Delete stops tracking the key with the labels l, returning true if it was
+// tracked.
+func (t *Typed[L]) Delete(l L) bool {
+	return t.TopK.DeleteLabelValues(t.LabelValues(l)...)
+}
+
+// structLabels returns the label names of the struct type typ, and the
+// indexes of the corresponding fields.
+func structLabels(typ reflect.Type) ([]string, []int, error) {
+	if typ.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("topk: label type %v is not a struct", typ)
+	}
+	var (
+		names  []string
+		fields []int
+	)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, ok := f.Tag.Lookup("topk")
+		if name == "-" {
+			continue
+		}
+		if !ok || name == "" {
+			name = snakeCase(f.Name)
+		}
+		if f.Type.Kind() != reflect.String {
+			return nil, nil, fmt.Errorf("topk: label field %s of %v is not a string", f.Name, typ)
+		}
+		names = append(names, name)
+		fields = append(fields, i)
+	}
+	return names, fields, nil
+}
+
+// snakeCase converts a Go identifier like "StatusCode" or "HTTPMethod" to
+// "status_code" or "http_method".
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type method string
+
+type testLabels struct {
+	Path       string
+	HTTPMethod method
+	Code       string `topk:"status"`
+	Ignored    string `topk:"-"`
+	unexported int
+}
+
+func TestTyped(t *testing.T) {
+	k := NewTyped[testLabels](TopKOpts{Name: metricName, Buckets: 5})
+	if got, want := k.FreeLabelNames(), []string{"path", "http_method", "status"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got label names %v, expected %v", got, want)
+	}
+
+	l := testLabels{Path: "/x", HTTPMethod: "GET", Code: "200", Ignored: "y"}
+	k.With(l).Add(2)
+	if count, _, tracked := k.Estimate(prometheus.Labels{"path": "/x", "http_method": "GET", "status": "200"}); count != 2 || !tracked {
+		t.Errorf("Estimate = %v, %v", count, tracked)
+	}
+	if !k.Delete(l) || k.Delete(l) {
+		t.Error("Delete should succeed exactly once")
+	}
+}
+
+func TestTypedPanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected panic for a non-string field")
+		}
+	}()
+	NewTyped[struct{ Code int }](TopKOpts{Name: metricName})
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"Path":       "path",
+		"StatusCode": "status_code",
+		"HTTPMethod": "http_method",
+		"UserID":     "user_id",
+	} {
+		if got := snakeCase(in); got != want {
+			t.Errorf("snakeCase(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
@@ -19,8 +19,9 @@ limitations under the License.
 package topk
 
 import (
-	"bytes"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
@@ -56,17 +57,100 @@ func (r *topkCurry) CurryWith(labels prometheus.Labels) (TopK, error) {
 			if !ok {
 				continue // Label stays unset
 			}
-			newCurry = append(newCurry, curriedLabelValue{i, val})
+			v, err := r.root.labelValue(i, val)
+			if err != nil {
+				return nil, err
+			}
+			newCurry = append(newCurry, curriedLabelValue{i, intern(escapeLabelValue(v))})
 		}
 	}
 	if leftover := len(oldCurry) + len(labels) - len(newCurry); leftover > 0 {
 		return nil, fmt.Errorf("%d unknown label(s) found during currying", leftover)
 	}
 
-	return &topkCurry{
-		curry: newCurry,
-		root:  r.root,
-	}, nil
+	return r.root.newCurry(newCurry), nil
+}
+
+// newCurry returns a TopK with the given curried labels.
+func (r *topkRoot) newCurry(curry []curriedLabelValue) *topkCurry {
+	t := &topkCurry{curry: curry, root: r, children: newBucketCache()}
+	t.freeNames = t.FreeLabelNames()
+	var prefix strings.Builder
+	for i, cv := range curry {
+		if cv.index != i {
+			break
+		}
+		prefix.WriteString(cv.value)
+		prefix.WriteByte(model.SeparatorByte)
+		t.prefixLabels++
+	}
+	t.prefix = intern(prefix.String())
+	return t
+}
+
+// MustCurryWithLabelValues works as CurryWithLabelValues but panics where
+// CurryWithLabelValues would have returned an error.
+func (r *topkCurry) MustCurryWithLabelValues(lvs ...string) TopK {
+	n, err := r.CurryWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// CurryWithLabelValues curries the first len(lvs) labels that are not
+// curried yet, in the order of the label names, without building a
+// prometheus.Labels map.
+func (r *topkCurry) CurryWithLabelValues(lvs ...string) (TopK, error) {
+	if free := len(r.root.variableLabels) - len(r.curry); len(lvs) > free {
+		return nil, fmt.Errorf("received %d unrecognized labels", len(lvs)-free)
+	}
+
+	var (
+		newCurry      = make([]curriedLabelValue, 0, len(r.curry)+len(lvs))
+		oldCurry      = r.curry
+		iVals, iCurry int
+	)
+	for i := range r.root.variableLabels {
+		if iCurry < len(oldCurry) && oldCurry[iCurry].index == i {
+			newCurry = append(newCurry, oldCurry[iCurry])
+			iCurry++
+		} else if iVals < len(lvs) {
+			v, err := r.root.labelValue(i, lvs[iVals])
+			if err != nil {
+				return nil, err
+			}
+			newCurry = append(newCurry, curriedLabelValue{i, intern(escapeLabelValue(v))})
+			iVals++
+		}
+	}
+
+	return r.root.newCurry(newCurry), nil
+}
+
+// CurriedLabels returns the labels that are fixed by currying, with their
+// values.
+func (r *topkCurry) CurriedLabels() prometheus.Labels {
+	labels := make(prometheus.Labels, len(r.curry))
+	for _, cv := range r.curry {
+		labels[r.root.variableLabels[cv.index]] = unescapeLabelValue(cv.value)
+	}
+	return labels
+}
+
+// FreeLabelNames returns the names of the labels that are not curried, in the
+// order expected by WithLabelValues.
+func (r *topkCurry) FreeLabelNames() []string {
+	names := make([]string, 0, len(r.root.variableLabels)-len(r.curry))
+	iCurry := 0
+	for i, label := range r.root.variableLabels {
+		if iCurry < len(r.curry) && r.curry[iCurry].index == i {
+			iCurry++
+			continue
+		}
+		names = append(names, label)
+	}
+	return names
 }
 
 func (r *topkCurry) compositeWithLabels(labels prometheus.Labels) (string, error) {
@@ -74,13 +158,22 @@ func (r *topkCurry) compositeWithLabels(labels prometheus.Labels) (string, error
 		return "", err
 	}
 
+	size := len(r.prefix)
+	for _, v := range labels {
+		size += len(v) + 1
+	}
+	for _, cv := range r.curry[r.prefixLabels:] {
+		size += len(cv.value) + 1
+	}
 	var (
-		keyBuf bytes.Buffer
+		keyBuf strings.Builder
 		curry  = r.curry
-		iCurry int
+		iCurry = r.prefixLabels
 	)
-
-	for i, label := range r.root.variableLabels {
+	keyBuf.Grow(size)
+	keyBuf.WriteString(r.prefix)
+	for i := iCurry; i < len(r.root.variableLabels); i++ {
+		label := r.root.variableLabels[i]
 		val, ok := labels[label]
 		if iCurry < len(curry) && curry[iCurry].index == i {
 			if ok {
@@ -92,7 +185,11 @@ func (r *topkCurry) compositeWithLabels(labels prometheus.Labels) (string, error
 			if !ok {
 				return "", fmt.Errorf("label name %q missing in label map", label)
 			}
-			keyBuf.WriteString(val)
+			v, err := r.root.labelValue(i, val)
+			if err != nil {
+				return "", err
+			}
+			writeLabelValue(&keyBuf, v)
 		}
 		keyBuf.WriteByte(model.SeparatorByte)
 	}
@@ -104,17 +201,33 @@ func (r *topkCurry) compositeWithLabelValues(lvs ...string) (string, error) {
 		return "", err
 	}
 
+	// the size of the key if no value is escaped or changed by the label
+	// value pipeline, so that it is usually allocated once
+	size := len(r.prefix)
+	for _, v := range lvs {
+		size += len(v) + 1
+	}
+	for _, cv := range r.curry[r.prefixLabels:] {
+		size += len(cv.value) + 1
+	}
 	var (
-		keyBuf        bytes.Buffer
-		curry         = r.curry
-		iVals, iCurry int
+		keyBuf strings.Builder
+		curry  = r.curry
+		iVals  int
+		iCurry = r.prefixLabels
 	)
-	for i := 0; i < len(r.root.variableLabels); i++ {
+	keyBuf.Grow(size)
+	keyBuf.WriteString(r.prefix)
+	for i := iCurry; i < len(r.root.variableLabels); i++ {
 		if iCurry < len(curry) && curry[iCurry].index == i {
 			keyBuf.WriteString(curry[iCurry].value)
 			iCurry++
 		} else {
-			keyBuf.WriteString(lvs[iVals])
+			v, err := r.root.labelValue(i, lvs[iVals])
+			if err != nil {
+				return "", err
+			}
+			writeLabelValue(&keyBuf, v)
 			iVals++
 		}
 		keyBuf.WriteByte(model.SeparatorByte)
@@ -143,36 +256,144 @@ func validateLabelValues(lvs []string, expectCount int) error {
 
 // GetMetricWith implements the Vec interface.
 func (r *topkCurry) GetMetricWith(labels prometheus.Labels) (TopKBucket, error) {
-	composite, err := r.compositeWithLabels(labels)
+	b, err := r.bucketWithLabels(labels)
 	if err != nil {
 		return nil, err
 	}
-	return &topkWithLabelValues{compositeLabel: composite, root: r.root}, nil
+	return b, nil
 }
 
 // With implements the Vec interface.
 func (r *topkCurry) With(labels prometheus.Labels) TopKBucket {
-	composite, err := r.compositeWithLabels(labels)
+	b, err := r.bucketWithLabels(labels)
 	if err != nil {
 		panic(err)
 	}
-	return &topkWithLabelValues{compositeLabel: composite, root: r.root}
+	return b
 }
 
 // GetMetricWithLabelValues implements the Vec interface.
 func (r *topkCurry) GetMetricWithLabelValues(lvs ...string) (TopKBucket, error) {
-	composite, err := r.compositeWithLabelValues(lvs...)
+	b, err := r.bucketWithLabelValues(lvs)
 	if err != nil {
 		return nil, err
 	}
-	return &topkWithLabelValues{compositeLabel: composite, root: r.root}, nil
+	return b, nil
 }
 
 // WithLabelValues implements the Vec interface.
 func (r *topkCurry) WithLabelValues(lvs ...string) TopKBucket {
-	composite, err := r.compositeWithLabelValues(lvs...)
+	b, err := r.bucketWithLabelValues(lvs)
 	if err != nil {
 		panic(err)
 	}
-	return &topkWithLabelValues{compositeLabel: composite, root: r.root}
+	return b
+}
+
+// Delete implements the Vec interface. It stops tracking the key with the
+// given labels, returning true if it was tracked.
+func (r *topkCurry) Delete(labels prometheus.Labels) bool {
+	composite, err := r.compositeWithLabels(labels)
+	if err != nil {
+		return false
+	}
+	return r.root.delete(composite)
+}
+
+// DeleteLabelValues implements the Vec interface.
+func (r *topkCurry) DeleteLabelValues(lvs ...string) bool {
+	composite, err := r.compositeWithLabelValues(lvs...)
+	if err != nil {
+		return false
+	}
+	return r.root.delete(composite)
+}
+
+// DeletePartialMatch implements the Vec interface. It stops tracking all keys
+// that match the given labels, in addition to the curried labels, and
+// returns the number of deleted keys.
+func (r *topkCurry) DeletePartialMatch(labels prometheus.Labels) int {
+	match := make(map[int]string, len(labels)+len(r.curry))
+	for i, label := range r.root.variableLabels {
+		if val, ok := labels[label]; ok {
+			v, err := r.root.labelValue(i, val)
+			if err != nil {
+				return 0 // rejected label values never match
+			}
+			match[i] = escapeLabelValue(v)
+		}
+	}
+	if len(match) != len(labels) {
+		return 0 // unknown label names never match
+	}
+	for _, cv := range r.curry {
+		match[cv.index] = cv.value
+	}
+
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+
+	var deleted int
+	for _, e := range r.root.stream.Keys() {
+		split := strings.Split(e.Key, labelParseSplit)
+		matches := true
+		for i, val := range match {
+			if split[i] != val {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			r.root.stream.Remove(e.Key)
+			delete(r.root.keyState, e.Key)
+			delete(r.root.lastSeen, e.Key)
+			r.root.audit.delete(e.Key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Reset implements the Vec interface. Like for the Prometheus Vec types, it
+// discards all counts of the root TopK, even if called on a curried TopK.
+func (r *topkCurry) Reset() {
+	r.root.lockStream()
+	defer r.root.streamMtx.Unlock()
+	r.root.stream.Reset()
+	if r.root.keyState != nil {
+		r.root.keyState = make(map[string]*keyState)
+	}
+	if r.root.lastSeen != nil {
+		r.root.lastSeen = make(map[string]time.Time)
+	}
+	if r.root.audit != nil {
+		r.root.audit.reset(r.root.stream)
+	}
+	r.root.shareAlert.reset(r.root.stream)
+}
+
+// labelValue returns the value used in keys for the value v of the label at
+// index i, after applying the LabelValuePolicy, the constraint of the label,
+// and the MaxLabelValueLength.
+func (r *topkRoot) labelValue(i int, v string) (string, error) {
+	v, err := r.labelValuePolicy.apply(v)
+	if err != nil {
+		return "", err
+	}
+	if r.constraints != nil && r.constraints[i] != nil {
+		v = r.constraints[i](v)
+	}
+	if r.maxLabelValueLength > 0 {
+		v = truncateLabelValue(v, r.maxLabelValueLength)
+	}
+	return v, nil
+}
+
+func (r *topkRoot) delete(composite string) bool {
+	r.lockStream()
+	defer r.streamMtx.Unlock()
+	delete(r.keyState, composite)
+	delete(r.lastSeen, composite)
+	r.audit.delete(composite)
+	return r.stream.Remove(composite)
 }
@@ -0,0 +1,123 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCurryWithLabelValues(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5}, []string{"a", "b", "c"})
+
+	k.MustCurryWithLabelValues("1").WithLabelValues("x", "p").Add(2)
+	k.MustCurryWith(prometheus.Labels{"b": "y"}).MustCurryWithLabelValues("2").WithLabelValues("q").Add(3)
+	k.MustCurryWithLabelValues().MustCurryWithLabelValues("3", "z", "r").WithLabelValues().Add(4)
+
+	for _, tc := range []struct {
+		labels prometheus.Labels
+		want   float64
+	}{
+		{prometheus.Labels{"a": "1", "b": "x", "c": "p"}, 2},
+		{prometheus.Labels{"a": "2", "b": "y", "c": "q"}, 3},
+		{prometheus.Labels{"a": "3", "b": "z", "c": "r"}, 4},
+	} {
+		if count, _, tracked := k.Estimate(tc.labels); count != tc.want || !tracked {
+			t.Errorf("Estimate(%v) = %v, %v, expected %v", tc.labels, count, tracked, tc.want)
+		}
+	}
+
+	if _, err := k.CurryWithLabelValues("1", "2", "3", "4"); err == nil {
+		t.Error("expected error for too many label values")
+	}
+	if _, err := k.MustCurryWithLabelValues("1", "2").CurryWithLabelValues("3", "4"); err == nil {
+		t.Error("expected error for too many label values after currying")
+	}
+}
+
+func TestCurriedLabels(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 5}, []string{"a", "b", "c"})
+	if got := k.CurriedLabels(); len(got) != 0 {
+		t.Errorf("CurriedLabels = %v on uncurried TopK", got)
+	}
+	if got, want := k.FreeLabelNames(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FreeLabelNames = %v, expected %v", got, want)
+	}
+
+	curried := k.MustCurryWith(prometheus.Labels{"b": "x"}).MustCurryWithLabelValues("1")
+	if got, want := curried.CurriedLabels(), (prometheus.Labels{"a": "1", "b": "x"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("CurriedLabels = %v, expected %v", got, want)
+	}
+	if got, want := curried.FreeLabelNames(), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FreeLabelNames = %v, expected %v", got, want)
+	}
+}
+
+func TestLabelConstraints(t *testing.T) {
+	k := NewTopK(TopKOpts{
+		Name:    metricName,
+		Buckets: 5,
+		LabelConstraints: map[string]func(string) string{
+			"method": strings.ToUpper,
+		},
+	}, []string{"path", "method"})
+
+	k.WithLabelValues("/x", "get").Inc()
+	k.With(prometheus.Labels{"path": "/x", "method": "Get"}).Inc()
+	k.MustCurryWithLabelValues("/x").WithLabelValues("GET").Inc()
+	k.MustCurryWith(prometheus.Labels{"method": "post"}).WithLabelValues("/x").Inc()
+
+	if count, _, _ := k.Estimate(prometheus.Labels{"path": "/x", "method": "get"}); count != 3 {
+		t.Errorf("got count %v for the normalized key, expected 3", count)
+	}
+	if n := len(k.Snapshot()); n != 2 {
+		t.Errorf("got %d keys, expected 2", n)
+	}
+	if got := k.MustCurryWith(prometheus.Labels{"method": "post"}).CurriedLabels(); got["method"] != "POST" {
+		t.Errorf("curried value %q is not normalized", got["method"])
+	}
+	if n := k.DeletePartialMatch(prometheus.Labels{"method": "post"}); n != 1 {
+		t.Errorf("DeletePartialMatch deleted %d keys, expected 1", n)
+	}
+	if !k.DeleteLabelValues("/x", "get") {
+		t.Error("DeleteLabelValues did not normalize the label value")
+	}
+}
+
+func TestAllocs(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName}, []string{"a", "b", "c"})
+	c := k.MustCurryWithLabelValues("1").(*topkCurry)
+	if c.prefix != "1\xff" || c.prefixLabels != 1 {
+		t.Errorf("got prefix %q for %d labels", c.prefix, c.prefixLabels)
+	}
+
+	b := k.WithLabelValues("1", "x", "y")
+	if allocs := testing.AllocsPerRun(100, func() { b.Inc() }); allocs != 0 {
+		t.Errorf("Inc allocates %v times", allocs)
+	}
+	lvs := []string{"1", "x", "y"}
+	if allocs := testing.AllocsPerRun(100, func() { k.WithLabelValues(lvs...).Inc() }); allocs != 0 {
+		t.Errorf("WithLabelValues of a cached bucket allocates %v times", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { c.compositeWithLabelValues("x", "y") }); allocs != 1 {
+		t.Errorf("compositeWithLabelValues allocates %v times", allocs)
+	}
+}
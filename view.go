@@ -0,0 +1,70 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LabeledView returns a view of t that adds labels to all of its exported
+// metrics, like TopKOpts.ViewLabels, so that several views of the same
+// TopK, or TopKs that only differ by those labels, can be registered in one
+// metric family. The curried TopKs of the view add the labels too. The
+// labels are checked when the view is registered, and must not be a label
+// of t.
+func LabeledView(t TopK, labels prometheus.Labels) TopK {
+	return &labeledView{TopK: t, labels: labels, c: prometheus.WrapCollectorWith(labels, t)}
+}
+
+type labeledView struct {
+	TopK
+	labels prometheus.Labels
+	c      prometheus.Collector
+}
+
+func (v *labeledView) Describe(ch chan<- *prometheus.Desc) {
+	v.c.Describe(ch)
+}
+
+func (v *labeledView) Collect(ch chan<- prometheus.Metric) {
+	v.c.Collect(ch)
+}
+
+func (v *labeledView) CurryWith(labels prometheus.Labels) (TopK, error) {
+	t, err := v.TopK.CurryWith(labels)
+	if err != nil {
+		return nil, err
+	}
+	return LabeledView(t, v.labels), nil
+}
+
+func (v *labeledView) MustCurryWith(labels prometheus.Labels) TopK {
+	return LabeledView(v.TopK.MustCurryWith(labels), v.labels)
+}
+
+func (v *labeledView) CurryWithLabelValues(lvs ...string) (TopK, error) {
+	t, err := v.TopK.CurryWithLabelValues(lvs...)
+	if err != nil {
+		return nil, err
+	}
+	return LabeledView(t, v.labels), nil
+}
+
+func (v *labeledView) MustCurryWithLabelValues(lvs ...string) TopK {
+	return LabeledView(v.TopK.MustCurryWithLabelValues(lvs...), v.labels)
+}
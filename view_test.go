@@ -0,0 +1,86 @@
+/*
+Copyright 2019 Google LLC
+Copyright 2019 Kane York
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topk
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestViewLabels(t *testing.T) {
+	short := NewTopK(TopKOpts{Name: metricName, Buckets: 2, ViewLabels: prometheus.Labels{"window": "5m"}}, []string{"key"})
+	long := NewTopK(TopKOpts{Name: metricName, Buckets: 2, ViewLabels: prometheus.Labels{"window": "1h"}}, []string{"key"})
+	short.WithLabelValues("a").Add(1)
+	long.WithLabelValues("a").Add(5)
+
+	// the snapshots leave the view labels out, so the variants can be
+	// merged into each other
+	if labels := short.SnapshotProto().GetConstLabels(); len(labels) != 0 {
+		t.Errorf("snapshot has const labels %v", labels)
+	}
+	if err := long.Merge(short); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(short, long)
+	expected := `
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{key="a",window="1h"} 6
+test_metric{key="a",window="5m"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewTopKWithOptions(metricName, WithLabelNames("window"), WithViewLabels(prometheus.Labels{"window": "5m"})); err == nil {
+		t.Error("expected error for a view label clashing with a label name")
+	}
+}
+
+func TestLabeledView(t *testing.T) {
+	k := NewTopK(TopKOpts{Name: metricName, Buckets: 2}, []string{"a", "b"})
+	k.WithLabelValues("1", "x").Add(2)
+	k.WithLabelValues("2", "y").Add(3)
+
+	// like a curried Vec, the curried view collects the whole TopK
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(
+		LabeledView(k, prometheus.Labels{"view": "all"}),
+		LabeledView(k, prometheus.Labels{"view": "curried"}).MustCurryWithLabelValues("1"),
+	)
+	expected := `
+# HELP test_metric 
+# TYPE test_metric counter
+test_metric{a="1",b="x",view="all"} 2
+test_metric{a="1",b="x",view="curried"} 2
+test_metric{a="2",b="y",view="all"} 3
+test_metric{a="2",b="y",view="curried"} 3
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), metricName); err != nil {
+		t.Error(err)
+	}
+
+	if err := reg.Register(LabeledView(k, prometheus.Labels{"a": "1"})); err == nil {
+		t.Error("expected error for a view label clashing with a label name")
+	}
+}